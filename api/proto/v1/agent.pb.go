@@ -25,19 +25,64 @@ const (
 type TaskType int32
 
 const (
-	TaskType_TASK_TYPE_UNSPECIFIED      TaskType = 0
-	TaskType_TASK_TYPE_INSTALL_PACKAGES TaskType = 1
+	TaskType_TASK_TYPE_UNSPECIFIED          TaskType = 0
+	TaskType_TASK_TYPE_INSTALL_PACKAGES     TaskType = 1
+	TaskType_TASK_TYPE_CREATE_VM            TaskType = 2
+	TaskType_TASK_TYPE_DELETE_VM            TaskType = 3
+	TaskType_TASK_TYPE_POWER_VM             TaskType = 4
+	TaskType_TASK_TYPE_RECEIVE_VM_MIGRATION TaskType = 5
+	TaskType_TASK_TYPE_SEND_VM_MIGRATION    TaskType = 6
+	TaskType_TASK_TYPE_SNAPSHOT_VM          TaskType = 7
+	TaskType_TASK_TYPE_DELETE_VM_SNAPSHOT   TaskType = 8
+	TaskType_TASK_TYPE_RESTORE_VM           TaskType = 9
+	TaskType_TASK_TYPE_UPDATE_VM_CLOUD_INIT TaskType = 10
+	TaskType_TASK_TYPE_ATTACH_VM_DEVICE     TaskType = 11
+	TaskType_TASK_TYPE_DETACH_VM_DEVICE     TaskType = 12
+	TaskType_TASK_TYPE_RESIZE_VM            TaskType = 13
+	TaskType_TASK_TYPE_RELEASE_VM           TaskType = 14
+	TaskType_TASK_TYPE_UNINSTALL_PACKAGES   TaskType = 15
+	TaskType_TASK_TYPE_ROLLBACK_PACKAGES    TaskType = 16
 )
 
 // Enum value maps for TaskType.
 var (
 	TaskType_name = map[int32]string{
-		0: "TASK_TYPE_UNSPECIFIED",
-		1: "TASK_TYPE_INSTALL_PACKAGES",
+		0:  "TASK_TYPE_UNSPECIFIED",
+		1:  "TASK_TYPE_INSTALL_PACKAGES",
+		2:  "TASK_TYPE_CREATE_VM",
+		3:  "TASK_TYPE_DELETE_VM",
+		4:  "TASK_TYPE_POWER_VM",
+		5:  "TASK_TYPE_RECEIVE_VM_MIGRATION",
+		6:  "TASK_TYPE_SEND_VM_MIGRATION",
+		7:  "TASK_TYPE_SNAPSHOT_VM",
+		8:  "TASK_TYPE_DELETE_VM_SNAPSHOT",
+		9:  "TASK_TYPE_RESTORE_VM",
+		10: "TASK_TYPE_UPDATE_VM_CLOUD_INIT",
+		11: "TASK_TYPE_ATTACH_VM_DEVICE",
+		12: "TASK_TYPE_DETACH_VM_DEVICE",
+		13: "TASK_TYPE_RESIZE_VM",
+		14: "TASK_TYPE_RELEASE_VM",
+		15: "TASK_TYPE_UNINSTALL_PACKAGES",
+		16: "TASK_TYPE_ROLLBACK_PACKAGES",
 	}
 	TaskType_value = map[string]int32{
-		"TASK_TYPE_UNSPECIFIED":      0,
-		"TASK_TYPE_INSTALL_PACKAGES": 1,
+		"TASK_TYPE_UNSPECIFIED":          0,
+		"TASK_TYPE_INSTALL_PACKAGES":     1,
+		"TASK_TYPE_CREATE_VM":            2,
+		"TASK_TYPE_DELETE_VM":            3,
+		"TASK_TYPE_POWER_VM":             4,
+		"TASK_TYPE_RECEIVE_VM_MIGRATION": 5,
+		"TASK_TYPE_SEND_VM_MIGRATION":    6,
+		"TASK_TYPE_SNAPSHOT_VM":          7,
+		"TASK_TYPE_DELETE_VM_SNAPSHOT":   8,
+		"TASK_TYPE_RESTORE_VM":           9,
+		"TASK_TYPE_UPDATE_VM_CLOUD_INIT": 10,
+		"TASK_TYPE_ATTACH_VM_DEVICE":     11,
+		"TASK_TYPE_DETACH_VM_DEVICE":     12,
+		"TASK_TYPE_RESIZE_VM":            13,
+		"TASK_TYPE_RELEASE_VM":           14,
+		"TASK_TYPE_UNINSTALL_PACKAGES":   15,
+		"TASK_TYPE_ROLLBACK_PACKAGES":    16,
 	}
 )
 
@@ -133,7 +178,7 @@ type Task struct {
 	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	HostId        string                 `protobuf:"bytes,2,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`
 	Type          TaskType               `protobuf:"varint,3,opt,name=type,proto3,enum=vertera.v1.TaskType" json:"type,omitempty"`
-	Params        []byte                 `protobuf:"bytes,4,opt,name=params,proto3" json:"params,omitempty"` // Marshaled InstallPackagesParams for now
+	Params        []byte                 `protobuf:"bytes,4,opt,name=params,proto3" json:"params,omitempty"` // JSON-encoded params for the task type
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -246,6 +291,7 @@ type TaskResult struct {
 	Status        string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"` // queued, running, succeeded, failed
 	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`   // non-empty when failed
 	Logs          string                 `protobuf:"bytes,4,opt,name=logs,proto3" json:"logs,omitempty"`     // optional inline logs snippet
+	Result        []byte                 `protobuf:"bytes,5,opt,name=result,proto3" json:"result,omitempty"` // JSON-encoded task output, set with succeeded
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -308,6 +354,524 @@ func (x *TaskResult) GetLogs() string {
 	return ""
 }
 
+func (x *TaskResult) GetResult() []byte {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+// VmStateReport carries a VM state change observed by the agent outside of a
+// task, e.g. the guest powered off or the VMM exited.
+type VmStateReport struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	VmId          string                 `protobuf:"bytes,1,opt,name=vm_id,json=vmId,proto3" json:"vm_id,omitempty"`
+	State         string                 `protobuf:"bytes,2,opt,name=state,proto3" json:"state,omitempty"`                 // running, stopped, error
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`                 // non-empty when state is error
+	HostId        string                 `protobuf:"bytes,4,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"` // reporting host; reports from a host the VM left are ignored
+	Crash         *VmCrash               `protobuf:"bytes,5,opt,name=crash,proto3" json:"crash,omitempty"`                 // set when the VMM exited on its own
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VmStateReport) Reset() {
+	*x = VmStateReport{}
+	mi := &file_v1_agent_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VmStateReport) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VmStateReport) ProtoMessage() {}
+
+func (x *VmStateReport) ProtoReflect() protoreflect.Message {
+	mi := &file_v1_agent_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VmStateReport.ProtoReflect.Descriptor instead.
+func (*VmStateReport) Descriptor() ([]byte, []int) {
+	return file_v1_agent_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *VmStateReport) GetVmId() string {
+	if x != nil {
+		return x.VmId
+	}
+	return ""
+}
+
+func (x *VmStateReport) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *VmStateReport) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *VmStateReport) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+func (x *VmStateReport) GetCrash() *VmCrash {
+	if x != nil {
+		return x.Crash
+	}
+	return nil
+}
+
+// VmCrash describes an unexpected exit of a VM's VMM process.
+type VmCrash struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ExitCode      int32                  `protobuf:"varint,1,opt,name=exit_code,json=exitCode,proto3" json:"exit_code,omitempty"`         // -1 when killed by a signal or unknown
+	Restarts      int32                  `protobuf:"varint,2,opt,name=restarts,proto3" json:"restarts,omitempty"`                         // restarts in a row, including this one when restarted
+	Restarted     bool                   `protobuf:"varint,3,opt,name=restarted,proto3" json:"restarted,omitempty"`                       // the agent restarted the VMM per the VM's restart policy
+	ConsoleTail   string                 `protobuf:"bytes,4,opt,name=console_tail,json=consoleTail,proto3" json:"console_tail,omitempty"` // last lines of serial console output
+	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VmCrash) Reset() {
+	*x = VmCrash{}
+	mi := &file_v1_agent_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VmCrash) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VmCrash) ProtoMessage() {}
+
+func (x *VmCrash) ProtoReflect() protoreflect.Message {
+	mi := &file_v1_agent_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VmCrash.ProtoReflect.Descriptor instead.
+func (*VmCrash) Descriptor() ([]byte, []int) {
+	return file_v1_agent_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *VmCrash) GetExitCode() int32 {
+	if x != nil {
+		return x.ExitCode
+	}
+	return 0
+}
+
+func (x *VmCrash) GetRestarts() int32 {
+	if x != nil {
+		return x.Restarts
+	}
+	return 0
+}
+
+func (x *VmCrash) GetRestarted() bool {
+	if x != nil {
+		return x.Restarted
+	}
+	return false
+}
+
+func (x *VmCrash) GetConsoleTail() string {
+	if x != nil {
+		return x.ConsoleTail
+	}
+	return ""
+}
+
+func (x *VmCrash) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type VmStateAck struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	VmId          string                 `protobuf:"bytes,1,opt,name=vm_id,json=vmId,proto3" json:"vm_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VmStateAck) Reset() {
+	*x = VmStateAck{}
+	mi := &file_v1_agent_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VmStateAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VmStateAck) ProtoMessage() {}
+
+func (x *VmStateAck) ProtoReflect() protoreflect.Message {
+	mi := &file_v1_agent_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VmStateAck.ProtoReflect.Descriptor instead.
+func (*VmStateAck) Descriptor() ([]byte, []int) {
+	return file_v1_agent_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *VmStateAck) GetVmId() string {
+	if x != nil {
+		return x.VmId
+	}
+	return ""
+}
+
+// InventoryReport carries a host inventory snapshot collected by the agent.
+type InventoryReport struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	HostId        string                 `protobuf:"bytes,1,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`
+	Inventory     []byte                 `protobuf:"bytes,2,opt,name=inventory,proto3" json:"inventory,omitempty"` // JSON-encoded inventory (see the Inventory API schema)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InventoryReport) Reset() {
+	*x = InventoryReport{}
+	mi := &file_v1_agent_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InventoryReport) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InventoryReport) ProtoMessage() {}
+
+func (x *InventoryReport) ProtoReflect() protoreflect.Message {
+	mi := &file_v1_agent_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InventoryReport.ProtoReflect.Descriptor instead.
+func (*InventoryReport) Descriptor() ([]byte, []int) {
+	return file_v1_agent_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *InventoryReport) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+func (x *InventoryReport) GetInventory() []byte {
+	if x != nil {
+		return x.Inventory
+	}
+	return nil
+}
+
+type InventoryAck struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	HostId        string                 `protobuf:"bytes,1,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InventoryAck) Reset() {
+	*x = InventoryAck{}
+	mi := &file_v1_agent_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InventoryAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InventoryAck) ProtoMessage() {}
+
+func (x *InventoryAck) ProtoReflect() protoreflect.Message {
+	mi := &file_v1_agent_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InventoryAck.ProtoReflect.Descriptor instead.
+func (*InventoryAck) Descriptor() ([]byte, []int) {
+	return file_v1_agent_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *InventoryAck) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+// VmMetricsReport carries the metric samples the agent collected from the
+// VMs running on its host.
+type VmMetricsReport struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	HostId        string                 `protobuf:"bytes,1,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`
+	Metrics       []byte                 `protobuf:"bytes,2,opt,name=metrics,proto3" json:"metrics,omitempty"` // JSON object whose "vms" holds the samples (see the VmMetricsSample API schema)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VmMetricsReport) Reset() {
+	*x = VmMetricsReport{}
+	mi := &file_v1_agent_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VmMetricsReport) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VmMetricsReport) ProtoMessage() {}
+
+func (x *VmMetricsReport) ProtoReflect() protoreflect.Message {
+	mi := &file_v1_agent_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VmMetricsReport.ProtoReflect.Descriptor instead.
+func (*VmMetricsReport) Descriptor() ([]byte, []int) {
+	return file_v1_agent_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *VmMetricsReport) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+func (x *VmMetricsReport) GetMetrics() []byte {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+type VmMetricsAck struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	HostId        string                 `protobuf:"bytes,1,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VmMetricsAck) Reset() {
+	*x = VmMetricsAck{}
+	mi := &file_v1_agent_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VmMetricsAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VmMetricsAck) ProtoMessage() {}
+
+func (x *VmMetricsAck) ProtoReflect() protoreflect.Message {
+	mi := &file_v1_agent_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VmMetricsAck.ProtoReflect.Descriptor instead.
+func (*VmMetricsAck) Descriptor() ([]byte, []int) {
+	return file_v1_agent_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *VmMetricsAck) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+// ConsoleRequest asks an agent to attach to the serial console of one of
+// its VMs and to serve it on a Console stream for session_id.
+type ConsoleRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SessionId     string                 `protobuf:"bytes,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	VmId          string                 `protobuf:"bytes,2,opt,name=vm_id,json=vmId,proto3" json:"vm_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConsoleRequest) Reset() {
+	*x = ConsoleRequest{}
+	mi := &file_v1_agent_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConsoleRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsoleRequest) ProtoMessage() {}
+
+func (x *ConsoleRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_v1_agent_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsoleRequest.ProtoReflect.Descriptor instead.
+func (*ConsoleRequest) Descriptor() ([]byte, []int) {
+	return file_v1_agent_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ConsoleRequest) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *ConsoleRequest) GetVmId() string {
+	if x != nil {
+		return x.VmId
+	}
+	return ""
+}
+
+// ConsoleFrame carries console bytes in either direction of a Console
+// stream. The agent's first frame names the session and holds the console
+// history, or the error that kept it from attaching.
+type ConsoleFrame struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SessionId     string                 `protobuf:"bytes,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConsoleFrame) Reset() {
+	*x = ConsoleFrame{}
+	mi := &file_v1_agent_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConsoleFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsoleFrame) ProtoMessage() {}
+
+func (x *ConsoleFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_v1_agent_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsoleFrame.ProtoReflect.Descriptor instead.
+func (*ConsoleFrame) Descriptor() ([]byte, []int) {
+	return file_v1_agent_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ConsoleFrame) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *ConsoleFrame) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *ConsoleFrame) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
 type RegisterRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
@@ -318,7 +882,7 @@ type RegisterRequest struct {
 
 func (x *RegisterRequest) Reset() {
 	*x = RegisterRequest{}
-	mi := &file_v1_agent_proto_msgTypes[4]
+	mi := &file_v1_agent_proto_msgTypes[13]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -330,7 +894,7 @@ func (x *RegisterRequest) String() string {
 func (*RegisterRequest) ProtoMessage() {}
 
 func (x *RegisterRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_v1_agent_proto_msgTypes[4]
+	mi := &file_v1_agent_proto_msgTypes[13]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -343,7 +907,7 @@ func (x *RegisterRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RegisterRequest.ProtoReflect.Descriptor instead.
 func (*RegisterRequest) Descriptor() ([]byte, []int) {
-	return file_v1_agent_proto_rawDescGZIP(), []int{4}
+	return file_v1_agent_proto_rawDescGZIP(), []int{13}
 }
 
 func (x *RegisterRequest) GetAgentId() string {
@@ -369,7 +933,7 @@ type RegisterResponse struct {
 
 func (x *RegisterResponse) Reset() {
 	*x = RegisterResponse{}
-	mi := &file_v1_agent_proto_msgTypes[5]
+	mi := &file_v1_agent_proto_msgTypes[14]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -381,7 +945,7 @@ func (x *RegisterResponse) String() string {
 func (*RegisterResponse) ProtoMessage() {}
 
 func (x *RegisterResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_v1_agent_proto_msgTypes[5]
+	mi := &file_v1_agent_proto_msgTypes[14]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -394,7 +958,7 @@ func (x *RegisterResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RegisterResponse.ProtoReflect.Descriptor instead.
 func (*RegisterResponse) Descriptor() ([]byte, []int) {
-	return file_v1_agent_proto_rawDescGZIP(), []int{5}
+	return file_v1_agent_proto_rawDescGZIP(), []int{14}
 }
 
 func (x *RegisterResponse) GetAssignedId() string {
@@ -404,6 +968,60 @@ func (x *RegisterResponse) GetAssignedId() string {
 	return ""
 }
 
+// HeartbeatAck returns the host's state as the controller sees it, e.g.
+// failed after the host was fenced.
+type HeartbeatAck struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	HostId        string                 `protobuf:"bytes,1,opt,name=host_id,json=hostId,proto3" json:"host_id,omitempty"`
+	HostState     string                 `protobuf:"bytes,2,opt,name=host_state,json=hostState,proto3" json:"host_state,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HeartbeatAck) Reset() {
+	*x = HeartbeatAck{}
+	mi := &file_v1_agent_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HeartbeatAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeartbeatAck) ProtoMessage() {}
+
+func (x *HeartbeatAck) ProtoReflect() protoreflect.Message {
+	mi := &file_v1_agent_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatAck.ProtoReflect.Descriptor instead.
+func (*HeartbeatAck) Descriptor() ([]byte, []int) {
+	return file_v1_agent_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *HeartbeatAck) GetHostId() string {
+	if x != nil {
+		return x.HostId
+	}
+	return ""
+}
+
+func (x *HeartbeatAck) GetHostState() string {
+	if x != nil {
+		return x.HostState
+	}
+	return ""
+}
+
 var File_v1_agent_proto protoreflect.FileDescriptor
 
 const file_v1_agent_proto_rawDesc = "" +
@@ -421,27 +1039,88 @@ const file_v1_agent_proto_rawDesc = "" +
 	"\x04type\x18\x03 \x01(\x0e2\x14.vertera.v1.TaskTypeR\x04type\x12\x16\n" +
 	"\x06params\x18\x04 \x01(\fR\x06params\"\x19\n" +
 	"\aTaskAck\x12\x0e\n" +
-	"\x02id\x18\x01 \x01(\tR\x02id\"^\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"v\n" +
 	"\n" +
 	"TaskResult\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
 	"\x06status\x18\x02 \x01(\tR\x06status\x12\x14\n" +
 	"\x05error\x18\x03 \x01(\tR\x05error\x12\x12\n" +
-	"\x04logs\x18\x04 \x01(\tR\x04logs\"H\n" +
+	"\x04logs\x18\x04 \x01(\tR\x04logs\x12\x16\n" +
+	"\x06result\x18\x05 \x01(\fR\x06result\"\x94\x01\n" +
+	"\rVmStateReport\x12\x13\n" +
+	"\x05vm_id\x18\x01 \x01(\tR\x04vmId\x12\x14\n" +
+	"\x05state\x18\x02 \x01(\tR\x05state\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\x12\x17\n" +
+	"\ahost_id\x18\x04 \x01(\tR\x06hostId\x12)\n" +
+	"\x05crash\x18\x05 \x01(\v2\x13.vertera.v1.VmCrashR\x05crash\"\x99\x01\n" +
+	"\aVmCrash\x12\x1b\n" +
+	"\texit_code\x18\x01 \x01(\x05R\bexitCode\x12\x1a\n" +
+	"\brestarts\x18\x02 \x01(\x05R\brestarts\x12\x1c\n" +
+	"\trestarted\x18\x03 \x01(\bR\trestarted\x12!\n" +
+	"\fconsole_tail\x18\x04 \x01(\tR\vconsoleTail\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\"!\n" +
+	"\n" +
+	"VmStateAck\x12\x13\n" +
+	"\x05vm_id\x18\x01 \x01(\tR\x04vmId\"H\n" +
+	"\x0fInventoryReport\x12\x17\n" +
+	"\ahost_id\x18\x01 \x01(\tR\x06hostId\x12\x1c\n" +
+	"\tinventory\x18\x02 \x01(\fR\tinventory\"'\n" +
+	"\fInventoryAck\x12\x17\n" +
+	"\ahost_id\x18\x01 \x01(\tR\x06hostId\"D\n" +
+	"\x0fVmMetricsReport\x12\x17\n" +
+	"\ahost_id\x18\x01 \x01(\tR\x06hostId\x12\x18\n" +
+	"\ametrics\x18\x02 \x01(\fR\ametrics\"'\n" +
+	"\fVmMetricsAck\x12\x17\n" +
+	"\ahost_id\x18\x01 \x01(\tR\x06hostId\"D\n" +
+	"\x0eConsoleRequest\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x01 \x01(\tR\tsessionId\x12\x13\n" +
+	"\x05vm_id\x18\x02 \x01(\tR\x04vmId\"W\n" +
+	"\fConsoleFrame\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x01 \x01(\tR\tsessionId\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"H\n" +
 	"\x0fRegisterRequest\x12\x19\n" +
 	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x1a\n" +
 	"\bhostname\x18\x02 \x01(\tR\bhostname\"3\n" +
 	"\x10RegisterResponse\x12\x1f\n" +
 	"\vassigned_id\x18\x01 \x01(\tR\n" +
-	"assignedId*E\n" +
+	"assignedId\"F\n" +
+	"\fHeartbeatAck\x12\x17\n" +
+	"\ahost_id\x18\x01 \x01(\tR\x06hostId\x12\x1d\n" +
+	"\n" +
+	"host_state\x18\x02 \x01(\tR\thostState*\x85\x04\n" +
 	"\bTaskType\x12\x19\n" +
 	"\x15TASK_TYPE_UNSPECIFIED\x10\x00\x12\x1e\n" +
-	"\x1aTASK_TYPE_INSTALL_PACKAGES\x10\x012\xd5\x01\n" +
+	"\x1aTASK_TYPE_INSTALL_PACKAGES\x10\x01\x12\x17\n" +
+	"\x13TASK_TYPE_CREATE_VM\x10\x02\x12\x17\n" +
+	"\x13TASK_TYPE_DELETE_VM\x10\x03\x12\x16\n" +
+	"\x12TASK_TYPE_POWER_VM\x10\x04\x12\"\n" +
+	"\x1eTASK_TYPE_RECEIVE_VM_MIGRATION\x10\x05\x12\x1f\n" +
+	"\x1bTASK_TYPE_SEND_VM_MIGRATION\x10\x06\x12\x19\n" +
+	"\x15TASK_TYPE_SNAPSHOT_VM\x10\a\x12 \n" +
+	"\x1cTASK_TYPE_DELETE_VM_SNAPSHOT\x10\b\x12\x18\n" +
+	"\x14TASK_TYPE_RESTORE_VM\x10\t\x12\"\n" +
+	"\x1eTASK_TYPE_UPDATE_VM_CLOUD_INIT\x10\n" +
+	"\x12\x1e\n" +
+	"\x1aTASK_TYPE_ATTACH_VM_DEVICE\x10\v\x12\x1e\n" +
+	"\x1aTASK_TYPE_DETACH_VM_DEVICE\x10\f\x12\x17\n" +
+	"\x13TASK_TYPE_RESIZE_VM\x10\r\x12\x18\n" +
+	"\x14TASK_TYPE_RELEASE_VM\x10\x0e\x12 \n" +
+	"\x1cTASK_TYPE_UNINSTALL_PACKAGES\x10\x0f\x12\x1f\n" +
+	"\x1bTASK_TYPE_ROLLBACK_PACKAGES\x10\x102\x80\x05\n" +
 	"\fAgentService\x12E\n" +
 	"\bRegister\x12\x1b.vertera.v1.RegisterRequest\x1a\x1c.vertera.v1.RegisterResponse\x12=\n" +
 	"\n" +
 	"WatchTasks\x12\x1b.vertera.v1.RegisterRequest\x1a\x10.vertera.v1.Task0\x01\x12?\n" +
-	"\x10ReportTaskResult\x12\x16.vertera.v1.TaskResult\x1a\x13.vertera.v1.TaskAckB5Z3github.com/VerteraIO/vertera/api/proto/v1;verterapbb\x06proto3"
+	"\x10ReportTaskResult\x12\x16.vertera.v1.TaskResult\x1a\x13.vertera.v1.TaskAck\x12B\n" +
+	"\tHeartbeat\x12\x1b.vertera.v1.RegisterRequest\x1a\x18.vertera.v1.HeartbeatAck\x12B\n" +
+	"\rReportVmState\x12\x19.vertera.v1.VmStateReport\x1a\x16.vertera.v1.VmStateAck\x12H\n" +
+	"\x0fReportInventory\x12\x1b.vertera.v1.InventoryReport\x1a\x18.vertera.v1.InventoryAck\x12H\n" +
+	"\x0fReportVmMetrics\x12\x1b.vertera.v1.VmMetricsReport\x1a\x18.vertera.v1.VmMetricsAck\x12J\n" +
+	"\rWatchConsoles\x12\x1b.vertera.v1.RegisterRequest\x1a\x1a.vertera.v1.ConsoleRequest0\x01\x12A\n" +
+	"\aConsole\x12\x18.vertera.v1.ConsoleFrame\x1a\x18.vertera.v1.ConsoleFrame(\x010\x01B5Z3github.com/VerteraIO/vertera/api/proto/v1;verterapbb\x06proto3"
 
 var (
 	file_v1_agent_proto_rawDescOnce sync.Once
@@ -456,29 +1135,52 @@ func file_v1_agent_proto_rawDescGZIP() []byte {
 }
 
 var file_v1_agent_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_v1_agent_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_v1_agent_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
 var file_v1_agent_proto_goTypes = []any{
 	(TaskType)(0),                 // 0: vertera.v1.TaskType
 	(*InstallPackagesParams)(nil), // 1: vertera.v1.InstallPackagesParams
 	(*Task)(nil),                  // 2: vertera.v1.Task
 	(*TaskAck)(nil),               // 3: vertera.v1.TaskAck
 	(*TaskResult)(nil),            // 4: vertera.v1.TaskResult
-	(*RegisterRequest)(nil),       // 5: vertera.v1.RegisterRequest
-	(*RegisterResponse)(nil),      // 6: vertera.v1.RegisterResponse
+	(*VmStateReport)(nil),         // 5: vertera.v1.VmStateReport
+	(*VmCrash)(nil),               // 6: vertera.v1.VmCrash
+	(*VmStateAck)(nil),            // 7: vertera.v1.VmStateAck
+	(*InventoryReport)(nil),       // 8: vertera.v1.InventoryReport
+	(*InventoryAck)(nil),          // 9: vertera.v1.InventoryAck
+	(*VmMetricsReport)(nil),       // 10: vertera.v1.VmMetricsReport
+	(*VmMetricsAck)(nil),          // 11: vertera.v1.VmMetricsAck
+	(*ConsoleRequest)(nil),        // 12: vertera.v1.ConsoleRequest
+	(*ConsoleFrame)(nil),          // 13: vertera.v1.ConsoleFrame
+	(*RegisterRequest)(nil),       // 14: vertera.v1.RegisterRequest
+	(*RegisterResponse)(nil),      // 15: vertera.v1.RegisterResponse
+	(*HeartbeatAck)(nil),          // 16: vertera.v1.HeartbeatAck
 }
 var file_v1_agent_proto_depIdxs = []int32{
-	0, // 0: vertera.v1.Task.type:type_name -> vertera.v1.TaskType
-	5, // 1: vertera.v1.AgentService.Register:input_type -> vertera.v1.RegisterRequest
-	5, // 2: vertera.v1.AgentService.WatchTasks:input_type -> vertera.v1.RegisterRequest
-	4, // 3: vertera.v1.AgentService.ReportTaskResult:input_type -> vertera.v1.TaskResult
-	6, // 4: vertera.v1.AgentService.Register:output_type -> vertera.v1.RegisterResponse
-	2, // 5: vertera.v1.AgentService.WatchTasks:output_type -> vertera.v1.Task
-	3, // 6: vertera.v1.AgentService.ReportTaskResult:output_type -> vertera.v1.TaskAck
-	4, // [4:7] is the sub-list for method output_type
-	1, // [1:4] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	0,  // 0: vertera.v1.Task.type:type_name -> vertera.v1.TaskType
+	6,  // 1: vertera.v1.VmStateReport.crash:type_name -> vertera.v1.VmCrash
+	14, // 2: vertera.v1.AgentService.Register:input_type -> vertera.v1.RegisterRequest
+	14, // 3: vertera.v1.AgentService.WatchTasks:input_type -> vertera.v1.RegisterRequest
+	4,  // 4: vertera.v1.AgentService.ReportTaskResult:input_type -> vertera.v1.TaskResult
+	14, // 5: vertera.v1.AgentService.Heartbeat:input_type -> vertera.v1.RegisterRequest
+	5,  // 6: vertera.v1.AgentService.ReportVmState:input_type -> vertera.v1.VmStateReport
+	8,  // 7: vertera.v1.AgentService.ReportInventory:input_type -> vertera.v1.InventoryReport
+	10, // 8: vertera.v1.AgentService.ReportVmMetrics:input_type -> vertera.v1.VmMetricsReport
+	14, // 9: vertera.v1.AgentService.WatchConsoles:input_type -> vertera.v1.RegisterRequest
+	13, // 10: vertera.v1.AgentService.Console:input_type -> vertera.v1.ConsoleFrame
+	15, // 11: vertera.v1.AgentService.Register:output_type -> vertera.v1.RegisterResponse
+	2,  // 12: vertera.v1.AgentService.WatchTasks:output_type -> vertera.v1.Task
+	3,  // 13: vertera.v1.AgentService.ReportTaskResult:output_type -> vertera.v1.TaskAck
+	16, // 14: vertera.v1.AgentService.Heartbeat:output_type -> vertera.v1.HeartbeatAck
+	7,  // 15: vertera.v1.AgentService.ReportVmState:output_type -> vertera.v1.VmStateAck
+	9,  // 16: vertera.v1.AgentService.ReportInventory:output_type -> vertera.v1.InventoryAck
+	11, // 17: vertera.v1.AgentService.ReportVmMetrics:output_type -> vertera.v1.VmMetricsAck
+	12, // 18: vertera.v1.AgentService.WatchConsoles:output_type -> vertera.v1.ConsoleRequest
+	13, // 19: vertera.v1.AgentService.Console:output_type -> vertera.v1.ConsoleFrame
+	11, // [11:20] is the sub-list for method output_type
+	2,  // [2:11] is the sub-list for method input_type
+	2,  // [2:2] is the sub-list for extension type_name
+	2,  // [2:2] is the sub-list for extension extendee
+	0,  // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_v1_agent_proto_init() }
@@ -492,7 +1194,7 @@ func file_v1_agent_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_v1_agent_proto_rawDesc), len(file_v1_agent_proto_rawDesc)),
 			NumEnums:      1,
-			NumMessages:   6,
+			NumMessages:   16,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
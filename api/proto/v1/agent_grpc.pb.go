@@ -22,6 +22,12 @@ const (
 	AgentService_Register_FullMethodName         = "/vertera.v1.AgentService/Register"
 	AgentService_WatchTasks_FullMethodName       = "/vertera.v1.AgentService/WatchTasks"
 	AgentService_ReportTaskResult_FullMethodName = "/vertera.v1.AgentService/ReportTaskResult"
+	AgentService_Heartbeat_FullMethodName        = "/vertera.v1.AgentService/Heartbeat"
+	AgentService_ReportVmState_FullMethodName    = "/vertera.v1.AgentService/ReportVmState"
+	AgentService_ReportInventory_FullMethodName  = "/vertera.v1.AgentService/ReportInventory"
+	AgentService_ReportVmMetrics_FullMethodName  = "/vertera.v1.AgentService/ReportVmMetrics"
+	AgentService_WatchConsoles_FullMethodName    = "/vertera.v1.AgentService/WatchConsoles"
+	AgentService_Console_FullMethodName          = "/vertera.v1.AgentService/Console"
 )
 
 // AgentServiceClient is the client API for AgentService service.
@@ -34,6 +40,20 @@ type AgentServiceClient interface {
 	WatchTasks(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Task], error)
 	// Agent reports the result of a task
 	ReportTaskResult(ctx context.Context, in *TaskResult, opts ...grpc.CallOption) (*TaskAck, error)
+	// Agent reports that it is alive; hosts that stop heartbeating are failed
+	// over
+	Heartbeat(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*HeartbeatAck, error)
+	// Agent reports a VM state change it observed on its own
+	ReportVmState(ctx context.Context, in *VmStateReport, opts ...grpc.CallOption) (*VmStateAck, error)
+	// Agent reports a host inventory snapshot
+	ReportInventory(ctx context.Context, in *InventoryReport, opts ...grpc.CallOption) (*InventoryAck, error)
+	// Agent reports metric samples of its VMs
+	ReportVmMetrics(ctx context.Context, in *VmMetricsReport, opts ...grpc.CallOption) (*VmMetricsAck, error)
+	// Controller streams console sessions opened for the agent's VMs
+	WatchConsoles(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ConsoleRequest], error)
+	// Agent serves one console session: VM output flows to the controller,
+	// viewer input back to the agent
+	Console(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ConsoleFrame, ConsoleFrame], error)
 }
 
 type agentServiceClient struct {
@@ -83,6 +103,78 @@ func (c *agentServiceClient) ReportTaskResult(ctx context.Context, in *TaskResul
 	return out, nil
 }
 
+func (c *agentServiceClient) Heartbeat(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*HeartbeatAck, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HeartbeatAck)
+	err := c.cc.Invoke(ctx, AgentService_Heartbeat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) ReportVmState(ctx context.Context, in *VmStateReport, opts ...grpc.CallOption) (*VmStateAck, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VmStateAck)
+	err := c.cc.Invoke(ctx, AgentService_ReportVmState_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) ReportInventory(ctx context.Context, in *InventoryReport, opts ...grpc.CallOption) (*InventoryAck, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InventoryAck)
+	err := c.cc.Invoke(ctx, AgentService_ReportInventory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) ReportVmMetrics(ctx context.Context, in *VmMetricsReport, opts ...grpc.CallOption) (*VmMetricsAck, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VmMetricsAck)
+	err := c.cc.Invoke(ctx, AgentService_ReportVmMetrics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) WatchConsoles(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ConsoleRequest], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &AgentService_ServiceDesc.Streams[1], AgentService_WatchConsoles_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[RegisterRequest, ConsoleRequest]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AgentService_WatchConsolesClient = grpc.ServerStreamingClient[ConsoleRequest]
+
+func (c *agentServiceClient) Console(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ConsoleFrame, ConsoleFrame], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &AgentService_ServiceDesc.Streams[2], AgentService_Console_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ConsoleFrame, ConsoleFrame]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AgentService_ConsoleClient = grpc.BidiStreamingClient[ConsoleFrame, ConsoleFrame]
+
 // AgentServiceServer is the server API for AgentService service.
 // All implementations must embed UnimplementedAgentServiceServer
 // for forward compatibility.
@@ -93,6 +185,20 @@ type AgentServiceServer interface {
 	WatchTasks(*RegisterRequest, grpc.ServerStreamingServer[Task]) error
 	// Agent reports the result of a task
 	ReportTaskResult(context.Context, *TaskResult) (*TaskAck, error)
+	// Agent reports that it is alive; hosts that stop heartbeating are failed
+	// over
+	Heartbeat(context.Context, *RegisterRequest) (*HeartbeatAck, error)
+	// Agent reports a VM state change it observed on its own
+	ReportVmState(context.Context, *VmStateReport) (*VmStateAck, error)
+	// Agent reports a host inventory snapshot
+	ReportInventory(context.Context, *InventoryReport) (*InventoryAck, error)
+	// Agent reports metric samples of its VMs
+	ReportVmMetrics(context.Context, *VmMetricsReport) (*VmMetricsAck, error)
+	// Controller streams console sessions opened for the agent's VMs
+	WatchConsoles(*RegisterRequest, grpc.ServerStreamingServer[ConsoleRequest]) error
+	// Agent serves one console session: VM output flows to the controller,
+	// viewer input back to the agent
+	Console(grpc.BidiStreamingServer[ConsoleFrame, ConsoleFrame]) error
 	mustEmbedUnimplementedAgentServiceServer()
 }
 
@@ -112,6 +218,24 @@ func (UnimplementedAgentServiceServer) WatchTasks(*RegisterRequest, grpc.ServerS
 func (UnimplementedAgentServiceServer) ReportTaskResult(context.Context, *TaskResult) (*TaskAck, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ReportTaskResult not implemented")
 }
+func (UnimplementedAgentServiceServer) Heartbeat(context.Context, *RegisterRequest) (*HeartbeatAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedAgentServiceServer) ReportVmState(context.Context, *VmStateReport) (*VmStateAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportVmState not implemented")
+}
+func (UnimplementedAgentServiceServer) ReportInventory(context.Context, *InventoryReport) (*InventoryAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportInventory not implemented")
+}
+func (UnimplementedAgentServiceServer) ReportVmMetrics(context.Context, *VmMetricsReport) (*VmMetricsAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportVmMetrics not implemented")
+}
+func (UnimplementedAgentServiceServer) WatchConsoles(*RegisterRequest, grpc.ServerStreamingServer[ConsoleRequest]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchConsoles not implemented")
+}
+func (UnimplementedAgentServiceServer) Console(grpc.BidiStreamingServer[ConsoleFrame, ConsoleFrame]) error {
+	return status.Errorf(codes.Unimplemented, "method Console not implemented")
+}
 func (UnimplementedAgentServiceServer) mustEmbedUnimplementedAgentServiceServer() {}
 func (UnimplementedAgentServiceServer) testEmbeddedByValue()                      {}
 
@@ -180,6 +304,96 @@ func _AgentService_ReportTaskResult_Handler(srv interface{}, ctx context.Context
 	return interceptor(ctx, in, info, handler)
 }
 
+func _AgentService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).Heartbeat(ctx, req.(*RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_ReportVmState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VmStateReport)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).ReportVmState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_ReportVmState_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).ReportVmState(ctx, req.(*VmStateReport))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_ReportInventory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InventoryReport)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).ReportInventory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_ReportInventory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).ReportInventory(ctx, req.(*InventoryReport))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_ReportVmMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VmMetricsReport)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).ReportVmMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_ReportVmMetrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).ReportVmMetrics(ctx, req.(*VmMetricsReport))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_WatchConsoles_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RegisterRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AgentServiceServer).WatchConsoles(m, &grpc.GenericServerStream[RegisterRequest, ConsoleRequest]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AgentService_WatchConsolesServer = grpc.ServerStreamingServer[ConsoleRequest]
+
+func _AgentService_Console_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AgentServiceServer).Console(&grpc.GenericServerStream[ConsoleFrame, ConsoleFrame]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AgentService_ConsoleServer = grpc.BidiStreamingServer[ConsoleFrame, ConsoleFrame]
+
 // AgentService_ServiceDesc is the grpc.ServiceDesc for AgentService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -195,6 +409,22 @@ var AgentService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ReportTaskResult",
 			Handler:    _AgentService_ReportTaskResult_Handler,
 		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _AgentService_Heartbeat_Handler,
+		},
+		{
+			MethodName: "ReportVmState",
+			Handler:    _AgentService_ReportVmState_Handler,
+		},
+		{
+			MethodName: "ReportInventory",
+			Handler:    _AgentService_ReportInventory_Handler,
+		},
+		{
+			MethodName: "ReportVmMetrics",
+			Handler:    _AgentService_ReportVmMetrics_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -202,6 +432,17 @@ var AgentService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _AgentService_WatchTasks_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "WatchConsoles",
+			Handler:       _AgentService_WatchConsoles_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Console",
+			Handler:       _AgentService_Console_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "v1/agent.proto",
 }
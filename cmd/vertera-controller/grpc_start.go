@@ -36,7 +36,6 @@ func init() {
 				return
 			}
 			// Issue server cert for controller with SANs localhost and 127.0.0.1
-			_, serverKeyPath = pki.Paths(pkiDir, "controller")
 			serverCertPath, serverKeyPath, err = pki.IssueCertificate(pkiDir, "controller", "vertera-controller", true, caCert, caKey, 365*24*time.Hour, []string{"localhost", "127.0.0.1"})
 			if err != nil {
 				log.Printf("PKI IssueCertificate error: %v", err)
@@ -3,8 +3,10 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 
 	httpserver "github.com/VerteraIO/vertera/internal/http"
+	"github.com/VerteraIO/vertera/internal/controlplane/ha"
 	"github.com/VerteraIO/vertera/internal/controlplane/reconciler"
 	"github.com/VerteraIO/vertera/internal/controlplane/scheduler"
 	"github.com/VerteraIO/vertera/internal/controlplane/stores"
@@ -12,15 +14,27 @@ import (
 
 func main() {
 	// Initialize control plane components
-	st := stores.New()
+	// The HTTP and gRPC handlers share the process-wide defaults.
+	st := stores.Default
 	st.Start()
+	// Seed the artifact registry hosts install packages from
+	if path := os.Getenv("VERTERA_ARTIFACTS_FILE"); path != "" {
+		n, err := st.LoadArtifacts(path)
+		if err != nil {
+			log.Fatalf("load artifacts: %v", err)
+		}
+		log.Printf("artifact registry: loaded %d artifact(s) from %s", n, path)
+	}
 
-	sch := scheduler.New()
+	sch := scheduler.Default
 	go sch.Start()
 
-	rec := reconciler.New()
+	rec := reconciler.New(st)
 	go rec.Start()
 
+	// Fail over hosts that stop heartbeating
+	go ha.Default.Start()
+
 	// Start HTTP server
 	srv := httpserver.NewServer()
 	addr := ":8080" // TODO: switch to :8443 with TLS when certs are wired
@@ -0,0 +1,213 @@
+package collector
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Host collects the host's CPU, memory (including hugepage pools), kernel and
+// OS details from procfs/sysfs, plus the size of the filesystem holding VM
+// disks. The result follows the Inventory API schema.
+type Host struct {
+	// Root is prepended to every path read; empty means "/". Used by tests.
+	Root string
+	// StoragePath is where VM disks are created; omitted from the inventory when empty.
+	StoragePath string
+}
+
+func (h *Host) Name() string { return "host" }
+
+func (h *Host) Collect() (map[string]any, error) {
+	cpu, err := h.cpu()
+	if err != nil {
+		return nil, err
+	}
+	mem, err := h.memory()
+	if err != nil {
+		return nil, err
+	}
+	inv := map[string]any{
+		"cpu":         cpu,
+		"memory":      mem,
+		"collectedAt": time.Now().UTC(),
+	}
+	if rel, err := os.ReadFile(h.path("proc/sys/kernel/osrelease")); err == nil {
+		inv["kernel"] = map[string]any{"release": strings.TrimSpace(string(rel))}
+	}
+	if osr, err := h.osRelease(); err == nil {
+		inv["os"] = osr
+	}
+	if st, err := h.storage(); err == nil {
+		inv["storage"] = st
+	}
+	return inv, nil
+}
+
+func (h *Host) path(p string) string {
+	root := h.Root
+	if root == "" {
+		root = "/"
+	}
+	return filepath.Join(root, p)
+}
+
+// cpu summarises /proc/cpuinfo into sockets, cores per socket and threads per core.
+func (h *Host) cpu() (map[string]any, error) {
+	f, err := os.Open(h.path("proc/cpuinfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var (
+		model             string
+		flags             []string
+		logical           int
+		coresPerSocket    int
+		siblingsPerSocket int
+		sockets           = map[string]struct{}{}
+	)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		key, val, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		switch key {
+		case "processor":
+			logical++
+		case "physical id":
+			sockets[val] = struct{}{}
+		case "cpu cores":
+			coresPerSocket, _ = strconv.Atoi(val)
+		case "siblings":
+			siblingsPerSocket, _ = strconv.Atoi(val)
+		case "model name":
+			model = val
+		case "flags", "Features":
+			if flags == nil {
+				flags = strings.Fields(val)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	nSockets := len(sockets)
+	if nSockets == 0 {
+		nSockets = 1
+	}
+	if coresPerSocket == 0 {
+		// No topology information (e.g. some ARM kernels): one thread per core.
+		coresPerSocket = logical / nSockets
+	}
+	threads := 1
+	if coresPerSocket > 0 && siblingsPerSocket > coresPerSocket {
+		threads = siblingsPerSocket / coresPerSocket
+	}
+	return map[string]any{
+		"sockets": nSockets,
+		"cores":   coresPerSocket,
+		"threads": threads,
+		"model":   model,
+		"flags":   flags,
+	}, nil
+}
+
+// memory reads MemTotal and the hugepage pools of every supported page size.
+func (h *Host) memory() (map[string]any, error) {
+	f, err := os.Open(h.path("proc/meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var total int64
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kib, _ := strconv.ParseInt(fields[1], 10, 64)
+			total = kib << 10
+			break
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	mem := map[string]any{"total": total}
+
+	dirs, _ := filepath.Glob(h.path("sys/kernel/mm/hugepages/hugepages-*kB"))
+	pools := map[string]any{}
+	for _, dir := range dirs {
+		size := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(dir), "hugepages-"), "kB")
+		pools[size] = map[string]any{
+			"total": readInt(filepath.Join(dir, "nr_hugepages")),
+			"free":  readInt(filepath.Join(dir, "free_hugepages")),
+		}
+	}
+	if len(pools) > 0 {
+		mem["hugepages"] = pools
+	}
+	return mem, nil
+}
+
+// osRelease returns the identifying fields of /etc/os-release.
+func (h *Host) osRelease() (map[string]any, error) {
+	data, err := os.ReadFile(h.path("etc/os-release"))
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]any{}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, val, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "ID", "ID_LIKE", "VERSION_ID", "PRETTY_NAME":
+			out[strings.ToLower(key)] = strings.Trim(val, `"'`)
+		}
+	}
+	return out, nil
+}
+
+// storage reports the size of the filesystem holding StoragePath. The path
+// does not need to exist yet; its closest existing parent is used.
+func (h *Host) storage() (map[string]any, error) {
+	if h.StoragePath == "" {
+		return nil, os.ErrNotExist
+	}
+	p := h.path(h.StoragePath)
+	var fs syscall.Statfs_t
+	for {
+		err := syscall.Statfs(p, &fs)
+		if err == nil {
+			break
+		}
+		parent := filepath.Dir(p)
+		if !os.IsNotExist(err) || parent == p {
+			return nil, err
+		}
+		p = parent
+	}
+	return map[string]any{
+		"path":  h.StoragePath,
+		"total": int64(fs.Blocks) * int64(fs.Bsize),
+		"free":  int64(fs.Bavail) * int64(fs.Bsize),
+	}, nil
+}
+
+func readInt(path string) int64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return n
+}
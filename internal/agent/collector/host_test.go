@@ -0,0 +1,73 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFile(t *testing.T, root, path, data string) {
+	t.Helper()
+	full := filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHostCollect(t *testing.T) {
+	root := t.TempDir()
+	// Two sockets, two cores per socket, two threads per core.
+	cpuinfo := ""
+	for i := 0; i < 8; i++ {
+		socket := "0"
+		if i >= 4 {
+			socket = "1"
+		}
+		cpuinfo += "processor\t: " + string(rune('0'+i)) + "\n" +
+			"model name\t: Test CPU\n" +
+			"physical id\t: " + socket + "\n" +
+			"siblings\t: 4\n" +
+			"cpu cores\t: 2\n" +
+			"flags\t\t: fpu vmx avx2\n\n"
+	}
+	writeFile(t, root, "proc/cpuinfo", cpuinfo)
+	writeFile(t, root, "proc/meminfo", "MemTotal:       16384 kB\nMemFree:         1024 kB\n")
+	writeFile(t, root, "sys/kernel/mm/hugepages/hugepages-2048kB/nr_hugepages", "512\n")
+	writeFile(t, root, "sys/kernel/mm/hugepages/hugepages-2048kB/free_hugepages", "100\n")
+	writeFile(t, root, "proc/sys/kernel/osrelease", "5.14.0-test\n")
+	writeFile(t, root, "etc/os-release", "NAME=\"Test Linux\"\nID=\"rocky\"\nVERSION_ID=\"9.4\"\n")
+
+	inv, err := (&Host{Root: root, StoragePath: "/var/lib/vertera/vms"}).Collect()
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	cpu := inv["cpu"].(map[string]any)
+	if cpu["sockets"] != 2 || cpu["cores"] != 2 || cpu["threads"] != 2 || cpu["model"] != "Test CPU" {
+		t.Fatalf("cpu = %v", cpu)
+	}
+	if !reflect.DeepEqual(cpu["flags"], []string{"fpu", "vmx", "avx2"}) {
+		t.Fatalf("flags = %v", cpu["flags"])
+	}
+	mem := inv["memory"].(map[string]any)
+	if mem["total"] != int64(16384<<10) {
+		t.Fatalf("memory total = %v", mem["total"])
+	}
+	pool := mem["hugepages"].(map[string]any)["2048"].(map[string]any)
+	if pool["total"] != int64(512) || pool["free"] != int64(100) {
+		t.Fatalf("hugepages = %v", pool)
+	}
+	if inv["kernel"].(map[string]any)["release"] != "5.14.0-test" {
+		t.Fatalf("kernel = %v", inv["kernel"])
+	}
+	if osr := inv["os"].(map[string]any); osr["id"] != "rocky" || osr["version_id"] != "9.4" {
+		t.Fatalf("os = %v", osr)
+	}
+	// The storage path does not exist yet, so the root's filesystem is reported.
+	if st := inv["storage"].(map[string]any); st["path"] != "/var/lib/vertera/vms" || st["total"].(int64) <= 0 {
+		t.Fatalf("storage = %v", st)
+	}
+}
@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VerteraIO/vertera/internal/agent/runtime"
+	"github.com/VerteraIO/vertera/internal/agent/supervisor"
+)
+
+// userHZ is the unit of the CPU times in /proc/<pid>/stat, fixed at 100 by
+// the kernel ABI.
+const userHZ = 100
+
+// VMMList lists a host's VMMs and hands out their API clients.
+// Implemented by supervisor.Supervisor.
+type VMMList interface {
+	List() []supervisor.Info
+	Client(vmID string) (runtime.CloudHypervisor, error)
+}
+
+// VMs collects the resource use of every VM on the host: the CPU time and
+// resident memory of its VMM process from procfs and Cloud Hypervisor's
+// per-device counters. Each sample follows the VmMetricsSample API schema.
+type VMs struct {
+	// Root is prepended to every path read; empty means "/". Used by tests.
+	Root string
+	VMMs VMMList
+	// Timeout bounds each counters request; zero means 5s.
+	Timeout time.Duration
+}
+
+func (v *VMs) Name() string { return "vms" }
+
+// Collect returns the samples under "vms". VMMs whose process is gone are
+// skipped; a VMM without a VM yields a sample without counters.
+func (v *VMs) Collect() (map[string]any, error) {
+	h := &Host{Root: v.Root}
+	samples := []map[string]any{}
+	for _, info := range v.VMMs.List() {
+		cpu, rss, err := h.process(info.PID)
+		if err != nil {
+			continue
+		}
+		sample := map[string]any{
+			"vmId":           info.VMID,
+			"collectedAt":    time.Now().UTC(),
+			"cpuSeconds":     cpu,
+			"memoryRssBytes": rss,
+		}
+		if counters, err := v.counters(info.VMID); err == nil {
+			sample["counters"] = counters
+		}
+		samples = append(samples, sample)
+	}
+	return map[string]any{"vms": samples}, nil
+}
+
+func (v *VMs) counters(vmID string) (map[string]map[string]int64, error) {
+	c, err := v.VMMs.Client(vmID)
+	if err != nil {
+		return nil, err
+	}
+	timeout := v.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.Counters(ctx)
+}
+
+// process reads a process's user plus system CPU time in seconds from
+// /proc/<pid>/stat and its resident memory in bytes from /proc/<pid>/status.
+func (h *Host) process(pid int) (float64, int64, error) {
+	dir := "proc/" + strconv.Itoa(pid)
+	stat, err := os.ReadFile(h.path(dir + "/stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+	// The command name may contain spaces and parentheses; the fields
+	// after it start with the state (field 3), utime and stime are 14 and 15.
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("parse %s/stat", dir)
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 13 {
+		return 0, 0, fmt.Errorf("parse %s/stat", dir)
+	}
+	utime, err1 := strconv.ParseUint(fields[11], 10, 64)
+	stime, err2 := strconv.ParseUint(fields[12], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("parse %s/stat", dir)
+	}
+	status, err := os.ReadFile(h.path(dir + "/status"))
+	if err != nil {
+		return 0, 0, err
+	}
+	var rss int64
+	for _, line := range strings.Split(string(status), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "VmRSS:" {
+			kib, _ := strconv.ParseInt(fields[1], 10, 64)
+			rss = kib << 10
+			break
+		}
+	}
+	return float64(utime+stime) / userHZ, rss, nil
+}
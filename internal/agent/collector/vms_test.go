@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
+
+	"github.com/VerteraIO/vertera/internal/agent/runtime"
+	"github.com/VerteraIO/vertera/internal/agent/supervisor"
+	"github.com/VerteraIO/vertera/internal/hypervisor"
+	"github.com/VerteraIO/vertera/internal/hypervisor/chfake"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+type fakeVMMs struct {
+	infos   []supervisor.Info
+	clients map[string]runtime.CloudHypervisor
+}
+
+func (f *fakeVMMs) List() []supervisor.Info { return f.infos }
+
+func (f *fakeVMMs) Client(vmID string) (runtime.CloudHypervisor, error) {
+	if c, ok := f.clients[vmID]; ok {
+		return c, nil
+	}
+	return nil, supervisor.ErrUnknownVM
+}
+
+func TestVMsCollect(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "proc/41/stat", "41 (cloud-hyper (x)) S 1 41 41 0 -1 4194560 100 0 0 0 250 150 0 0 20 0 3 0 100\n")
+	writeFile(t, root, "proc/41/status", "Name:\tcloud-hypervisor\nVmRSS:\t  524288 kB\nThreads:\t3\n")
+	writeFile(t, root, "proc/42/stat", "42 (cloud-hypervisor) S 1 42 42 0 -1 4194560 100 0 0 0 10 5 0 0 20 0 3 0 100\n")
+	writeFile(t, root, "proc/42/status", "VmRSS:\t  1024 kB\n")
+
+	sock := filepath.Join(t.TempDir(), "api.sock")
+	fake, err := chfake.Start(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	c, err := hypervisor.NewCloudHypervisorClient(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	spec := vmspec.Spec{ID: "vm-a", Vcpus: 1, MemoryMiB: 512}
+	if err := c.CreateVM(ctx, spec.VmConfig(vmspec.Layout{Firmware: "/fw"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.BootVM(ctx); err != nil {
+		t.Fatal(err)
+	}
+	fake.SetCounters(ch.VmCounters{"disk0": {"read_bytes": 4096}})
+
+	vmms := &fakeVMMs{
+		infos: []supervisor.Info{{VMID: "vm-a", PID: 41}, {VMID: "vm-b", PID: 42}, {VMID: "vm-gone", PID: 43}},
+		// vm-b's VMM has no API client: its sample carries no counters.
+		clients: map[string]runtime.CloudHypervisor{"vm-a": c},
+	}
+	out, err := (&VMs{Root: root, VMMs: vmms}).Collect()
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	samples := out["vms"].([]map[string]any)
+	if len(samples) != 2 {
+		t.Fatalf("expected the VMMs with a process, got %v", samples)
+	}
+	a := samples[0]
+	if a["vmId"] != "vm-a" || a["cpuSeconds"] != 4.0 || a["memoryRssBytes"] != int64(512<<20) {
+		t.Fatalf("vm-a = %v", a)
+	}
+	if counters := a["counters"].(map[string]map[string]int64); counters["disk0"]["read_bytes"] != 4096 {
+		t.Fatalf("vm-a counters = %v", counters)
+	}
+	if b := samples[1]; b["vmId"] != "vm-b" || b["cpuSeconds"] != 0.15 || b["counters"] != nil {
+		t.Fatalf("vm-b = %v", b)
+	}
+}
@@ -0,0 +1,247 @@
+// Package console attaches to the serial consoles of the host's VMs. Cloud
+// Hypervisor serves each VM's serial port on a unix socket that takes one
+// client at a time; the agent keeps that connection, remembers the most
+// recent output and fans it out to the viewers attached through the
+// controller.
+package console
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultHistorySize is how much recent output is kept per VM.
+const DefaultHistorySize = 64 << 10
+
+// watchTimeout bounds how long Watch waits for a freshly started VMM to
+// create its serial socket.
+const watchTimeout = 10 * time.Second
+
+// viewerBuffer is how many reads a viewer may fall behind before it is
+// disconnected; a stalled viewer must not hold up the others.
+const viewerBuffer = 64
+
+// ErrClosed is returned when writing to a console that is gone.
+var ErrClosed = errors.New("console closed")
+
+// Manager keeps one connection per VM console.
+type Manager struct {
+	socketPath  func(vmID string) string
+	historySize int
+
+	mu       sync.Mutex
+	consoles map[string]*console
+	// ended keeps the output of consoles whose VMM went away, for crash
+	// reports, until the VM's console is connected again or forgotten.
+	ended map[string]*Ring
+}
+
+type console struct {
+	conn    net.Conn
+	history *Ring
+	viewers map[*Viewer]struct{}
+}
+
+// NewManager returns a manager that finds the serial socket of a VM with
+// socketPath and keeps historySize bytes of output per VM.
+func NewManager(socketPath func(vmID string) string, historySize int) *Manager {
+	if historySize <= 0 {
+		historySize = DefaultHistorySize
+	}
+	return &Manager{socketPath: socketPath, historySize: historySize, consoles: make(map[string]*console), ended: make(map[string]*Ring)}
+}
+
+// Watch connects to the console of a VM whose VMM was just started, so its
+// boot output is kept for later viewers. It returns at once; the connection
+// is retried in the background until the socket appears.
+func (m *Manager) Watch(vmID string) {
+	go func() {
+		deadline := time.Now().Add(watchTimeout)
+		for {
+			_, err := m.connect(vmID)
+			if err == nil || time.Now().After(deadline) {
+				return
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+	}()
+}
+
+// Attach returns a new viewer of a VM's console, connecting to it first if
+// needed.
+func (m *Manager) Attach(vmID string) (*Viewer, error) {
+	if _, err := m.connect(vmID); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.consoles[vmID]
+	if !ok {
+		// The VMM went away right after we connected.
+		return nil, fmt.Errorf("console of vm %s: %w", vmID, ErrClosed)
+	}
+	v := &Viewer{History: c.history.Bytes(), m: m, c: c, out: make(chan []byte, viewerBuffer)}
+	c.viewers[v] = struct{}{}
+	return v, nil
+}
+
+// LastOutput returns the most recent output of a VM's console, also after
+// its VMM went away. It is empty if the console was never connected.
+func (m *Manager) LastOutput(vmID string) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.consoles[vmID]; ok {
+		return c.history.Bytes()
+	}
+	if r, ok := m.ended[vmID]; ok {
+		return r.Bytes()
+	}
+	return nil
+}
+
+// Forget drops the output kept of a VM whose VMM went away, e.g. once the VM
+// is deleted.
+func (m *Manager) Forget(vmID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.ended, vmID)
+}
+
+// connect returns the VM's console, dialing its socket if there is no
+// connection yet.
+func (m *Manager) connect(vmID string) (*console, error) {
+	m.mu.Lock()
+	c, ok := m.consoles[vmID]
+	m.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+	conn, err := net.Dial("unix", m.socketPath(vmID))
+	if err != nil {
+		return nil, fmt.Errorf("connect to console of vm %s: %w", vmID, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.consoles[vmID]; ok {
+		conn.Close()
+		return c, nil
+	}
+	c = &console{conn: conn, history: NewRing(m.historySize), viewers: make(map[*Viewer]struct{})}
+	m.consoles[vmID] = c
+	delete(m.ended, vmID)
+	go m.read(vmID, c)
+	return c, nil
+}
+
+// read copies console output into the history and to the viewers until the
+// VMM closes the socket.
+func (m *Manager) read(vmID string, c *console) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.conn.Read(buf)
+		if n > 0 {
+			data := append([]byte(nil), buf[:n]...)
+			m.mu.Lock()
+			c.history.Write(data)
+			for v := range c.viewers {
+				select {
+				case v.out <- data:
+				default:
+					delete(c.viewers, v)
+					close(v.out)
+				}
+			}
+			m.mu.Unlock()
+		}
+		if err != nil {
+			break
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c.conn.Close()
+	if m.consoles[vmID] == c {
+		delete(m.consoles, vmID)
+		m.ended[vmID] = c.history
+	}
+	for v := range c.viewers {
+		delete(c.viewers, v)
+		close(v.out)
+	}
+}
+
+// Viewer is one attachment to a console.
+type Viewer struct {
+	// History is the output kept from before the viewer attached.
+	History []byte
+
+	m   *Manager
+	c   *console
+	out chan []byte
+}
+
+// Output delivers the console's output. It is closed when the console goes
+// away, the viewer falls too far behind or Close is called.
+func (v *Viewer) Output() <-chan []byte { return v.out }
+
+// Write sends input to the VM's serial port.
+func (v *Viewer) Write(p []byte) (int, error) {
+	v.m.mu.Lock()
+	_, ok := v.c.viewers[v]
+	v.m.mu.Unlock()
+	if !ok {
+		return 0, ErrClosed
+	}
+	return v.c.conn.Write(p)
+}
+
+// Close detaches the viewer. The console connection stays open.
+func (v *Viewer) Close() {
+	v.m.mu.Lock()
+	defer v.m.mu.Unlock()
+	if _, ok := v.c.viewers[v]; ok {
+		delete(v.c.viewers, v)
+		close(v.out)
+	}
+}
+
+// Ring keeps the last bytes written to it.
+type Ring struct {
+	buf  []byte
+	pos  int
+	full bool
+}
+
+// NewRing returns a ring holding up to size bytes.
+func NewRing(size int) *Ring {
+	return &Ring{buf: make([]byte, size)}
+}
+
+// Write appends p, dropping the oldest bytes once the ring is full.
+func (r *Ring) Write(p []byte) {
+	if len(p) >= len(r.buf) {
+		copy(r.buf, p[len(p)-len(r.buf):])
+		r.pos, r.full = 0, true
+		return
+	}
+	n := copy(r.buf[r.pos:], p)
+	if n < len(p) {
+		copy(r.buf, p[n:])
+		r.full = true
+	}
+	r.pos = (r.pos + len(p)) % len(r.buf)
+	if r.pos == 0 && len(p) > 0 {
+		r.full = true
+	}
+}
+
+// Bytes returns a copy of the ring's contents, oldest first.
+func (r *Ring) Bytes() []byte {
+	if !r.full {
+		return append([]byte(nil), r.buf[:r.pos]...)
+	}
+	return append(append([]byte(nil), r.buf[r.pos:]...), r.buf[:r.pos]...)
+}
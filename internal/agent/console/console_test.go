@@ -0,0 +1,145 @@
+package console
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRing(t *testing.T) {
+	r := NewRing(8)
+	r.Write([]byte("abc"))
+	if got := string(r.Bytes()); got != "abc" {
+		t.Fatalf("got %q", got)
+	}
+	r.Write([]byte("defgh"))
+	r.Write([]byte("ij"))
+	if got := string(r.Bytes()); got != "cdefghij" {
+		t.Fatalf("got %q after wrapping", got)
+	}
+	r.Write([]byte("0123456789"))
+	if got := string(r.Bytes()); got != "23456789" {
+		t.Fatalf("got %q after an oversized write", got)
+	}
+}
+
+// fakeSerial plays Cloud Hypervisor's serial socket: it accepts one client,
+// records its input and sends what is written to out.
+type fakeSerial struct {
+	path  string
+	ln    net.Listener
+	conns chan net.Conn
+}
+
+func newFakeSerial(t *testing.T) *fakeSerial {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "serial.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeSerial{path: path, ln: ln, conns: make(chan net.Conn, 1)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.conns <- c
+		}
+	}()
+	return s
+}
+
+func recv(t *testing.T, v *Viewer) string {
+	t.Helper()
+	select {
+	case data, ok := <-v.Output():
+		if !ok {
+			return ""
+		}
+		return string(data)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no console output")
+		return ""
+	}
+}
+
+func TestManager(t *testing.T) {
+	serial := newFakeSerial(t)
+	m := NewManager(func(vmID string) string {
+		if vmID != "vm-1" {
+			return filepath.Join(t.TempDir(), "missing.sock")
+		}
+		return serial.path
+	}, 16)
+	if _, err := m.Attach("vm-2"); err == nil {
+		t.Fatal("expected attaching to a vm without a console to fail")
+	}
+
+	// Output printed before anyone attached is kept
+	m.Watch("vm-1")
+	conn := <-serial.conns
+	defer conn.Close()
+	_, _ = conn.Write([]byte("booting... login: "))
+	var a *Viewer
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var err error
+		if a, err = m.Attach("vm-1"); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(string(a.History), "login: ") || time.Now().After(deadline) {
+			break
+		}
+		a.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if string(a.History) != "oting... login: " {
+		t.Fatalf("unexpected history %q", a.History)
+	}
+
+	// Output goes to every viewer and input reaches the serial port
+	b, err := m.Attach("vm-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Write([]byte("root\n")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 5)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(got); err != nil || !bytes.Equal(got, []byte("root\n")) {
+		t.Fatalf("serial port got %q, %v", got, err)
+	}
+	_, _ = conn.Write([]byte("Password: "))
+	if got := recv(t, a); got != "Password: " {
+		t.Fatalf("viewer a got %q", got)
+	}
+	if got := recv(t, b); got != "Password: " {
+		t.Fatalf("viewer b got %q", got)
+	}
+
+	// Closing one viewer leaves the other; the VMM going away ends both
+	a.Close()
+	if _, err := a.Write([]byte("x")); err != ErrClosed {
+		t.Fatalf("expected write to a closed viewer to fail, got %v", err)
+	}
+	conn.Close()
+	if got := recv(t, b); got != "" {
+		t.Fatalf("expected output to end, got %q", got)
+	}
+
+	// The output outlives the console until the VM is forgotten
+	if got := string(m.LastOutput("vm-1")); got != "ogin: Password: " {
+		t.Fatalf("unexpected last output %q", got)
+	}
+	m.Forget("vm-1")
+	if got := m.LastOutput("vm-1"); got != nil {
+		t.Fatalf("expected forgotten output, got %q", got)
+	}
+}
@@ -0,0 +1,77 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/VerteraIO/vertera/internal/agent/runtime"
+	"github.com/VerteraIO/vertera/internal/cloudinit"
+	"github.com/VerteraIO/vertera/internal/hypervisor"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+// UpdateCloudInit replaces a VM's cloud-init data and regenerates its seed
+// image; the guest sees the change the next time cloud-init runs. When the
+// VM gains or loses its cloud-init data the seed disk is hot-plugged into or
+// removed from a VM that exists in its VMM.
+type UpdateCloudInit struct {
+	VMMs      runtime.VMMs
+	VMID      string
+	CloudInit *vmspec.CloudInit
+}
+
+func (u *UpdateCloudInit) Name() string { return "update-vm-cloud-init" }
+
+func (u *UpdateCloudInit) Run() error {
+	ctx := context.Background()
+	dir := u.VMMs.Dir(u.VMID)
+	spec, err := vmspec.Load(dir)
+	if err != nil {
+		return fmt.Errorf("load vm spec: %w", err)
+	}
+	had := spec.CloudInit != nil
+	spec.CloudInit = u.CloudInit
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	layout := vmLayout(u.VMMs, u.VMID, "")
+	if spec.CloudInit != nil {
+		if err := writeSeed(spec, layout); err != nil {
+			return err
+		}
+	}
+	if c, err := u.VMMs.Client(u.VMID); err == nil && had != (spec.CloudInit != nil) {
+		if had {
+			err = c.RemoveDevice(ctx, vmspec.SeedDiskID)
+		} else {
+			_, err = c.AddDisk(ctx, vmspec.SeedDiskConfig(layout))
+		}
+		if err != nil && !errors.Is(err, hypervisor.ErrVMNotCreated) {
+			return fmt.Errorf("update seed disk: %w", err)
+		}
+	}
+	if err := spec.Save(dir); err != nil {
+		return fmt.Errorf("save vm spec: %w", err)
+	}
+	if spec.CloudInit == nil {
+		return cloudinit.RemoveSeed(layout.SeedPath())
+	}
+	return nil
+}
+
+// writeSeed brings a VM's cloud-init seed image in line with its spec.
+func writeSeed(spec *vmspec.Spec, layout vmspec.Layout) error {
+	if spec.CloudInit == nil {
+		return cloudinit.RemoveSeed(layout.SeedPath())
+	}
+	files := cloudinit.Files{
+		UserData:      spec.CloudInit.UserData,
+		MetaData:      spec.CloudInit.MetaData,
+		NetworkConfig: spec.CloudInit.NetworkConfig,
+	}
+	if files.MetaData == "" {
+		files.MetaData = cloudinit.MetaData(spec.ID, spec.Name)
+	}
+	return cloudinit.WriteSeed(layout.SeedPath(), files)
+}
@@ -0,0 +1,63 @@
+package executor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/cloudinit"
+	"github.com/VerteraIO/vertera/internal/network"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+func TestCloudInitSeed(t *testing.T) {
+	vmms := newFakeVMMs(t)
+	ovs := &fakeOVS{ports: map[string]network.PortSpec{}}
+	spec := testSpec()
+	spec.CloudInit = &vmspec.CloudInit{UserData: "#cloud-config\n"}
+	if err := (&CreateVM{VMMs: vmms, OVS: ovs, Spec: spec, Firmware: "/fw"}).Run(); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	seed := filepath.Join(vmms.Dir(spec.ID), "cidata.iso")
+	hasSeedDisk := func() bool {
+		for _, d := range *vmms.fake(spec.ID).Config().Disks {
+			if *d.Id == vmspec.SeedDiskID {
+				return *d.Path == seed && *d.Readonly
+			}
+		}
+		return false
+	}
+	got, err := os.ReadFile(seed)
+	want := cloudinit.Image(cloudinit.Files{MetaData: cloudinit.MetaData(spec.ID, spec.Name), UserData: "#cloud-config\n"})
+	if err != nil || !bytes.Equal(got, want) || !hasSeedDisk() {
+		t.Fatalf("seed not written or attached: %v", err)
+	}
+
+	// Changed data regenerates the seed
+	update := &UpdateCloudInit{VMMs: vmms, VMID: spec.ID, CloudInit: &vmspec.CloudInit{UserData: "#cloud-config\n", MetaData: "instance-id: i-2\n"}}
+	if err := update.Run(); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	got, _ = os.ReadFile(seed)
+	if !bytes.Equal(got, cloudinit.Image(cloudinit.Files{MetaData: "instance-id: i-2\n", UserData: "#cloud-config\n"})) {
+		t.Fatal("seed not regenerated")
+	}
+	if saved, _ := vmspec.Load(vmms.Dir(spec.ID)); saved.CloudInit.MetaData != "instance-id: i-2\n" {
+		t.Fatalf("spec not updated: %+v", saved.CloudInit)
+	}
+
+	// Dropping the data unplugs and removes the seed; adding it plugs it back
+	if err := (&UpdateCloudInit{VMMs: vmms, VMID: spec.ID}).Run(); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := os.Stat(seed); !os.IsNotExist(err) || hasSeedDisk() {
+		t.Fatalf("seed left behind: %v", err)
+	}
+	if err := update.Run(); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if !hasSeedDisk() {
+		t.Fatal("seed disk not hot-plugged")
+	}
+}
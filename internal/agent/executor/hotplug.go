@@ -0,0 +1,222 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/VerteraIO/vertera/internal/agent/runtime"
+	"github.com/VerteraIO/vertera/internal/hypervisor"
+	"github.com/VerteraIO/vertera/internal/network"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+// AttachDevice adds a disk or a NIC, exactly one of which is set, to a VM's
+// persisted spec, creates the disk or plugs the NIC's port into its DVS
+// bridge, and hot-plugs the device into a VM that exists in its VMM. A VM
+// without one picks the device up from the spec when it next boots. Running
+// it again for a device the spec already has finishes an earlier attempt.
+type AttachDevice struct {
+	VMMs       runtime.VMMs
+	OVS        runtime.OpenvSwitch
+	Images     runtime.ImageCache
+	DiskImages runtime.DiskImages
+	VMID       string
+	Disk       *vmspec.Disk
+	Nic        *vmspec.Nic
+}
+
+func (a *AttachDevice) Name() string { return "attach-vm-device" }
+
+func (a *AttachDevice) Run() error {
+	if (a.Disk == nil) == (a.Nic == nil) {
+		return errors.New("attach exactly one of a disk or a nic")
+	}
+	ctx := context.Background()
+	dir := a.VMMs.Dir(a.VMID)
+	spec, err := vmspec.Load(dir)
+	if err != nil {
+		return fmt.Errorf("load vm spec: %w", err)
+	}
+	layout := vmLayout(a.VMMs, a.VMID, "")
+	var id string
+	if a.Disk != nil {
+		id = a.Disk.ID
+		if !hasSpecDevice(spec, id) {
+			spec.Disks = append(spec.Disks, *a.Disk)
+		}
+	} else {
+		id = a.Nic.ID
+		if !hasSpecDevice(spec, id) {
+			spec.Nics = append(spec.Nics, *a.Nic)
+			setVhostSockets(spec, layout)
+		}
+	}
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	// Prepare the backing file or port, then plug the device; undo the
+	// preparation if the VM refuses the device so nothing is left behind.
+	var cfgErr error
+	if d := specDisk(spec, id); d != nil {
+		_, statErr := os.Stat(layout.DiskPath(*d))
+		created := statErr != nil
+		if err := os.MkdirAll(layout.VolumeDir, 0o750); err != nil {
+			return fmt.Errorf("create volume dir: %w", err)
+		}
+		if err := provisionDisk(ctx, a.Images, a.DiskImages, layout, *d); err != nil {
+			return err
+		}
+		cfgErr = hotplug(ctx, a.VMMs, a.VMID, id, func(c runtime.CloudHypervisor) error {
+			_, err := c.AddDisk(ctx, vmspec.DiskConfig(*d, layout))
+			return err
+		})
+		if cfgErr != nil && created {
+			_ = os.Remove(layout.DiskPath(*d))
+		}
+	} else {
+		n := specNic(spec, id)
+		ports := []network.PortSpec{n.Port}
+		if err := (&PlugPorts{OVS: a.OVS, Ports: ports}).Run(); err != nil {
+			return err
+		}
+		cfgErr = hotplug(ctx, a.VMMs, a.VMID, id, func(c runtime.CloudHypervisor) error {
+			_, err := c.AddNet(ctx, vmspec.NetConfig(*n, layout))
+			return err
+		})
+		if cfgErr != nil {
+			_ = (&UnplugPorts{OVS: a.OVS, Ports: ports}).Run()
+		}
+	}
+	if cfgErr != nil {
+		return fmt.Errorf("hot-plug %s: %w", id, cfgErr)
+	}
+	if err := spec.Save(dir); err != nil {
+		return fmt.Errorf("save vm spec: %w", err)
+	}
+	return nil
+}
+
+// DetachDevice unplugs a disk or NIC from a VM that exists in its VMM, drops
+// it from the VM's persisted spec and deletes the disk's file or the NIC's
+// port. A device the spec no longer has is already detached.
+type DetachDevice struct {
+	VMMs     runtime.VMMs
+	OVS      runtime.OpenvSwitch
+	VMID     string
+	DeviceID string
+}
+
+func (d *DetachDevice) Name() string { return "detach-vm-device" }
+
+func (d *DetachDevice) Run() error {
+	ctx := context.Background()
+	dir := d.VMMs.Dir(d.VMID)
+	spec, err := vmspec.Load(dir)
+	if err != nil {
+		return fmt.Errorf("load vm spec: %w", err)
+	}
+	if !hasSpecDevice(spec, d.DeviceID) {
+		return nil
+	}
+	if c, err := d.VMMs.Client(d.VMID); err == nil {
+		present, err := vmHasDevice(ctx, c, d.DeviceID)
+		if err == nil && present {
+			err = c.RemoveDevice(ctx, d.DeviceID)
+		}
+		if err != nil && !errors.Is(err, hypervisor.ErrVMNotCreated) {
+			return fmt.Errorf("unplug %s: %w", d.DeviceID, err)
+		}
+	}
+
+	layout := vmLayout(d.VMMs, d.VMID, "")
+	disk, nic := specDisk(spec, d.DeviceID), specNic(spec, d.DeviceID)
+	disks := spec.Disks[:0:0]
+	for _, x := range spec.Disks {
+		if x.ID != d.DeviceID {
+			disks = append(disks, x)
+		}
+	}
+	nics := spec.Nics[:0:0]
+	for _, x := range spec.Nics {
+		if x.ID != d.DeviceID {
+			nics = append(nics, x)
+		}
+	}
+	spec.Disks, spec.Nics = disks, nics
+	if err := spec.Save(dir); err != nil {
+		return fmt.Errorf("save vm spec: %w", err)
+	}
+	if disk != nil {
+		if err := os.Remove(layout.DiskPath(*disk)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove disk %s: %w", disk.ID, err)
+		}
+		return nil
+	}
+	return (&UnplugPorts{OVS: d.OVS, Ports: []network.PortSpec{nic.Port}}).Run()
+}
+
+// hotplug runs add against the VM's VMM unless there is no VMM, the VM is not
+// created in it, or it already has the device.
+func hotplug(ctx context.Context, vmms runtime.VMMs, vmID, id string, add func(runtime.CloudHypervisor) error) error {
+	c, err := vmms.Client(vmID)
+	if err != nil {
+		return nil
+	}
+	present, err := vmHasDevice(ctx, c, id)
+	if err == nil && !present {
+		err = add(c)
+	}
+	if errors.Is(err, hypervisor.ErrVMNotCreated) {
+		return nil
+	}
+	return err
+}
+
+// vmHasDevice reports whether the VM's running config has a disk or NIC with
+// the given id.
+func vmHasDevice(ctx context.Context, c runtime.CloudHypervisor, id string) (bool, error) {
+	info, err := c.GetVMInfo(ctx)
+	if err != nil {
+		return false, err
+	}
+	if info.Config.Disks != nil {
+		for _, d := range *info.Config.Disks {
+			if d.Id != nil && *d.Id == id {
+				return true, nil
+			}
+		}
+	}
+	if info.Config.Net != nil {
+		for _, n := range *info.Config.Net {
+			if n.Id != nil && *n.Id == id {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func hasSpecDevice(spec *vmspec.Spec, id string) bool {
+	return specDisk(spec, id) != nil || specNic(spec, id) != nil
+}
+
+func specDisk(spec *vmspec.Spec, id string) *vmspec.Disk {
+	for i := range spec.Disks {
+		if spec.Disks[i].ID == id {
+			return &spec.Disks[i]
+		}
+	}
+	return nil
+}
+
+func specNic(spec *vmspec.Spec, id string) *vmspec.Nic {
+	for i := range spec.Nics {
+		if spec.Nics[i].ID == id {
+			return &spec.Nics[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/network"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+func TestHotplug(t *testing.T) {
+	vmms := newFakeVMMs(t)
+	ovs := &fakeOVS{ports: map[string]network.PortSpec{}}
+	spec := testSpec()
+	if err := (&CreateVM{VMMs: vmms, OVS: ovs, Spec: spec, Firmware: "/fw"}).Run(); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	fake := vmms.fake(spec.ID)
+	hasDevice := func(id string) bool {
+		cfg := fake.Config()
+		for _, d := range *cfg.Disks {
+			if *d.Id == id {
+				return true
+			}
+		}
+		for _, n := range *cfg.Net {
+			if *n.Id == id {
+				return true
+			}
+		}
+		return false
+	}
+	inSpec := func(id string) bool {
+		saved, err := vmspec.Load(vmms.Dir(spec.ID))
+		return err == nil && hasSpecDevice(saved, id)
+	}
+
+	disk := &vmspec.Disk{ID: "disk1", SizeGiB: 2}
+	attach := &AttachDevice{VMMs: vmms, OVS: ovs, VMID: spec.ID, Disk: disk}
+	if err := attach.Run(); err != nil {
+		t.Fatalf("attach disk: %v", err)
+	}
+	diskPath := filepath.Join(vmms.VolumeDir(spec.ID), "disk1.raw")
+	if st, err := os.Stat(diskPath); err != nil || st.Size() != 2<<30 {
+		t.Fatalf("disk not created: %v", err)
+	}
+	if !hasDevice("disk1") || !inSpec("disk1") {
+		t.Fatal("disk not hot-plugged and persisted")
+	}
+	// A retried attach is a no-op
+	if err := attach.Run(); err != nil {
+		t.Fatalf("re-attach disk: %v", err)
+	}
+
+	nic := &vmspec.Nic{ID: "net1", MAC: "02:00:00:00:00:02", Port: network.PortSpec{
+		Bridge: "br-prod", Name: "vtvm-exec-1", Type: network.PortTypeTap, VlanMode: network.VlanModeAccess, Tag: 20,
+	}}
+	if err := (&AttachDevice{VMMs: vmms, OVS: ovs, VMID: spec.ID, Nic: nic}).Run(); err != nil {
+		t.Fatalf("attach nic: %v", err)
+	}
+	if p, ok := ovs.ports["vtvm-exec-1"]; !ok || p.Tag != 20 {
+		t.Fatalf("nic port not plugged: %+v", ovs.ports)
+	}
+	if !hasDevice("net1") || !inSpec("net1") {
+		t.Fatal("nic not hot-plugged and persisted")
+	}
+
+	// A refused hot-plug leaves neither the port nor the spec entry behind:
+	// vhost-user needs the shared memory the VM was not booted with
+	bad := &vmspec.Nic{ID: "net2", MAC: "02:00:00:00:00:03", Port: network.PortSpec{
+		Bridge: "br-prod", Name: "vtvm-exec-2", Type: network.PortTypeVhostUser, VlanMode: network.VlanModeAccess, Tag: 20,
+	}}
+	if err := (&AttachDevice{VMMs: vmms, OVS: ovs, VMID: spec.ID, Nic: bad}).Run(); err == nil {
+		t.Fatal("expected a vhost-user nic to be refused")
+	}
+	if _, ok := ovs.ports["vtvm-exec-2"]; ok || inSpec("net2") {
+		t.Fatal("refused nic left behind")
+	}
+
+	for _, id := range []string{"disk1", "net1"} {
+		detach := &DetachDevice{VMMs: vmms, OVS: ovs, VMID: spec.ID, DeviceID: id}
+		if err := detach.Run(); err != nil {
+			t.Fatalf("detach %s: %v", id, err)
+		}
+		if hasDevice(id) || inSpec(id) {
+			t.Fatalf("%s not detached", id)
+		}
+		if err := detach.Run(); err != nil {
+			t.Fatalf("re-detach %s: %v", id, err)
+		}
+	}
+	if _, err := os.Stat(diskPath); !os.IsNotExist(err) {
+		t.Fatalf("disk file left behind: %v", err)
+	}
+	if _, ok := ovs.ports["vtvm-exec-1"]; ok {
+		t.Fatal("nic port left behind")
+	}
+	if !hasDevice("disk0") || !hasDevice("net0") {
+		t.Fatal("other devices were detached")
+	}
+}
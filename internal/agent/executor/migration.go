@@ -0,0 +1,180 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
+
+	"github.com/VerteraIO/vertera/internal/agent/runtime"
+	"github.com/VerteraIO/vertera/internal/network"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+// Defaults for the migration executors.
+const (
+	DefaultReceiveTimeout = 30 * time.Minute
+	DefaultConnectTimeout = 30 * time.Second
+	connectRetryInterval  = time.Second
+)
+
+// ReceiveMigration is the target half of a live migration: it plugs the VM's
+// ports into this host's DVS bridges, starts an empty VMM and waits for the
+// source to send the VM on Port. The VMM takes the VM config from the source,
+// so the VM's disks must already be present at the same paths (shared
+// storage). Copy-on-write disks also read from their image, which is fetched
+// into this host's cache through Images. A failed receive stops the VMM and
+// unplugs the ports again.
+type ReceiveMigration struct {
+	VMMs   runtime.VMMs
+	OVS    runtime.OpenvSwitch
+	Images runtime.ImageCache
+	Spec   vmspec.Spec
+	Port   int
+	// Timeout bounds waiting for the source and the transfer; 0 means
+	// DefaultReceiveTimeout.
+	Timeout time.Duration
+	// Listening, if set, is called once the VM's disks, images and ports are
+	// ready and the VMM is started, right before it starts listening for the
+	// source.
+	Listening func()
+}
+
+func (r *ReceiveMigration) Name() string { return "receive-vm-migration" }
+
+func (r *ReceiveMigration) Run() error {
+	if err := r.Spec.Validate(); err != nil {
+		return err
+	}
+	if r.Port <= 0 || r.Port > 65535 {
+		return fmt.Errorf("invalid migration port %d", r.Port)
+	}
+	layout := vmLayout(r.VMMs, r.Spec.ID, "")
+	dir := layout.Dir
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("create vm dir: %w", err)
+	}
+	setVhostSockets(&r.Spec, layout)
+	for _, d := range r.Spec.Disks {
+		if _, err := os.Stat(layout.DiskPath(d)); err != nil {
+			return fmt.Errorf("disk %s is not available on this host, live migration needs shared storage: %w", d.ID, err)
+		}
+		if d.Image != nil && d.Clone == vmspec.CloneCOW && r.Images != nil {
+			if _, err := r.Images.Ensure(context.Background(), *d.Image); err != nil {
+				return fmt.Errorf("disk %s: %w", d.ID, err)
+			}
+		}
+	}
+	if err := r.Spec.Save(dir); err != nil {
+		return fmt.Errorf("save vm spec: %w", err)
+	}
+	if err := writeSeed(&r.Spec, layout); err != nil {
+		return err
+	}
+
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = DefaultReceiveTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := r.receive(ctx)
+	if err != nil {
+		cleanup := context.Background()
+		_ = r.VMMs.Stop(cleanup, r.Spec.ID)
+		_ = (&UnplugPorts{OVS: r.OVS, Ports: nicPorts(&r.Spec)}).Run()
+	}
+	return err
+}
+
+func (r *ReceiveMigration) receive(ctx context.Context) error {
+	if err := (&PlugPorts{OVS: r.OVS, Ports: nicPorts(&r.Spec)}).Run(); err != nil {
+		return err
+	}
+	c, err := r.VMMs.Start(ctx, r.Spec.ID)
+	if err != nil {
+		return fmt.Errorf("start vmm: %w", err)
+	}
+	if r.Listening != nil {
+		r.Listening()
+	}
+	return c.ReceiveMigration(ctx, fmt.Sprintf("tcp:0.0.0.0:%d", r.Port))
+}
+
+// SendMigration is the source half of a live migration: it sends the running
+// VM to DestinationURL, retrying refused connections for the moment between
+// the target reporting it is listening and its VMM binding the port. Once
+// the VM runs on the target the local VMM is stopped and the ports are
+// unplugged; the volume directory is kept because on shared storage it
+// holds the disks the VM keeps using. A failed send leaves the VM running
+// here.
+type SendMigration struct {
+	VMMs           runtime.VMMs
+	OVS            runtime.OpenvSwitch
+	VMID           string
+	DestinationURL string
+	// ConnectTimeout is how long to retry a refused connection; 0 means
+	// DefaultConnectTimeout.
+	ConnectTimeout time.Duration
+}
+
+func (s *SendMigration) Name() string { return "send-vm-migration" }
+
+func (s *SendMigration) Run() error {
+	ctx := context.Background()
+	spec, err := vmspec.Load(s.VMMs.Dir(s.VMID))
+	if err != nil {
+		return fmt.Errorf("load vm spec: %w", err)
+	}
+	c, err := s.VMMs.Client(s.VMID)
+	if err != nil {
+		return fmt.Errorf("vm %s has no running vmm: %w", s.VMID, err)
+	}
+	timeout := s.ConnectTimeout
+	if timeout == 0 {
+		timeout = DefaultConnectTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err = c.SendMigration(ctx, s.DestinationURL)
+		if err == nil {
+			break
+		}
+		if !connectionRefused(err) || time.Now().After(deadline) {
+			resumeIfPaused(ctx, c)
+			return err
+		}
+		time.Sleep(connectRetryInterval)
+	}
+
+	if err := s.VMMs.Stop(ctx, s.VMID); err != nil {
+		return err
+	}
+	return (&UnplugPorts{OVS: s.OVS, Ports: nicPorts(spec)}).Run()
+}
+
+// connectionRefused reports whether a send failed because the receiver was
+// not listening (yet).
+func connectionRefused(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "connection refused")
+}
+
+// resumeIfPaused makes sure a VM whose migration was aborted keeps running.
+func resumeIfPaused(ctx context.Context, c runtime.CloudHypervisor) {
+	if info, err := c.GetVMInfo(ctx); err == nil && info.State == ch.Paused {
+		_ = c.ResumeVM(ctx)
+	}
+}
+
+// setVhostSockets places the vhost-user sockets of NICs without one in the
+// VM's runtime directory.
+func setVhostSockets(spec *vmspec.Spec, layout vmspec.Layout) {
+	for i, n := range spec.Nics {
+		if n.Port.Type == network.PortTypeVhostUser && n.Port.SocketPath == "" {
+			spec.Nics[i].Port.SocketPath = layout.VhostSocketPath(n)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package executor
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
+
+	"github.com/VerteraIO/vertera/internal/network"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func TestMigrationExecutors(t *testing.T) {
+	src, dst := newFakeVMMs(t), newFakeVMMs(t)
+	srcOVS := &fakeOVS{ports: map[string]network.PortSpec{}}
+	dstOVS := &fakeOVS{ports: map[string]network.PortSpec{}}
+	spec := testSpec()
+	if err := (&CreateVM{VMMs: src, OVS: srcOVS, Spec: spec, Firmware: "/fw"}).Run(); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// Without the disk on the target nothing is set up there
+	port := freePort(t)
+	listening := make(chan struct{}, 1)
+	recv := &ReceiveMigration{VMMs: dst, OVS: dstOVS, Spec: testSpec(), Port: port, Listening: func() { listening <- struct{}{} }}
+	if err := recv.Run(); err == nil || len(dstOVS.ports) != 0 || dst.fake(spec.ID) != nil {
+		t.Fatalf("expected receive without disks to fail cleanly: %v", err)
+	}
+	if len(listening) != 0 {
+		t.Fatal("receive without disks must not report listening")
+	}
+
+	// The target's bridge differs; its ports follow the target's spec
+	layout := vmLayout(dst, spec.ID, "")
+	if err := os.MkdirAll(layout.VolumeDir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureDisk(layout.DiskPath(spec.Disks[0]), 1<<30); err != nil {
+		t.Fatal(err)
+	}
+	target := testSpec()
+	target.Nics[0].Port.Bridge = "br-prod-b"
+	received := make(chan error, 1)
+	go func() {
+		received <- (&ReceiveMigration{VMMs: dst, OVS: dstOVS, Spec: target, Port: port, Listening: func() { listening <- struct{}{} }}).Run()
+	}()
+	select {
+	case <-listening:
+	case err := <-received:
+		t.Fatalf("receive ended before listening: %v", err)
+	}
+	send := &SendMigration{VMMs: src, OVS: srcOVS, VMID: spec.ID, DestinationURL: fmt.Sprintf("tcp:127.0.0.1:%d", port)}
+	if err := send.Run(); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if err := <-received; err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if f := dst.fake(spec.ID); f == nil || f.State() != ch.Running {
+		t.Fatal("vm is not running on the target")
+	}
+	if src.fake(spec.ID) != nil || len(srcOVS.ports) != 0 {
+		t.Fatalf("source kept its vmm or ports: %v", srcOVS.ports)
+	}
+	if p := dstOVS.ports["vtvm-exec-0"]; p.Bridge != "br-prod-b" {
+		t.Fatalf("nic not plugged into the target bridge: %+v", p)
+	}
+	if _, err := os.Stat(src.Dir(spec.ID)); err != nil {
+		t.Fatalf("source runtime dir should be kept: %v", err)
+	}
+	if saved, err := vmspec.Load(dst.Dir(spec.ID)); err != nil || saved.Nics[0].Port.Bridge != "br-prod-b" {
+		t.Fatalf("target spec not saved: %v", err)
+	}
+
+	// A failed send keeps the VM running on the source
+	send = &SendMigration{VMMs: dst, OVS: dstOVS, VMID: spec.ID, DestinationURL: fmt.Sprintf("tcp:127.0.0.1:%d", freePort(t)), ConnectTimeout: 1}
+	if err := send.Run(); err == nil {
+		t.Fatal("expected send without a receiver to fail")
+	}
+	if f := dst.fake(spec.ID); f == nil || f.State() != ch.Running || len(dstOVS.ports) != 1 {
+		t.Fatal("failed send should leave the vm running")
+	}
+}
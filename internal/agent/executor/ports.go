@@ -0,0 +1,53 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/VerteraIO/vertera/internal/agent/runtime"
+	"github.com/VerteraIO/vertera/internal/network"
+)
+
+// PlugPorts attaches VM NICs to their DVS bridges with the port group's VLAN settings.
+// Re-running it re-applies the settings, so it is safe after a partial failure.
+type PlugPorts struct {
+	OVS   runtime.OpenvSwitch
+	Ports []network.PortSpec
+}
+
+func (p *PlugPorts) Name() string { return "plug-ports" }
+
+func (p *PlugPorts) Run() error {
+	ctx := context.Background()
+	bridges := map[string]bool{}
+	for _, spec := range p.Ports {
+		if !bridges[spec.Bridge] {
+			if err := p.OVS.EnsureBridge(ctx, spec.Bridge); err != nil {
+				return fmt.Errorf("ensure bridge %s: %w", spec.Bridge, err)
+			}
+			bridges[spec.Bridge] = true
+		}
+		if err := p.OVS.AddPort(ctx, spec); err != nil {
+			return fmt.Errorf("add port %s: %w", spec.Name, err)
+		}
+	}
+	return nil
+}
+
+// UnplugPorts removes VM NICs from their DVS bridges. Missing ports are ignored.
+type UnplugPorts struct {
+	OVS   runtime.OpenvSwitch
+	Ports []network.PortSpec
+}
+
+func (u *UnplugPorts) Name() string { return "unplug-ports" }
+
+func (u *UnplugPorts) Run() error {
+	ctx := context.Background()
+	for _, spec := range u.Ports {
+		if err := u.OVS.DeletePort(ctx, spec.Bridge, spec.Name); err != nil {
+			return fmt.Errorf("delete port %s: %w", spec.Name, err)
+		}
+	}
+	return nil
+}
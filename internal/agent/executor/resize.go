@@ -0,0 +1,115 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
+
+	"github.com/VerteraIO/vertera/internal/agent/runtime"
+	"github.com/VerteraIO/vertera/internal/hypervisor"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+// ResizeVM gives a VM new vCPUs and memory. A running VM is resized through
+// Cloud Hypervisor's resize API, within the vCPUs and hot-pluggable memory it
+// was booted with; otherwise, or when the VMM refuses, the new size is only
+// persisted and takes effect on the next boot. Either way the persisted spec
+// carries the new size.
+type ResizeVM struct {
+	VMMs         runtime.VMMs
+	VMID         string
+	Vcpus        int
+	MemoryMiB    int
+	MaxVcpus     int
+	MaxMemoryMiB int
+
+	// Live reports, once Run succeeded, whether the running VM has the new
+	// size; Reason says why not.
+	Live   bool
+	Reason string
+}
+
+func (r *ResizeVM) Name() string { return "resize-vm" }
+
+func (r *ResizeVM) Run() error {
+	dir := r.VMMs.Dir(r.VMID)
+	spec, err := vmspec.Load(dir)
+	if err != nil {
+		return fmt.Errorf("load vm spec: %w", err)
+	}
+	spec.Vcpus, spec.MemoryMiB = r.Vcpus, r.MemoryMiB
+	spec.MaxVcpus, spec.MaxMemoryMiB = r.MaxVcpus, r.MaxMemoryMiB
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	r.Reason = r.resizeLive(context.Background())
+	r.Live = r.Reason == ""
+	if err := spec.Save(dir); err != nil {
+		return fmt.Errorf("save vm spec: %w", err)
+	}
+	return nil
+}
+
+// resizeLive resizes the running VM and returns why it could not.
+func (r *ResizeVM) resizeLive(ctx context.Context) string {
+	c, err := r.VMMs.Client(r.VMID)
+	if err != nil {
+		return "vm is not running"
+	}
+	info, err := c.GetVMInfo(ctx)
+	if errors.Is(err, hypervisor.ErrVMNotCreated) {
+		return "vm is not running"
+	}
+	if err != nil {
+		return err.Error()
+	}
+	if info.State != ch.Running {
+		return fmt.Sprintf("vm is %s", info.State)
+	}
+	cur := resourcesOf(info.Config)
+	if r.Vcpus != cur.vcpus {
+		if r.Vcpus > cur.maxVcpus {
+			return fmt.Sprintf("vm was booted with at most %d vcpus", cur.maxVcpus)
+		}
+		if err := c.Resize(ctx, &r.Vcpus, nil); err != nil {
+			return fmt.Sprintf("resize vcpus: %v", err)
+		}
+	}
+	if want := int64(r.MemoryMiB) << 20; want != cur.memory {
+		if want > cur.maxMemory {
+			return fmt.Sprintf("vm was booted with at most %d MiB of memory", cur.maxMemory>>20)
+		}
+		if err := c.Resize(ctx, nil, &want); err != nil {
+			return fmt.Sprintf("resize memory: %v", err)
+		}
+	}
+	return ""
+}
+
+// vmResources are the vCPUs and memory (in bytes) of a VM as created in its
+// VMM, and how far they can grow.
+type vmResources struct {
+	vcpus, maxVcpus   int
+	memory, maxMemory int64
+}
+
+// resourcesOf reads the resources from a VM's config; hot-plugged memory
+// counts towards its memory.
+func resourcesOf(cfg ch.VmConfig) vmResources {
+	var s vmResources
+	if cfg.Cpus != nil {
+		s.vcpus, s.maxVcpus = cfg.Cpus.BootVcpus, cfg.Cpus.MaxVcpus
+	}
+	if m := cfg.Memory; m != nil {
+		s.memory, s.maxMemory = m.Size, m.Size
+		if m.HotpluggedSize != nil {
+			s.memory += *m.HotpluggedSize
+		}
+		if m.HotplugSize != nil {
+			s.maxMemory += *m.HotplugSize
+		}
+	}
+	return s
+}
@@ -0,0 +1,68 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/network"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+func TestResizeVM(t *testing.T) {
+	vmms := newFakeVMMs(t)
+	ovs := &fakeOVS{ports: map[string]network.PortSpec{}}
+	spec := testSpec()
+	spec.MaxVcpus, spec.MaxMemoryMiB = 4, 1024
+	if err := (&CreateVM{VMMs: vmms, OVS: ovs, Spec: spec, Firmware: "/fw"}).Run(); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	current := func() vmResources { return resourcesOf(*vmms.fake(spec.ID).Config()) }
+	saved := func() *vmspec.Spec {
+		s, err := vmspec.Load(vmms.Dir(spec.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	// Within the headroom the running VM is resized live
+	r := &ResizeVM{VMMs: vmms, VMID: spec.ID, Vcpus: 4, MemoryMiB: 1024, MaxVcpus: 4, MaxMemoryMiB: 1024}
+	if err := r.Run(); err != nil || !r.Live {
+		t.Fatalf("live resize: %v %q", err, r.Reason)
+	}
+	if got := current(); got.vcpus != 4 || got.memory != 1024<<20 {
+		t.Fatalf("vm not resized: %+v", got)
+	}
+	if s := saved(); s.Vcpus != 4 || s.MemoryMiB != 1024 {
+		t.Fatalf("new size not persisted: %+v", s)
+	}
+
+	// Beyond it the new size waits for the next boot
+	r = &ResizeVM{VMMs: vmms, VMID: spec.ID, Vcpus: 8, MemoryMiB: 2048, MaxVcpus: 8, MaxMemoryMiB: 2048}
+	if err := r.Run(); err != nil || r.Live || r.Reason == "" {
+		t.Fatalf("expected a next-boot resize: %v %v %q", err, r.Live, r.Reason)
+	}
+	if got := current(); got.vcpus != 4 {
+		t.Fatalf("running vm changed: %+v", got)
+	}
+	if err := (&PowerVM{VMMs: vmms, OVS: ovs, VMID: spec.ID, Op: "off"}).Run(); err != nil {
+		t.Fatalf("power off: %v", err)
+	}
+	if err := (&PowerVM{VMMs: vmms, OVS: ovs, VMID: spec.ID, Op: "on", Firmware: "/fw"}).Run(); err != nil {
+		t.Fatalf("power on: %v", err)
+	}
+	if got := current(); got.vcpus != 8 || got.maxVcpus != 8 || got.memory != 2048<<20 {
+		t.Fatalf("vm not recreated at its new size: %+v", got)
+	}
+
+	// A stopped VM only gets the new size persisted
+	if err := (&PowerVM{VMMs: vmms, OVS: ovs, VMID: spec.ID, Op: "off"}).Run(); err != nil {
+		t.Fatalf("power off: %v", err)
+	}
+	r = &ResizeVM{VMMs: vmms, VMID: spec.ID, Vcpus: 2, MemoryMiB: 512, MaxVcpus: 8, MaxMemoryMiB: 2048}
+	if err := r.Run(); err != nil || r.Live {
+		t.Fatalf("expected a next-boot resize: %v %v", err, r.Live)
+	}
+	if s := saved(); s.Vcpus != 2 || s.MemoryMiB != 512 {
+		t.Fatalf("new size not persisted: %+v", s)
+	}
+}
@@ -0,0 +1,317 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
+
+	"github.com/VerteraIO/vertera/internal/agent/runtime"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+// A snapshot lives in the volume directory of the VM it was taken of, next
+// to its disks on persistent storage, so deleting the VM deletes its
+// snapshots. It holds Cloud Hypervisor's snapshot files, the VM's spec at the
+// time and a copy of each disk:
+//
+//	<volume dir>/snapshots/<snapshot id>/{config.json,state.json,memory-ranges,spec.json}
+//	<volume dir>/snapshots/<snapshot id>/disks/<disk file>
+//
+// Disks are copied file for file, so the copy of a copy-on-write disk is its
+// overlay and still needs the host's cached image.
+const (
+	snapshotsDir  = "snapshots"
+	snapshotDisks = "disks"
+	// restoreDir stages a snapshot for vm.restore inside the target VM's
+	// volume directory, on the snapshot's storage so its state files can be
+	// linked rather than copied.
+	restoreDir = "restore"
+)
+
+// SnapshotDir is where the snapshot of a VM is kept.
+func SnapshotDir(vmms runtime.VMMs, vmID, snapshotID string) string {
+	return filepath.Join(vmms.VolumeDir(vmID), snapshotsDir, snapshotID)
+}
+
+// SnapshotVM pauses a running VM, writes its snapshot and copies its disks
+// next to it, then resumes the VM. Re-running it replaces a partial
+// snapshot. A VM that cannot be resumed fails the snapshot.
+type SnapshotVM struct {
+	VMMs       runtime.VMMs
+	VMID       string
+	SnapshotID string
+
+	// SizeBytes is the size of the snapshot once Run succeeded.
+	SizeBytes int64
+}
+
+func (s *SnapshotVM) Name() string { return "snapshot-vm" }
+
+func (s *SnapshotVM) Run() (err error) {
+	ctx := context.Background()
+	dir := s.VMMs.Dir(s.VMID)
+	spec, err := vmspec.Load(dir)
+	if err != nil {
+		return fmt.Errorf("load vm spec: %w", err)
+	}
+	c, err := s.VMMs.Client(s.VMID)
+	if err != nil {
+		return fmt.Errorf("vm %s has no running vmm: %w", s.VMID, err)
+	}
+	info, err := c.GetVMInfo(ctx)
+	if err != nil {
+		return err
+	}
+	switch info.State {
+	case ch.Running:
+		if err := c.PauseVM(ctx); err != nil {
+			return err
+		}
+		defer func() {
+			if rerr := c.ResumeVM(ctx); rerr != nil && err == nil {
+				err = fmt.Errorf("resume vm: %w", rerr)
+			}
+		}()
+	case ch.Paused:
+	default:
+		return fmt.Errorf("vm is %s, only running vms can be snapshotted", info.State)
+	}
+
+	snap := SnapshotDir(s.VMMs, s.VMID, s.SnapshotID)
+	if err := s.write(ctx, c, spec, vmLayout(s.VMMs, s.VMID, ""), snap); err != nil {
+		_ = os.RemoveAll(snap)
+		return err
+	}
+	s.SizeBytes, err = dirSize(snap)
+	return err
+}
+
+func (s *SnapshotVM) write(ctx context.Context, c runtime.CloudHypervisor, spec *vmspec.Spec, layout vmspec.Layout, snap string) error {
+	if err := os.RemoveAll(snap); err != nil {
+		return fmt.Errorf("clear snapshot dir: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(snap, snapshotDisks), 0o750); err != nil {
+		return fmt.Errorf("create snapshot dir: %w", err)
+	}
+	if err := c.SnapshotVM(ctx, "file://"+snap); err != nil {
+		return err
+	}
+	for _, d := range spec.Disks {
+		path := layout.DiskPath(d)
+		if err := copySparse(filepath.Join(snap, snapshotDisks, filepath.Base(path)), path); err != nil {
+			return err
+		}
+	}
+	return spec.Save(snap)
+}
+
+// DeleteSnapshot removes a snapshot's files; a missing snapshot is not an
+// error.
+type DeleteSnapshot struct {
+	VMMs       runtime.VMMs
+	VMID       string
+	SnapshotID string
+}
+
+func (d *DeleteSnapshot) Name() string { return "delete-vm-snapshot" }
+
+func (d *DeleteSnapshot) Run() error {
+	return os.RemoveAll(SnapshotDir(d.VMMs, d.VMID, d.SnapshotID))
+}
+
+// RestoreVM brings Spec's VM back to a snapshot taken of SourceVMID, which is
+// either the same VM or, when restoring into a new VM, the VM the snapshot
+// was taken of. Spec must have the snapshot's vCPUs, memory and disks. The
+// VM's VMM is replaced, its disks are overwritten with the snapshot's copies
+// and the VM resumes from the snapshotted memory; the snapshot's device
+// config is rewritten from Spec, so NICs and disk paths are the target's.
+type RestoreVM struct {
+	VMMs       runtime.VMMs
+	OVS        runtime.OpenvSwitch
+	Spec       vmspec.Spec
+	SourceVMID string
+	SnapshotID string
+	Firmware   string
+}
+
+func (r *RestoreVM) Name() string { return "restore-vm" }
+
+func (r *RestoreVM) Run() error {
+	if err := r.Spec.Validate(); err != nil {
+		return err
+	}
+	snap := SnapshotDir(r.VMMs, r.SourceVMID, r.SnapshotID)
+	taken, err := vmspec.Load(snap)
+	if err != nil {
+		return fmt.Errorf("load snapshot %s: %w", r.SnapshotID, err)
+	}
+	if err := matchesSnapshot(&r.Spec, taken); err != nil {
+		return err
+	}
+	layout := vmLayout(r.VMMs, r.Spec.ID, r.Firmware)
+	dir := layout.Dir
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("create vm dir: %w", err)
+	}
+	if err := os.MkdirAll(layout.VolumeDir, 0o750); err != nil {
+		return fmt.Errorf("create volume dir: %w", err)
+	}
+	setVhostSockets(&r.Spec, layout)
+	if err := r.Spec.Save(dir); err != nil {
+		return fmt.Errorf("save vm spec: %w", err)
+	}
+
+	ctx := context.Background()
+	if err := r.VMMs.Stop(ctx, r.Spec.ID); err != nil {
+		return err
+	}
+	for i, d := range r.Spec.Disks {
+		src := filepath.Join(snap, snapshotDisks, filepath.Base(vmspec.Layout{}.DiskPath(taken.Disks[i])))
+		if err := copySparse(layout.DiskPath(d), src); err != nil {
+			return err
+		}
+	}
+	if err := writeSeed(&r.Spec, layout); err != nil {
+		return err
+	}
+	staged := filepath.Join(layout.VolumeDir, restoreDir)
+	defer os.RemoveAll(staged)
+	if err := stageSnapshot(staged, snap, r.Spec.VmConfig(layout)); err != nil {
+		return err
+	}
+	if err := (&PlugPorts{OVS: r.OVS, Ports: nicPorts(&r.Spec)}).Run(); err != nil {
+		return err
+	}
+	c, err := r.VMMs.Start(ctx, r.Spec.ID)
+	if err != nil {
+		return fmt.Errorf("start vmm: %w", err)
+	}
+	if err := c.RestoreVM(ctx, "file://"+staged); err != nil {
+		_ = r.VMMs.Stop(ctx, r.Spec.ID)
+		return err
+	}
+	return c.ResumeVM(ctx)
+}
+
+// matchesSnapshot checks that spec has the shape of the VM a snapshot was
+// taken of; guest memory and device state only fit such a VM.
+func matchesSnapshot(spec, taken *vmspec.Spec) error {
+	if spec.Vcpus != taken.Vcpus || spec.MemoryMiB != taken.MemoryMiB {
+		return fmt.Errorf("snapshot was taken with %d vcpus and %d MiB, vm has %d vcpus and %d MiB",
+			taken.Vcpus, taken.MemoryMiB, spec.Vcpus, spec.MemoryMiB)
+	}
+	if len(spec.Disks) != len(taken.Disks) || len(spec.Nics) != len(taken.Nics) {
+		return fmt.Errorf("snapshot has %d disk(s) and %d nic(s), vm has %d and %d",
+			len(taken.Disks), len(taken.Nics), len(spec.Disks), len(spec.Nics))
+	}
+	if (spec.CloudInit != nil) != (taken.CloudInit != nil) {
+		return fmt.Errorf("snapshot and vm differ in having a cloud-init seed disk")
+	}
+	var l vmspec.Layout
+	for i, d := range spec.Disks {
+		if filepath.Ext(l.DiskPath(d)) != filepath.Ext(l.DiskPath(taken.Disks[i])) {
+			return fmt.Errorf("disk %s is a %s file in the snapshot", d.ID, filepath.Ext(l.DiskPath(taken.Disks[i])))
+		}
+	}
+	return nil
+}
+
+// stageSnapshot prepares dir for vm.restore: the snapshot's state files are
+// linked (or copied) and config.json is replaced by cfg.
+func stageSnapshot(dir, snap string, cfg ch.VmConfig) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("clear restore dir: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("create restore dir: %w", err)
+	}
+	entries, err := os.ReadDir(snap)
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	for _, e := range entries {
+		switch name := e.Name(); {
+		case e.IsDir(), name == "config.json", name == "spec.json":
+		default:
+			src, dst := filepath.Join(snap, name), filepath.Join(dir, name)
+			if err := os.Link(src, dst); err != nil {
+				if err := copySparse(dst, src); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "config.json"), b, 0o640)
+}
+
+// copySparse copies the file src to dst, skipping zero blocks so sparse
+// disks stay sparse.
+func copySparse(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", src, err)
+	}
+	defer in.Close()
+	st, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", dst, err)
+	}
+	buf := make([]byte, 1<<20)
+	zero := make([]byte, len(buf))
+	var off int64
+	for {
+		n, rerr := io.ReadFull(in, buf)
+		if n > 0 && !bytes.Equal(buf[:n], zero[:n]) {
+			if _, err := out.WriteAt(buf[:n], off); err != nil {
+				out.Close()
+				return fmt.Errorf("write %s: %w", dst, err)
+			}
+		}
+		off += int64(n)
+		if errors.Is(rerr, io.EOF) || errors.Is(rerr, io.ErrUnexpectedEOF) {
+			break
+		}
+		if rerr != nil {
+			out.Close()
+			return fmt.Errorf("read %s: %w", src, rerr)
+		}
+	}
+	if err := out.Truncate(st.Size()); err != nil {
+		out.Close()
+		return fmt.Errorf("size %s: %w", dst, err)
+	}
+	return out.Close()
+}
+
+// dirSize sums the sizes of the files below dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,128 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
+
+	"github.com/VerteraIO/vertera/internal/hypervisor/chfake"
+	"github.com/VerteraIO/vertera/internal/network"
+)
+
+func TestSnapshotExecutors(t *testing.T) {
+	vmms := newFakeVMMs(t)
+	ovs := &fakeOVS{ports: map[string]network.PortSpec{}}
+	spec := testSpec()
+	if err := (&CreateVM{VMMs: vmms, OVS: ovs, Spec: spec, Firmware: "/fw"}).Run(); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	disk := filepath.Join(vmms.VolumeDir(spec.ID), "disk0.raw")
+	writeAt := func(path, data string) {
+		t.Helper()
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteAt([]byte(data), 4096); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readAt := func(path string) string {
+		t.Helper()
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		b := make([]byte, 5)
+		if _, err := f.ReadAt(b, 4096); err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	writeAt(disk, "hello")
+
+	snap := &SnapshotVM{VMMs: vmms, VMID: spec.ID, SnapshotID: "s1"}
+	if err := snap.Run(); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	dir := SnapshotDir(vmms, spec.ID, "s1")
+	if readAt(filepath.Join(dir, "disks", "disk0.raw")) != "hello" || snap.SizeBytes < 1<<30 {
+		t.Fatalf("snapshot disk not copied, size %d", snap.SizeBytes)
+	}
+	if vmms.fake(spec.ID).State() != ch.Running {
+		t.Fatal("vm not resumed after snapshot")
+	}
+	if !strings.HasPrefix(dir, vmms.VolumeDir(spec.ID)) {
+		t.Fatalf("snapshot not kept with the vm's volumes: %s", dir)
+	}
+
+	// A VM left paused fails the snapshot
+	vmms.fake(spec.ID).InjectFailure("vm.resume", chfake.Failure{Status: 500, Body: "Error from API: resume failed", Times: 1})
+	if err := (&SnapshotVM{VMMs: vmms, VMID: spec.ID, SnapshotID: "s0"}).Run(); err == nil || !strings.Contains(err.Error(), "resume failed") {
+		t.Fatalf("expected the failed resume to fail the snapshot: %v", err)
+	}
+	if vmms.fake(spec.ID).State() != ch.Paused {
+		t.Fatal("vm should stay paused after the failed resume")
+	}
+	c, err := vmms.Client(spec.ID)
+	if err == nil {
+		err = c.ResumeVM(context.Background())
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Restoring the same VM rolls its disk back
+	writeAt(disk, "world")
+	if err := (&RestoreVM{VMMs: vmms, OVS: ovs, Spec: spec, SourceVMID: spec.ID, SnapshotID: "s1", Firmware: "/fw"}).Run(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if readAt(disk) != "hello" || vmms.fake(spec.ID).State() != ch.Running {
+		t.Fatal("vm not restored")
+	}
+	if _, err := os.Stat(filepath.Join(vmms.VolumeDir(spec.ID), "restore")); !os.IsNotExist(err) {
+		t.Fatalf("restore staging dir left behind: %v", err)
+	}
+
+	// Restoring into a new VM uses that VM's disks and ports
+	clone := testSpec()
+	clone.ID, clone.Nics[0].Port.Name, clone.Nics[0].MAC = "vm-clone", "vtvm-clone-0", "02:00:00:00:00:02"
+	if err := (&RestoreVM{VMMs: vmms, OVS: ovs, Spec: clone, SourceVMID: spec.ID, SnapshotID: "s1", Firmware: "/fw"}).Run(); err != nil {
+		t.Fatalf("restore into new vm: %v", err)
+	}
+	cfg := vmms.fake("vm-clone").Config()
+	if *(*cfg.Net)[0].Tap != "vtvm-clone-0" || *(*cfg.Disks)[0].Path != filepath.Join(vmms.VolumeDir("vm-clone"), "disk0.raw") {
+		t.Fatalf("clone restored with the source's devices: %+v", cfg)
+	}
+	if readAt(filepath.Join(vmms.VolumeDir("vm-clone"), "disk0.raw")) != "hello" {
+		t.Fatal("clone disk not restored")
+	}
+	if _, ok := ovs.ports["vtvm-clone-0"]; !ok {
+		t.Fatalf("clone port not plugged: %v", ovs.ports)
+	}
+
+	bigger := testSpec()
+	bigger.ID, bigger.Vcpus = "vm-bigger", 4
+	if err := (&RestoreVM{VMMs: vmms, OVS: ovs, Spec: bigger, SourceVMID: spec.ID, SnapshotID: "s1"}).Run(); err == nil {
+		t.Fatal("expected restore into a vm of another shape to fail")
+	}
+
+	if err := (&DeleteSnapshot{VMMs: vmms, VMID: spec.ID, SnapshotID: "s1"}).Run(); err != nil {
+		t.Fatalf("delete snapshot: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("snapshot left behind: %v", err)
+	}
+	if err := (&DeleteSnapshot{VMMs: vmms, VMID: spec.ID, SnapshotID: "s1"}).Run(); err != nil {
+		t.Fatalf("deleting a missing snapshot: %v", err)
+	}
+	if err := (&RestoreVM{VMMs: vmms, OVS: ovs, Spec: spec, SourceVMID: spec.ID, SnapshotID: "s1"}).Run(); err == nil {
+		t.Fatal("expected restore of a deleted snapshot to fail")
+	}
+}
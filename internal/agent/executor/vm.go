@@ -0,0 +1,287 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
+
+	"github.com/VerteraIO/vertera/internal/agent/runtime"
+	"github.com/VerteraIO/vertera/internal/hypervisor"
+	"github.com/VerteraIO/vertera/internal/network"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+// CreateVM prepares a VM's disks and ports, starts its VMM and boots it.
+// Each step tolerates having already been done, so a failed create can be
+// retried; DeleteVM cleans up whatever a failed create left behind. Disks
+// created from images need Images and DiskImages.
+type CreateVM struct {
+	VMMs       runtime.VMMs
+	OVS        runtime.OpenvSwitch
+	Images     runtime.ImageCache
+	DiskImages runtime.DiskImages
+	Spec       vmspec.Spec
+	Firmware   string
+	// Recover re-creates a VM whose host failed from the disks it left on
+	// shared or replicated storage; a missing disk fails the create instead
+	// of being provisioned empty.
+	Recover bool
+}
+
+func (c *CreateVM) Name() string { return "create-vm" }
+
+func (c *CreateVM) Run() error {
+	if err := c.Spec.Validate(); err != nil {
+		return err
+	}
+	layout := vmLayout(c.VMMs, c.Spec.ID, c.Firmware)
+	dir := layout.Dir
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("create vm dir: %w", err)
+	}
+	if err := os.MkdirAll(layout.VolumeDir, 0o750); err != nil {
+		return fmt.Errorf("create volume dir: %w", err)
+	}
+	setVhostSockets(&c.Spec, layout)
+	if err := c.Spec.Save(dir); err != nil {
+		return fmt.Errorf("save vm spec: %w", err)
+	}
+	ctx := context.Background()
+	for _, d := range c.Spec.Disks {
+		if c.Recover {
+			if _, err := os.Stat(layout.DiskPath(d)); err != nil {
+				return fmt.Errorf("disk %s is not available on this host, recovery needs shared or replicated storage: %w", d.ID, err)
+			}
+			if d.Image != nil && d.Clone == vmspec.CloneCOW && c.Images != nil {
+				// The overlay reads from the image, which this host may not
+				// have cached yet.
+				if _, err := c.Images.Ensure(ctx, *d.Image); err != nil {
+					return fmt.Errorf("disk %s: %w", d.ID, err)
+				}
+			}
+			continue
+		}
+		if err := provisionDisk(ctx, c.Images, c.DiskImages, layout, d); err != nil {
+			return err
+		}
+	}
+	return bootFromSpec(ctx, c.VMMs, c.OVS, &c.Spec, layout)
+}
+
+// provisionDisk creates a disk unless it already exists. Image disks are
+// built under a temporary name, as an overlay on the cached image or a raw
+// copy of it, grown to the disk's size and then renamed into place, so a
+// disk that exists is complete.
+func provisionDisk(ctx context.Context, images runtime.ImageCache, diskImages runtime.DiskImages, layout vmspec.Layout, d vmspec.Disk) error {
+	path, size := layout.DiskPath(d), int64(d.SizeGiB)<<30
+	if d.Image == nil {
+		return ensureDisk(path, size)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if images == nil || diskImages == nil {
+		return fmt.Errorf("disk %s: no image support on this host", d.ID)
+	}
+	src, err := images.Ensure(ctx, *d.Image)
+	if err != nil {
+		return fmt.Errorf("disk %s: %w", d.ID, err)
+	}
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp)
+	format := "raw"
+	if d.Clone == vmspec.CloneCOW {
+		format = "qcow2"
+		err = diskImages.CreateOverlay(ctx, src, d.Image.Format, tmp)
+	} else {
+		err = diskImages.Convert(ctx, src, d.Image.Format, tmp)
+	}
+	if err == nil {
+		err = diskImages.Resize(ctx, tmp, format, size)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("disk %s: %w", d.ID, err)
+	}
+	return nil
+}
+
+// DeleteVM stops a VM's VMM, removes its ports and deletes its runtime and
+// volume directories.
+type DeleteVM struct {
+	VMMs runtime.VMMs
+	OVS  runtime.OpenvSwitch
+	VMID string
+}
+
+func (d *DeleteVM) Name() string { return "delete-vm" }
+
+func (d *DeleteVM) Run() error {
+	ctx := context.Background()
+	dir := d.VMMs.Dir(d.VMID)
+	// The spec tells us which ports to remove; without it there is nothing to unplug.
+	spec, err := vmspec.Load(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("load vm spec: %w", err)
+	}
+	if err := d.VMMs.Stop(ctx, d.VMID); err != nil {
+		return err
+	}
+	if spec != nil {
+		if err := (&UnplugPorts{OVS: d.OVS, Ports: nicPorts(spec)}).Run(); err != nil {
+			return err
+		}
+	}
+	return d.VMMs.Remove(ctx, d.VMID)
+}
+
+// ReleaseVM stops a VM on a failed host that came back after the VM was
+// recovered on another host, and removes its ports. The volume directory is
+// kept: on shared storage it holds the disks the VM now uses elsewhere.
+type ReleaseVM struct {
+	VMMs runtime.VMMs
+	OVS  runtime.OpenvSwitch
+	VMID string
+}
+
+func (r *ReleaseVM) Name() string { return "release-vm" }
+
+func (r *ReleaseVM) Run() error {
+	ctx := context.Background()
+	spec, err := vmspec.Load(r.VMMs.Dir(r.VMID))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("load vm spec: %w", err)
+	}
+	if err := r.VMMs.Stop(ctx, r.VMID); err != nil {
+		return err
+	}
+	if spec == nil {
+		return nil
+	}
+	return (&UnplugPorts{OVS: r.OVS, Ports: nicPorts(spec)}).Run()
+}
+
+// PowerVM changes a VM's power state. "on" recreates the VM from its persisted
+// spec if the VMM is gone (e.g. the guest powered itself off); "off" is a hard
+// power-off that keeps the VMM running.
+type PowerVM struct {
+	VMMs     runtime.VMMs
+	OVS      runtime.OpenvSwitch
+	VMID     string
+	Op       string
+	Firmware string
+}
+
+func (p *PowerVM) Name() string { return "power-vm-" + p.Op }
+
+func (p *PowerVM) Run() error {
+	ctx := context.Background()
+	switch p.Op {
+	case "on":
+		layout := vmLayout(p.VMMs, p.VMID, p.Firmware)
+		spec, err := vmspec.Load(layout.Dir)
+		if err != nil {
+			return fmt.Errorf("load vm spec: %w", err)
+		}
+		return bootFromSpec(ctx, p.VMMs, p.OVS, spec, layout)
+	case "off":
+		c, err := p.VMMs.Client(p.VMID)
+		if err != nil {
+			// No VMM means the VM is already off.
+			return nil
+		}
+		err = c.ShutdownVM(ctx)
+		if errors.Is(err, hypervisor.ErrVMNotBooted) || errors.Is(err, hypervisor.ErrVMNotCreated) {
+			return nil
+		}
+		return err
+	case "reboot":
+		c, err := p.VMMs.Client(p.VMID)
+		if err != nil {
+			return err
+		}
+		return c.RebootVM(ctx)
+	default:
+		return fmt.Errorf("unknown power op %q", p.Op)
+	}
+}
+
+// bootFromSpec writes the VM's cloud-init seed, plugs its ports, makes sure a
+// VMM is running with the VM created in it at the spec's size and brings the
+// VM to the running state.
+func bootFromSpec(ctx context.Context, vmms runtime.VMMs, ovs runtime.OpenvSwitch, spec *vmspec.Spec, layout vmspec.Layout) error {
+	if err := writeSeed(spec, layout); err != nil {
+		return err
+	}
+	if err := (&PlugPorts{OVS: ovs, Ports: nicPorts(spec)}).Run(); err != nil {
+		return err
+	}
+	c, err := vmms.Start(ctx, spec.ID)
+	if err != nil {
+		return fmt.Errorf("start vmm: %w", err)
+	}
+	cfg := spec.VmConfig(layout)
+	if err := c.CreateVM(ctx, cfg); err != nil && !errors.Is(err, hypervisor.ErrVMAlreadyCreated) {
+		return err
+	}
+	info, err := c.GetVMInfo(ctx)
+	if err != nil {
+		return err
+	}
+	switch info.State {
+	case ch.Running:
+		return nil
+	case ch.Paused:
+		return c.ResumeVM(ctx)
+	}
+	if resourcesOf(info.Config) != resourcesOf(cfg) {
+		// The VM was resized while it could not be resized live; recreate it
+		// with its new size.
+		if err := c.DeleteVM(ctx); err != nil {
+			return err
+		}
+		if err := c.CreateVM(ctx, cfg); err != nil {
+			return err
+		}
+	}
+	if err := c.BootVM(ctx); err != nil && !errors.Is(err, hypervisor.ErrVMAlreadyBooted) {
+		return err
+	}
+	return nil
+}
+
+// vmLayout returns where a VM's files live on this host.
+func vmLayout(vmms runtime.VMMs, vmID, firmware string) vmspec.Layout {
+	return vmspec.Layout{Dir: vmms.Dir(vmID), VolumeDir: vmms.VolumeDir(vmID), Firmware: firmware}
+}
+
+func nicPorts(spec *vmspec.Spec) []network.PortSpec {
+	ports := make([]network.PortSpec, 0, len(spec.Nics))
+	for _, n := range spec.Nics {
+		ports = append(ports, n.Port)
+	}
+	return ports
+}
+
+// ensureDisk creates a sparse raw disk of the given size unless it already exists.
+func ensureDisk(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o640)
+	if errors.Is(err, os.ErrExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("create disk %s: %w", path, err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		_ = os.Remove(path)
+		return fmt.Errorf("size disk %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
+
+	"github.com/VerteraIO/vertera/internal/agent/runtime"
+	"github.com/VerteraIO/vertera/internal/hypervisor"
+	"github.com/VerteraIO/vertera/internal/hypervisor/chfake"
+	"github.com/VerteraIO/vertera/internal/network"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+// fakeVMMs runs an in-process fake VMM per VM instead of cloud-hypervisor processes.
+type fakeVMMs struct {
+	t       *testing.T
+	root    string
+	volumes string
+	mu      sync.Mutex
+	fakes   map[string]*chfake.Server
+}
+
+func newFakeVMMs(t *testing.T) *fakeVMMs {
+	f := &fakeVMMs{t: t, root: t.TempDir(), volumes: t.TempDir(), fakes: map[string]*chfake.Server{}}
+	t.Cleanup(func() {
+		for _, s := range f.fakes {
+			_ = s.Close()
+		}
+	})
+	return f
+}
+
+func (f *fakeVMMs) Dir(vmID string) string { return filepath.Join(f.root, vmID) }
+
+func (f *fakeVMMs) VolumeDir(vmID string) string { return filepath.Join(f.volumes, vmID) }
+
+func (f *fakeVMMs) Start(ctx context.Context, vmID string) (runtime.CloudHypervisor, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.fakes[vmID]; !ok {
+		if err := os.MkdirAll(f.Dir(vmID), 0o750); err != nil {
+			return nil, err
+		}
+		s, err := chfake.Start(filepath.Join(f.Dir(vmID), "api.sock"))
+		if err != nil {
+			return nil, err
+		}
+		f.fakes[vmID] = s
+	}
+	return hypervisor.NewCloudHypervisorClient(filepath.Join(f.Dir(vmID), "api.sock"))
+}
+
+func (f *fakeVMMs) Client(vmID string) (runtime.CloudHypervisor, error) {
+	f.mu.Lock()
+	_, ok := f.fakes[vmID]
+	f.mu.Unlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return hypervisor.NewCloudHypervisorClient(filepath.Join(f.Dir(vmID), "api.sock"))
+}
+
+func (f *fakeVMMs) Stop(ctx context.Context, vmID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.fakes[vmID]; ok {
+		_ = s.Close()
+		delete(f.fakes, vmID)
+	}
+	return nil
+}
+
+func (f *fakeVMMs) Remove(ctx context.Context, vmID string) error {
+	_ = f.Stop(ctx, vmID)
+	_ = os.RemoveAll(f.VolumeDir(vmID))
+	return os.RemoveAll(f.Dir(vmID))
+}
+
+func (f *fakeVMMs) fake(vmID string) *chfake.Server {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fakes[vmID]
+}
+
+// fakeOVS records the ports present on each bridge.
+type fakeOVS struct {
+	mu    sync.Mutex
+	ports map[string]network.PortSpec
+}
+
+func (o *fakeOVS) EnsureBridge(ctx context.Context, bridge string) error { return nil }
+
+func (o *fakeOVS) AddPort(ctx context.Context, spec network.PortSpec) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.ports[spec.Name] = spec
+	return nil
+}
+
+func (o *fakeOVS) DeletePort(ctx context.Context, bridge, name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.ports, name)
+	return nil
+}
+
+func testSpec() vmspec.Spec {
+	return vmspec.Spec{
+		ID:        "vm-exec",
+		Name:      "web",
+		Vcpus:     2,
+		MemoryMiB: 512,
+		Nics: []vmspec.Nic{{ID: "net0", MAC: "02:00:00:00:00:01", Port: network.PortSpec{
+			Bridge: "br-prod", Name: "vtvm-exec-0", Type: network.PortTypeTap, VlanMode: network.VlanModeAccess, Tag: 10,
+		}}},
+		Disks: []vmspec.Disk{{ID: "disk0", SizeGiB: 1}},
+	}
+}
+
+func TestVMExecutors(t *testing.T) {
+	vmms := newFakeVMMs(t)
+	ovs := &fakeOVS{ports: map[string]network.PortSpec{}}
+
+	create := &CreateVM{VMMs: vmms, OVS: ovs, Spec: testSpec(), Firmware: "/fw"}
+	if err := create.Run(); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	fake := vmms.fake("vm-exec")
+	if fake.State() != ch.Running {
+		t.Fatalf("expected running vm, got %q", fake.State())
+	}
+	cfg := fake.Config()
+	if cfg.Cpus.BootVcpus != 2 || *(*cfg.Net)[0].Tap != "vtvm-exec-0" || *(*cfg.Disks)[0].Path != filepath.Join(vmms.VolumeDir("vm-exec"), "disk0.raw") {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+	if st, err := os.Stat(filepath.Join(vmms.VolumeDir("vm-exec"), "disk0.raw")); err != nil || st.Size() != 1<<30 {
+		t.Fatalf("disk not created: %v %v", st, err)
+	}
+	if _, ok := ovs.ports["vtvm-exec-0"]; !ok {
+		t.Fatalf("port not plugged: %v", ovs.ports)
+	}
+	// Re-running a create converges instead of failing.
+	if err := create.Run(); err != nil {
+		t.Fatalf("create again: %v", err)
+	}
+
+	if err := (&PowerVM{VMMs: vmms, OVS: ovs, VMID: "vm-exec", Op: "off"}).Run(); err != nil {
+		t.Fatalf("power off: %v", err)
+	}
+	if fake.State() != ch.Shutdown {
+		t.Fatalf("expected shutdown, got %q", fake.State())
+	}
+	if err := (&PowerVM{VMMs: vmms, OVS: ovs, VMID: "vm-exec", Op: "off"}).Run(); err != nil {
+		t.Fatalf("power off is idempotent: %v", err)
+	}
+	if err := (&PowerVM{VMMs: vmms, OVS: ovs, VMID: "vm-exec", Op: "on", Firmware: "/fw"}).Run(); err != nil {
+		t.Fatalf("power on: %v", err)
+	}
+	if fake.State() != ch.Running {
+		t.Fatalf("expected running after power on, got %q", fake.State())
+	}
+
+	// Power on after the VMM went away recreates the VM from the persisted spec.
+	_ = vmms.Stop(context.Background(), "vm-exec")
+	if err := (&PowerVM{VMMs: vmms, OVS: ovs, VMID: "vm-exec", Op: "on", Firmware: "/fw"}).Run(); err != nil {
+		t.Fatalf("power on without vmm: %v", err)
+	}
+	if vmms.fake("vm-exec").State() != ch.Running {
+		t.Fatalf("vm not recreated")
+	}
+
+	if err := (&DeleteVM{VMMs: vmms, OVS: ovs, VMID: "vm-exec"}).Run(); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if len(ovs.ports) != 0 {
+		t.Fatalf("ports left behind: %v", ovs.ports)
+	}
+	if _, err := os.Stat(vmms.Dir("vm-exec")); !os.IsNotExist(err) {
+		t.Fatalf("runtime dir left behind: %v", err)
+	}
+	if _, err := os.Stat(vmms.VolumeDir("vm-exec")); !os.IsNotExist(err) {
+		t.Fatalf("volume dir left behind: %v", err)
+	}
+}
+
+func TestRecoverAndReleaseVM(t *testing.T) {
+	// Both hosts keep their volumes on the same shared storage.
+	failed, survivor := newFakeVMMs(t), newFakeVMMs(t)
+	survivor.volumes = failed.volumes
+	failedOVS := &fakeOVS{ports: map[string]network.PortSpec{}}
+	survivorOVS := &fakeOVS{ports: map[string]network.PortSpec{}}
+
+	recoverVM := &CreateVM{VMMs: survivor, OVS: survivorOVS, Spec: testSpec(), Firmware: "/fw", Recover: true}
+	if err := recoverVM.Run(); err == nil {
+		t.Fatal("expected recovery without the vm's disks to fail")
+	}
+	disk := filepath.Join(failed.VolumeDir("vm-exec"), "disk0.raw")
+	if _, err := os.Stat(disk); !os.IsNotExist(err) {
+		t.Fatalf("recovery provisioned a disk: %v", err)
+	}
+
+	if err := (&CreateVM{VMMs: failed, OVS: failedOVS, Spec: testSpec(), Firmware: "/fw"}).Run(); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := os.WriteFile(disk, []byte("guest data"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := recoverVM.Run(); err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	if survivor.fake("vm-exec").State() != ch.Running {
+		t.Fatalf("recovered vm not running")
+	}
+	if data, _ := os.ReadFile(disk); string(data) != "guest data" {
+		t.Fatalf("recovery touched the disk: %q", data)
+	}
+
+	// The failed host comes back and releases the VM, keeping its files
+	if err := (&ReleaseVM{VMMs: failed, OVS: failedOVS, VMID: "vm-exec"}).Run(); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if failed.fake("vm-exec") != nil || len(failedOVS.ports) != 0 {
+		t.Fatalf("vm not released: ports %v", failedOVS.ports)
+	}
+	if _, err := os.Stat(disk); err != nil {
+		t.Fatalf("release removed the disk: %v", err)
+	}
+	if len(survivorOVS.ports) != 1 {
+		t.Fatalf("release touched the survivor: %v", survivorOVS.ports)
+	}
+}
+
+func TestCreateVMBootFailure(t *testing.T) {
+	vmms := newFakeVMMs(t)
+	ovs := &fakeOVS{ports: map[string]network.PortSpec{}}
+	if _, err := vmms.Start(context.Background(), "vm-exec"); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	vmms.fake("vm-exec").InjectFailure("vm.boot", chfake.Failure{Status: 500, Body: "Error from API: boot failed", Times: 1})
+
+	create := &CreateVM{VMMs: vmms, OVS: ovs, Spec: testSpec(), Firmware: "/fw"}
+	if err := create.Run(); err == nil {
+		t.Fatalf("expected boot failure to surface")
+	}
+	if err := create.Run(); err != nil {
+		t.Fatalf("retry after transient boot failure: %v", err)
+	}
+}
+
+// fakeImages serves every image from one file and records disk image
+// commands, creating their output files.
+type fakeImages struct {
+	path  string
+	calls []string
+	fail  string
+}
+
+func (f *fakeImages) Ensure(ctx context.Context, img vmspec.Image) (string, error) {
+	f.calls = append(f.calls, "ensure "+img.ID)
+	return f.path, nil
+}
+
+func (f *fakeImages) Convert(ctx context.Context, src, srcFormat, dst string) error {
+	f.calls = append(f.calls, "convert "+srcFormat+" "+filepath.Base(dst))
+	return os.WriteFile(dst, nil, 0o640)
+}
+
+func (f *fakeImages) CreateOverlay(ctx context.Context, backing, backingFormat, dst string) error {
+	f.calls = append(f.calls, "overlay "+backingFormat+" "+filepath.Base(dst))
+	return os.WriteFile(dst, nil, 0o640)
+}
+
+func (f *fakeImages) Resize(ctx context.Context, path, format string, sizeBytes int64) error {
+	f.calls = append(f.calls, fmt.Sprintf("resize %s %s %d", format, filepath.Base(path), sizeBytes))
+	if f.fail != "" {
+		return errors.New(f.fail)
+	}
+	return nil
+}
+
+func TestCreateVMFromImage(t *testing.T) {
+	vmms := newFakeVMMs(t)
+	ovs := &fakeOVS{ports: map[string]network.PortSpec{}}
+	images := &fakeImages{path: "/cache/img1.qcow2", fail: "no space left"}
+	img := &vmspec.Image{ID: "img1", Format: "qcow2", SHA256: "abc"}
+	spec := testSpec()
+	spec.Disks = []vmspec.Disk{
+		{ID: "disk0", SizeGiB: 10, Image: img, Clone: vmspec.CloneCOW},
+		{ID: "disk1", SizeGiB: 20, Image: img, Clone: vmspec.CloneFull},
+		{ID: "disk2", SizeGiB: 1},
+	}
+
+	if err := (&CreateVM{VMMs: vmms, OVS: ovs, Spec: spec}).Run(); err == nil {
+		t.Fatal("expected image disk without an image cache to fail")
+	}
+	create := &CreateVM{VMMs: vmms, OVS: ovs, Images: images, DiskImages: images, Spec: spec, Firmware: "/fw"}
+	if err := create.Run(); err == nil {
+		t.Fatal("expected failed resize to surface")
+	}
+	if entries, _ := os.ReadDir(vmms.VolumeDir(spec.ID)); len(entries) != 0 {
+		t.Fatalf("partial disk left behind: %v", entries)
+	}
+
+	images.fail, images.calls = "", nil
+	if err := create.Run(); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	want := []string{
+		"ensure img1", "overlay qcow2 disk0.qcow2.tmp", "resize qcow2 disk0.qcow2.tmp 10737418240",
+		"ensure img1", "convert qcow2 disk1.raw.tmp", "resize raw disk1.raw.tmp 21474836480",
+	}
+	if fmt.Sprint(images.calls) != fmt.Sprint(want) {
+		t.Fatalf("unexpected image commands:\n got %v\nwant %v", images.calls, want)
+	}
+	cfg := vmms.fake(spec.ID).Config()
+	if *(*cfg.Disks)[0].Path != filepath.Join(vmms.VolumeDir(spec.ID), "disk0.qcow2") || *(*cfg.Disks)[1].Path != filepath.Join(vmms.VolumeDir(spec.ID), "disk1.raw") {
+		t.Fatalf("unexpected disks: %+v", *cfg.Disks)
+	}
+
+	// Existing disks are kept as they are
+	images.calls = nil
+	if err := create.Run(); err != nil || len(images.calls) != 0 {
+		t.Fatalf("create again: %v, commands %v", err, images.calls)
+	}
+}
@@ -0,0 +1,124 @@
+// Package imagecache keeps a host-local copy of the catalog images used by the
+// host's VMs. Images are fetched from the controller the first time a VM
+// needs them and are verified against their SHA-256 before use.
+package imagecache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/VerteraIO/vertera/internal/agent/runtime"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+// Cache stores images as <Dir>/<image id>.<format>. A file only appears
+// under its final name once it has been downloaded completely and its
+// checksum matched, so a present file is always usable.
+type Cache struct {
+	dir        string
+	controller string
+	client     *http.Client
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+var _ runtime.ImageCache = (*Cache)(nil)
+
+// New returns a cache in dir that downloads images from the controller's
+// HTTP API at controllerURL (e.g. http://controller:8080).
+func New(dir, controllerURL string) *Cache {
+	return &Cache{
+		dir:        dir,
+		controller: strings.TrimRight(controllerURL, "/"),
+		client:     http.DefaultClient,
+		locks:      make(map[string]*sync.Mutex),
+	}
+}
+
+// Path is where the cache keeps img.
+func (c *Cache) Path(img vmspec.Image) string {
+	return filepath.Join(c.dir, img.ID+"."+img.Format)
+}
+
+// Ensure returns the path of the cached copy of img, downloading it first if
+// it is missing. Concurrent calls for the same image share one download.
+func (c *Cache) Ensure(ctx context.Context, img vmspec.Image) (string, error) {
+	if img.ID == "" || strings.ContainsAny(img.ID, `/\`) || img.ID == "." || img.ID == ".." {
+		return "", fmt.Errorf("invalid image id %q", img.ID)
+	}
+	lock := c.lock(img.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	path := c.Path(img)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := os.MkdirAll(c.dir, 0o750); err != nil {
+		return "", fmt.Errorf("create image cache: %w", err)
+	}
+	if err := c.download(ctx, img, path); err != nil {
+		return "", fmt.Errorf("fetch image %s: %w", img.ID, err)
+	}
+	return path, nil
+}
+
+func (c *Cache) lock(id string) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		c.locks[id] = l
+	}
+	return l
+}
+
+// download fetches img into a temporary file next to path and renames it
+// into place once the checksum matches.
+func (c *Cache) download(ctx context.Context, img vmspec.Image, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.controller+"/api/v1/images/"+url.PathEscape(img.ID)+"/file", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("controller returned %s", resp.Status)
+	}
+
+	tmp, err := os.CreateTemp(c.dir, img.ID+".*.part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != img.SHA256 {
+		return fmt.Errorf("checksum mismatch: got sha256 %s, want %s", sum, img.SHA256)
+	}
+	// Cached images back copy-on-write disks and must never change.
+	if err := os.Chmod(tmp.Name(), 0o440); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
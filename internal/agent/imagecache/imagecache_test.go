@@ -0,0 +1,74 @@
+package imagecache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+func TestEnsure(t *testing.T) {
+	data := []byte("raw image contents")
+	sum := sha256.Sum256(data)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/api/v1/images/img1/file", "/api/v1/images/bad/file":
+			_, _ = w.Write(data)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	c := New(dir, srv.URL+"/")
+	img := vmspec.Image{ID: "img1", Format: "raw", SHA256: hex.EncodeToString(sum[:])}
+	var wg sync.WaitGroup
+	paths := make([]string, 4)
+	errs := make([]error, 4)
+	for i := range paths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			paths[i], errs[i] = c.Ensure(context.Background(), img)
+		}(i)
+	}
+	wg.Wait()
+	for i := range paths {
+		if errs[i] != nil || paths[i] != c.Path(img) {
+			t.Fatalf("ensure %d: %s %v", i, paths[i], errs[i])
+		}
+	}
+	if got, _ := os.ReadFile(c.Path(img)); string(got) != string(data) {
+		t.Fatalf("cached image has %q", got)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("expected one download, got %d", n)
+	}
+
+	// A file with the wrong checksum is never cached
+	bad := vmspec.Image{ID: "bad", Format: "qcow2", SHA256: hex.EncodeToString(make([]byte, 32))}
+	if _, err := c.Ensure(context.Background(), bad); err == nil {
+		t.Fatal("expected checksum mismatch")
+	}
+	if _, err := c.Ensure(context.Background(), vmspec.Image{ID: "missing", Format: "raw", SHA256: img.SHA256}); err == nil {
+		t.Fatal("expected missing image to fail")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "img1.raw" {
+		t.Fatalf("unexpected cache contents: %v", entries)
+	}
+
+	if _, err := c.Ensure(context.Background(), vmspec.Image{ID: "../etc", Format: "raw"}); err == nil {
+		t.Fatal("expected path-like image id to be rejected")
+	}
+}
@@ -1,15 +1,98 @@
 package runtime
 
+import (
+	"context"
+
+	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
+
+	"github.com/VerteraIO/vertera/internal/network"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
 // Placeholder interfaces for host runtime integrations used by the agent.
 // These will wrap Cloud Hypervisor and OVS (libovsdb) operations.
 
-// CloudHypervisor abstracts operations the agent may invoke on the local CH daemon.
-// It is intentionally minimal for now; expand as features are added.
+// CloudHypervisor abstracts operations the agent may invoke on a single VM's
+// CH API socket. Implemented by hypervisor.CloudHypervisorClient; state errors
+// wrap hypervisor.ErrVMNotCreated, ErrVMAlreadyBooted, etc.
 type CloudHypervisor interface {
-	// TODO: Define operations like CreateVM, DeleteVM, etc.
+	Ping(ctx context.Context) (*ch.VmmPingResponse, error)
+	ShutdownVMM(ctx context.Context) error
+
+	// Lifecycle
+	CreateVM(ctx context.Context, config ch.VmConfig) error
+	BootVM(ctx context.Context) error
+	ShutdownVM(ctx context.Context) error
+	RebootVM(ctx context.Context) error
+	PowerButton(ctx context.Context) error
+	PauseVM(ctx context.Context) error
+	ResumeVM(ctx context.Context) error
+	DeleteVM(ctx context.Context) error
+	GetVMInfo(ctx context.Context) (*ch.VmInfo, error)
+
+	// Resources and devices
+	Resize(ctx context.Context, vcpus *int, ramBytes *int64) error
+	AddDisk(ctx context.Context, disk ch.DiskConfig) (*ch.PciDeviceInfo, error)
+	AddNet(ctx context.Context, net ch.NetConfig) (*ch.PciDeviceInfo, error)
+	AddFs(ctx context.Context, fs ch.FsConfig) (*ch.PciDeviceInfo, error)
+	AddVsock(ctx context.Context, vsock ch.VsockConfig) (*ch.PciDeviceInfo, error)
+	RemoveDevice(ctx context.Context, id string) error
+	Counters(ctx context.Context) (ch.VmCounters, error)
+
+	// Live migration. Both calls block until the VM has been transferred;
+	// the receiving VMM must not have a VM yet.
+	ReceiveMigration(ctx context.Context, receiverURL string) error
+	SendMigration(ctx context.Context, destinationURL string) error
+
+	// Snapshots. SnapshotVM needs a paused VM; RestoreVM needs a VMM without
+	// a VM and leaves the restored VM paused.
+	SnapshotVM(ctx context.Context, destinationURL string) error
+	RestoreVM(ctx context.Context, sourceURL string) error
 }
 
 // OpenvSwitch abstracts OVS operations performed via libovsdb or CLI.
+// Implemented by ovs.Client.
 type OpenvSwitch interface {
-	// TODO: Define operations like EnsureBridge, AddPort, SetIfaceOptions, etc.
+	// EnsureBridge creates the DVS bridge if missing.
+	EnsureBridge(ctx context.Context, bridge string) error
+	// AddPort creates or updates a VM port (tap or vhost-user) with its VLAN settings.
+	AddPort(ctx context.Context, spec network.PortSpec) error
+	// DeletePort removes a VM port; missing ports are not an error.
+	DeletePort(ctx context.Context, bridge, name string) error
+}
+
+// VMMs starts and stops the per-VM Cloud Hypervisor processes on a host.
+// Implemented by supervisor.Supervisor.
+type VMMs interface {
+	// Start launches (or returns the already running) VMM for a VM.
+	Start(ctx context.Context, vmID string) (CloudHypervisor, error)
+	// Client returns the API client of a running VMM.
+	Client(vmID string) (CloudHypervisor, error)
+	// Stop shuts the VMM down, keeping the VM's runtime directory.
+	Stop(ctx context.Context, vmID string) error
+	// Remove stops the VMM and deletes the VM's runtime and volume
+	// directories.
+	Remove(ctx context.Context, vmID string) error
+	// Dir is the VM's runtime directory.
+	Dir(vmID string) string
+	// VolumeDir is the VM's directory of disks on persistent storage.
+	VolumeDir(vmID string) string
+}
+
+// ImageCache keeps local copies of catalog images on a host.
+// Implemented by imagecache.Cache.
+type ImageCache interface {
+	// Ensure returns the path of the host's verified copy of img, fetching
+	// it from the controller first if needed.
+	Ensure(ctx context.Context, img vmspec.Image) (string, error)
+}
+
+// DiskImages creates and resizes disk image files. Implemented by qemuimg.Client.
+type DiskImages interface {
+	// Convert writes a raw copy of the image at src, of the given format, to dst.
+	Convert(ctx context.Context, src, srcFormat, dst string) error
+	// CreateOverlay creates a qcow2 file at dst backed by the image at backing.
+	CreateOverlay(ctx context.Context, backing, backingFormat, dst string) error
+	// Resize grows the disk image at path to sizeBytes.
+	Resize(ctx context.Context, path, format string, sizeBytes int64) error
 }
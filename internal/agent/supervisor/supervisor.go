@@ -0,0 +1,624 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/VerteraIO/vertera/internal/agent/runtime"
+	"github.com/VerteraIO/vertera/internal/hypervisor"
+)
+
+// A Cloud Hypervisor VMM manages exactly one VM per API socket, so the agent
+// runs one cloud-hypervisor process per VM. Each VM gets a directory under
+// RuntimeDir:
+//
+//	<RuntimeDir>/<vmID>/api.sock   CH API socket
+//	<RuntimeDir>/<vmID>/vmm.pid    pid of the cloud-hypervisor process
+//	<RuntimeDir>/<vmID>/vmm.log    VMM stdout/stderr
+//
+// Disks live on persistent storage instead, in <VolumeDir>/<vmID>; only
+// Remove touches them.
+//
+// VMMs are started in their own session so they outlive an agent restart
+// (run the agent unit with KillMode=process); Rediscover adopts them again.
+
+const (
+	socketFile = "api.sock"
+	pidFile    = "vmm.pid"
+	logFile    = "vmm.log"
+)
+
+var ErrUnknownVM = errors.New("no vmm for vm")
+
+var _ runtime.VMMs = (*Supervisor)(nil)
+
+var vmIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// Config configures a Supervisor. Zero values fall back to defaults.
+type Config struct {
+	// RuntimeDir holds per-VM directories. Default DefaultRuntimeDir.
+	RuntimeDir string
+	// VolumeDir holds per-VM disk directories. Default DefaultVolumeDir.
+	VolumeDir string
+	// Binary is the cloud-hypervisor executable. Default "cloud-hypervisor".
+	Binary string
+	// Command builds the VMM command for a VM; it overrides Binary (used by tests).
+	Command func(vmID, socketPath string) *exec.Cmd
+	// PingTimeout bounds how long Start waits for the API socket to answer. Default 10s.
+	PingTimeout time.Duration
+	// StopTimeout bounds how long Stop waits for a graceful VMM exit before SIGKILL. Default 10s.
+	StopTimeout time.Duration
+	// MaxRestarts is how many times a crashed VMM is restarted; 0 only reports the crash.
+	MaxRestarts int
+	// RestartBackoff is the delay before restarting a crashed VMM. Default 2s.
+	RestartBackoff time.Duration
+	// Restart, when set, decides instead of MaxRestarts and RestartBackoff
+	// whether a VMM that exited on its own is restarted and after how long.
+	Restart func(Event) (time.Duration, bool)
+	// StableAfter is how long a VMM has to run before its restart count
+	// starts over. Default 10m.
+	StableAfter time.Duration
+	// OnExit is called when a VMM exits without being asked to.
+	OnExit func(Event)
+}
+
+// Event describes an unexpected VMM exit.
+type Event struct {
+	VMID     string
+	PID      int
+	ExitCode int // -1 if unknown (adopted process or killed by signal)
+	Err      error
+	// Restarted is true if the supervisor started a fresh VMM. The fresh VMM has
+	// no VM; the caller must create and boot it again.
+	Restarted bool
+	// Restarts counts the restarts in a row: those before the exit, or with
+	// Restarted, including the fresh VMM.
+	Restarts int
+}
+
+// Info is a snapshot of a supervised VMM.
+type Info struct {
+	VMID       string    `json:"vmId"`
+	PID        int       `json:"pid"`
+	SocketPath string    `json:"socketPath"`
+	StartedAt  time.Time `json:"startedAt"`
+	Restarts   int       `json:"restarts"`
+	Adopted    bool      `json:"adopted"`
+}
+
+type vmm struct {
+	Info
+	client   *hypervisor.CloudHypervisorClient
+	proc     *os.Process
+	done     chan struct{}
+	exitErr  error // valid once done is closed
+	stopping bool
+}
+
+// Supervisor spawns, monitors and stops per-VM cloud-hypervisor processes.
+type Supervisor struct {
+	cfg  Config
+	mu   sync.Mutex
+	vmms map[string]*vmm
+	// spawning reserves a VM id while its VMM is being spawned or adopted,
+	// so that only one process at a time binds the VM's socket. The channel
+	// is closed when the spawn or adoption is over.
+	spawning map[string]chan struct{}
+	// restarts holds the VMs whose VMM exited unexpectedly until a restart
+	// is decided against or its backoff is over; Stop closes and deletes the
+	// channel to call the restart off.
+	restarts map[string]chan struct{}
+}
+
+// DefaultRuntimeDir is the RuntimeDir used when none is configured.
+const DefaultRuntimeDir = "/run/vertera/vms"
+
+// DefaultVolumeDir is the VolumeDir used when none is configured.
+const DefaultVolumeDir = "/var/lib/vertera/volumes"
+
+// New returns a Supervisor with defaults applied to cfg.
+func New(cfg Config) *Supervisor {
+	if cfg.RuntimeDir == "" {
+		cfg.RuntimeDir = DefaultRuntimeDir
+	}
+	if cfg.VolumeDir == "" {
+		cfg.VolumeDir = DefaultVolumeDir
+	}
+	if cfg.Binary == "" {
+		cfg.Binary = "cloud-hypervisor"
+	}
+	if cfg.PingTimeout == 0 {
+		cfg.PingTimeout = 10 * time.Second
+	}
+	if cfg.StopTimeout == 0 {
+		cfg.StopTimeout = 10 * time.Second
+	}
+	if cfg.RestartBackoff == 0 {
+		cfg.RestartBackoff = 2 * time.Second
+	}
+	if cfg.StableAfter == 0 {
+		cfg.StableAfter = 10 * time.Minute
+	}
+	return &Supervisor{
+		cfg:      cfg,
+		vmms:     make(map[string]*vmm),
+		spawning: make(map[string]chan struct{}),
+		restarts: make(map[string]chan struct{}),
+	}
+}
+
+// Dir returns the runtime directory of a VM.
+func (s *Supervisor) Dir(vmID string) string { return filepath.Join(s.cfg.RuntimeDir, vmID) }
+
+// VolumeDir returns the directory of a VM's disks.
+func (s *Supervisor) VolumeDir(vmID string) string { return filepath.Join(s.cfg.VolumeDir, vmID) }
+
+// SocketPath returns the CH API socket path of a VM.
+func (s *Supervisor) SocketPath(vmID string) string { return filepath.Join(s.Dir(vmID), socketFile) }
+
+// Start launches a VMM for vmID and waits until it answers Ping.
+// If a VMM for vmID is already running it is returned as is.
+func (s *Supervisor) Start(ctx context.Context, vmID string) (runtime.CloudHypervisor, error) {
+	if !vmIDPattern.MatchString(vmID) {
+		return nil, fmt.Errorf("invalid vm id %q", vmID)
+	}
+	for {
+		s.mu.Lock()
+		if v, ok := s.vmms[vmID]; ok {
+			s.mu.Unlock()
+			return v.client, nil
+		}
+		busy, ok := s.spawning[vmID]
+		if !ok {
+			s.spawning[vmID] = make(chan struct{})
+			s.mu.Unlock()
+			break
+		}
+		s.mu.Unlock()
+		// Another Start, a restart or Rediscover is bringing up the VMM;
+		// use theirs.
+		select {
+		case <-busy:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	v, err := s.spawn(ctx, vmID)
+	s.spawned(vmID, v)
+	if err != nil {
+		return nil, err
+	}
+	return v.client, nil
+}
+
+// spawned ends the reservation of vmID taken before a spawn or adoption and
+// registers the VMM if there is one.
+func (s *Supervisor) spawned(vmID string, v *vmm) {
+	s.mu.Lock()
+	if v != nil {
+		s.vmms[vmID] = v
+	}
+	close(s.spawning[vmID])
+	delete(s.spawning, vmID)
+	s.mu.Unlock()
+	if v != nil {
+		go s.monitor(v)
+	}
+}
+
+func (s *Supervisor) command(vmID, sock string) *exec.Cmd {
+	if s.cfg.Command != nil {
+		return s.cfg.Command(vmID, sock)
+	}
+	return exec.Command(s.cfg.Binary, "--api-socket", "path="+sock)
+}
+
+func (s *Supervisor) spawn(ctx context.Context, vmID string) (*vmm, error) {
+	dir := s.Dir(vmID)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("create runtime dir: %w", err)
+	}
+	sock := s.SocketPath(vmID)
+	// A stale socket from a previous VMM makes CH fail to bind.
+	_ = os.Remove(sock)
+
+	logf, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("open vmm log: %w", err)
+	}
+	cmd := s.command(vmID, sock)
+	cmd.Stdout = logf
+	cmd.Stderr = logf
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		_ = logf.Close()
+		return nil, fmt.Errorf("start vmm: %w", err)
+	}
+	_ = logf.Close()
+
+	v := &vmm{
+		Info: Info{VMID: vmID, PID: cmd.Process.Pid, SocketPath: sock, StartedAt: time.Now().UTC()},
+		proc: cmd.Process,
+		done: make(chan struct{}),
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	if err := os.WriteFile(filepath.Join(dir, pidFile), []byte(strconv.Itoa(v.PID)), 0640); err != nil {
+		_ = v.proc.Kill()
+		<-exited
+		return nil, fmt.Errorf("write pid file: %w", err)
+	}
+	v.client, err = hypervisor.NewCloudHypervisorClient(sock)
+	if err != nil {
+		_ = v.proc.Kill()
+		<-exited
+		return nil, err
+	}
+	if err := s.waitReady(ctx, v.client, exited); err != nil {
+		_ = v.proc.Kill()
+		select {
+		case <-exited:
+		case <-time.After(time.Second):
+		}
+		s.cleanup(vmID)
+		return nil, fmt.Errorf("vmm for %s not ready: %w", vmID, err)
+	}
+	// Hand the Wait result over to monitor.
+	go func() {
+		err := <-exited
+		v.setExit(err)
+	}()
+	return v, nil
+}
+
+// waitReady polls Ping until it succeeds, the process exits or the timeout elapses.
+func (s *Supervisor) waitReady(ctx context.Context, c *hypervisor.CloudHypervisorClient, exited <-chan error) error {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.PingTimeout)
+	defer cancel()
+	delay := 20 * time.Millisecond
+	for {
+		pctx, pcancel := context.WithTimeout(ctx, time.Second)
+		_, err := c.Ping(pctx)
+		pcancel()
+		if err == nil {
+			return nil
+		}
+		select {
+		case werr := <-exited:
+			return fmt.Errorf("vmm exited during startup: %v", werr)
+		case <-ctx.Done():
+			return fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+		case <-time.After(delay):
+		}
+		if delay < 500*time.Millisecond {
+			delay *= 2
+		}
+	}
+}
+
+func (v *vmm) setExit(err error) {
+	v.exitErr = err
+	close(v.done)
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	return -1
+}
+
+// monitor waits for the VMM to exit and reports or restarts it if the exit was unexpected.
+func (s *Supervisor) monitor(v *vmm) {
+	<-v.done
+	werr := v.exitErr
+
+	// Until the restart decision is made, a Stop cancels it.
+	cancel := make(chan struct{})
+	s.mu.Lock()
+	stopping := v.stopping
+	if cur, ok := s.vmms[v.VMID]; ok && cur == v {
+		delete(s.vmms, v.VMID)
+	}
+	if !stopping {
+		s.restarts[v.VMID] = cancel
+	}
+	s.mu.Unlock()
+	if stopping {
+		return
+	}
+
+	_ = os.Remove(v.SocketPath)
+	_ = os.Remove(filepath.Join(s.Dir(v.VMID), pidFile))
+	ev := Event{VMID: v.VMID, PID: v.PID, ExitCode: exitCode(werr), Err: werr, Restarts: v.Restarts}
+	if v.Adopted {
+		ev.ExitCode = -1
+	}
+	if time.Since(v.StartedAt) >= s.cfg.StableAfter {
+		ev.Restarts = 0
+	}
+	log.Printf("supervisor: vmm for %s (pid %d) exited unexpectedly: code=%d err=%v", v.VMID, v.PID, ev.ExitCode, werr)
+
+	delay, restart := s.cfg.RestartBackoff, ev.Restarts < s.cfg.MaxRestarts
+	if s.cfg.Restart != nil {
+		delay, restart = s.cfg.Restart(ev)
+	}
+	if restart && s.awaitRestart(v.VMID, cancel, delay) {
+		nv, err := s.spawn(context.Background(), v.VMID)
+		if err == nil {
+			nv.Restarts = ev.Restarts + 1
+			ev.Restarted = true
+			ev.Restarts = nv.Restarts
+		} else {
+			log.Printf("supervisor: restart of vmm for %s failed: %v", v.VMID, err)
+		}
+		s.spawned(v.VMID, nv)
+	}
+	if !restart {
+		s.mu.Lock()
+		if s.restarts[v.VMID] == cancel {
+			delete(s.restarts, v.VMID)
+		}
+		s.mu.Unlock()
+	}
+	if s.cfg.OnExit != nil {
+		s.cfg.OnExit(ev)
+	}
+}
+
+// awaitRestart waits out the backoff before restarting the VMM of vmID. It
+// reserves the VM id for the restart and reports true unless the VM was
+// stopped in the meantime, closing cancel, or another VMM has taken its
+// place.
+func (s *Supervisor) awaitRestart(vmID string, cancel chan struct{}, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-cancel:
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.restarts[vmID] != cancel {
+		return false
+	}
+	delete(s.restarts, vmID)
+	_, running := s.vmms[vmID]
+	_, busy := s.spawning[vmID]
+	if running || busy {
+		return false
+	}
+	s.spawning[vmID] = make(chan struct{})
+	return true
+}
+
+// Client returns the API client of a running VMM.
+func (s *Supervisor) Client(vmID string) (runtime.CloudHypervisor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.vmms[vmID]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownVM, vmID)
+	}
+	return v.client, nil
+}
+
+// List returns the supervised VMMs ordered by VM id.
+func (s *Supervisor) List() []Info {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Info, 0, len(s.vmms))
+	for _, v := range s.vmms {
+		out = append(out, v.Info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].VMID < out[j].VMID })
+	return out
+}
+
+// Stop shuts the VMM down: first through the API, then SIGTERM, then SIGKILL.
+// The socket and pid file are removed; the runtime directory is kept.
+func (s *Supervisor) Stop(ctx context.Context, vmID string) error {
+	for {
+		s.mu.Lock()
+		if cancel, ok := s.restarts[vmID]; ok {
+			close(cancel)
+			delete(s.restarts, vmID)
+		}
+		busy, ok := s.spawning[vmID]
+		if !ok {
+			break
+		}
+		s.mu.Unlock()
+		// Stop the VMM being spawned once it is up.
+		select {
+		case <-busy:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	v, ok := s.vmms[vmID]
+	if ok {
+		v.stopping = true
+	}
+	s.mu.Unlock()
+	if !ok {
+		s.cleanup(vmID)
+		return nil
+	}
+
+	sctx, cancel := context.WithTimeout(ctx, s.cfg.StopTimeout/2)
+	_ = v.client.ShutdownVMM(sctx)
+	cancel()
+	if !waitDone(v.done, s.cfg.StopTimeout/2) {
+		_ = v.proc.Signal(syscall.SIGTERM)
+		if !waitDone(v.done, s.cfg.StopTimeout/2) {
+			_ = v.proc.Kill()
+			if !waitDone(v.done, 5*time.Second) {
+				return fmt.Errorf("vmm for %s (pid %d) did not exit", vmID, v.PID)
+			}
+		}
+	}
+	s.mu.Lock()
+	if cur, ok := s.vmms[vmID]; ok && cur == v {
+		delete(s.vmms, vmID)
+	}
+	s.mu.Unlock()
+	s.cleanup(vmID)
+	return nil
+}
+
+// Remove stops the VMM and deletes the VM's runtime and volume directories.
+func (s *Supervisor) Remove(ctx context.Context, vmID string) error {
+	if err := s.Stop(ctx, vmID); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.VolumeDir(vmID)); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.Dir(vmID))
+}
+
+func (s *Supervisor) cleanup(vmID string) {
+	_ = os.Remove(s.SocketPath(vmID))
+	_ = os.Remove(filepath.Join(s.Dir(vmID), pidFile))
+}
+
+func waitDone(done <-chan struct{}, d time.Duration) bool {
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+// Rediscover adopts VMMs that kept running while the agent was down. For every
+// VM directory with a live pid whose socket answers Ping, the VMM is adopted;
+// stale sockets and pid files are removed. It returns the adopted VM ids.
+func (s *Supervisor) Rediscover(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(s.cfg.RuntimeDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var adopted []string
+	for _, e := range entries {
+		vmID := e.Name()
+		if !e.IsDir() || !vmIDPattern.MatchString(vmID) {
+			continue
+		}
+		s.mu.Lock()
+		_, known := s.vmms[vmID]
+		_, busy := s.spawning[vmID]
+		if !known && !busy {
+			// Reserve the VM as a spawn does, so a Start racing the adoption
+			// waits for it instead of having its VMM replaced or its runtime
+			// directory cleaned up.
+			s.spawning[vmID] = make(chan struct{})
+		}
+		s.mu.Unlock()
+		if known || busy {
+			continue
+		}
+		v := s.adopt(ctx, vmID)
+		s.spawned(vmID, v)
+		if v != nil {
+			adopted = append(adopted, vmID)
+		}
+	}
+	return adopted, nil
+}
+
+// adopt takes over the VMM left running for vmID, which the caller has
+// reserved. It returns nil and removes stale files when there is no live
+// VMM, and nil when the VMM does not answer.
+func (s *Supervisor) adopt(ctx context.Context, vmID string) *vmm {
+	pid, err := readPid(filepath.Join(s.Dir(vmID), pidFile))
+	if err != nil || !processAlive(pid) || !cmdlineMentions(pid, s.SocketPath(vmID)) {
+		s.cleanup(vmID)
+		return nil
+	}
+	client, err := hypervisor.NewCloudHypervisorClient(s.SocketPath(vmID))
+	if err != nil {
+		return nil
+	}
+	pctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	_, err = client.Ping(pctx)
+	cancel()
+	if err != nil {
+		log.Printf("supervisor: vmm for %s (pid %d) alive but not answering: %v", vmID, pid, err)
+		return nil
+	}
+	proc, _ := os.FindProcess(pid)
+	v := &vmm{
+		Info:   Info{VMID: vmID, PID: pid, SocketPath: s.SocketPath(vmID), StartedAt: time.Now().UTC(), Adopted: true},
+		client: client,
+		proc:   proc,
+		done:   make(chan struct{}),
+	}
+	// Not our child, so Wait is unavailable: poll for liveness instead.
+	go func() {
+		for processAlive(pid) {
+			time.Sleep(time.Second)
+		}
+		v.setExit(nil)
+	}()
+	return v
+}
+
+func readPid(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	if err := syscall.Kill(pid, 0); err != nil && !errors.Is(err, syscall.EPERM) {
+		return false
+	}
+	// Reaped children and zombies both count as gone.
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	if i := strings.LastIndexByte(string(b), ')'); i >= 0 && i+2 < len(b) && b[i+2] == 'Z' {
+		return false
+	}
+	return true
+}
+
+// cmdlineMentions guards against pid reuse: the process must reference the VM's socket.
+func cmdlineMentions(pid int, sock string) bool {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(b), sock)
+}
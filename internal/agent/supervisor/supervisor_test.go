@@ -0,0 +1,346 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
+
+	"github.com/VerteraIO/vertera/internal/hypervisor/chfake"
+)
+
+// TestHelperProcess is not a real test: it is the fake cloud-hypervisor
+// binary the supervisor spawns in the tests below.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("VERTERA_CHFAKE_VMM") != "1" {
+		return
+	}
+	args := os.Args
+	for i, a := range args {
+		if a == "--" {
+			args = args[i+1:]
+			break
+		}
+	}
+	os.Exit(chfake.RunVMM(args))
+}
+
+func fakeVMMCommand(vmID, socketPath string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$", "--", "--api-socket", "path="+socketPath)
+	cmd.Env = append(os.Environ(), "VERTERA_CHFAKE_VMM=1")
+	return cmd
+}
+
+func newTestSupervisor(t *testing.T, cfg Config) *Supervisor {
+	t.Helper()
+	if cfg.RuntimeDir == "" {
+		cfg.RuntimeDir = t.TempDir()
+	}
+	cfg.VolumeDir = t.TempDir()
+	if cfg.Command == nil {
+		cfg.Command = fakeVMMCommand
+	}
+	cfg.StopTimeout = 2 * time.Second
+	s := New(cfg)
+	t.Cleanup(func() {
+		for _, info := range s.List() {
+			_ = s.Stop(context.Background(), info.VMID)
+		}
+	})
+	return s
+}
+
+func waitEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for vmm exit event")
+		return Event{}
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	s := newTestSupervisor(t, Config{})
+	ctx := context.Background()
+
+	if _, err := s.Start(ctx, "../escape"); err == nil {
+		t.Fatalf("expected invalid vm id to be rejected")
+	}
+	c, err := s.Start(ctx, "vm1")
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if _, err := c.Ping(ctx); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	again, err := s.Start(ctx, "vm1")
+	if err != nil || again != c {
+		t.Fatalf("second start should return the running vmm: %v", err)
+	}
+	infos := s.List()
+	if len(infos) != 1 || infos[0].PID == 0 {
+		t.Fatalf("unexpected list: %+v", infos)
+	}
+
+	if err := s.Stop(ctx, "vm1"); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if processAlive(infos[0].PID) {
+		t.Fatalf("vmm pid %d still alive after stop", infos[0].PID)
+	}
+	if _, err := os.Stat(s.SocketPath("vm1")); !os.IsNotExist(err) {
+		t.Fatalf("socket left behind: %v", err)
+	}
+	if _, err := s.Client("vm1"); !errors.Is(err, ErrUnknownVM) {
+		t.Fatalf("client after stop: got %v", err)
+	}
+	if err := s.Remove(ctx, "vm1"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := os.Stat(s.Dir("vm1")); !os.IsNotExist(err) {
+		t.Fatalf("runtime dir left behind: %v", err)
+	}
+}
+
+func TestCrashIsReportedAndRestarted(t *testing.T) {
+	events := make(chan Event, 4)
+	s := newTestSupervisor(t, Config{MaxRestarts: 1, RestartBackoff: 10 * time.Millisecond, OnExit: func(ev Event) { events <- ev }})
+	ctx := context.Background()
+	if _, err := s.Start(ctx, "vm1"); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	pid := s.List()[0].PID
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+		t.Fatalf("kill: %v", err)
+	}
+
+	ev := waitEvent(t, events)
+	if ev.VMID != "vm1" || ev.PID != pid || ev.ExitCode != -1 || !ev.Restarted || ev.Restarts != 1 {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	c, err := s.Client("vm1")
+	if err != nil {
+		t.Fatalf("client after restart: %v", err)
+	}
+	if _, err := c.Ping(ctx); err != nil {
+		t.Fatalf("restarted vmm not answering: %v", err)
+	}
+	// The restarted VMM starts empty; the caller has to recreate the VM.
+	if _, err := c.GetVMInfo(ctx); err == nil {
+		t.Fatalf("restarted vmm should have no vm")
+	}
+}
+
+func TestStopCancelsPendingRestart(t *testing.T) {
+	events := make(chan Event, 1)
+	s := newTestSupervisor(t, Config{MaxRestarts: 1, RestartBackoff: 300 * time.Millisecond, OnExit: func(ev Event) { events <- ev }})
+	ctx := context.Background()
+	if _, err := s.Start(ctx, "vm1"); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if err := syscall.Kill(s.List()[0].PID, syscall.SIGKILL); err != nil {
+		t.Fatalf("kill: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.List()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Stopped during the backoff, the VM must stay down.
+	if err := s.Stop(ctx, "vm1"); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if ev := waitEvent(t, events); ev.Restarted {
+		t.Fatalf("vmm restarted after stop: %+v", ev)
+	}
+	time.Sleep(400 * time.Millisecond)
+	if _, err := s.Client("vm1"); !errors.Is(err, ErrUnknownVM) {
+		t.Fatalf("stopped vm has a vmm again: %v", err)
+	}
+}
+
+func TestConcurrentStartsSpawnOnce(t *testing.T) {
+	var spawns atomic.Int32
+	command := func(vmID, socketPath string) *exec.Cmd {
+		spawns.Add(1)
+		return fakeVMMCommand(vmID, socketPath)
+	}
+	s := newTestSupervisor(t, Config{Command: command})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := s.Start(ctx, "vm1")
+			if err == nil {
+				_, err = c.Ping(ctx)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("start: %v", err)
+		}
+	}
+	if n := spawns.Load(); n != 1 {
+		t.Fatalf("expected one vmm to be spawned, got %d", n)
+	}
+}
+
+func TestRestartFuncDecides(t *testing.T) {
+	events := make(chan Event, 4)
+	var seen []Event
+	restart := func(ev Event) (time.Duration, bool) {
+		seen = append(seen, ev)
+		return time.Millisecond, ev.Restarts < 1
+	}
+	s := newTestSupervisor(t, Config{MaxRestarts: 5, Restart: restart, OnExit: func(ev Event) { events <- ev }})
+	if _, err := s.Start(context.Background(), "vm1"); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	for want := 0; want < 2; want++ {
+		if err := syscall.Kill(s.List()[0].PID, syscall.SIGKILL); err != nil {
+			t.Fatalf("kill: %v", err)
+		}
+		ev := waitEvent(t, events)
+		if ev.Restarted != (want == 0) || ev.Restarts != 1 {
+			t.Fatalf("exit %d: unexpected event: %+v", want, ev)
+		}
+	}
+	if len(seen) != 2 || seen[0].Restarts != 0 || seen[1].Restarts != 1 || seen[1].ExitCode != -1 {
+		t.Fatalf("restart func saw %+v", seen)
+	}
+	if _, err := s.Client("vm1"); !errors.Is(err, ErrUnknownVM) {
+		t.Fatalf("vmm restarted against the restart func: %v", err)
+	}
+}
+
+func TestGuestPowerOffIsACleanExit(t *testing.T) {
+	events := make(chan Event, 1)
+	s := newTestSupervisor(t, Config{OnExit: func(ev Event) { events <- ev }})
+	ctx := context.Background()
+	c, err := s.Start(ctx, "vm1")
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	fw := "/fw"
+	if err := c.CreateVM(ctx, ch.VmConfig{Payload: ch.PayloadConfig{Firmware: &fw}}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := c.BootVM(ctx); err != nil {
+		t.Fatalf("boot: %v", err)
+	}
+	if err := c.PowerButton(ctx); err != nil {
+		t.Fatalf("power button: %v", err)
+	}
+
+	ev := waitEvent(t, events)
+	if ev.ExitCode != 0 || ev.Restarted {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	if _, err := s.Client("vm1"); !errors.Is(err, ErrUnknownVM) {
+		t.Fatalf("exited vmm still listed: %v", err)
+	}
+}
+
+func TestRediscoverAdoptsRunningVMMs(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestSupervisor(t, Config{RuntimeDir: dir})
+	ctx := context.Background()
+	if _, err := first.Start(ctx, "vm1"); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	pid := first.List()[0].PID
+
+	// A stale VM directory whose pid is gone is cleaned up, not adopted.
+	if err := os.MkdirAll(first.Dir("vm2"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(first.Dir("vm2"), pidFile), []byte("999999999"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second supervisor stands in for a restarted agent.
+	second := newTestSupervisor(t, Config{RuntimeDir: dir})
+	adopted, err := second.Rediscover(ctx)
+	if err != nil {
+		t.Fatalf("rediscover: %v", err)
+	}
+	if len(adopted) != 1 || adopted[0] != "vm1" {
+		t.Fatalf("adopted %v", adopted)
+	}
+	info := second.List()[0]
+	if !info.Adopted || info.PID != pid {
+		t.Fatalf("unexpected adopted info: %+v", info)
+	}
+	if _, err := os.Stat(filepath.Join(first.Dir("vm2"), pidFile)); !os.IsNotExist(err) {
+		t.Fatalf("stale pid file not cleaned up: %v", err)
+	}
+	if err := second.Stop(ctx, "vm1"); err != nil {
+		t.Fatalf("stop adopted vmm: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for processAlive(pid) && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if processAlive(pid) {
+		t.Fatalf("adopted vmm still running")
+	}
+}
+
+func TestRediscoverRacingStart(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestSupervisor(t, Config{RuntimeDir: dir})
+	ctx := context.Background()
+	if _, err := first.Start(ctx, "vm1"); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	// Either the adoption or the Start brings up vm1's VMM, never both.
+	var spawns atomic.Int32
+	command := func(vmID, socketPath string) *exec.Cmd {
+		spawns.Add(1)
+		return fakeVMMCommand(vmID, socketPath)
+	}
+	second := newTestSupervisor(t, Config{RuntimeDir: dir, Command: command})
+	var adopted []string
+	var rerr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		adopted, rerr = second.Rediscover(ctx)
+	}()
+	c, err := second.Start(ctx, "vm1")
+	<-done
+	if err != nil || rerr != nil {
+		t.Fatalf("start: %v, rediscover: %v", err, rerr)
+	}
+	if _, err := c.Ping(ctx); err != nil {
+		t.Fatalf("vmm from start does not answer: %v", err)
+	}
+	if n := int(spawns.Load()) + len(adopted); n != 1 {
+		t.Fatalf("expected one vmm for vm1, got %d spawned and %v adopted", spawns.Load(), adopted)
+	}
+	if infos := second.List(); len(infos) != 1 || infos[0].Adopted != (len(adopted) == 1) {
+		t.Fatalf("unexpected vmms: %+v", infos)
+	}
+	if _, err := os.Stat(second.SocketPath("vm1")); err != nil {
+		t.Fatalf("vm1's socket is gone: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+// Package cloudinit renders cloud-init NoCloud seed images: small ISO9660
+// file systems labelled "cidata" holding a VM's user-data, meta-data and
+// network-config, which cloud-init in the guest reads on boot.
+package cloudinit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// VolumeLabel is the file system label cloud-init looks for.
+const VolumeLabel = "cidata"
+
+// Files are the contents of a seed. MetaData and UserData are always
+// written, NetworkConfig only when set.
+type Files struct {
+	MetaData      string
+	UserData      string
+	NetworkConfig string
+}
+
+// MetaData is the meta-data of a VM that did not bring its own.
+func MetaData(instanceID, hostname string) string {
+	return fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", instanceID, hostname)
+}
+
+// WriteSeed writes the seed image for files to path. The file is replaced
+// atomically and left alone when it already has the same contents, so
+// rewriting an unchanged seed does not disturb a VM that has it open.
+func WriteSeed(path string, files Files) error {
+	img := Image(files)
+	if cur, err := os.ReadFile(path); err == nil && bytes.Equal(cur, img) {
+		return nil
+	}
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp)
+	if err := os.WriteFile(tmp, img, 0o440); err != nil {
+		return fmt.Errorf("write seed: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write seed: %w", err)
+	}
+	return nil
+}
+
+// RemoveSeed deletes the seed image at path if there is one.
+func RemoveSeed(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// The image is laid out as follows, in 2048-byte sectors:
+//
+//	0-15   system area
+//	16     primary volume descriptor
+//	17     Joliet supplementary volume descriptor
+//	18     volume descriptor set terminator
+//	19-22  L and M path tables of the primary and the Joliet tree
+//	23     primary root directory
+//	24     Joliet root directory
+//	25-    file data, shared by both trees
+//
+// The primary tree has ISO9660 names; Linux and cloud-init see the Joliet
+// tree, which keeps the names NoCloud expects.
+const (
+	sectorSize      = 2048
+	pvdSector       = 16
+	svdSector       = 17
+	termSector      = 18
+	pathTableSector = 19
+	rootSector      = 23
+	jolietRoot      = 24
+	dataSector      = 25
+)
+
+type seedFile struct {
+	name string // NoCloud name, e.g. "user-data"
+	data []byte
+	loc  uint32
+}
+
+// Image renders the seed image for files. The output only depends on files.
+func Image(files Files) []byte {
+	list := []*seedFile{
+		{name: "meta-data", data: []byte(files.MetaData)},
+	}
+	if files.NetworkConfig != "" {
+		list = append(list, &seedFile{name: "network-config", data: []byte(files.NetworkConfig)})
+	}
+	list = append(list, &seedFile{name: "user-data", data: []byte(files.UserData)})
+
+	next := uint32(dataSector)
+	for _, f := range list {
+		f.loc = next
+		next += sectors(len(f.data))
+	}
+	img := make([]byte, int(next)*sectorSize)
+	sector := func(n int) []byte { return img[n*sectorSize : (n+1)*sectorSize] }
+
+	writeDir(sector(rootSector), rootSector, list, isoName)
+	writeDir(sector(jolietRoot), jolietRoot, list, jolietName)
+	writeVolumeDescriptor(sector(pvdSector), 1, next, pathTableSector, rootSector, padded)
+	writeVolumeDescriptor(sector(svdSector), 2, next, pathTableSector+2, jolietRoot, ucs2Padded)
+	copy(sector(svdSector)[88:], "%/E") // Joliet UCS-2 level 3
+	term := sector(termSector)
+	term[0], term[6] = 255, 1
+	copy(term[1:], "CD001")
+	writePathTables(img, pathTableSector, rootSector)
+	writePathTables(img, pathTableSector+2, jolietRoot)
+	for _, f := range list {
+		copy(img[int(f.loc)*sectorSize:], f.data)
+	}
+	return img
+}
+
+func sectors(n int) uint32 {
+	return uint32((n + sectorSize - 1) / sectorSize)
+}
+
+// isoName is a file's name in the primary tree, e.g. "USER_DATA.;1".
+func isoName(name string) []byte {
+	return []byte(strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + ".;1")
+}
+
+// jolietName is a file's name in the Joliet tree: big-endian UCS-2.
+func jolietName(name string) []byte {
+	return ucs2(name)
+}
+
+func ucs2(s string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = binary.BigEndian.AppendUint16(b, r)
+	}
+	return b
+}
+
+// writeDir writes the root directory, which holds all files, into buf.
+func writeDir(buf []byte, self uint32, files []*seedFile, name func(string) []byte) {
+	off := dirRecord(buf, self, sectorSize, true, []byte{0})
+	off += dirRecord(buf[off:], self, sectorSize, true, []byte{1})
+	for _, f := range files {
+		off += dirRecord(buf[off:], f.loc, uint32(len(f.data)), false, name(f.name))
+	}
+}
+
+// dirRecord writes a directory record and returns its length.
+func dirRecord(buf []byte, loc, size uint32, dir bool, id []byte) int {
+	n := 33 + len(id)
+	if n%2 == 1 {
+		n++
+	}
+	buf[0] = byte(n)
+	bothEndian32(buf[2:], loc)
+	bothEndian32(buf[10:], size)
+	// Recording date 18-24 stays zero: not specified.
+	if dir {
+		buf[25] = 0x02
+	}
+	bothEndian16(buf[28:], 1)
+	buf[32] = byte(len(id))
+	copy(buf[33:], id)
+	return n
+}
+
+// writeVolumeDescriptor fills a primary (typ 1) or supplementary (typ 2)
+// volume descriptor; pad encodes its text fields.
+func writeVolumeDescriptor(buf []byte, typ byte, volumeSectors, pathTable, root uint32, pad func(string, int) []byte) {
+	buf[0] = typ
+	copy(buf[1:], "CD001")
+	buf[6] = 1
+	copy(buf[8:40], pad("LINUX", 32))
+	copy(buf[40:72], pad(VolumeLabel, 32))
+	bothEndian32(buf[80:], volumeSectors)
+	bothEndian16(buf[120:], 1)
+	bothEndian16(buf[124:], 1)
+	bothEndian16(buf[128:], sectorSize)
+	bothEndian32(buf[132:], pathTableSize)
+	binary.LittleEndian.PutUint32(buf[140:], pathTable)
+	binary.BigEndian.PutUint32(buf[148:], pathTable+1)
+	dirRecord(buf[156:190], root, sectorSize, true, []byte{0})
+	for _, f := range []struct{ off, n int }{{190, 128}, {318, 128}, {446, 128}, {574, 128}, {702, 37}, {739, 37}, {776, 37}} {
+		copy(buf[f.off:f.off+f.n], pad("", f.n))
+	}
+	// Creation, modification, expiration and effective dates: not specified.
+	for _, off := range []int{813, 830, 847, 864} {
+		copy(buf[off:off+16], "0000000000000000")
+	}
+	buf[881] = 1
+}
+
+// pathTableSize is the size of a path table listing only the root.
+const pathTableSize = 10
+
+// writePathTables writes the L path table at sector at and the M path table
+// after it.
+func writePathTables(img []byte, at, root uint32) {
+	l := img[int(at)*sectorSize:]
+	l[0] = 1
+	binary.LittleEndian.PutUint32(l[2:], root)
+	binary.LittleEndian.PutUint16(l[6:], 1)
+	m := img[int(at+1)*sectorSize:]
+	m[0] = 1
+	binary.BigEndian.PutUint32(m[2:], root)
+	binary.BigEndian.PutUint16(m[6:], 1)
+}
+
+func padded(s string, n int) []byte {
+	return []byte(s + strings.Repeat(" ", n-len(s)))
+}
+
+func ucs2Padded(s string, n int) []byte {
+	b := ucs2(s)
+	for len(b)+2 <= n {
+		b = append(b, 0, ' ')
+	}
+	if len(b) < n {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func bothEndian16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func bothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
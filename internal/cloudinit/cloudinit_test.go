@@ -0,0 +1,106 @@
+package cloudinit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// readJoliet lists the files of a seed image's Joliet root directory.
+func readJoliet(t *testing.T, img []byte) map[string]string {
+	t.Helper()
+	svd := img[svdSector*sectorSize:]
+	if svd[0] != 2 || string(svd[1:6]) != "CD001" || string(svd[88:91]) != "%/E" {
+		t.Fatalf("no Joliet volume descriptor")
+	}
+	root := binary.LittleEndian.Uint32(svd[156+2:])
+	dir := img[int(root)*sectorSize:][:sectorSize]
+	files := map[string]string{}
+	for off := 0; off < len(dir) && dir[off] != 0; off += int(dir[off]) {
+		rec := dir[off:]
+		id := rec[33 : 33+int(rec[32])]
+		if len(id) == 1 {
+			continue // . and ..
+		}
+		u := make([]uint16, len(id)/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(id[2*i:])
+		}
+		loc, size := binary.LittleEndian.Uint32(rec[2:]), binary.LittleEndian.Uint32(rec[10:])
+		files[string(utf16.Decode(u))] = string(img[int(loc)*sectorSize:][:size])
+	}
+	return files
+}
+
+func TestImage(t *testing.T) {
+	userData := "#cloud-config\nssh_authorized_keys:\n  - ssh-ed25519 AAAA test\n" + strings.Repeat("# padding\n", 300)
+	img := Image(Files{MetaData: MetaData("vm-1", "web-1"), UserData: userData, NetworkConfig: "version: 2\n"})
+	if len(img)%sectorSize != 0 {
+		t.Fatalf("image size %d is not a whole number of sectors", len(img))
+	}
+	pvd := img[pvdSector*sectorSize:]
+	if pvd[0] != 1 || string(pvd[1:6]) != "CD001" || strings.TrimSpace(string(pvd[40:72])) != VolumeLabel {
+		t.Fatalf("bad primary volume descriptor")
+	}
+	if got := binary.LittleEndian.Uint32(pvd[80:]); int(got)*sectorSize != len(img) {
+		t.Fatalf("volume size %d sectors, image has %d bytes", got, len(img))
+	}
+	files := readJoliet(t, img)
+	want := map[string]string{
+		"meta-data":      "instance-id: vm-1\nlocal-hostname: web-1\n",
+		"user-data":      userData,
+		"network-config": "version: 2\n",
+	}
+	for name, data := range want {
+		if files[name] != data {
+			t.Errorf("%s: got %q", name, files[name])
+		}
+	}
+	if len(files) != len(want) {
+		t.Errorf("unexpected files: %v", files)
+	}
+
+	if !bytes.Equal(img, Image(Files{MetaData: MetaData("vm-1", "web-1"), UserData: userData, NetworkConfig: "version: 2\n"})) {
+		t.Fatal("rendering is not deterministic")
+	}
+	files = readJoliet(t, Image(Files{MetaData: "instance-id: x\n"}))
+	if _, ok := files["network-config"]; ok || len(files) != 2 || files["user-data"] != "" {
+		t.Fatalf("expected only meta-data and empty user-data, got %v", files)
+	}
+}
+
+func TestWriteSeed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cidata.iso")
+	files := Files{MetaData: MetaData("vm-1", "web-1"), UserData: "#cloud-config\n"}
+	if err := WriteSeed(path, files); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := os.Stat(path)
+
+	// An unchanged seed is not rewritten
+	if err := WriteSeed(path, files); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.Stat(path); !os.SameFile(st, again) {
+		t.Fatal("unchanged seed was replaced")
+	}
+	files.UserData = "#cloud-config\nhostname: db-1\n"
+	if err := WriteSeed(path, files); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	if readJoliet(t, b)["user-data"] != files.UserData {
+		t.Fatal("seed not regenerated")
+	}
+
+	if err := RemoveSeed(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveSeed(path); err != nil {
+		t.Fatalf("removing a missing seed: %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+// Package capacity accounts for host and cluster resources. Physical capacity
+// comes from the hosts' latest inventory; usage is the sum of the VMs placed
+// on them. The cluster's capacity policy turns physical capacity into
+// allocatable capacity by subtracting the host overhead reservation and
+// applying the overcommit ratios.
+package capacity
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+)
+
+// Resources is an amount of each schedulable resource.
+type Resources struct {
+	Vcpus        int   `json:"vcpus"`
+	MemoryMiB    int64 `json:"memoryMiB"`
+	HugepagesMiB int64 `json:"hugepagesMiB"`
+	DiskGiB      int64 `json:"diskGiB"`
+}
+
+func (r Resources) add(o Resources) Resources {
+	return Resources{
+		Vcpus:        r.Vcpus + o.Vcpus,
+		MemoryMiB:    r.MemoryMiB + o.MemoryMiB,
+		HugepagesMiB: r.HugepagesMiB + o.HugepagesMiB,
+		DiskGiB:      r.DiskGiB + o.DiskGiB,
+	}
+}
+
+func (r Resources) sub(o Resources) Resources {
+	return Resources{
+		Vcpus:        r.Vcpus - o.Vcpus,
+		MemoryMiB:    r.MemoryMiB - o.MemoryMiB,
+		HugepagesMiB: r.HugepagesMiB - o.HugepagesMiB,
+		DiskGiB:      r.DiskGiB - o.DiskGiB,
+	}
+}
+
+// Host is the resource accounting of one host. Memory excludes the hugepage
+// pools, which are accounted separately and never overcommitted; neither is
+// disk. Free is Allocatable minus Used and goes negative when a host is
+// overcommitted beyond its policy (for example after the policy was tightened).
+type Host struct {
+	HostID   string `json:"hostId"`
+	Hostname string `json:"hostname"`
+	// HasInventory is false until the agent reported an inventory; all
+	// capacity figures are zero until then.
+	HasInventory bool `json:"hasInventory"`
+	// HasStorage is false when the inventory carries no storage figures, in
+	// which case disk capacity is unknown rather than zero.
+	HasStorage  bool      `json:"hasStorage"`
+	Physical    Resources `json:"physical"`
+	Reserved    Resources `json:"reserved"`
+	Allocatable Resources `json:"allocatable"`
+	Used        Resources `json:"used"`
+	Free        Resources `json:"free"`
+	VMs         int       `json:"vms"`
+}
+
+// Cluster is the resource accounting of a cluster and each of its hosts.
+type Cluster struct {
+	ClusterID   string                `json:"clusterId"`
+	Policy      stores.CapacityPolicy `json:"policy"`
+	Hosts       []*Host               `json:"hosts"`
+	Physical    Resources             `json:"physical"`
+	Reserved    Resources             `json:"reserved"`
+	Allocatable Resources             `json:"allocatable"`
+	Used        Resources             `json:"used"`
+	Free        Resources             `json:"free"`
+}
+
+// Usage sums the resources allocated to vms. Hugepage-backed VMs consume
+// hugepage memory instead of regular memory.
+func Usage(vms []*stores.VM) Resources {
+	var r Resources
+	for _, vm := range vms {
+		r.Vcpus += vm.Vcpus
+		if vm.Requirements.Hugepages {
+			r.HugepagesMiB += int64(vm.MemoryMiB)
+		} else {
+			r.MemoryMiB += int64(vm.MemoryMiB)
+		}
+		for _, d := range vm.Disks {
+			r.DiskGiB += int64(d.SizeGiB)
+		}
+	}
+	return r
+}
+
+// Physical returns the resources a host reports in its inventory.
+func Physical(inv *stores.Inventory) Resources {
+	if inv == nil {
+		return Resources{}
+	}
+	var huge int64
+	for size, pool := range inv.Memory.Hugepages {
+		if kib, err := strconv.ParseInt(size, 10, 64); err == nil {
+			huge += pool.Total * kib >> 10
+		}
+	}
+	r := Resources{
+		Vcpus:        inv.CPU.LogicalCPUs(),
+		MemoryMiB:    inv.Memory.Total>>20 - huge,
+		HugepagesMiB: huge,
+	}
+	if inv.Storage != nil {
+		r.DiskGiB = inv.Storage.Total >> 30
+	}
+	return r
+}
+
+// ForHost computes the accounting of host h from its latest inventory (nil
+// if none was reported), the VMs placed on it and its cluster's policy.
+func ForHost(h *stores.Host, inv *stores.Inventory, vms []*stores.VM, policy stores.CapacityPolicy) *Host {
+	hc := &Host{
+		HostID:       h.ID,
+		Hostname:     h.Hostname,
+		HasInventory: inv != nil,
+		HasStorage:   inv != nil && inv.Storage != nil,
+		Used:         Usage(vms),
+		VMs:          len(vms),
+	}
+	if inv != nil {
+		hc.Physical = Physical(inv)
+		hc.Reserved = Resources{Vcpus: policy.ReservedVcpus, MemoryMiB: int64(policy.ReservedMemoryMiB)}
+		base := hc.Physical.sub(hc.Reserved)
+		hc.Allocatable = Resources{
+			Vcpus:        int(math.Floor(float64(max(base.Vcpus, 0)) * policy.CPUOvercommit)),
+			MemoryMiB:    int64(math.Floor(float64(max(base.MemoryMiB, 0)) * policy.MemoryOvercommit)),
+			HugepagesMiB: base.HugepagesMiB,
+			DiskGiB:      base.DiskGiB,
+		}
+	}
+	hc.Free = hc.Allocatable.sub(hc.Used)
+	return hc
+}
+
+// HostFor computes the accounting of the host with the given id.
+func HostFor(st *stores.Stores, hostID string) (*Host, error) {
+	h, err := st.GetHost(hostID)
+	if err != nil {
+		return nil, err
+	}
+	return forStoredHost(st, h), nil
+}
+
+// ForCluster computes the accounting of a cluster. A cluster is known when it
+// was created through the API or when any host is a member of it.
+func ForCluster(st *stores.Stores, clusterID string) (*Cluster, error) {
+	if clusterID == "" {
+		return nil, fmt.Errorf("%w: cluster id is required", stores.ErrInvalid)
+	}
+	hosts := st.ListHosts("", clusterID)
+	if _, err := st.GetCluster(clusterID); err != nil && len(hosts) == 0 {
+		return nil, err
+	}
+	cc := &Cluster{ClusterID: clusterID, Policy: st.CapacityPolicy(clusterID), Hosts: make([]*Host, 0, len(hosts))}
+	for _, h := range hosts {
+		hc := forStoredHost(st, h)
+		cc.Hosts = append(cc.Hosts, hc)
+		cc.Physical = cc.Physical.add(hc.Physical)
+		cc.Reserved = cc.Reserved.add(hc.Reserved)
+		cc.Allocatable = cc.Allocatable.add(hc.Allocatable)
+		cc.Used = cc.Used.add(hc.Used)
+	}
+	cc.Free = cc.Allocatable.sub(cc.Used)
+	return cc, nil
+}
+
+func forStoredHost(st *stores.Stores, h *stores.Host) *Host {
+	inv, err := st.LatestInventory(h.ID)
+	if err != nil {
+		inv = nil
+	}
+	return ForHost(h, inv, st.HostVMs(h.ID), st.CapacityPolicy(h.ClusterID))
+}
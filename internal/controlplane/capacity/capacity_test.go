@@ -0,0 +1,72 @@
+package capacity
+
+import (
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+)
+
+func TestForHost(t *testing.T) {
+	h := &stores.Host{ID: "h1", Hostname: "h1"}
+	inv := &stores.Inventory{
+		CPU: stores.InventoryCPU{Sockets: 2, Cores: 4, Threads: 2},
+		Memory: stores.InventoryMemory{
+			Total:     32 << 30,
+			Hugepages: map[string]stores.HugepagePool{"2048": {Total: 2048, Free: 2048}, "1048576": {Total: 4, Free: 4}},
+		},
+		Storage: &stores.InventoryStorage{Path: "/run/vertera/vms", Total: 500 << 30, Free: 400 << 30},
+	}
+	vms := []*stores.VM{
+		{Vcpus: 4, MemoryMiB: 4096, Disks: []stores.VMDisk{{SizeGiB: 20}, {SizeGiB: 30}}},
+		{Vcpus: 2, MemoryMiB: 2048, Requirements: stores.VMRequirements{Hugepages: true}},
+	}
+	policy := stores.CapacityPolicy{CPUOvercommit: 2, MemoryOvercommit: 1.5, ReservedVcpus: 2, ReservedMemoryMiB: 2048}
+
+	hc := ForHost(h, inv, vms, policy)
+	// 32 GiB minus 4 GiB of 2 MiB pages and 4 GiB of 1 GiB pages
+	if want := (Resources{Vcpus: 16, MemoryMiB: 24576, HugepagesMiB: 8192, DiskGiB: 500}); hc.Physical != want {
+		t.Fatalf("physical = %+v, want %+v", hc.Physical, want)
+	}
+	// Overcommit applies to vCPUs and memory only, after the reservation
+	if want := (Resources{Vcpus: 28, MemoryMiB: 33792, HugepagesMiB: 8192, DiskGiB: 500}); hc.Allocatable != want {
+		t.Fatalf("allocatable = %+v, want %+v", hc.Allocatable, want)
+	}
+	if want := (Resources{Vcpus: 6, MemoryMiB: 4096, HugepagesMiB: 2048, DiskGiB: 50}); hc.Used != want {
+		t.Fatalf("used = %+v, want %+v", hc.Used, want)
+	}
+	if want := (Resources{Vcpus: 22, MemoryMiB: 29696, HugepagesMiB: 6144, DiskGiB: 450}); hc.Free != want || hc.VMs != 2 {
+		t.Fatalf("free = %+v, want %+v", hc.Free, want)
+	}
+
+	// Without inventory nothing is allocatable but usage is still reported
+	hc = ForHost(h, nil, vms, policy)
+	if hc.HasInventory || hc.Allocatable != (Resources{}) || hc.Free.Vcpus != -6 {
+		t.Fatalf("unexpected capacity without inventory: %+v", hc)
+	}
+}
+
+func TestForCluster(t *testing.T) {
+	st := stores.New()
+	if _, err := ForCluster(st, "missing"); err == nil {
+		t.Fatal("expected error for unknown cluster")
+	}
+	// Clusters referenced only by hosts use the default policy
+	cluster := "adhoc"
+	for _, name := range []string{"a", "b"} {
+		h, err := st.CreateHost(stores.HostCreate{ProjectID: "p1", ClusterID: &cluster, Hostname: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		inv := stores.Inventory{CPU: stores.InventoryCPU{Sockets: 1, Cores: 4, Threads: 1}, Memory: stores.InventoryMemory{Total: 8 << 30}}
+		if err := st.RecordInventory(h.ID, inv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cc, err := ForCluster(st, cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.Policy != stores.DefaultCapacityPolicy() || len(cc.Hosts) != 2 || cc.Allocatable != (Resources{Vcpus: 8, MemoryMiB: 16384}) {
+		t.Fatalf("unexpected cluster capacity: %+v", cc)
+	}
+}
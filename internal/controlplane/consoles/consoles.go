@@ -0,0 +1,152 @@
+// Package consoles brokers serial console sessions between API clients and
+// agents. Agents only dial the controller, so opening a console is a round
+// trip: the controller sends a request on the agent's console watch stream,
+// the agent attaches to the VM's console and calls back with a Console
+// stream for the session, and the broker joins that stream to the viewer.
+package consoles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnavailable is returned when the host's agent is not watching for
+	// console sessions or did not attach in time.
+	ErrUnavailable = errors.New("console unavailable")
+	// ErrUnknownSession is returned when an agent attaches to a session that
+	// was never opened or has given up waiting.
+	ErrUnknownSession = errors.New("unknown console session")
+)
+
+// DefaultAttachTimeout is how long Open waits for the agent.
+const DefaultAttachTimeout = 10 * time.Second
+
+// Request asks an agent to serve the console of one of its VMs.
+type Request struct {
+	SessionID string
+	VMID      string
+}
+
+// Broker pairs viewers with agent console streams.
+type Broker struct {
+	attachTimeout time.Duration
+
+	mu      sync.Mutex
+	subs    map[string]chan *Request // hostID -> the agent's watch stream
+	waiting map[string]chan attached // sessionID -> Open waiting for the agent
+}
+
+type attached struct {
+	conn net.Conn
+	err  error
+}
+
+// NewBroker returns a broker that waits attachTimeout for agents to attach.
+func NewBroker(attachTimeout time.Duration) *Broker {
+	return &Broker{
+		attachTimeout: attachTimeout,
+		subs:          make(map[string]chan *Request),
+		waiting:       make(map[string]chan attached),
+	}
+}
+
+// Default is the process-wide broker shared by the HTTP and gRPC handlers.
+var Default = NewBroker(DefaultAttachTimeout)
+
+// Subscribe registers the console watch stream of a host's agent, replacing
+// an earlier one. The caller must call the returned func when the stream
+// ends.
+func (b *Broker) Subscribe(hostID string) (<-chan *Request, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan *Request, 8)
+	b.subs[hostID] = ch
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.subs[hostID] == ch {
+			delete(b.subs, hostID)
+		}
+	}
+}
+
+// Open asks hostID's agent for the console of vmID and returns the viewer's
+// end of the session once the agent attached. Closing it ends the session.
+func (b *Broker) Open(ctx context.Context, hostID, sessionID, vmID string) (net.Conn, error) {
+	ready := make(chan attached, 1)
+	b.mu.Lock()
+	sub, ok := b.subs[hostID]
+	if ok {
+		select {
+		case sub <- &Request{SessionID: sessionID, VMID: vmID}:
+			b.waiting[sessionID] = ready
+		default:
+			ok = false
+		}
+	}
+	b.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: agent of host %s is not connected", ErrUnavailable, hostID)
+	}
+
+	timer := time.NewTimer(b.attachTimeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case a := <-ready:
+		return a.conn, a.err
+	case <-timer.C:
+		err = fmt.Errorf("%w: agent of host %s did not attach to the console", ErrUnavailable, hostID)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	b.mu.Lock()
+	_, pending := b.waiting[sessionID]
+	delete(b.waiting, sessionID)
+	b.mu.Unlock()
+	if !pending {
+		// The agent attached while we gave up.
+		if a := <-ready; a.conn != nil {
+			a.conn.Close()
+		}
+	}
+	return nil, err
+}
+
+// Attach hands the agent's end of a session to the agent's Console stream.
+func (b *Broker) Attach(sessionID string) (net.Conn, error) {
+	ready, err := b.take(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	viewer, agent := net.Pipe()
+	ready <- attached{conn: viewer}
+	return agent, nil
+}
+
+// Reject fails a session the agent could not attach to, e.g. because the
+// VM's VMM is not running.
+func (b *Broker) Reject(sessionID string, reason error) error {
+	ready, err := b.take(sessionID)
+	if err != nil {
+		return err
+	}
+	ready <- attached{err: fmt.Errorf("%w: %v", ErrUnavailable, reason)}
+	return nil
+}
+
+func (b *Broker) take(sessionID string) (chan attached, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ready, ok := b.waiting[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownSession, sessionID)
+	}
+	delete(b.waiting, sessionID)
+	return ready, nil
+}
@@ -0,0 +1,59 @@
+package consoles
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestOpen(t *testing.T) {
+	b := NewBroker(200 * time.Millisecond)
+	ctx := context.Background()
+	if _, err := b.Open(ctx, "host-1", "s0", "vm-1"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected an unconnected agent to fail, got %v", err)
+	}
+
+	reqs, unsubscribe := b.Subscribe("host-1")
+	defer unsubscribe()
+	go func() {
+		for req := range reqs {
+			switch req.VMID {
+			case "vm-1":
+				conn, err := b.Attach(req.SessionID)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				go func() { _, _ = io.Copy(conn, conn); conn.Close() }()
+			case "vm-2":
+				_ = b.Reject(req.SessionID, errors.New("vmm not running"))
+			}
+		}
+	}()
+
+	conn, err := b.Open(ctx, "host-1", "s1", "vm-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+	conn.Close()
+
+	if _, err := b.Open(ctx, "host-1", "s2", "vm-2"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected the agent's rejection, got %v", err)
+	}
+	// The agent never answers for vm-3
+	if _, err := b.Open(ctx, "host-1", "s3", "vm-3"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if _, err := b.Attach("s3"); !errors.Is(err, ErrUnknownSession) {
+		t.Fatalf("expected a late attach to fail, got %v", err)
+	}
+}
@@ -0,0 +1,372 @@
+// Package ha fails over hosts that stop heartbeating. A host silent for the
+// grace period is marked unreachable; once fencing confirms it is down it is
+// marked failed and its running HA VMs are re-created from their shared disks
+// on other hosts of its cluster. VMs never start elsewhere before the host is
+// fenced, and a failed host that comes back first stops every VM that moved
+// away before it can rejoin, so no VM runs twice.
+package ha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/dispatch"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+	"github.com/VerteraIO/vertera/internal/controlplane/vms"
+)
+
+// VMs is the VM service failovers recover VMs with. The returned task
+// succeeds once the VM runs on its new host.
+type VMs interface {
+	Recover(id, failedHostID string) (*tasks.Task, error)
+}
+
+var _ VMs = (*vms.Service)(nil)
+
+// Fencer makes sure an unreachable host is down, e.g. by powering it off
+// through its BMC. A nil error confirms the host is fenced.
+type Fencer interface {
+	Fence(ctx context.Context, h *stores.Host) error
+}
+
+// CommandFencer fences hosts by running Command with sh -c. The host is
+// passed in VERTERA_HOST_ID and VERTERA_HOSTNAME; exit status 0 confirms the
+// host is down.
+type CommandFencer struct {
+	Command string
+}
+
+// Fence runs the fence command for h.
+func (f CommandFencer) Fence(ctx context.Context, h *stores.Host) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", f.Command)
+	cmd.Env = append(os.Environ(), "VERTERA_HOST_ID="+h.ID, "VERTERA_HOSTNAME="+h.Hostname)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(out) > 0 {
+			return fmt.Errorf("fence command: %v: %s", err, out)
+		}
+		return fmt.Errorf("fence command: %v", err)
+	}
+	return nil
+}
+
+// Manager detects failed hosts and runs their failovers.
+type Manager struct {
+	store    *stores.Stores
+	tasks    *tasks.Manager
+	dispatch *dispatch.Manager
+	vms      VMs
+	// fencer is nil when hosts are only fenced by operator confirmation.
+	fencer   Fencer
+	grace    time.Duration
+	interval time.Duration
+
+	// mu serialises failover progress so a VM is never recovered twice.
+	mu sync.Mutex
+}
+
+// New returns a Manager and subscribes it to task status changes. Hosts are
+// declared unreachable after VERTERA_HA_GRACE_PERIOD (default 2m) without a
+// heartbeat and checked every VERTERA_HA_INTERVAL (default 10s) once
+// started. A nil fencer leaves fencing to the operator.
+func New(st *stores.Stores, tm *tasks.Manager, dm *dispatch.Manager, v VMs, f Fencer) *Manager {
+	m := &Manager{
+		store:    st,
+		tasks:    tm,
+		dispatch: dm,
+		vms:      v,
+		fencer:   f,
+		grace:    envDuration("VERTERA_HA_GRACE_PERIOD", 2*time.Minute),
+		interval: envDuration("VERTERA_HA_INTERVAL", 10*time.Second),
+	}
+	tm.AddListener(m.handleTask)
+	return m
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return def
+}
+
+// defaultFencer runs VERTERA_HA_FENCE_COMMAND if it is set.
+func defaultFencer() Fencer {
+	if c := os.Getenv("VERTERA_HA_FENCE_COMMAND"); c != "" {
+		return CommandFencer{Command: c}
+	}
+	return nil
+}
+
+// Default is the process-wide manager backed by the default store, tasks,
+// dispatcher and VM service.
+var Default = New(stores.Default, tasks.Default, dispatch.Default, vms.Default, defaultFencer())
+
+func (m *Manager) Start() {
+	log.Println("controlplane: ha manager started")
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.Check()
+		<-ticker.C
+	}
+}
+
+// Check marks hosts that missed their heartbeats for the grace period
+// unreachable, fences unreachable hosts if a fencer is configured and retries
+// VMs of failovers in progress that found no host yet.
+func (m *Manager) Check() {
+	cutoff := time.Now().UTC().Add(-m.grace)
+	for _, h := range m.store.ListHosts("", "") {
+		if h.LastSeenAt == nil || !h.LastSeenAt.Before(cutoff) {
+			continue
+		}
+		if _, err := m.store.MarkHostUnreachable(h.ID, cutoff); err == nil {
+			log.Printf("ha: host %s missed its heartbeats since %s, marked unreachable", h.Hostname, h.LastSeenAt.Format(time.RFC3339))
+		}
+	}
+	if m.fencer != nil {
+		for _, hostID := range m.store.HostFailovers(stores.FailoverAwaitingFence) {
+			m.fence(hostID)
+		}
+	}
+	for _, hostID := range m.store.HostFailovers(stores.FailoverInProgress) {
+		m.advance(hostID)
+	}
+}
+
+// fence runs the fencer for an unreachable host and starts its failover once
+// the host is fenced; a fencing error is recorded and retried on the next
+// check.
+func (m *Manager) fence(hostID string) {
+	h, err := m.store.GetHost(hostID)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), m.interval)
+	err = m.fencer.Fence(ctx, h)
+	cancel()
+	if err != nil {
+		log.Printf("ha: fence host %s: %v", h.Hostname, err)
+		_, _ = m.store.UpdateHostFailover(hostID, func(f *stores.HostFailover) error {
+			if f.State == stores.FailoverAwaitingFence {
+				f.Error = err.Error()
+			}
+			return nil
+		})
+		return
+	}
+	if _, err := m.ConfirmFenced(hostID, stores.FencedByCommand); err != nil {
+		log.Printf("ha: host %s: %v", h.Hostname, err)
+	}
+}
+
+// ConfirmFenced records that an unreachable host is down, marks it failed and
+// starts recovering its HA VMs.
+func (m *Manager) ConfirmFenced(hostID, by string) (*stores.HostFailover, error) {
+	if _, err := m.store.ConfirmHostFenced(hostID, by); err != nil {
+		return nil, err
+	}
+	log.Printf("ha: host %s fenced (%s), failing over its vms", hostID, by)
+	m.advance(hostID)
+	return m.store.GetHostFailover(hostID)
+}
+
+// Heartbeat records that a host's agent is alive. A failed host that is back
+// is asked to stop the VMs that moved away from it.
+func (m *Manager) Heartbeat(hostID string) (*stores.Host, error) {
+	prev, err := m.store.GetHost(hostID)
+	if err != nil {
+		return nil, err
+	}
+	h, err := m.store.HostHeartbeat(hostID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case prev.State == stores.HostStateUnreachable && h.State != stores.HostStateUnreachable:
+		log.Printf("ha: host %s is back before it was fenced", h.Hostname)
+	case h.State == stores.HostStateFailed:
+		m.release(hostID)
+	}
+	return h, nil
+}
+
+// Rejoin returns a failed host that released its moved VMs to service.
+func (m *Manager) Rejoin(hostID string) (*stores.Host, error) {
+	h, err := m.store.RejoinHost(hostID)
+	if err == nil {
+		log.Printf("ha: host %s rejoined", h.Hostname)
+	}
+	return h, err
+}
+
+// release sends a release task for every VM that moved away from the failed
+// host and has no release queued or running.
+func (m *Manager) release(hostID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.store.GetHostFailover(hostID)
+	if err != nil {
+		return
+	}
+	var sent []*tasks.Task
+	for i := range f.VMs {
+		e := &f.VMs[i]
+		if e.TaskID == "" || e.Released {
+			continue
+		}
+		if t, ok := m.tasks.Get(e.ReleaseTaskID); ok && t.Status != tasks.StatusFailed {
+			continue
+		}
+		t, err := m.tasks.EnqueueReleaseVM(hostID, tasks.ReleaseVMParams{VMID: e.VMID})
+		if err != nil {
+			log.Printf("ha: release vm %s on host %s: %v", e.VMID, hostID, err)
+			continue
+		}
+		e.ReleaseTaskID = t.ID
+		sent = append(sent, t)
+	}
+	if len(sent) == 0 {
+		return
+	}
+	if _, err := m.store.UpdateHostFailover(hostID, func(cur *stores.HostFailover) error {
+		cur.VMs = f.VMs
+		return nil
+	}); err != nil {
+		log.Printf("ha: failover of host %s: %v", hostID, err)
+		return
+	}
+	for _, t := range sent {
+		m.dispatch.AddPending(hostID, t)
+	}
+}
+
+// handleTask records finished release tasks and advances every failover in
+// progress when a task finishes; create tasks are how recoveries complete.
+func (m *Manager) handleTask(t tasks.Task) {
+	if t.Status != tasks.StatusSucceeded && t.Status != tasks.StatusFailed {
+		return
+	}
+	if t.Type == tasks.TypeReleaseVM && t.Status == tasks.StatusSucceeded {
+		m.released(t)
+	}
+	for _, hostID := range m.store.HostFailovers(stores.FailoverInProgress) {
+		m.advance(hostID)
+	}
+}
+
+// released marks the VM of a succeeded release task as released.
+func (m *Manager) released(t tasks.Task) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.store.UpdateHostFailover(t.HostID, func(f *stores.HostFailover) error {
+		for i := range f.VMs {
+			if f.VMs[i].ReleaseTaskID == t.ID {
+				f.VMs[i].Released = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("ha: release task %s: %v", t.ID, err)
+	}
+}
+
+// advance checks the VMs being recovered, recovers pending ones and completes
+// the failover once nothing is left to do.
+func (m *Manager) advance(hostID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.store.GetHostFailover(hostID)
+	if err != nil || f.State != stores.FailoverInProgress {
+		return
+	}
+	m.step(f)
+	_, err = m.store.UpdateHostFailover(hostID, func(cur *stores.HostFailover) error {
+		cur.VMs, cur.State = f.VMs, f.State
+		return nil
+	})
+	if err != nil {
+		log.Printf("ha: failover of host %s: %v", hostID, err)
+		return
+	}
+	if f.State == stores.FailoverCompleted {
+		log.Printf("ha: failover of host %s completed", hostID)
+	}
+}
+
+func (m *Manager) step(f *stores.HostFailover) {
+	left := 0
+	for i := range f.VMs {
+		e := &f.VMs[i]
+		switch e.Status {
+		case stores.FailoverVMInProgress:
+			m.check(e)
+		case stores.FailoverVMPending:
+			m.start(f, e)
+		}
+		if e.Status == stores.FailoverVMPending || e.Status == stores.FailoverVMInProgress {
+			left++
+		}
+	}
+	if left == 0 {
+		f.State = stores.FailoverCompleted
+	}
+}
+
+// start recovers one pending VM. A VM no host can take stays pending and is
+// retried on the next check.
+func (m *Manager) start(f *stores.HostFailover, e *stores.FailoverVM) {
+	vm, err := m.store.GetVM(e.VMID)
+	if errors.Is(err, stores.ErrNotFound) {
+		e.Status, e.Detail = stores.FailoverVMSkipped, "vm was deleted"
+		return
+	}
+	if err != nil {
+		e.Status, e.Detail = stores.FailoverVMFailed, err.Error()
+		return
+	}
+	if vm.HostID != f.HostID {
+		e.Status, e.Detail = stores.FailoverVMSkipped, "vm already left the host"
+		return
+	}
+	t, err := m.vms.Recover(vm.ID, f.HostID)
+	if errors.Is(err, stores.ErrConflict) {
+		e.Detail = err.Error()
+		return
+	}
+	if err != nil {
+		e.Status, e.Detail = stores.FailoverVMFailed, err.Error()
+		return
+	}
+	e.Status, e.TaskID, e.TargetHostID, e.Detail = stores.FailoverVMInProgress, t.ID, t.HostID, ""
+}
+
+// check settles a VM whose recovery is in flight.
+func (m *Manager) check(e *stores.FailoverVM) {
+	if _, err := m.store.GetVM(e.VMID); errors.Is(err, stores.ErrNotFound) {
+		e.Status, e.Detail = stores.FailoverVMSkipped, "vm was deleted"
+		return
+	}
+	t, ok := m.tasks.Get(e.TaskID)
+	if !ok {
+		e.Status, e.Detail = stores.FailoverVMFailed, fmt.Sprintf("task %s not found", e.TaskID)
+		return
+	}
+	switch t.Status {
+	case tasks.StatusFailed:
+		e.Status, e.Detail = stores.FailoverVMFailed, t.Error
+	case tasks.StatusSucceeded:
+		e.Status = stores.FailoverVMRecovered
+	}
+}
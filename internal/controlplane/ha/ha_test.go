@@ -0,0 +1,243 @@
+package ha
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/dispatch"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+)
+
+// fakeVMs moves recovered VMs to the survivor and records a create task
+// without an agent. With full set no host can take a VM.
+type fakeVMs struct {
+	st        *stores.Stores
+	tm        *tasks.Manager
+	survivor  string
+	full      bool
+	recovered []string
+}
+
+func (f *fakeVMs) Recover(id, failedHostID string) (*tasks.Task, error) {
+	if f.full {
+		return nil, errors.New("no host fits")
+	}
+	if _, err := f.st.RecoverVM(id, failedHostID, f.survivor); err != nil {
+		return nil, err
+	}
+	f.recovered = append(f.recovered, id)
+	return f.tm.EnqueueCreateVM(f.survivor, tasks.CreateVMParams{Recover: true})
+}
+
+// fakeFencer fails while err is set.
+type fakeFencer struct {
+	err    error
+	fenced []string
+}
+
+func (f *fakeFencer) Fence(_ context.Context, h *stores.Host) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.fenced = append(f.fenced, h.ID)
+	return nil
+}
+
+func setup(t *testing.T) (*stores.Stores, *tasks.Manager, *dispatch.Manager, *fakeVMs, [2]*stores.Host, [2]*stores.VM) {
+	t.Helper()
+	st := stores.New()
+	c, err := st.CreateCluster(stores.ClusterCreate{ProjectID: "p1", Name: "c1", Storage: stores.StorageShared})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hosts [2]*stores.Host
+	for i, name := range []string{"h1", "h2"} {
+		if _, err := st.CreateHost(stores.HostCreate{ProjectID: "p1", ClusterID: &c.ID, Hostname: name}); err != nil {
+			t.Fatal(err)
+		}
+		if hosts[i], err = st.RegisterAgentHost("", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var vms [2]*stores.VM
+	for i, name := range []string{"ha", "plain"} {
+		vm, err := st.CreateVM(stores.VMCreate{ProjectID: "p1", HostID: &hosts[0].ID, Name: name, Vcpus: 1, MemoryMiB: 512, HA: i == 0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vms[i], err = st.SetVMState(vm.ID, stores.VMStateRunning, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tm := tasks.NewManager()
+	return st, tm, dispatch.NewManager(), &fakeVMs{st: st, tm: tm, survivor: hosts[1].ID}, hosts, vms
+}
+
+// silence makes the host look silent for longer than the grace period.
+func silence(t *testing.T, st *stores.Stores, hostID string) {
+	t.Helper()
+	past := time.Now().UTC().Add(-time.Hour)
+	if _, err := st.UpdateHost(hostID, func(h *stores.Host) error { h.LastSeenAt = &past; return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hostState(t *testing.T, st *stores.Stores, hostID string) stores.HostState {
+	t.Helper()
+	h, err := st.GetHost(hostID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h.State
+}
+
+func TestFailover(t *testing.T) {
+	st, tm, dm, fv, hosts, vms := setup(t)
+	m := New(st, tm, dm, fv, nil)
+	m.grace = time.Minute
+	failed := hosts[0].ID
+
+	m.Check()
+	if hostState(t, st, failed) != stores.HostStateReady {
+		t.Fatal("host marked unreachable within the grace period")
+	}
+
+	// A host that comes back before it is fenced keeps its VMs.
+	silence(t, st, failed)
+	m.Check()
+	if hostState(t, st, failed) != stores.HostStateUnreachable || len(fv.recovered) != 0 {
+		t.Fatalf("expected unreachable host without recoveries, got %s, %v", hostState(t, st, failed), fv.recovered)
+	}
+	if _, err := m.Heartbeat(failed); err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := st.GetHostFailover(failed); f.State != stores.FailoverCancelled || hostState(t, st, failed) != stores.HostStateReady {
+		t.Fatalf("expected cancelled failover, got %+v", f)
+	}
+	if _, err := m.ConfirmFenced(failed, stores.FencedByOperator); !errors.Is(err, stores.ErrConflict) {
+		t.Fatalf("expected fencing a ready host to conflict, got %v", err)
+	}
+
+	silence(t, st, failed)
+	m.Check()
+	f, err := m.ConfirmFenced(failed, stores.FencedByOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostState(t, st, failed) != stores.HostStateFailed || f.State != stores.FailoverInProgress {
+		t.Fatalf("expected failed host with failover in progress, got %+v", f)
+	}
+	status := map[string]stores.FailoverVM{}
+	for _, e := range f.VMs {
+		status[e.Name] = e
+	}
+	if status["ha"].Status != stores.FailoverVMInProgress || status["ha"].TargetHostID != hosts[1].ID {
+		t.Fatalf("expected ha vm to be recovering on the survivor, got %+v", status["ha"])
+	}
+	if status["plain"].Status != stores.FailoverVMSkipped {
+		t.Fatalf("expected plain vm to be skipped, got %+v", status["plain"])
+	}
+	if vm, _ := st.GetVM(vms[1].ID); vm.State != stores.VMStateError {
+		t.Fatalf("expected plain vm in error, got %s", vm.State)
+	}
+
+	tm.UpdateStatusSucceeded(status["ha"].TaskID)
+	if f, _ = st.GetHostFailover(failed); f.State != stores.FailoverCompleted || f.VMs[0].Status != stores.FailoverVMRecovered {
+		t.Fatalf("expected completed failover, got %+v", f)
+	}
+
+	// The failed host must come back and release the moved VM first.
+	if _, err := m.Rejoin(failed); !errors.Is(err, stores.ErrConflict) {
+		t.Fatalf("expected rejoin before reconnecting to conflict, got %v", err)
+	}
+	if _, err := m.Heartbeat(failed); err != nil {
+		t.Fatal(err)
+	}
+	pending := dm.DrainPending(failed)
+	if len(pending) != 1 || pending[0].Type != tasks.TypeReleaseVM {
+		t.Fatalf("expected one release task, got %+v", pending)
+	}
+	if _, err := m.Heartbeat(failed); err != nil {
+		t.Fatal(err)
+	}
+	if again := dm.DrainPending(failed); len(again) != 0 {
+		t.Fatalf("release sent twice: %+v", again)
+	}
+	if _, err := m.Rejoin(failed); !errors.Is(err, stores.ErrConflict) {
+		t.Fatalf("expected rejoin before release to conflict, got %v", err)
+	}
+	tm.UpdateStatusSucceeded(pending[0].ID)
+	h, err := m.Rejoin(failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.State != stores.HostStateReady {
+		t.Fatalf("expected ready host, got %s", h.State)
+	}
+}
+
+func TestFailoverFencer(t *testing.T) {
+	st, tm, dm, fv, hosts, _ := setup(t)
+	fencer := &fakeFencer{err: errors.New("bmc unreachable")}
+	m := New(st, tm, dm, fv, fencer)
+	m.grace = time.Minute
+	failed := hosts[0].ID
+	fv.full = true
+
+	silence(t, st, failed)
+	m.Check()
+	f, _ := st.GetHostFailover(failed)
+	if f.State != stores.FailoverAwaitingFence || f.Error == "" {
+		t.Fatalf("expected fencing error to be recorded, got %+v", f)
+	}
+
+	fencer.err = nil
+	m.Check()
+	f, _ = st.GetHostFailover(failed)
+	if f.FencedBy != stores.FencedByCommand || len(fencer.fenced) != 1 {
+		t.Fatalf("expected host fenced by command, got %+v", f)
+	}
+	// Errors other than a lack of room are not retried.
+	if f.State != stores.FailoverCompleted || f.VMs[0].Status != stores.FailoverVMFailed {
+		t.Fatalf("expected failed recovery to complete the failover, got %+v", f)
+	}
+}
+
+func TestFailoverWaitsForCapacity(t *testing.T) {
+	st, tm, dm, fv, hosts, _ := setup(t)
+	m := New(st, tm, dm, &conflictVMs{fakeVMs: fv}, nil)
+	m.grace = time.Minute
+	failed := hosts[0].ID
+	fv.full = true
+
+	silence(t, st, failed)
+	m.Check()
+	f, err := m.ConfirmFenced(failed, stores.FencedByOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.VMs[0].Status != stores.FailoverVMPending || f.VMs[0].Detail == "" {
+		t.Fatalf("expected ha vm to wait for a host, got %+v", f.VMs[0])
+	}
+
+	fv.full = false
+	m.Check()
+	if f, _ = st.GetHostFailover(failed); f.VMs[0].Status != stores.FailoverVMInProgress {
+		t.Fatalf("expected recovery to be retried, got %+v", f.VMs[0])
+	}
+}
+
+// conflictVMs reports a full cluster as a conflict, like the VM service.
+type conflictVMs struct {
+	*fakeVMs
+}
+
+func (c *conflictVMs) Recover(id, failedHostID string) (*tasks.Task, error) {
+	if c.full {
+		return nil, stores.ErrConflict
+	}
+	return c.fakeVMs.Recover(id, failedHostID)
+}
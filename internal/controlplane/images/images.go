@@ -0,0 +1,226 @@
+// Package images maintains the controller's disk image library: it takes
+// image files uploaded through the API or imported from a URL, checksums
+// them, detects their format and keeps them for the agents to fetch.
+package images
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+)
+
+// DefaultDir holds image files unless VERTERA_IMAGE_DIR says otherwise.
+const DefaultDir = "/var/lib/vertera/images"
+
+// Service imports image files into the library and serves them.
+type Service struct {
+	store  *stores.Stores
+	dir    string
+	client *http.Client
+}
+
+// NewService returns a Service keeping image files in dir. An empty dir is
+// resolved from VERTERA_IMAGE_DIR, falling back to DefaultDir, on each use.
+func NewService(st *stores.Stores, dir string) *Service {
+	return &Service{store: st, dir: dir, client: http.DefaultClient}
+}
+
+// Default is the process-wide service backed by the default store.
+var Default = NewService(stores.Default, "")
+
+func (s *Service) root() string {
+	if s.dir != "" {
+		return s.dir
+	}
+	if dir := os.Getenv("VERTERA_IMAGE_DIR"); dir != "" {
+		return dir
+	}
+	return DefaultDir
+}
+
+// path is where the file of an image is kept.
+func (s *Service) path(id string) string {
+	return filepath.Join(s.root(), id)
+}
+
+// Create records an image. An image with a source URL is imported in the
+// background; any other image waits for Upload.
+func (s *Service) Create(in stores.ImageCreate) (*stores.Image, error) {
+	img, err := s.store.CreateImage(in)
+	if err != nil {
+		return nil, err
+	}
+	if img.SourceURL != "" {
+		go s.importURL(img.ID, img.SourceURL, img.SHA256)
+	}
+	return img, nil
+}
+
+// Upload stores the file of a pending (or failed) image from r. It returns
+// once the image is ready; a file that does not match the image's expected
+// checksum or is not a usable disk image fails with stores.ErrInvalid.
+func (s *Service) Upload(id string, r io.Reader) (*stores.Image, error) {
+	img, err := s.store.UpdateImage(id, func(img *stores.Image) error {
+		switch img.State {
+		case stores.ImagePending, stores.ImageError:
+		default:
+			return fmt.Errorf("%w: image is %s", stores.ErrConflict, img.State)
+		}
+		img.State, img.Error = stores.ImageImporting, ""
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.ingest(img.ID, r, img.SHA256)
+}
+
+// importURL downloads an image from its source URL.
+func (s *Service) importURL(id, sourceURL, sha string) {
+	resp, err := s.client.Get(sourceURL)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = fmt.Errorf("GET %s: %s", sourceURL, resp.Status)
+	}
+	if err != nil {
+		s.fail(id, err)
+		return
+	}
+	defer resp.Body.Close()
+	if _, err := s.ingest(id, resp.Body, sha); err != nil {
+		log.Printf("images: import %s: %v", id, err)
+	}
+}
+
+// ingest writes an image file from r, checksums it and detects its format,
+// then marks the image ready. On failure the image is marked failed.
+func (s *Service) ingest(id string, r io.Reader, want string) (*stores.Image, error) {
+	f, err := s.write(id, r, want)
+	if err != nil {
+		s.fail(id, err)
+		return nil, err
+	}
+	img, err := s.store.FinishImage(id, *f)
+	if err != nil {
+		// The image was deleted while it was being imported.
+		_ = os.Remove(s.path(id))
+		return nil, err
+	}
+	return img, nil
+}
+
+func (s *Service) write(id string, r io.Reader, want string) (*stores.ImageFile, error) {
+	if err := os.MkdirAll(s.root(), 0o750); err != nil {
+		return nil, fmt.Errorf("create image dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.root(), id+".*.part")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return nil, fmt.Errorf("write image: %w", err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if want != "" && sum != want {
+		return nil, fmt.Errorf("%w: checksum mismatch: got sha256 %s, want %s", stores.ErrInvalid, sum, want)
+	}
+	header := make([]byte, 512)
+	n, err := tmp.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	format, virtual, err := DetectFormat(header[:n], size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", stores.ErrInvalid, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), s.path(id)); err != nil {
+		return nil, err
+	}
+	return &stores.ImageFile{Format: format, SizeBytes: size, VirtualSizeBytes: virtual, SHA256: sum}, nil
+}
+
+func (s *Service) fail(id string, err error) {
+	_, uerr := s.store.UpdateImage(id, func(img *stores.Image) error {
+		img.State, img.Error = stores.ImageError, err.Error()
+		return nil
+	})
+	if uerr != nil && !errors.Is(uerr, stores.ErrNotFound) {
+		log.Printf("images: mark %s failed: %v", id, uerr)
+	}
+}
+
+// Open returns the file of a ready image; the caller closes it.
+func (s *Service) Open(id string) (*os.File, *stores.Image, error) {
+	img, err := s.store.GetImage(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if img.State != stores.ImageReady {
+		return nil, nil, fmt.Errorf("%w: image is %s", stores.ErrConflict, img.State)
+	}
+	f, err := os.Open(s.path(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, img, nil
+}
+
+// Delete removes an image no VM uses, along with its file.
+func (s *Service) Delete(id string) error {
+	if err := s.store.DeleteImage(id); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("images: remove file of %s: %v", id, err)
+	}
+	return nil
+}
+
+var (
+	qcow2Magic = []byte("QFI\xfb")
+	vmdkMagic  = []byte("KDMV")
+	vhdxMagic  = []byte("vhdxfile")
+)
+
+// DetectFormat tells raw and qcow2 images apart from the first bytes of the
+// file and returns the size of the disk the image holds. qcow2 images must
+// be self-contained; a backing file would point into the controller's file
+// system.
+func DetectFormat(header []byte, size int64) (stores.ImageFormat, int64, error) {
+	switch {
+	case bytes.HasPrefix(header, qcow2Magic):
+		if len(header) < 32 {
+			return "", 0, errors.New("truncated qcow2 header")
+		}
+		if v := binary.BigEndian.Uint32(header[4:8]); v != 2 && v != 3 {
+			return "", 0, fmt.Errorf("unsupported qcow2 version %d", v)
+		}
+		if binary.BigEndian.Uint64(header[8:16]) != 0 {
+			return "", 0, errors.New("qcow2 images with a backing file are not supported")
+		}
+		return stores.ImageFormatQcow2, int64(binary.BigEndian.Uint64(header[24:32])), nil
+	case bytes.HasPrefix(header, vmdkMagic), bytes.HasPrefix(header, vhdxMagic):
+		return "", 0, errors.New("unsupported image format, convert it to raw or qcow2")
+	case size == 0:
+		return "", 0, errors.New("image is empty")
+	}
+	return stores.ImageFormatRaw, size, nil
+}
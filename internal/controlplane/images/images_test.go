@@ -0,0 +1,127 @@
+package images
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+)
+
+func qcow2Header(virtual uint64) []byte {
+	h := make([]byte, 512)
+	copy(h, qcow2Magic)
+	binary.BigEndian.PutUint32(h[4:], 3)
+	binary.BigEndian.PutUint64(h[24:], virtual)
+	return h
+}
+
+func TestDetectFormat(t *testing.T) {
+	format, virtual, err := DetectFormat(qcow2Header(10<<30), 1<<20)
+	if err != nil || format != stores.ImageFormatQcow2 || virtual != 10<<30 {
+		t.Fatalf("qcow2: %s %d %v", format, virtual, err)
+	}
+	format, virtual, err = DetectFormat(make([]byte, 512), 2<<30)
+	if err != nil || format != stores.ImageFormatRaw || virtual != 2<<30 {
+		t.Fatalf("raw: %s %d %v", format, virtual, err)
+	}
+
+	backed := qcow2Header(1 << 30)
+	binary.BigEndian.PutUint64(backed[8:], 512)
+	for name, header := range map[string][]byte{
+		"backing file": backed,
+		"truncated":    qcow2Magic,
+		"vmdk":         []byte("KDMV\x01\x00\x00\x00"),
+	} {
+		if _, _, err := DetectFormat(header, 1<<20); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, _, err := DetectFormat(nil, 0); err == nil {
+		t.Error("expected empty image to be rejected")
+	}
+}
+
+func TestUploadAndImport(t *testing.T) {
+	st := stores.New()
+	s := NewService(st, t.TempDir())
+	data := qcow2Header(4 << 30)
+	sum := sha256.Sum256(data)
+	want := hex.EncodeToString(sum[:])
+
+	img, err := s.Create(stores.ImageCreate{Name: "jammy", SHA256: hex.EncodeToString(make([]byte, 32))})
+	if err != nil || img.State != stores.ImagePending {
+		t.Fatalf("create: %+v %v", img, err)
+	}
+	if _, err := s.Upload(img.ID, bytes.NewReader(data)); !errors.Is(err, stores.ErrInvalid) {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+	if got, _ := st.GetImage(img.ID); got.State != stores.ImageError {
+		t.Fatalf("expected failed image, got %+v", got)
+	}
+	_, _ = st.UpdateImage(img.ID, func(img *stores.Image) error { img.SHA256 = want; return nil })
+	img, err = s.Upload(img.ID, bytes.NewReader(data))
+	if err != nil || img.State != stores.ImageReady || img.Format != stores.ImageFormatQcow2 ||
+		img.VirtualSizeBytes != 4<<30 || img.SizeBytes != 512 || img.SHA256 != want {
+		t.Fatalf("upload: %+v %v", img, err)
+	}
+	if _, err := s.Upload(img.ID, bytes.NewReader(data)); !errors.Is(err, stores.ErrConflict) {
+		t.Fatalf("expected re-upload of a ready image to conflict, got %v", err)
+	}
+	f, _, err := s.Open(img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(f)
+	f.Close()
+	if !bytes.Equal(got, data) {
+		t.Fatal("stored file differs from the upload")
+	}
+
+	// Importing by URL happens in the background
+	src := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/raw.img" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(make([]byte, 1<<20))
+	}))
+	defer src.Close()
+	raw, err := s.Create(stores.ImageCreate{Name: "raw", SourceURL: src.URL + "/raw.img"})
+	if err != nil || raw.State != stores.ImageImporting {
+		t.Fatalf("create import: %+v %v", raw, err)
+	}
+	missing, _ := s.Create(stores.ImageCreate{Name: "missing", SourceURL: src.URL + "/missing.img"})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		raw, _ = st.GetImage(raw.ID)
+		missing, _ = st.GetImage(missing.ID)
+		if raw.State != stores.ImageImporting && missing.State != stores.ImageImporting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("imports did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if raw.State != stores.ImageReady || raw.Format != stores.ImageFormatRaw || raw.VirtualSizeBytes != 1<<20 {
+		t.Fatalf("unexpected imported image: %+v", raw)
+	}
+	if missing.State != stores.ImageError || missing.Error == "" {
+		t.Fatalf("expected failed import, got %+v", missing)
+	}
+
+	if err := s.Delete(raw.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Open(raw.ID); !errors.Is(err, stores.ErrNotFound) {
+		t.Fatalf("expected deleted image to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+// Package maintenance drains hosts for maintenance. Draining cordons the host
+// from scheduling and evacuates its running VMs according to the drain
+// policy, a few at a time, advancing whenever a VM task finishes. The host is
+// reported as drained only once no VM runs on it any more.
+package maintenance
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+	"github.com/VerteraIO/vertera/internal/controlplane/vms"
+)
+
+// VMs is the VM service a drain evacuates VMs with.
+type VMs interface {
+	Power(id, op string) (*tasks.Task, error)
+}
+
+// Migrator is implemented by VM services that can live-migrate a VM. An
+// empty targetHostID lets the scheduler pick the target. The returned task
+// succeeds once the VM runs on its new host.
+type Migrator interface {
+	Migrate(id, targetHostID string) (*tasks.Task, error)
+}
+
+var _ Migrator = (*vms.Service)(nil)
+
+// DrainOptions configures a drain. Policy defaults to migrate and
+// MaxParallel to 1.
+type DrainOptions struct {
+	Policy      stores.DrainPolicy `json:"policy"`
+	MaxParallel int                `json:"maxParallel"`
+}
+
+// Manager runs host drains.
+type Manager struct {
+	store *stores.Stores
+	tasks *tasks.Manager
+	vms   VMs
+
+	// mu serialises drain progress so a VM is never evacuated twice.
+	mu sync.Mutex
+}
+
+// New returns a Manager and subscribes it to task status changes.
+func New(st *stores.Stores, tm *tasks.Manager, v VMs) *Manager {
+	m := &Manager{store: st, tasks: tm, vms: v}
+	tm.AddListener(m.handleTask)
+	return m
+}
+
+// Default is the process-wide manager backed by the default store, tasks and
+// VM service.
+var Default = New(stores.Default, tasks.Default, vms.Default)
+
+// Drain cordons the host and starts evacuating its VMs.
+func (m *Manager) Drain(hostID string, opts DrainOptions) (*stores.HostDrain, error) {
+	if opts.Policy == "" {
+		opts.Policy = stores.DrainMigrate
+	}
+	if opts.MaxParallel == 0 {
+		opts.MaxParallel = 1
+	}
+	if _, ok := m.vms.(Migrator); !ok && opts.Policy == stores.DrainMigrate {
+		return nil, fmt.Errorf("%w: live migration is not available, use the stop policy", stores.ErrInvalid)
+	}
+	if _, err := m.store.StartHostDrain(hostID, opts.Policy, opts.MaxParallel); err != nil {
+		return nil, err
+	}
+	log.Printf("maintenance: draining host %s (policy %s)", hostID, opts.Policy)
+	m.advance(hostID)
+	return m.store.GetHostDrain(hostID)
+}
+
+// Undrain returns the host to service, cancelling a drain in progress.
+func (m *Manager) Undrain(hostID string) (*stores.Host, error) {
+	h, err := m.store.UndrainHost(hostID)
+	if err == nil {
+		log.Printf("maintenance: host %s back in service", hostID)
+	}
+	return h, err
+}
+
+// handleTask advances every drain in progress when a task finishes; VM
+// tasks are how evacuations complete and how creating VMs become movable.
+func (m *Manager) handleTask(t tasks.Task) {
+	if t.Status != tasks.StatusSucceeded && t.Status != tasks.StatusFailed {
+		return
+	}
+	for _, hostID := range m.store.ActiveHostDrains() {
+		m.advance(hostID)
+	}
+}
+
+// advance checks the VMs being evacuated, starts evacuating pending ones up
+// to MaxParallel and finishes the drain once nothing is left to do.
+func (m *Manager) advance(hostID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, err := m.store.GetHostDrain(hostID)
+	if err != nil || d.State != stores.DrainInProgress {
+		return
+	}
+	m.step(d)
+	_, err = m.store.UpdateHostDrain(hostID, func(cur *stores.HostDrain) error {
+		cur.VMs, cur.State, cur.Error = d.VMs, d.State, d.Error
+		return nil
+	})
+	if errors.Is(err, stores.ErrConflict) {
+		// Undrained while VM operations were being sent.
+		return
+	}
+	if err != nil {
+		log.Printf("maintenance: drain of host %s: %v", hostID, err)
+		return
+	}
+	switch d.State {
+	case stores.DrainCompleted:
+		log.Printf("maintenance: host %s drained", hostID)
+	case stores.DrainFailed:
+		log.Printf("maintenance: drain of host %s failed: %s", hostID, d.Error)
+	}
+}
+
+func (m *Manager) step(d *stores.HostDrain) {
+	inFlight, pending := 0, 0
+	for i := range d.VMs {
+		if e := &d.VMs[i]; e.Status == stores.DrainVMInProgress {
+			m.check(d, e)
+		}
+	}
+	for i := range d.VMs {
+		e := &d.VMs[i]
+		if e.Status == stores.DrainVMPending {
+			m.start(d, e, inFlight < d.MaxParallel)
+		}
+		switch e.Status {
+		case stores.DrainVMInProgress:
+			inFlight++
+		case stores.DrainVMPending:
+			pending++
+		}
+	}
+	if inFlight > 0 || pending > 0 {
+		return
+	}
+
+	failed := 0
+	for _, e := range d.VMs {
+		if e.Status == stores.DrainVMFailed {
+			failed++
+		}
+	}
+	running := 0
+	for _, vm := range m.store.ListVMs("", d.HostID) {
+		if vm.State == stores.VMStateRunning {
+			running++
+		}
+	}
+	switch {
+	case failed > 0:
+		d.State, d.Error = stores.DrainFailed, fmt.Sprintf("%d vm(s) could not be evacuated", failed)
+	case running > 0:
+		d.State, d.Error = stores.DrainFailed, fmt.Sprintf("%d vm(s) still running on the host", running)
+	default:
+		d.State = stores.DrainCompleted
+	}
+}
+
+// start evacuates one pending VM if a slot is free. VMs that are being
+// created, deleted, migrated or restored stay pending until their task
+// finishes; VMs that are not running are skipped.
+func (m *Manager) start(d *stores.HostDrain, e *stores.DrainVM, slot bool) {
+	vm, err := m.store.GetVM(e.VMID)
+	if errors.Is(err, stores.ErrNotFound) {
+		e.Status, e.Detail = stores.DrainVMDone, "vm was deleted"
+		return
+	}
+	if err != nil {
+		e.Status, e.Detail = stores.DrainVMFailed, err.Error()
+		return
+	}
+	if vm.HostID != d.HostID {
+		e.Status, e.Detail = stores.DrainVMDone, "vm already left the host"
+		return
+	}
+	switch vm.State {
+	case stores.VMStateRunning:
+	case stores.VMStateCreating, stores.VMStateDeleting, stores.VMStateMigrating, stores.VMStateRestoring:
+		return
+	default:
+		e.Status, e.Detail = stores.DrainVMSkipped, fmt.Sprintf("vm is %s", vm.State)
+		return
+	}
+	if !slot {
+		return
+	}
+
+	var t *tasks.Task
+	if d.Policy == stores.DrainStop {
+		t, err = m.vms.Power(vm.ID, tasks.PowerOff)
+	} else if mg, ok := m.vms.(Migrator); ok {
+		t, err = mg.Migrate(vm.ID, "")
+	} else {
+		err = errors.New("live migration is not available")
+	}
+	if err != nil {
+		e.Status, e.Detail = stores.DrainVMFailed, err.Error()
+		return
+	}
+	e.Status, e.TaskID = stores.DrainVMInProgress, t.ID
+}
+
+// check settles a VM whose evacuation is in flight.
+func (m *Manager) check(d *stores.HostDrain, e *stores.DrainVM) {
+	vm, err := m.store.GetVM(e.VMID)
+	switch {
+	case errors.Is(err, stores.ErrNotFound):
+		e.Status, e.Detail = stores.DrainVMDone, "vm was deleted"
+		return
+	case err != nil:
+		return
+	case d.Policy == stores.DrainMigrate && vm.HostID != d.HostID,
+		d.Policy == stores.DrainStop && vm.State == stores.VMStateStopped:
+		e.Status = stores.DrainVMDone
+		return
+	}
+	t, ok := m.tasks.Get(e.TaskID)
+	if !ok {
+		e.Status, e.Detail = stores.DrainVMFailed, fmt.Sprintf("task %s not found", e.TaskID)
+		return
+	}
+	switch t.Status {
+	case tasks.StatusFailed:
+		e.Status, e.Detail = stores.DrainVMFailed, t.Error
+	case tasks.StatusSucceeded:
+		e.Status, e.Detail = stores.DrainVMFailed, fmt.Sprintf("task succeeded but vm is %s on the host", vm.State)
+	}
+}
@@ -0,0 +1,154 @@
+package maintenance
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+)
+
+// fakeVMs records power operations as tasks without an agent.
+type fakeVMs struct {
+	tm *tasks.Manager
+}
+
+func (f *fakeVMs) Power(id, op string) (*tasks.Task, error) {
+	return f.tm.EnqueuePowerVM("host", tasks.PowerVMParams{VMID: id, Op: op})
+}
+
+// fakeMigrator also accepts migrations.
+type fakeMigrator struct {
+	fakeVMs
+	migrating []string
+}
+
+func (f *fakeMigrator) Migrate(id, _ string) (*tasks.Task, error) {
+	f.migrating = append(f.migrating, id)
+	return f.tm.Enqueue("host", "MIGRATE_VM", map[string]string{"vmId": id})
+}
+
+func setup(t *testing.T, names ...string) (*stores.Stores, *tasks.Manager, *stores.Host, []*stores.VM) {
+	t.Helper()
+	st := stores.New()
+	h, err := st.CreateHost(stores.HostCreate{ProjectID: "p1", Hostname: "h1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []*stores.VM
+	for _, name := range names {
+		vm, err := st.CreateVM(stores.VMCreate{ProjectID: "p1", HostID: &h.ID, Name: name, Vcpus: 1, MemoryMiB: 512})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vm, err = st.SetVMState(vm.ID, stores.VMStateRunning, ""); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, vm)
+	}
+	return st, tasks.NewManager(), h, out
+}
+
+func drainStatus(t *testing.T, st *stores.Stores, hostID string) (*stores.HostDrain, map[string]string) {
+	t.Helper()
+	d, err := st.GetHostDrain(hostID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := map[string]string{}
+	for _, e := range d.VMs {
+		status[e.Name] = e.Status
+	}
+	return d, status
+}
+
+func TestDrainMigrate(t *testing.T) {
+	st, tm, h, vms := setup(t, "a", "b", "c", "d")
+	if _, err := st.SetVMState(vms[3].ID, stores.VMStateStopped, ""); err != nil {
+		t.Fatal(err)
+	}
+	other := tasks.NewManager()
+	if _, err := New(st, other, &fakeVMs{tm: other}).Drain(h.ID, DrainOptions{}); !errors.Is(err, stores.ErrInvalid) {
+		t.Fatalf("expected migrate policy to need a migrator, got %v", err)
+	}
+
+	mg := &fakeMigrator{fakeVMs: fakeVMs{tm: tm}}
+	m := New(st, tm, mg)
+	d, err := m.Drain(h.ID, DrainOptions{MaxParallel: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host, _ := st.GetHost(h.ID); host.State != stores.HostStateDraining || d.State != stores.DrainInProgress {
+		t.Fatalf("expected draining host, got %s / %s", host.State, d.State)
+	}
+	if _, status := drainStatus(t, st, h.ID); status["a"] != "in-progress" || status["b"] != "in-progress" || status["c"] != "pending" || status["d"] != "skipped" {
+		t.Fatalf("unexpected progress: %v", status)
+	}
+	if _, err := m.Drain(h.ID, DrainOptions{}); !errors.Is(err, stores.ErrConflict) {
+		t.Fatalf("expected conflict for a second drain, got %v", err)
+	}
+
+	// a lands elsewhere, which frees a slot for c; b fails to migrate
+	taskOf := func(name string) string {
+		d, _ := st.GetHostDrain(h.ID)
+		for _, e := range d.VMs {
+			if e.Name == name {
+				return e.TaskID
+			}
+		}
+		return ""
+	}
+	if _, err := st.UpdateVM(vms[0].ID, func(vm *stores.VM) error { vm.HostID = "other"; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	tm.UpdateStatusSucceeded(taskOf("a"))
+	tm.UpdateStatusFailed(taskOf("b"), "target refused")
+	if _, status := drainStatus(t, st, h.ID); status["a"] != "done" || status["b"] != "failed" || status["c"] != "in-progress" {
+		t.Fatalf("unexpected progress: %v", status)
+	}
+	if _, err := st.UpdateVM(vms[2].ID, func(vm *stores.VM) error { vm.HostID = "other"; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	tm.UpdateStatusSucceeded(taskOf("c"))
+
+	d, _ = drainStatus(t, st, h.ID)
+	if d.State != stores.DrainFailed || d.Error != "1 vm(s) could not be evacuated" || d.FinishedAt == nil {
+		t.Fatalf("expected failed drain, got %+v", d)
+	}
+	if host, _ := st.GetHost(h.ID); host.State != stores.HostStateDraining {
+		t.Fatalf("host should stay cordoned after a failed drain, got %s", host.State)
+	}
+	if len(mg.migrating) != 3 {
+		t.Fatalf("expected 3 migrations, got %v", mg.migrating)
+	}
+	if host, err := m.Undrain(h.ID); err != nil || host.State != stores.HostStateReady {
+		t.Fatalf("Undrain: %v %+v", err, host)
+	}
+}
+
+func TestDrainStop(t *testing.T) {
+	st, tm, h, vms := setup(t, "a")
+	m := New(st, tm, &fakeVMs{tm: tm})
+	d, err := m.Drain(h.ID, DrainOptions{Policy: stores.DrainStop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.SetVMState(vms[0].ID, stores.VMStateStopped, ""); err != nil {
+		t.Fatal(err)
+	}
+	tm.UpdateStatusSucceeded(d.VMs[0].TaskID)
+
+	d, _ = drainStatus(t, st, h.ID)
+	if d.State != stores.DrainCompleted || d.VMs[0].Status != "done" {
+		t.Fatalf("expected completed drain, got %+v", d)
+	}
+	if host, _ := st.GetHost(h.ID); host.State != stores.HostStateDrained {
+		t.Fatalf("expected drained host, got %s", host.State)
+	}
+	if _, err := m.Undrain(h.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Undrain(h.ID); !errors.Is(err, stores.ErrConflict) {
+		t.Fatalf("expected conflict undraining a ready host, got %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+// Package mirror keeps copies of package artifacts on the controller so that
+// hosts without internet access can install them. Files come from offline
+// bundles imported through the API or are pulled from their upstream URL the
+// first time a host asks for them, and are kept under their SHA-256 digest.
+package mirror
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+)
+
+// DefaultDir holds mirrored files unless VERTERA_MIRROR_DIR says otherwise.
+const DefaultDir = "/var/lib/vertera/mirror"
+
+// ManifestName is the file in a bundle listing its artifacts, in the format
+// of VERTERA_ARTIFACTS_FILE.
+const ManifestName = "manifest.json"
+
+// ErrUpstream is returned when a file could not be pulled from upstream.
+var ErrUpstream = errors.New("upstream pull failed")
+
+// Service imports bundles into the mirror and serves mirrored files.
+type Service struct {
+	store  *stores.Stores
+	dir    string
+	client *http.Client
+
+	mu    sync.Mutex
+	pulls map[string]*sync.Mutex
+}
+
+// NewService returns a Service keeping files in dir. An empty dir is resolved
+// from VERTERA_MIRROR_DIR, falling back to DefaultDir, on each use.
+func NewService(st *stores.Stores, dir string) *Service {
+	return &Service{store: st, dir: dir, client: http.DefaultClient, pulls: make(map[string]*sync.Mutex)}
+}
+
+// Default is the process-wide service backed by the default store.
+var Default = NewService(stores.Default, "")
+
+func (s *Service) root() string {
+	if s.dir != "" {
+		return s.dir
+	}
+	if dir := os.Getenv("VERTERA_MIRROR_DIR"); dir != "" {
+		return dir
+	}
+	return DefaultDir
+}
+
+// path is where the file with the given hex digest is kept.
+func (s *Service) path(sum string) string {
+	return filepath.Join(s.root(), sum)
+}
+
+// bundleFile is a file read from a bundle into a temporary file.
+type bundleFile struct {
+	tmp  string
+	sum  string
+	size int64
+}
+
+// Import reads a bundle, a tar archive (optionally gzipped) holding
+// ManifestName and the files it lists, stores the files and registers their
+// artifacts. Artifacts already registered with the same digest are kept, so
+// importing a bundle twice is harmless. It returns the bundle's artifacts.
+func (s *Service) Import(r io.Reader) ([]*stores.Artifact, error) {
+	if err := os.MkdirAll(s.root(), 0o750); err != nil {
+		return nil, fmt.Errorf("create mirror dir: %w", err)
+	}
+	br := bufio.NewReader(r)
+	var src io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bundle: %v", stores.ErrInvalid, err)
+		}
+		defer zr.Close()
+		src = zr
+	}
+
+	files := map[string]*bundleFile{}
+	defer func() {
+		for _, f := range files {
+			_ = os.Remove(f.tmp)
+		}
+	}()
+	var manifest []byte
+	tr := tar.NewReader(src)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: bundle: %v", stores.ErrInvalid, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Base(hdr.Name)
+		if name == ManifestName {
+			if manifest, err = io.ReadAll(io.LimitReader(tr, 16<<20)); err != nil {
+				return nil, fmt.Errorf("%w: bundle: %v", stores.ErrInvalid, err)
+			}
+			continue
+		}
+		if _, dup := files[name]; dup {
+			return nil, fmt.Errorf("%w: bundle holds %s twice", stores.ErrInvalid, name)
+		}
+		f, err := s.write(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = f
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("%w: bundle has no %s", stores.ErrInvalid, ManifestName)
+	}
+	var entries []stores.ArtifactCreate
+	if err := json.Unmarshal(manifest, &entries); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", stores.ErrInvalid, ManifestName, err)
+	}
+
+	// Check the whole bundle before registering anything.
+	for i, e := range entries {
+		sum, err := hexDigest(e.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name, err)
+		}
+		f, ok := files[e.Name]
+		if !ok {
+			if _, err := os.Stat(s.path(sum)); err == nil {
+				continue
+			}
+			return nil, fmt.Errorf("%w: bundle lacks %s listed in %s", stores.ErrInvalid, e.Name, ManifestName)
+		}
+		if sum != f.sum {
+			return nil, fmt.Errorf("%w: %s: sha256 is %s, manifest says %s", stores.ErrInvalid, e.Name, f.sum, e.Digest)
+		}
+		if entries[i].SizeBytes == 0 {
+			entries[i].SizeBytes = f.size
+		}
+	}
+	for _, e := range entries {
+		if f, ok := files[e.Name]; ok {
+			if err := os.Rename(f.tmp, s.path(f.sum)); err != nil {
+				return nil, err
+			}
+			delete(files, e.Name)
+		}
+	}
+	out := make([]*stores.Artifact, 0, len(entries))
+	for _, e := range entries {
+		a, err := s.register(e)
+		if err != nil {
+			return out, fmt.Errorf("artifact %s %s %s: %w", e.Type, e.Version, e.Name, err)
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// register adds a bundle artifact to the registry unless it is there already.
+func (s *Service) register(in stores.ArtifactCreate) (*stores.Artifact, error) {
+	a, err := s.store.CreateArtifact(in)
+	if !errors.Is(err, stores.ErrConflict) {
+		return a, err
+	}
+	for _, a := range s.store.ListArtifacts(stores.ArtifactFilter{Type: in.Type, Version: in.Version}) {
+		if a.OSVersion == in.OSVersion && a.Name == in.Name && a.Digest == in.Digest {
+			return a, nil
+		}
+	}
+	return nil, err
+}
+
+// write copies r into a temporary file in the mirror, hashing it on the way.
+func (s *Service) write(r io.Reader) (*bundleFile, error) {
+	tmp, err := os.CreateTemp(s.root(), "import.*.part")
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Close()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return nil, fmt.Errorf("write mirror file: %w", err)
+	}
+	return &bundleFile{tmp: tmp.Name(), sum: hex.EncodeToString(h.Sum(nil)), size: size}, nil
+}
+
+// Open returns the mirrored file with the given digest ("sha256:<hex>"); the
+// caller closes it. A file that is not mirrored yet is pulled from the
+// upstream URL of a registered artifact with that digest first.
+func (s *Service) Open(digest string) (*os.File, error) {
+	sum, err := hexDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+	if f, err := os.Open(s.path(sum)); err == nil {
+		return f, nil
+	}
+	lock := s.lock(sum)
+	lock.Lock()
+	defer lock.Unlock()
+	if f, err := os.Open(s.path(sum)); err == nil {
+		return f, nil
+	}
+	if err := s.pull(digest, sum); err != nil {
+		return nil, err
+	}
+	return os.Open(s.path(sum))
+}
+
+// hexDigest returns the hex part of a "sha256:<hex>" digest.
+func hexDigest(digest string) (string, error) {
+	sum, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || len(sum) != sha256.Size*2 || strings.Trim(sum, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("%w: digest must be sha256: followed by 64 lowercase hex digits", stores.ErrInvalid)
+	}
+	return sum, nil
+}
+
+func (s *Service) lock(sum string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.pulls[sum]
+	if !ok {
+		l = &sync.Mutex{}
+		s.pulls[sum] = l
+	}
+	return l
+}
+
+// pull downloads a file from the upstream URL of a registered artifact.
+func (s *Service) pull(digest, sum string) error {
+	var sourceURL string
+	for _, a := range s.store.ListArtifacts(stores.ArtifactFilter{}) {
+		if a.Digest == digest && a.URL != "" {
+			sourceURL = a.URL
+			break
+		}
+	}
+	if sourceURL == "" {
+		return fmt.Errorf("%s is not mirrored and no artifact has an upstream URL for it: %w", digest, stores.ErrNotFound)
+	}
+	if err := os.MkdirAll(s.root(), 0o750); err != nil {
+		return fmt.Errorf("create mirror dir: %w", err)
+	}
+	resp, err := s.client.Get(sourceURL)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = fmt.Errorf("GET %s: %s", sourceURL, resp.Status)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUpstream, digest, err)
+	}
+	defer resp.Body.Close()
+	f, err := s.write(resp.Body)
+	if err != nil {
+		return err
+	}
+	if f.sum != sum {
+		_ = os.Remove(f.tmp)
+		return fmt.Errorf("%w: %s: %s has sha256 %s", ErrUpstream, digest, sourceURL, f.sum)
+	}
+	return os.Rename(f.tmp, s.path(sum))
+}
@@ -0,0 +1,145 @@
+package mirror
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+)
+
+func digestOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// bundle builds a gzipped bundle holding the manifest and files.
+func bundle(t *testing.T, manifest []stores.ArtifactCreate, files map[string][]byte) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	add := func(name string, data []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range files {
+		add("rpms/"+name, data)
+	}
+	m, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	add(ManifestName, m)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func readAll(t *testing.T, s *Service, digest string) []byte {
+	t.Helper()
+	f, err := s.Open(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestImport(t *testing.T) {
+	st := stores.New()
+	s := NewService(st, t.TempDir())
+	rpm := []byte("openvswitch 3.6.0")
+	manifest := []stores.ArtifactCreate{{Type: stores.ArtifactOVS, Version: "3.6.0", OSVersion: "el9", Name: "openvswitch.rpm", Digest: digestOf(rpm)}}
+
+	items, err := s.Import(bundle(t, manifest, map[string][]byte{"openvswitch.rpm": rpm}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].URL != "" || items[0].SizeBytes != int64(len(rpm)) || items[0].Channel != stores.ChannelStable {
+		t.Fatalf("unexpected artifacts: %+v", items)
+	}
+	if got := readAll(t, s, manifest[0].Digest); !bytes.Equal(got, rpm) {
+		t.Fatalf("mirrored file differs: %q", got)
+	}
+
+	// Importing the same bundle again keeps the registered artifact.
+	again, err := s.Import(bundle(t, manifest, map[string][]byte{"openvswitch.rpm": rpm}))
+	if err != nil || len(again) != 1 || again[0].ID != items[0].ID {
+		t.Fatalf("expected re-import to be harmless, got %+v, %v", again, err)
+	}
+
+	bad := []stores.ArtifactCreate{{Type: stores.ArtifactOVS, Version: "3.7.0", Name: "openvswitch.rpm", Digest: digestOf([]byte("other"))}}
+	if _, err := s.Import(bundle(t, bad, map[string][]byte{"openvswitch.rpm": rpm})); !errors.Is(err, stores.ErrInvalid) {
+		t.Fatalf("expected a digest mismatch to be refused, got %v", err)
+	}
+	missing := []stores.ArtifactCreate{{Type: stores.ArtifactOVS, Version: "3.7.0", Name: "missing.rpm", Digest: digestOf([]byte("missing"))}}
+	if _, err := s.Import(bundle(t, missing, nil)); !errors.Is(err, stores.ErrInvalid) {
+		t.Fatalf("expected a missing file to be refused, got %v", err)
+	}
+	if len(st.ListArtifacts(stores.ArtifactFilter{Version: "3.7.0"})) != 0 {
+		t.Fatal("refused bundle registered artifacts")
+	}
+}
+
+func TestOpenPullsFromUpstream(t *testing.T) {
+	rpm := []byte("cloud-hypervisor 47.0")
+	hits := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path == "/tampered.rpm" {
+			_, _ = w.Write([]byte("tampered"))
+			return
+		}
+		_, _ = w.Write(rpm)
+	}))
+	defer upstream.Close()
+
+	st := stores.New()
+	s := NewService(st, t.TempDir())
+	if _, err := s.Open(digestOf(rpm)); !errors.Is(err, stores.ErrNotFound) {
+		t.Fatalf("expected an unknown digest to be missing, got %v", err)
+	}
+	if _, err := st.CreateArtifact(stores.ArtifactCreate{Type: stores.ArtifactCloudHypervisor, Version: "47.0", Name: "ch.rpm", URL: upstream.URL + "/ch.rpm", Digest: digestOf(rpm)}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if got := readAll(t, s, digestOf(rpm)); !bytes.Equal(got, rpm) {
+			t.Fatalf("pulled file differs: %q", got)
+		}
+	}
+	if hits != 1 {
+		t.Fatalf("expected one upstream pull, got %d", hits)
+	}
+
+	other := []byte("firmware")
+	if _, err := st.CreateArtifact(stores.ArtifactCreate{Type: stores.ArtifactFirmware, Version: "1", Name: "fw.bin", URL: upstream.URL + "/tampered.rpm", Digest: digestOf(other)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open(digestOf(other)); !errors.Is(err, ErrUpstream) {
+		t.Fatalf("expected a tampered upstream file to be refused, got %v", err)
+	}
+	if _, err := s.Open("md5:abc"); !errors.Is(err, stores.ErrInvalid) {
+		t.Fatalf("expected a malformed digest to be invalid, got %v", err)
+	}
+}
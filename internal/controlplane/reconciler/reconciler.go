@@ -1,15 +1,119 @@
 package reconciler
 
-import "log"
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+)
 
 // Reconciler drives desired state towards actual state by
 // listing resources, scheduling work, and invoking executors.
-// This is a thin placeholder; expand with controllers as needed.
-type Reconciler struct{}
+// It currently checks placement groups against where their VMs actually run
+// and records any violations on the group.
+type Reconciler struct {
+	store    *stores.Stores
+	interval time.Duration
+}
 
-func New() *Reconciler { return &Reconciler{} }
+// New returns a reconciler over st. It runs every VERTERA_RECONCILE_INTERVAL
+// (default 30s) once started.
+func New(st *stores.Stores) *Reconciler {
+	interval := 30 * time.Second
+	if v := os.Getenv("VERTERA_RECONCILE_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			interval = d
+		}
+	}
+	return &Reconciler{store: st, interval: interval}
+}
 
 func (r *Reconciler) Start() {
-	// TODO: wire informers, watches, and queues
 	log.Println("controlplane: reconciler started")
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		r.Reconcile()
+		<-ticker.C
+	}
+}
+
+// Reconcile runs one pass over all resources.
+func (r *Reconciler) Reconcile() {
+	for _, g := range r.store.ListPlacementGroups("") {
+		violations := r.placementViolations(g)
+		if !reflect.DeepEqual(violations, g.Violations) {
+			for _, v := range violations {
+				log.Printf("reconciler: placement group %s (%s): %s", g.Name, g.ID, v.Detail)
+			}
+			if len(violations) == 0 && len(g.Violations) > 0 {
+				log.Printf("reconciler: placement group %s (%s): violations resolved", g.Name, g.ID)
+			}
+		}
+		if err := r.store.SetPlacementViolations(g.ID, violations); err != nil {
+			log.Printf("reconciler: placement group %s: %v", g.ID, err)
+		}
+	}
+}
+
+// placementViolations compares a group's policy with where its members run.
+// Violations are ordered by kind and host so passes can be compared.
+func (r *Reconciler) placementViolations(g *stores.PlacementGroup) []stores.PlacementViolation {
+	byHost := map[string][]string{}
+	for _, vm := range r.store.PlacementGroupMembers(g.ID) {
+		byHost[vm.HostID] = append(byHost[vm.HostID], vm.ID)
+	}
+	hostIDs := make([]string, 0, len(byHost))
+	for id := range byHost {
+		hostIDs = append(hostIDs, id)
+	}
+	sort.Strings(hostIDs)
+
+	out := []stores.PlacementViolation{}
+	switch g.Policy {
+	case stores.PolicyAntiAffinity:
+		for _, id := range hostIDs {
+			if n := len(byHost[id]); n > g.MaxPerHost {
+				out = append(out, stores.PlacementViolation{
+					Kind:   "crowded",
+					HostID: id,
+					VMIDs:  byHost[id],
+					Detail: fmt.Sprintf("host %s runs %d members, max %d", id, n, g.MaxPerHost),
+				})
+			}
+		}
+	case stores.PolicyAffinity:
+		if len(hostIDs) > 1 {
+			var vmIDs []string
+			for _, id := range hostIDs {
+				vmIDs = append(vmIDs, byHost[id]...)
+			}
+			out = append(out, stores.PlacementViolation{
+				Kind:   "split",
+				VMIDs:  vmIDs,
+				Detail: fmt.Sprintf("members run on %d hosts", len(hostIDs)),
+			})
+		}
+	}
+	for _, id := range hostIDs {
+		h, err := r.store.GetHost(id)
+		if err == nil && h.State != stores.HostStateError && h.State != stores.HostStateUnreachable && h.State != stores.HostStateFailed {
+			continue
+		}
+		state := "missing"
+		if h != nil {
+			state = string(h.State)
+		}
+		out = append(out, stores.PlacementViolation{
+			Kind:   "host-unavailable",
+			HostID: id,
+			VMIDs:  byHost[id],
+			Detail: fmt.Sprintf("%d member(s) on host %s which is %s", len(byHost[id]), id, state),
+		})
+	}
+	return out
 }
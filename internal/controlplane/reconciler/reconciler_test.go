@@ -0,0 +1,58 @@
+package reconciler
+
+import (
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+)
+
+func TestPlacementViolations(t *testing.T) {
+	st := stores.New()
+	var hosts []string
+	for _, name := range []string{"a", "b"} {
+		h, err := st.RegisterAgentHost(name, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts = append(hosts, h.ID)
+	}
+	g, err := st.CreatePlacementGroup(stores.PlacementGroupCreate{ProjectID: "p1", Name: "tier", Policy: stores.PolicyAffinity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, host := range hosts {
+		host := host
+		if _, err := st.CreateVM(stores.VMCreate{ProjectID: "p1", HostID: &host, PlacementGroupID: &g.ID, Name: "vm-" + host, Vcpus: 1, MemoryMiB: 512}); err != nil {
+			t.Fatalf("vm %d: %v", i, err)
+		}
+	}
+
+	r := New(st)
+	r.Reconcile()
+	g, _ = st.GetPlacementGroup(g.ID)
+	if len(g.Violations) != 1 || g.Violations[0].Kind != "split" || len(g.Violations[0].VMIDs) != 2 {
+		t.Fatalf("expected split violation, got %+v", g.Violations)
+	}
+
+	// A failed host is reported on top of the split
+	if _, err := st.UpdateHost(hosts[1], func(h *stores.Host) error { h.State = stores.HostStateError; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	r.Reconcile()
+	g, _ = st.GetPlacementGroup(g.ID)
+	if len(g.Violations) != 2 || g.Violations[1].Kind != "host-unavailable" || g.Violations[1].HostID != hosts[1] {
+		t.Fatalf("expected host-unavailable violation, got %+v", g.Violations)
+	}
+
+	// Moving the VM back together clears the split
+	for _, vm := range st.PlacementGroupMembers(g.ID) {
+		if _, err := st.UpdateVM(vm.ID, func(vm *stores.VM) error { vm.HostID = hosts[0]; return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Reconcile()
+	g, _ = st.GetPlacementGroup(g.ID)
+	if len(g.Violations) != 0 || g.CheckedAt == nil {
+		t.Fatalf("expected no violations, got %+v", g.Violations)
+	}
+}
@@ -0,0 +1,120 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+)
+
+// HostReady rejects hosts that are not in the ready state.
+type HostReady struct{}
+
+func (HostReady) Name() string { return "host-ready" }
+
+func (HostReady) Filter(_ Request, c *Candidate) (bool, string) {
+	if c.Host.State != stores.HostStateReady {
+		return false, fmt.Sprintf("host is %s", c.Host.State)
+	}
+	return true, ""
+}
+
+// Cluster rejects hosts outside the requested cluster.
+type Cluster struct{}
+
+func (Cluster) Name() string { return "cluster" }
+
+func (Cluster) Filter(req Request, c *Candidate) (bool, string) {
+	if req.ClusterID != "" && c.Host.ClusterID != req.ClusterID {
+		return false, fmt.Sprintf("host is not a member of cluster %s", req.ClusterID)
+	}
+	return true, ""
+}
+
+// SharedStorage rejects hosts whose cluster keeps VM disks on local storage
+// when the request needs shared storage.
+type SharedStorage struct {
+	Store *stores.Stores
+}
+
+func (SharedStorage) Name() string { return "shared-storage" }
+
+func (f SharedStorage) Filter(req Request, c *Candidate) (bool, string) {
+	if req.SharedStorage && !f.Store.SharedStorage(c.Host.ClusterID) {
+		return false, "host's cluster has no shared or replicated storage"
+	}
+	return true, ""
+}
+
+// PortGroups rejects hosts whose cluster has no DVS carrying every requested port group.
+type PortGroups struct {
+	Store *stores.Stores
+}
+
+func (PortGroups) Name() string { return "port-groups" }
+
+func (f PortGroups) Filter(req Request, c *Candidate) (bool, string) {
+	for _, name := range req.PortGroups {
+		if _, _, err := f.Store.ResolvePortGroup(c.Host.ClusterID, name); err != nil {
+			return false, fmt.Sprintf("port group %q: %v", name, err)
+		}
+	}
+	return true, ""
+}
+
+// Resources rejects hosts without enough free allocatable vCPUs, memory or,
+// for hugepage-backed VMs, hugepage memory according to their latest
+// inventory and cluster capacity policy. Disk is checked on hosts that report
+// their storage.
+type Resources struct{}
+
+func (Resources) Name() string { return "resources" }
+
+func (Resources) Filter(req Request, c *Candidate) (bool, string) {
+	if c.Inventory == nil {
+		return false, "no inventory reported"
+	}
+	free := c.Capacity().Free
+	if req.Vcpus > free.Vcpus {
+		return false, fmt.Sprintf("needs %d vCPUs, %d free", req.Vcpus, max(free.Vcpus, 0))
+	}
+	if req.Hugepages {
+		if int64(req.MemoryMiB) > free.HugepagesMiB {
+			return false, fmt.Sprintf("needs %d MiB hugepages, %d MiB free", req.MemoryMiB, max(free.HugepagesMiB, 0))
+		}
+	} else if int64(req.MemoryMiB) > free.MemoryMiB {
+		return false, fmt.Sprintf("needs %d MiB memory, %d MiB free", req.MemoryMiB, max(free.MemoryMiB, 0))
+	}
+	if c.Inventory.Storage != nil && int64(req.DiskGiB) > free.DiskGiB {
+		return false, fmt.Sprintf("needs %d GiB disk, %d GiB free", req.DiskGiB, max(free.DiskGiB, 0))
+	}
+	return true, ""
+}
+
+// CPUFlags rejects hosts whose CPUs lack a required flag.
+type CPUFlags struct{}
+
+func (CPUFlags) Name() string { return "cpu-flags" }
+
+func (CPUFlags) Filter(req Request, c *Candidate) (bool, string) {
+	if len(req.CPUFlags) == 0 {
+		return true, ""
+	}
+	if c.Inventory == nil {
+		return false, "no inventory reported"
+	}
+	have := make(map[string]struct{}, len(c.Inventory.CPU.Flags))
+	for _, f := range c.Inventory.CPU.Flags {
+		have[f] = struct{}{}
+	}
+	var missing []string
+	for _, f := range req.CPUFlags {
+		if _, ok := have[f]; !ok {
+			missing = append(missing, f)
+		}
+	}
+	if len(missing) > 0 {
+		return false, "missing cpu flags: " + strings.Join(missing, ", ")
+	}
+	return true, ""
+}
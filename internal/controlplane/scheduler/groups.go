@@ -0,0 +1,133 @@
+package scheduler
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+)
+
+// groupFit reports whether adding a member to hostID keeps the group's policy.
+func groupFit(g *stores.PlacementGroup, members []*stores.VM, hostID string) (bool, string) {
+	switch g.Policy {
+	case stores.PolicyAntiAffinity:
+		n := 0
+		for _, vm := range members {
+			if vm.HostID == hostID {
+				n++
+			}
+		}
+		if n >= g.MaxPerHost {
+			return false, fmt.Sprintf("host already runs %d member(s) of anti-affinity group %s (max %d)", n, g.Name, g.MaxPerHost)
+		}
+	case stores.PolicyAffinity:
+		hosts := map[string]struct{}{}
+		for _, vm := range members {
+			hosts[vm.HostID] = struct{}{}
+		}
+		if _, ok := hosts[hostID]; len(hosts) > 0 && !ok {
+			ids := make([]string, 0, len(hosts))
+			for id := range hosts {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			return false, fmt.Sprintf("affinity group %s runs on %s", g.Name, strings.Join(ids, ", "))
+		}
+	}
+	return true, ""
+}
+
+// groupFor returns the request's placement group and its current members,
+// leaving out the VM being placed.
+func groupFor(st *stores.Stores, req Request) (*stores.PlacementGroup, []*stores.VM, error) {
+	g, err := st.GetPlacementGroup(req.PlacementGroupID)
+	if err != nil {
+		return nil, nil, err
+	}
+	members := st.PlacementGroupMembers(g.ID)
+	if req.VMID != "" {
+		members = slices.DeleteFunc(members, func(vm *stores.VM) bool { return vm.ID == req.VMID })
+	}
+	return g, members, nil
+}
+
+// PlacementGroup rejects hosts that would break a hard affinity or
+// anti-affinity policy of the requested placement group.
+type PlacementGroup struct {
+	Store *stores.Stores
+}
+
+func (PlacementGroup) Name() string { return "placement-group" }
+
+func (f PlacementGroup) Filter(req Request, c *Candidate) (bool, string) {
+	if req.PlacementGroupID == "" {
+		return true, ""
+	}
+	g, members, err := groupFor(f.Store, req)
+	if err != nil {
+		return false, err.Error()
+	}
+	if g.Enforcement != stores.EnforcementHard {
+		return true, ""
+	}
+	return groupFit(g, members, c.Host.ID)
+}
+
+// PlacementGroupPreference favours hosts that keep the requested placement
+// group's policy; it is what enforces soft groups.
+type PlacementGroupPreference struct {
+	Store *stores.Stores
+}
+
+func (PlacementGroupPreference) Name() string { return "placement-group" }
+
+func (p PlacementGroupPreference) Score(req Request, c *Candidate, _ []*Candidate) float64 {
+	if req.PlacementGroupID == "" {
+		return 1
+	}
+	g, members, err := groupFor(p.Store, req)
+	if err != nil {
+		return 0
+	}
+	if ok, _ := groupFit(g, members, c.Host.ID); !ok {
+		return 0
+	}
+	if g.Policy == stores.PolicyAntiAffinity {
+		// Prefer the hosts with the fewest members even below the cap.
+		n := 0
+		for _, vm := range members {
+			if vm.HostID == c.Host.ID {
+				n++
+			}
+		}
+		return 1 - float64(n)/float64(g.MaxPerHost)
+	}
+	return 1
+}
+
+// CheckHost verifies that an explicitly chosen host is not in maintenance and
+// that placing req on it keeps its placement group's hard policy. Explicit
+// host choices skip the rest of the pipeline.
+func (s *Scheduler) CheckHost(req Request, hostID string) error {
+	if h, err := s.store.GetHost(hostID); err == nil {
+		if h.State == stores.HostStateDraining || h.State == stores.HostStateDrained {
+			return fmt.Errorf("%w: host %s is %s for maintenance", stores.ErrConflict, h.Hostname, h.State)
+		}
+	}
+	if req.PlacementGroupID == "" {
+		return nil
+	}
+	g, members, err := groupFor(s.store, req)
+	if err != nil {
+		return err
+	}
+	if g.Enforcement != stores.EnforcementHard {
+		return nil
+	}
+	if ok, reason := groupFit(g, members, hostID); !ok {
+		return fmt.Errorf("%w: %s", stores.ErrConflict, reason)
+	}
+	return nil
+}
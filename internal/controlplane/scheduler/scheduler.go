@@ -1,13 +1,309 @@
+// Package scheduler places VMs on hosts. Placement runs a pipeline of filters
+// that reject unsuitable hosts (each with a reason) followed by weighted
+// scorers that rank the remaining ones. Every decision is kept so operators
+// can see why a VM landed where it did.
 package scheduler
 
-import "log"
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
 
-// Scheduler assigns units of work to agents/nodes.
-// Placeholder for queueing, scoring, and assignment logic.
-type Scheduler struct{}
+	"github.com/VerteraIO/vertera/internal/controlplane/capacity"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+)
 
-func New() *Scheduler { return &Scheduler{} }
+// ErrNoHost is returned when every host was rejected by a filter.
+var ErrNoHost = errors.New("no host satisfies the placement request")
+
+// Request describes what a VM needs from its host.
+type Request struct {
+	ProjectID  string   `json:"projectId"`
+	ClusterID  string   `json:"clusterId,omitempty"`
+	Vcpus      int      `json:"vcpus"`
+	MemoryMiB  int      `json:"memoryMiB"`
+	DiskGiB    int      `json:"diskGiB,omitempty"`
+	Hugepages  bool     `json:"hugepages,omitempty"`
+	CPUFlags   []string `json:"cpuFlags,omitempty"`
+	PortGroups []string `json:"portGroups,omitempty"`
+	// PlacementGroupID is the affinity/anti-affinity group the VM joins.
+	PlacementGroupID string `json:"placementGroupId,omitempty"`
+	// VMID is set when placing an existing VM, e.g. a migration target; the
+	// VM does not count against its own placement group.
+	VMID string `json:"vmId,omitempty"`
+	// ExcludeHostIDs are rejected up front, e.g. a migrating VM's source.
+	ExcludeHostIDs []string `json:"excludeHostIds,omitempty"`
+	// SharedStorage asks for a host whose cluster keeps VM disks on shared
+	// or replicated storage, e.g. for an HA VM.
+	SharedStorage bool `json:"sharedStorage,omitempty"`
+}
+
+// RequestFor builds the placement request of a VM create.
+func RequestFor(in stores.VMCreate) Request {
+	req := Request{ProjectID: in.ProjectID, Vcpus: in.Vcpus, MemoryMiB: in.MemoryMiB}
+	if in.ClusterID != nil {
+		req.ClusterID = *in.ClusterID
+	}
+	if in.Requirements != nil {
+		req.Hugepages = in.Requirements.Hugepages
+		req.CPUFlags = in.Requirements.CPUFlags
+	}
+	for _, d := range in.Disks {
+		req.DiskGiB += d.SizeGiB
+	}
+	for _, n := range in.Nets {
+		req.PortGroups = append(req.PortGroups, n.PortGroup)
+	}
+	if in.PlacementGroupID != nil {
+		req.PlacementGroupID = *in.PlacementGroupID
+	}
+	req.SharedStorage = in.HA
+	return req
+}
+
+// Candidate is a host under consideration together with what is already
+// placed on it.
+type Candidate struct {
+	Host *stores.Host
+	// Inventory is the host's latest inventory; nil if none was reported yet.
+	Inventory *stores.Inventory
+	// VMs are the VMs placed on the host or being migrated to it.
+	VMs []*stores.VM
+	// Policy is the capacity policy of the host's cluster.
+	Policy stores.CapacityPolicy
+}
+
+// Capacity accounts for the candidate's allocatable and used resources under
+// its cluster's capacity policy.
+func (c *Candidate) Capacity() *capacity.Host {
+	return capacity.ForHost(c.Host, c.Inventory, c.VMs, c.Policy)
+}
+
+// Filter rejects hosts that cannot run the request. reason explains a rejection.
+type Filter interface {
+	Name() string
+	Filter(req Request, c *Candidate) (ok bool, reason string)
+}
+
+// Scorer rates a host that passed every filter, from 0 (worst) to 1 (best).
+type Scorer interface {
+	Name() string
+	Score(req Request, c *Candidate, all []*Candidate) float64
+}
+
+// WeightedScorer is a scorer and its weight in the final score.
+type WeightedScorer struct {
+	Scorer Scorer
+	Weight float64
+}
+
+// HostScore is the final and per-scorer score of an accepted host.
+type HostScore struct {
+	HostID   string             `json:"hostId"`
+	Hostname string             `json:"hostname"`
+	Score    float64            `json:"score"`
+	Scores   map[string]float64 `json:"scores"`
+}
+
+// Rejection records which filter turned a host down and why.
+type Rejection struct {
+	HostID   string `json:"hostId"`
+	Hostname string `json:"hostname"`
+	Filter   string `json:"filter"`
+	Reason   string `json:"reason"`
+}
+
+// Decision is the outcome of a placement run. HostID is empty when no host fit.
+type Decision struct {
+	VMID      string      `json:"vmId,omitempty"`
+	Request   Request     `json:"request"`
+	HostID    string      `json:"hostId,omitempty"`
+	Ranked    []HostScore `json:"ranked"`
+	Rejected  []Rejection `json:"rejected"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+// Scheduler runs the placement pipeline against the store.
+type Scheduler struct {
+	store *stores.Stores
+
+	mu        sync.RWMutex
+	filters   []Filter
+	scorers   []WeightedScorer
+	decisions map[string]*Decision // vmID -> last placement decision
+}
+
+// New returns a scheduler using the default filters and scorers.
+func New(st *stores.Stores) *Scheduler {
+	return &Scheduler{
+		store:     st,
+		filters:   DefaultFilters(st),
+		scorers:   DefaultScorers(st),
+		decisions: make(map[string]*Decision),
+	}
+}
+
+// Default is the process-wide scheduler backed by the default store.
+var Default = New(stores.Default)
+
+// DefaultFilters returns the built-in filters in evaluation order.
+func DefaultFilters(st *stores.Stores) []Filter {
+	return []Filter{
+		HostReady{},
+		Cluster{},
+		SharedStorage{Store: st},
+		PortGroups{Store: st},
+		Resources{},
+		CPUFlags{},
+		PlacementGroup{Store: st},
+	}
+}
+
+// DefaultScorers returns the built-in scorers and their default weights.
+// Bin-packing is available but disabled; give it a weight (and lower
+// least-loaded) to consolidate VMs instead of spreading them. Soft placement
+// groups outweigh the load-based scorers combined.
+func DefaultScorers(st *stores.Stores) []WeightedScorer {
+	return []WeightedScorer{
+		{Scorer: LeastLoaded{}, Weight: 1},
+		{Scorer: Spread{}, Weight: 0.5},
+		{Scorer: BinPack{}, Weight: 0},
+		{Scorer: PlacementGroupPreference{Store: st}, Weight: 2},
+	}
+}
+
+// SetFilters replaces the filter pipeline.
+func (s *Scheduler) SetFilters(filters ...Filter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filters = filters
+}
+
+// SetScorers replaces the scorers and their weights.
+func (s *Scheduler) SetScorers(scorers ...WeightedScorer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scorers = scorers
+}
 
 func (s *Scheduler) Start() {
 	log.Println("controlplane: scheduler started")
 }
+
+// Place runs the pipeline over all known hosts. It always returns the
+// decision; the error wraps ErrNoHost when nothing fit.
+func (s *Scheduler) Place(req Request) (*Decision, error) {
+	return s.run(req, s.candidates())
+}
+
+// Check runs the pipeline on a single host, e.g. the explicit target of a
+// migration. The error wraps ErrNoHost with the filter's reason when the
+// host was rejected.
+func (s *Scheduler) Check(req Request, hostID string) (*Decision, error) {
+	for _, c := range s.candidates() {
+		if c.Host.ID != hostID {
+			continue
+		}
+		d, err := s.run(req, []*Candidate{c})
+		if err != nil && len(d.Rejected) == 1 {
+			r := d.Rejected[0]
+			err = fmt.Errorf("%w: host %s rejected by %s: %s", ErrNoHost, r.Hostname, r.Filter, r.Reason)
+		}
+		return d, err
+	}
+	return nil, fmt.Errorf("host %s: %w", hostID, stores.ErrNotFound)
+}
+
+func (s *Scheduler) run(req Request, candidates []*Candidate) (*Decision, error) {
+	s.mu.RLock()
+	filters, scorers := s.filters, s.scorers
+	s.mu.RUnlock()
+
+	d := &Decision{Request: req, Ranked: []HostScore{}, Rejected: []Rejection{}, CreatedAt: time.Now().UTC()}
+	var accepted []*Candidate
+	for _, c := range candidates {
+		if slices.Contains(req.ExcludeHostIDs, c.Host.ID) {
+			d.Rejected = append(d.Rejected, Rejection{HostID: c.Host.ID, Hostname: c.Host.Hostname, Filter: "excluded", Reason: "excluded by the request"})
+			continue
+		}
+		rejected := false
+		for _, f := range filters {
+			if ok, reason := f.Filter(req, c); !ok {
+				d.Rejected = append(d.Rejected, Rejection{HostID: c.Host.ID, Hostname: c.Host.Hostname, Filter: f.Name(), Reason: reason})
+				rejected = true
+				break
+			}
+		}
+		if !rejected {
+			accepted = append(accepted, c)
+		}
+	}
+	if len(accepted) == 0 {
+		return d, fmt.Errorf("%w (%d host(s) rejected)", ErrNoHost, len(d.Rejected))
+	}
+
+	for _, c := range accepted {
+		hs := HostScore{HostID: c.Host.ID, Hostname: c.Host.Hostname, Scores: make(map[string]float64)}
+		for _, ws := range scorers {
+			v := ws.Scorer.Score(req, c, accepted)
+			hs.Scores[ws.Scorer.Name()] = v
+			hs.Score += ws.Weight * v
+		}
+		d.Ranked = append(d.Ranked, hs)
+	}
+	// Highest score first; hostname breaks ties so placement is deterministic.
+	sort.SliceStable(d.Ranked, func(i, j int) bool {
+		if d.Ranked[i].Score != d.Ranked[j].Score {
+			return d.Ranked[i].Score > d.Ranked[j].Score
+		}
+		return d.Ranked[i].Hostname < d.Ranked[j].Hostname
+	})
+	d.HostID = d.Ranked[0].HostID
+	return d, nil
+}
+
+// Record keeps a decision as the placement of vmID.
+func (s *Scheduler) Record(vmID string, d *Decision) {
+	cp := *d
+	cp.VMID = vmID
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.decisions[vmID] = &cp
+}
+
+// Forget drops the recorded placement of a VM.
+func (s *Scheduler) Forget(vmID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.decisions, vmID)
+}
+
+// Decision returns the recorded placement decision of a VM.
+func (s *Scheduler) Decision(vmID string) (*Decision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.decisions[vmID]
+	if !ok {
+		return nil, fmt.Errorf("placement decision for vm %s: %w", vmID, stores.ErrNotFound)
+	}
+	cp := *d
+	return &cp, nil
+}
+
+// candidates snapshots every host with its inventory and VMs.
+func (s *Scheduler) candidates() []*Candidate {
+	hosts := s.store.ListHosts("", "")
+	out := make([]*Candidate, 0, len(hosts))
+	for _, h := range hosts {
+		c := &Candidate{Host: h, VMs: s.store.HostVMs(h.ID), Policy: s.store.CapacityPolicy(h.ClusterID)}
+		if inv, err := s.store.LatestInventory(h.ID); err == nil {
+			c.Inventory = inv
+		}
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,184 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/network"
+)
+
+// addHost registers a ready host in cluster with the given CPUs, memory and
+// 2 MiB hugepages.
+func addHost(t *testing.T, st *stores.Stores, cluster, hostname string, cpus int, memMiB int64, hugepages int64, flags ...string) *stores.Host {
+	t.Helper()
+	h, err := st.CreateHost(stores.HostCreate{ProjectID: "p1", ClusterID: &cluster, Hostname: hostname})
+	if err != nil {
+		t.Fatalf("create host: %v", err)
+	}
+	if _, err := st.RegisterAgentHost("", hostname); err != nil {
+		t.Fatalf("register host: %v", err)
+	}
+	inv := stores.Inventory{
+		CPU:    stores.InventoryCPU{Sockets: 1, Cores: cpus, Threads: 1, Flags: flags},
+		Memory: stores.InventoryMemory{Total: memMiB << 20},
+	}
+	if hugepages > 0 {
+		inv.Memory.Hugepages = map[string]stores.HugepagePool{"2048": {Total: hugepages, Free: hugepages}}
+	}
+	if err := st.RecordInventory(h.ID, inv); err != nil {
+		t.Fatalf("record inventory: %v", err)
+	}
+	return h
+}
+
+func newTestStore(t *testing.T) *stores.Stores {
+	t.Helper()
+	st := stores.New()
+	d, err := st.CreateDvs(stores.DvsCreate{ClusterID: "c1", Name: "net"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vlan := 10
+	if _, err := st.CreatePortGroup(stores.PortGroupCreate{DvsID: d.ID, Name: "web", VlanMode: network.VlanModeAccess, VlanID: &vlan}); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func rejectedBy(d *Decision, hostID string) string {
+	for _, r := range d.Rejected {
+		if r.HostID == hostID {
+			return r.Filter
+		}
+	}
+	return ""
+}
+
+func TestFilters(t *testing.T) {
+	st := newTestStore(t)
+	big := addHost(t, st, "c1", "big", 16, 32768, 1024, "avx2", "vmx")
+	small := addHost(t, st, "c1", "small", 4, 8192, 0, "vmx")
+	other := addHost(t, st, "c2", "other", 16, 32768, 0)
+	enrolled, err := st.CreateHost(stores.HostCreate{ProjectID: "p1", Hostname: "enrolled"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(st)
+
+	d, err := s.Place(Request{ProjectID: "p1", ClusterID: "c1", Vcpus: 2, MemoryMiB: 1024, PortGroups: []string{"web"}})
+	if err != nil {
+		t.Fatalf("Place: %v", err)
+	}
+	if len(d.Ranked) != 2 || d.HostID != big.ID {
+		t.Fatalf("expected big then small, got %+v", d.Ranked)
+	}
+	if rejectedBy(d, other.ID) != "cluster" || rejectedBy(d, enrolled.ID) != "host-ready" {
+		t.Fatalf("unexpected rejections: %+v", d.Rejected)
+	}
+
+	// Port groups must exist in the host's cluster
+	d, _ = s.Place(Request{ProjectID: "p1", Vcpus: 1, MemoryMiB: 512, PortGroups: []string{"web"}})
+	if rejectedBy(d, other.ID) != "port-groups" {
+		t.Fatalf("expected other to lack port group web: %+v", d.Rejected)
+	}
+
+	d, _ = s.Place(Request{ProjectID: "p1", ClusterID: "c1", Vcpus: 1, MemoryMiB: 512, CPUFlags: []string{"avx2"}})
+	if d.HostID != big.ID || rejectedBy(d, small.ID) != "cpu-flags" {
+		t.Fatalf("expected only big to have avx2: %+v", d)
+	}
+
+	// c1 was never created, so its disks count as local
+	d, err = s.Place(Request{ProjectID: "p1", ClusterID: "c1", Vcpus: 1, MemoryMiB: 512, SharedStorage: true})
+	if !errors.Is(err, ErrNoHost) || rejectedBy(d, big.ID) != "shared-storage" {
+		t.Fatalf("expected no host with shared storage, got %v %+v", err, d)
+	}
+
+	d, _ = s.Place(Request{ProjectID: "p1", ClusterID: "c1", Vcpus: 8, MemoryMiB: 512})
+	if d.HostID != big.ID || rejectedBy(d, small.ID) != "resources" {
+		t.Fatalf("expected small to lack vCPUs: %+v", d)
+	}
+
+	// big has 1024 x 2 MiB hugepages = 2 GiB
+	if _, err := s.Place(Request{ProjectID: "p1", ClusterID: "c1", Vcpus: 1, MemoryMiB: 2048, Hugepages: true}); err != nil {
+		t.Fatalf("2 GiB of hugepages should fit: %v", err)
+	}
+	d, err = s.Place(Request{ProjectID: "p1", ClusterID: "c1", Vcpus: 1, MemoryMiB: 4096, Hugepages: true})
+	if !errors.Is(err, ErrNoHost) || d.HostID != "" || rejectedBy(d, big.ID) != "resources" {
+		t.Fatalf("expected no host for 4 GiB of hugepages, got %v %+v", err, d)
+	}
+}
+
+func TestAllocatedResourcesAreSubtracted(t *testing.T) {
+	st := newTestStore(t)
+	h := addHost(t, st, "c1", "only", 4, 8192, 0)
+	s := New(st)
+	hostID := h.ID
+	if _, err := st.CreateVM(stores.VMCreate{ProjectID: "p1", HostID: &hostID, Name: "a", Vcpus: 3, MemoryMiB: 1024}); err != nil {
+		t.Fatal(err)
+	}
+	d, err := s.Place(Request{ProjectID: "p1", Vcpus: 2, MemoryMiB: 512})
+	if !errors.Is(err, ErrNoHost) || d.Rejected[0].Reason != "needs 2 vCPUs, 1 free" {
+		t.Fatalf("expected vCPU shortage, got %v %+v", err, d.Rejected)
+	}
+}
+
+func TestScorers(t *testing.T) {
+	st := newTestStore(t)
+	a := addHost(t, st, "c1", "a", 8, 16384, 0)
+	b := addHost(t, st, "c1", "b", 8, 16384, 0)
+	aID := a.ID
+	if _, err := st.CreateVM(stores.VMCreate{ProjectID: "p1", HostID: &aID, Name: "busy", Vcpus: 4, MemoryMiB: 8192}); err != nil {
+		t.Fatal(err)
+	}
+	s := New(st)
+	req := Request{ProjectID: "p1", Vcpus: 1, MemoryMiB: 1024}
+
+	// Least-loaded and spread both favour the empty host
+	d, err := s.Place(req)
+	if err != nil || d.HostID != b.ID {
+		t.Fatalf("expected b, got %v %+v", err, d)
+	}
+	if sc := d.Ranked[0].Scores; sc["spread"] != 1 || sc["least-loaded"] <= d.Ranked[1].Scores["least-loaded"] {
+		t.Fatalf("unexpected scores: %+v", d.Ranked)
+	}
+
+	// Bin-packing favours the fuller host
+	s.SetScorers(WeightedScorer{Scorer: BinPack{}, Weight: 1})
+	if d, err = s.Place(req); err != nil || d.HostID != a.ID {
+		t.Fatalf("expected a with bin-packing, got %v %+v", err, d)
+	}
+
+	s.Record("vm-1", d)
+	got, err := s.Decision("vm-1")
+	if err != nil || got.VMID != "vm-1" || got.HostID != a.ID {
+		t.Fatalf("Decision: %v %+v", err, got)
+	}
+	s.Forget("vm-1")
+	if _, err := s.Decision("vm-1"); !errors.Is(err, stores.ErrNotFound) {
+		t.Fatalf("expected not found after Forget, got %v", err)
+	}
+}
+
+func TestHardAffinity(t *testing.T) {
+	st := newTestStore(t)
+	a := addHost(t, st, "c1", "a", 8, 16384, 0)
+	b := addHost(t, st, "c1", "b", 8, 16384, 0)
+	g, err := st.CreatePlacementGroup(stores.PlacementGroupCreate{ProjectID: "p1", Name: "tier", Policy: stores.PolicyAffinity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aID := a.ID
+	if _, err := st.CreateVM(stores.VMCreate{ProjectID: "p1", HostID: &aID, PlacementGroupID: &g.ID, Name: "first", Vcpus: 4, MemoryMiB: 8192}); err != nil {
+		t.Fatal(err)
+	}
+	s := New(st)
+	req := Request{ProjectID: "p1", Vcpus: 1, MemoryMiB: 512, PlacementGroupID: g.ID}
+	d, err := s.Place(req)
+	if err != nil || d.HostID != a.ID || rejectedBy(d, b.ID) != "placement-group" {
+		t.Fatalf("expected the busier host a, got %v %+v", err, d)
+	}
+	if err := s.CheckHost(req, b.ID); !errors.Is(err, stores.ErrConflict) {
+		t.Fatalf("expected conflict placing on b, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package scheduler
+
+// utilization is the fraction of the candidate's allocatable vCPUs and
+// memory (averaged) that would be allocated after placing req on it.
+// Hugepage-backed requests are measured against the hugepage pools.
+func utilization(req Request, c *Candidate) float64 {
+	hc := c.Capacity()
+	capMem, usedMem := hc.Allocatable.MemoryMiB, hc.Used.MemoryMiB
+	if req.Hugepages {
+		capMem, usedMem = hc.Allocatable.HugepagesMiB, hc.Used.HugepagesMiB
+	}
+	if hc.Allocatable.Vcpus == 0 || capMem == 0 {
+		return 1
+	}
+	cpu := float64(hc.Used.Vcpus+req.Vcpus) / float64(hc.Allocatable.Vcpus)
+	mem := float64(usedMem+int64(req.MemoryMiB)) / float64(capMem)
+	return clamp((cpu + mem) / 2)
+}
+
+func clamp(v float64) float64 {
+	switch {
+	case v < 0:
+		return 0
+	case v > 1:
+		return 1
+	}
+	return v
+}
+
+// LeastLoaded prefers hosts with the most resources left after placement.
+type LeastLoaded struct{}
+
+func (LeastLoaded) Name() string { return "least-loaded" }
+
+func (LeastLoaded) Score(req Request, c *Candidate, _ []*Candidate) float64 {
+	return 1 - utilization(req, c)
+}
+
+// BinPack prefers the fullest hosts that still fit, keeping others empty.
+type BinPack struct{}
+
+func (BinPack) Name() string { return "bin-pack" }
+
+func (BinPack) Score(req Request, c *Candidate, _ []*Candidate) float64 {
+	return utilization(req, c)
+}
+
+// Spread prefers hosts running fewer of the project's VMs, relative to the
+// busiest candidate, so a project's VMs are spread across hosts.
+type Spread struct{}
+
+func (Spread) Name() string { return "spread" }
+
+func (Spread) Score(req Request, c *Candidate, all []*Candidate) float64 {
+	count := func(c *Candidate) int {
+		n := 0
+		for _, vm := range c.VMs {
+			if vm.ProjectID == req.ProjectID {
+				n++
+			}
+		}
+		return n
+	}
+	most := 0
+	for _, o := range all {
+		if n := count(o); n > most {
+			most = n
+		}
+	}
+	if most == 0 {
+		return 1
+	}
+	return 1 - float64(count(c))/float64(most)
+}
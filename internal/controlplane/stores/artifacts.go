@@ -0,0 +1,327 @@
+package stores
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/VerteraIO/vertera/internal/packages"
+)
+
+// ArtifactType is what an artifact installs on a host.
+type ArtifactType string
+
+const (
+	ArtifactOVS             ArtifactType = "ovs"
+	ArtifactCloudHypervisor ArtifactType = "cloud-hypervisor"
+	ArtifactFirmware        ArtifactType = "firmware"
+	ArtifactAgent           ArtifactType = "agent"
+)
+
+// ArtifactChannel is the release channel of an artifact. Hosts get the
+// newest stable version unless a version is requested explicitly.
+type ArtifactChannel string
+
+const (
+	ChannelStable    ArtifactChannel = "stable"
+	ChannelCandidate ArtifactChannel = "candidate"
+)
+
+// Artifact is one file of a release in the artifact registry, e.g. one of
+// the RPMs of an Open vSwitch version.
+type Artifact struct {
+	ID      string          `json:"id"`
+	Type    ArtifactType    `json:"type"`
+	Version string          `json:"version"`
+	Channel ArtifactChannel `json:"channel"`
+	// OSVersion is the distribution the file is built for, e.g. "el9",
+	// "fc40", "debian12" or "ubuntu24.04"; empty for files that run anywhere.
+	OSVersion string `json:"osVersion,omitempty"`
+	// Name is the file name hosts cache the download under.
+	Name string `json:"name"`
+	// URL is where the file is published upstream; empty for files that
+	// are only available from the controller's package mirror.
+	URL string `json:"url,omitempty"`
+	// Digest is the file's checksum as "sha256:<hex>".
+	Digest    string `json:"digest"`
+	SizeBytes int64  `json:"sizeBytes,omitempty"`
+	// Optional files are listed but not installed by default.
+	Optional  bool      `json:"optional"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ArtifactCreate holds the fields accepted when registering an artifact.
+// Channel defaults to stable.
+type ArtifactCreate struct {
+	Type      ArtifactType    `json:"type"`
+	Version   string          `json:"version"`
+	Channel   ArtifactChannel `json:"channel"`
+	OSVersion string          `json:"osVersion"`
+	Name      string          `json:"name"`
+	URL       string          `json:"url"`
+	Digest    string          `json:"digest"`
+	SizeBytes int64           `json:"sizeBytes"`
+	Optional  bool            `json:"optional"`
+}
+
+// ArtifactUpdate holds the mutable fields of an artifact; nil fields are left
+// unchanged. Changing the channel promotes or demotes a release.
+type ArtifactUpdate struct {
+	Channel *ArtifactChannel `json:"channel"`
+}
+
+// ArtifactFilter narrows ListArtifacts; empty fields match everything.
+type ArtifactFilter struct {
+	Type    ArtifactType
+	Channel ArtifactChannel
+	Version string
+}
+
+var sha256Digest = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+func (t ArtifactType) validate() error {
+	switch t {
+	case ArtifactOVS, ArtifactCloudHypervisor, ArtifactFirmware, ArtifactAgent:
+		return nil
+	}
+	return fmt.Errorf("%w: type must be one of ovs, cloud-hypervisor, firmware, agent", ErrInvalid)
+}
+
+func (c ArtifactChannel) validate() error {
+	if c != ChannelStable && c != ChannelCandidate {
+		return fmt.Errorf("%w: channel must be stable or candidate", ErrInvalid)
+	}
+	return nil
+}
+
+// CreateArtifact validates and registers an artifact. A type, version and
+// OS version hold each file name once.
+func (s *Stores) CreateArtifact(in ArtifactCreate) (*Artifact, error) {
+	if in.Channel == "" {
+		in.Channel = ChannelStable
+	}
+	if err := in.Type.validate(); err != nil {
+		return nil, err
+	}
+	if err := in.Channel.validate(); err != nil {
+		return nil, err
+	}
+	if in.Version == "" || in.Name == "" {
+		return nil, fmt.Errorf("%w: version and name are required", ErrInvalid)
+	}
+	if strings.ContainsAny(in.Name, `/\`) || in.Name == "." || in.Name == ".." {
+		return nil, fmt.Errorf("%w: name must be a file name", ErrInvalid)
+	}
+	if err := packages.ValidateOSVersion(in.OSVersion); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	// Host packages are installed by the package manager of the OS they
+	// are built for, so they must be in its format.
+	if in.OSVersion != "" && (in.Type == ArtifactOVS || in.Type == ArtifactCloudHypervisor) {
+		if ext := packages.OSFamily(in.OSVersion).Ext(); !strings.HasSuffix(in.Name, ext) {
+			return nil, fmt.Errorf("%w: %s packages for %s must be %s files", ErrInvalid, in.Type, in.OSVersion, ext)
+		}
+	}
+	if in.URL != "" {
+		if u, err := url.Parse(in.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%w: url must be an http(s) URL", ErrInvalid)
+		}
+	}
+	if !sha256Digest.MatchString(in.Digest) {
+		return nil, fmt.Errorf("%w: digest must be sha256: followed by 64 lowercase hex digits", ErrInvalid)
+	}
+	if in.SizeBytes < 0 {
+		return nil, fmt.Errorf("%w: sizeBytes must not be negative", ErrInvalid)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.artifacts {
+		if a.Type == in.Type && a.Version == in.Version && a.OSVersion == in.OSVersion && a.Name == in.Name {
+			return nil, fmt.Errorf("%w: artifact %s is already registered for %s %s", ErrConflict, in.Name, in.Type, in.Version)
+		}
+	}
+	a := &Artifact{
+		ID:        uuid.NewString(),
+		Type:      in.Type,
+		Version:   in.Version,
+		Channel:   in.Channel,
+		OSVersion: in.OSVersion,
+		Name:      in.Name,
+		URL:       in.URL,
+		Digest:    in.Digest,
+		SizeBytes: in.SizeBytes,
+		Optional:  in.Optional,
+		CreatedAt: time.Now().UTC(),
+	}
+	s.artifacts[a.ID] = a
+	cp := *a
+	return &cp, nil
+}
+
+// LoadArtifacts registers the artifacts listed in a JSON file holding an
+// array of ArtifactCreate, skipping ones that are already registered.
+func (s *Stores) LoadArtifacts(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var in []ArtifactCreate
+	if err := json.Unmarshal(data, &in); err != nil {
+		return 0, fmt.Errorf("decode %s: %w", path, err)
+	}
+	n := 0
+	for _, a := range in {
+		_, err := s.CreateArtifact(a)
+		if err == nil {
+			n++
+			continue
+		}
+		if !errors.Is(err, ErrConflict) {
+			return n, fmt.Errorf("artifact %s %s %s: %w", a.Type, a.Version, a.Name, err)
+		}
+	}
+	return n, nil
+}
+
+// GetArtifact returns a copy of the artifact with the given id.
+func (s *Stores) GetArtifact(id string) (*Artifact, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.artifacts[id]
+	if !ok {
+		return nil, fmt.Errorf("artifact %s: %w", id, ErrNotFound)
+	}
+	cp := *a
+	return &cp, nil
+}
+
+// ListArtifacts returns the artifacts matching f by type, newest version
+// first, then by OS version and name.
+func (s *Stores) ListArtifacts(f ArtifactFilter) []*Artifact {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]*Artifact, 0, len(s.artifacts))
+	for _, a := range s.artifacts {
+		if (f.Type != "" && a.Type != f.Type) || (f.Channel != "" && a.Channel != f.Channel) || (f.Version != "" && a.Version != f.Version) {
+			continue
+		}
+		cp := *a
+		out = append(out, &cp)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if c := packages.CompareVersions(a.Version, b.Version); c != 0 {
+			return c > 0
+		}
+		if a.OSVersion != b.OSVersion {
+			return a.OSVersion < b.OSVersion
+		}
+		return a.Name < b.Name
+	})
+	return out
+}
+
+// UpdateArtifact applies the non-nil fields of in.
+func (s *Stores) UpdateArtifact(id string, in ArtifactUpdate) (*Artifact, error) {
+	if in.Channel != nil {
+		if err := in.Channel.validate(); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.artifacts[id]
+	if !ok {
+		return nil, fmt.Errorf("artifact %s: %w", id, ErrNotFound)
+	}
+	if in.Channel != nil {
+		a.Channel = *in.Channel
+	}
+	cp := *a
+	return &cp, nil
+}
+
+// DeleteArtifact removes an artifact from the registry. Hosts keep files
+// they already downloaded.
+func (s *Stores) DeleteArtifact(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.artifacts[id]; !ok {
+		return fmt.Errorf("artifact %s: %w", id, ErrNotFound)
+	}
+	delete(s.artifacts, id)
+	return nil
+}
+
+// ResolvePackage returns the files of a package release for osVersion. An
+// empty version picks the newest stable release built for osVersion; an
+// empty osVersion is only accepted when the release is built for a single
+// distribution.
+func (s *Stores) ResolvePackage(pkgType packages.PackageType, version, osVersion string) ([]packages.PackageInfo, error) {
+	typ := ArtifactType(pkgType)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var match []*Artifact
+	for _, a := range s.artifacts {
+		if a.Type != typ || (osVersion != "" && a.OSVersion != "" && a.OSVersion != osVersion) {
+			continue
+		}
+		if (version != "" && a.Version != version) || (version == "" && a.Channel != ChannelStable) {
+			continue
+		}
+		match = append(match, a)
+	}
+	if len(match) == 0 {
+		what := "stable release"
+		if version != "" {
+			what = "release " + version
+		}
+		if osVersion != "" {
+			what += " for " + osVersion
+		}
+		return nil, fmt.Errorf("no %s %s is registered: %w", typ, what, ErrNotFound)
+	}
+	if version == "" {
+		for _, a := range match {
+			if packages.CompareVersions(a.Version, version) > 0 {
+				version = a.Version
+			}
+		}
+	}
+	oses := map[string]bool{}
+	var files []*Artifact
+	for _, a := range match {
+		if a.Version != version {
+			continue
+		}
+		files = append(files, a)
+		if a.OSVersion != "" {
+			oses[a.OSVersion] = true
+		}
+	}
+	if osVersion == "" && len(oses) > 1 {
+		names := make([]string, 0, len(oses))
+		for o := range oses {
+			names = append(names, o)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%w: os_version is required, %s %s is built for %s", ErrInvalid, typ, version, strings.Join(names, ", "))
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	out := make([]packages.PackageInfo, 0, len(files))
+	for _, a := range files {
+		out = append(out, packages.PackageInfo{Name: a.Name, Version: a.Version, URL: a.URL, Size: a.SizeBytes, SHA256: strings.TrimPrefix(a.Digest, "sha256:"), Required: !a.Optional})
+	}
+	return out, nil
+}
@@ -0,0 +1,233 @@
+package stores
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CapacityPolicy controls how much of a cluster's hosts VMs may be allocated.
+// Allocatable capacity is (physical - reserved) * overcommit.
+type CapacityPolicy struct {
+	CPUOvercommit     float64 `json:"cpuOvercommit"`
+	MemoryOvercommit  float64 `json:"memoryOvercommit"`
+	ReservedVcpus     int     `json:"reservedVcpus"`
+	ReservedMemoryMiB int     `json:"reservedMemoryMiB"`
+}
+
+// DefaultCapacityPolicy applies to hosts outside any cluster and to clusters
+// that were never configured: no overcommit and no reservation.
+func DefaultCapacityPolicy() CapacityPolicy {
+	return CapacityPolicy{CPUOvercommit: 1, MemoryOvercommit: 1}
+}
+
+func (p CapacityPolicy) validate() error {
+	if p.CPUOvercommit < 1 || p.MemoryOvercommit < 1 {
+		return fmt.Errorf("%w: overcommit ratios must be at least 1", ErrInvalid)
+	}
+	if p.ReservedVcpus < 0 || p.ReservedMemoryMiB < 0 {
+		return fmt.Errorf("%w: reserved resources cannot be negative", ErrInvalid)
+	}
+	return nil
+}
+
+// ClusterStorage says where the VM volume directories of a cluster's hosts,
+// and so the VMs' disks, live.
+type ClusterStorage string
+
+const (
+	// StorageLocal disks exist on one host only.
+	StorageLocal ClusterStorage = "local"
+	// StorageShared disks are on storage mounted at the same path on every
+	// host of the cluster, e.g. NFS or a cluster filesystem.
+	StorageShared ClusterStorage = "shared"
+	// StorageReplicated disks are kept in sync on every host of the cluster.
+	StorageReplicated ClusterStorage = "replicated"
+)
+
+func (s ClusterStorage) validate() error {
+	switch s {
+	case StorageLocal, StorageShared, StorageReplicated:
+		return nil
+	}
+	return fmt.Errorf("%w: storage must be local, shared or replicated", ErrInvalid)
+}
+
+// Cluster groups hosts that share networks and a capacity policy.
+type Cluster struct {
+	ID             string         `json:"id"`
+	ProjectID      string         `json:"projectId"`
+	Name           string         `json:"name"`
+	CapacityPolicy CapacityPolicy `json:"capacityPolicy"`
+	Storage        ClusterStorage `json:"storage"`
+	CreatedAt      time.Time      `json:"createdAt"`
+}
+
+// ClusterCreate holds the fields accepted when creating a cluster. Storage
+// defaults to local.
+type ClusterCreate struct {
+	ProjectID      string          `json:"projectId"`
+	Name           string          `json:"name"`
+	CapacityPolicy *CapacityPolicy `json:"capacityPolicy"`
+	Storage        ClusterStorage  `json:"storage"`
+}
+
+// ClusterUpdate holds the mutable fields of a cluster; nil fields are left unchanged.
+type ClusterUpdate struct {
+	Name           *string         `json:"name"`
+	CapacityPolicy *CapacityPolicy `json:"capacityPolicy"`
+	Storage        *ClusterStorage `json:"storage"`
+}
+
+// CreateCluster validates and stores a cluster.
+func (s *Stores) CreateCluster(in ClusterCreate) (*Cluster, error) {
+	if in.ProjectID == "" || in.Name == "" {
+		return nil, fmt.Errorf("%w: projectId and name are required", ErrInvalid)
+	}
+	policy := DefaultCapacityPolicy()
+	if in.CapacityPolicy != nil {
+		policy = *in.CapacityPolicy
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	storage := StorageLocal
+	if in.Storage != "" {
+		storage = in.Storage
+	}
+	if err := storage.validate(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clusters {
+		if c.ProjectID == in.ProjectID && c.Name == in.Name {
+			return nil, fmt.Errorf("%w: cluster %q already exists in project", ErrConflict, in.Name)
+		}
+	}
+	c := &Cluster{
+		ID:             uuid.NewString(),
+		ProjectID:      in.ProjectID,
+		Name:           in.Name,
+		CapacityPolicy: policy,
+		Storage:        storage,
+		CreatedAt:      time.Now().UTC(),
+	}
+	s.clusters[c.ID] = c
+	cp := *c
+	return &cp, nil
+}
+
+// GetCluster returns a copy of the cluster with the given id.
+func (s *Stores) GetCluster(id string) (*Cluster, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.clusters[id]
+	if !ok {
+		return nil, fmt.Errorf("cluster %s: %w", id, ErrNotFound)
+	}
+	cp := *c
+	return &cp, nil
+}
+
+// ListClusters returns clusters, optionally filtered by project, ordered by name.
+func (s *Stores) ListClusters(projectID string) []*Cluster {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]*Cluster, 0, len(s.clusters))
+	for _, c := range s.clusters {
+		if projectID != "" && c.ProjectID != projectID {
+			continue
+		}
+		cp := *c
+		out = append(out, &cp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// UpdateCluster renames a cluster or replaces its capacity policy or storage.
+func (s *Stores) UpdateCluster(id string, in ClusterUpdate) (*Cluster, error) {
+	if in.CapacityPolicy != nil {
+		if err := in.CapacityPolicy.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if in.Storage != nil {
+		if err := in.Storage.validate(); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clusters[id]
+	if !ok {
+		return nil, fmt.Errorf("cluster %s: %w", id, ErrNotFound)
+	}
+	if in.Name != nil {
+		if *in.Name == "" {
+			return nil, fmt.Errorf("%w: name cannot be empty", ErrInvalid)
+		}
+		for _, o := range s.clusters {
+			if o.ID != id && o.ProjectID == c.ProjectID && o.Name == *in.Name {
+				return nil, fmt.Errorf("%w: cluster %q already exists in project", ErrConflict, *in.Name)
+			}
+		}
+		c.Name = *in.Name
+	}
+	if in.CapacityPolicy != nil {
+		c.CapacityPolicy = *in.CapacityPolicy
+	}
+	if in.Storage != nil {
+		c.Storage = *in.Storage
+	}
+	cp := *c
+	return &cp, nil
+}
+
+// DeleteCluster removes a cluster that no host or DVS belongs to.
+func (s *Stores) DeleteCluster(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clusters[id]; !ok {
+		return fmt.Errorf("cluster %s: %w", id, ErrNotFound)
+	}
+	for _, h := range s.hosts {
+		if h.ClusterID == id {
+			return fmt.Errorf("%w: cluster still has hosts", ErrConflict)
+		}
+	}
+	for _, d := range s.dvs {
+		if d.ClusterID == id {
+			return fmt.Errorf("%w: cluster still has distributed switches", ErrConflict)
+		}
+	}
+	delete(s.clusters, id)
+	return nil
+}
+
+// SharedStorage reports whether the VM disks of clusterID's hosts are on
+// shared or replicated storage, so a VM can be started on any of them.
+func (s *Stores) SharedStorage(clusterID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sharedStorageLocked(clusterID)
+}
+
+func (s *Stores) sharedStorageLocked(clusterID string) bool {
+	c, ok := s.clusters[clusterID]
+	return ok && (c.Storage == StorageShared || c.Storage == StorageReplicated)
+}
+
+// CapacityPolicy returns the capacity policy that applies to hosts in
+// clusterID, falling back to DefaultCapacityPolicy.
+func (s *Stores) CapacityPolicy(clusterID string) CapacityPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if c, ok := s.clusters[clusterID]; ok {
+		return c.CapacityPolicy
+	}
+	return DefaultCapacityPolicy()
+}
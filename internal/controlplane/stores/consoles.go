@@ -0,0 +1,86 @@
+package stores
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxConsoleSessions bounds the console audit log kept per VM.
+const maxConsoleSessions = 50
+
+// ConsoleSession is an audit record of one serial console access.
+type ConsoleSession struct {
+	ID         string     `json:"id"`
+	VMID       string     `json:"vmId"`
+	HostID     string     `json:"hostId"`
+	RemoteAddr string     `json:"remoteAddr"`
+	UserAgent  string     `json:"userAgent,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	EndedAt    *time.Time `json:"endedAt,omitempty"`
+	// BytesIn counts viewer input sent to the VM, BytesOut console output
+	// sent to the viewer.
+	BytesIn  int64  `json:"bytesIn"`
+	BytesOut int64  `json:"bytesOut"`
+	Error    string `json:"error,omitempty"`
+}
+
+// StartConsoleSession records a console access to a running VM.
+func (s *Stores) StartConsoleSession(vmID, remoteAddr, userAgent string) (*ConsoleSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, ok := s.vms[vmID]
+	if !ok {
+		return nil, fmt.Errorf("vm %s: %w", vmID, ErrNotFound)
+	}
+	if vm.State != VMStateRunning {
+		return nil, fmt.Errorf("%w: vm is %s, only running vms have a console", ErrConflict, vm.State)
+	}
+	sess := &ConsoleSession{
+		ID:         uuid.NewString(),
+		VMID:       vm.ID,
+		HostID:     vm.HostID,
+		RemoteAddr: remoteAddr,
+		UserAgent:  userAgent,
+		StartedAt:  time.Now().UTC(),
+	}
+	sessions := append(s.consoleSessions[vmID], sess)
+	if len(sessions) > maxConsoleSessions {
+		sessions = sessions[len(sessions)-maxConsoleSessions:]
+	}
+	s.consoleSessions[vmID] = sessions
+	cp := *sess
+	return &cp, nil
+}
+
+// EndConsoleSession records the end of a console access; errMsg says why it
+// failed, if it did.
+func (s *Stores) EndConsoleSession(vmID, id string, bytesIn, bytesOut int64, errMsg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sess := range s.consoleSessions[vmID] {
+		if sess.ID == id {
+			now := time.Now().UTC()
+			sess.EndedAt, sess.BytesIn, sess.BytesOut, sess.Error = &now, bytesIn, bytesOut, errMsg
+			return nil
+		}
+	}
+	return fmt.Errorf("console session %s: %w", id, ErrNotFound)
+}
+
+// ListConsoleSessions returns a VM's console audit log, newest first.
+func (s *Stores) ListConsoleSessions(vmID string) ([]*ConsoleSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.vms[vmID]; !ok {
+		return nil, fmt.Errorf("vm %s: %w", vmID, ErrNotFound)
+	}
+	sessions := s.consoleSessions[vmID]
+	out := make([]*ConsoleSession, 0, len(sessions))
+	for i := len(sessions) - 1; i >= 0; i-- {
+		cp := *sessions[i]
+		out = append(out, &cp)
+	}
+	return out, nil
+}
@@ -0,0 +1,122 @@
+package stores
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+// maxVMCrashes bounds the crash log kept per VM.
+const maxVMCrashes = 20
+
+// Restart policies of a VM, enforced by the agent of its host.
+const (
+	RestartNever     = vmspec.RestartNever
+	RestartOnFailure = vmspec.RestartOnFailure
+	RestartAlways    = vmspec.RestartAlways
+)
+
+// VMRestartPolicy says whether a VM whose VMM process exits without being
+// asked to is restarted: never, on-failure (only after a crash, not when the
+// guest powered itself off) or always. Restarts in a row stop after
+// MaxRestarts; the delay before each starts at BackoffSeconds and doubles.
+type VMRestartPolicy struct {
+	Policy         string `json:"policy"`
+	MaxRestarts    int    `json:"maxRestarts"`
+	BackoffSeconds int    `json:"backoffSeconds"`
+}
+
+// Defaults of a restart policy that restarts.
+const (
+	defaultMaxRestarts    = 3
+	defaultBackoffSeconds = 5
+)
+
+// withDefaults validates a requested policy and fills in its defaults; nil
+// means never.
+func (p *VMRestartPolicy) withDefaults() (VMRestartPolicy, error) {
+	if p == nil || p.Policy == "" {
+		return VMRestartPolicy{Policy: RestartNever}, nil
+	}
+	out := *p
+	switch out.Policy {
+	case RestartNever:
+		return VMRestartPolicy{Policy: RestartNever}, nil
+	case RestartOnFailure, RestartAlways:
+	default:
+		return VMRestartPolicy{}, fmt.Errorf("%w: restartPolicy.policy must be never, on-failure or always", ErrInvalid)
+	}
+	if out.MaxRestarts < 0 || out.BackoffSeconds < 0 {
+		return VMRestartPolicy{}, fmt.Errorf("%w: restartPolicy.maxRestarts and backoffSeconds must not be negative", ErrInvalid)
+	}
+	if out.MaxRestarts == 0 {
+		out.MaxRestarts = defaultMaxRestarts
+	}
+	if out.BackoffSeconds == 0 {
+		out.BackoffSeconds = defaultBackoffSeconds
+	}
+	return out, nil
+}
+
+func (p VMRestartPolicy) spec() *vmspec.RestartPolicy {
+	if p.Policy == "" || p.Policy == RestartNever {
+		return nil
+	}
+	return &vmspec.RestartPolicy{Policy: p.Policy, MaxRestarts: p.MaxRestarts, BackoffSeconds: p.BackoffSeconds}
+}
+
+// VMCrash records an unexpected exit of a VM's VMM process.
+type VMCrash struct {
+	VMID     string `json:"vmId"`
+	HostID   string `json:"hostId"`
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+	// Restarted is true if the agent restarted the VM per its restart
+	// policy; Restarts counts the restarts in a row.
+	Restarted bool `json:"restarted"`
+	Restarts  int  `json:"restarts"`
+	// ConsoleTail is the last serial console output before the exit.
+	ConsoleTail string    `json:"consoleTail,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// RecordVMCrash adds a crash reported by the agent of hostID to the VM's
+// crash log. Reports from a host the VM is not on are ignored.
+func (s *Stores) RecordVMCrash(vmID, hostID string, c VMCrash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, ok := s.vms[vmID]
+	if !ok {
+		return fmt.Errorf("vm %s: %w", vmID, ErrNotFound)
+	}
+	if vm.HostID != hostID {
+		return nil
+	}
+	c.VMID, c.HostID = vm.ID, hostID
+	if c.At.IsZero() {
+		c.At = time.Now().UTC()
+	}
+	crashes := append(s.vmCrashes[vmID], &c)
+	if len(crashes) > maxVMCrashes {
+		crashes = crashes[len(crashes)-maxVMCrashes:]
+	}
+	s.vmCrashes[vmID] = crashes
+	return nil
+}
+
+// ListVMCrashes returns a VM's crash log, newest first.
+func (s *Stores) ListVMCrashes(vmID string) ([]*VMCrash, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.vms[vmID]; !ok {
+		return nil, fmt.Errorf("vm %s: %w", vmID, ErrNotFound)
+	}
+	crashes := s.vmCrashes[vmID]
+	out := make([]*VMCrash, 0, len(crashes))
+	for i := len(crashes) - 1; i >= 0; i-- {
+		cp := *crashes[i]
+		out = append(out, &cp)
+	}
+	return out, nil
+}
@@ -0,0 +1,171 @@
+package stores
+
+import (
+	"fmt"
+	"time"
+)
+
+// checkDeviceChangeLocked checks that vm's disks and NICs may change: it
+// has to be running or stopped, and without snapshots, whose restore needs
+// the devices the snapshot was taken with.
+func (s *Stores) checkDeviceChangeLocked(vm *VM) error {
+	if vm.State != VMStateRunning && vm.State != VMStateStopped {
+		return fmt.Errorf("%w: vm is %s", ErrConflict, vm.State)
+	}
+	if s.hasSnapshotsLocked(vm.ID) {
+		return fmt.Errorf("%w: vm has snapshots, delete them before changing its devices", ErrConflict)
+	}
+	return nil
+}
+
+// AddVMDisk adds a disk to a running or stopped VM and returns the VM and
+// the new disk's id.
+func (s *Stores) AddVMDisk(vmID string, d VMDisk) (*VM, string, error) {
+	if d.SizeGiB < 1 {
+		return nil, "", fmt.Errorf("%w: sizeGiB must be at least 1", ErrInvalid)
+	}
+	if d.ID != "" {
+		return nil, "", fmt.Errorf("%w: the disk id is assigned by the server", ErrInvalid)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, ok := s.vms[vmID]
+	if !ok {
+		return nil, "", fmt.Errorf("vm %s: %w", vmID, ErrNotFound)
+	}
+	if err := s.checkDeviceChangeLocked(vm); err != nil {
+		return nil, "", err
+	}
+	if err := s.checkDiskImageLocked(vm.ProjectID, len(vm.Disks), d); err != nil {
+		return nil, "", err
+	}
+	if d.ImageID != nil && *d.ImageID != "" && d.Clone == "" {
+		d.Clone = DiskCloneCOW
+	}
+	ids := make([]string, len(vm.Disks)+1)
+	for i, disk := range vm.Disks {
+		ids[i] = disk.ID
+	}
+	if err := assignDeviceIDs(diskIDPrefix, "disks", ids); err != nil {
+		return nil, "", err
+	}
+	d.ID = ids[len(vm.Disks)]
+	vm.Disks = append(vm.Disks, d)
+	vm.UpdatedAt = time.Now().UTC()
+	return copyVM(vm), d.ID, nil
+}
+
+// AddVMNic adds a NIC on a port group of the VM's cluster to a running or
+// stopped VM, taking a reference on the port group, and returns the VM and
+// the new NIC's id.
+func (s *Stores) AddVMNic(vmID string, n VMNic) (*VM, string, error) {
+	if n.PortGroup == "" {
+		return nil, "", fmt.Errorf("%w: portGroup is required", ErrInvalid)
+	}
+	if n.ID != "" {
+		return nil, "", fmt.Errorf("%w: the nic id is assigned by the server", ErrInvalid)
+	}
+	mac, err := nicMAC("nic", n.MacAddress)
+	if err != nil {
+		return nil, "", err
+	}
+	vm, err := s.GetVM(vmID)
+	if err != nil {
+		return nil, "", err
+	}
+	// Resolve the port group before taking the write lock; ResolvePortGroup locks itself.
+	pg, _, err := s.ResolvePortGroup(vm.ClusterID, n.PortGroup)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.vms[vmID]
+	if !ok {
+		return nil, "", fmt.Errorf("vm %s: %w", vmID, ErrNotFound)
+	}
+	if stored.ClusterID != vm.ClusterID {
+		return nil, "", fmt.Errorf("%w: vm moved to another cluster", ErrConflict)
+	}
+	if err := s.checkDeviceChangeLocked(stored); err != nil {
+		return nil, "", err
+	}
+	if _, ok := s.portGroups[pg.ID]; !ok {
+		return nil, "", fmt.Errorf("port group %q: %w", n.PortGroup, ErrNotFound)
+	}
+	ids := make([]string, len(stored.Nets)+1)
+	for i, nic := range stored.Nets {
+		ids[i] = nic.ID
+	}
+	if err := assignDeviceIDs(nicIDPrefix, "nets", ids); err != nil {
+		return nil, "", err
+	}
+	nic := VMNic{ID: ids[len(stored.Nets)], PortGroup: n.PortGroup, PortGroupID: pg.ID, MacAddress: &mac}
+	s.acquireLocked(pg.ID, nicOwner(vmID, nic))
+	stored.Nets = append(stored.Nets, nic)
+	stored.UpdatedAt = time.Now().UTC()
+	return copyVM(stored), nic.ID, nil
+}
+
+// CheckVMDeviceRemoval checks that a disk or NIC of a VM may be removed.
+func (s *Stores) CheckVMDeviceRemoval(vmID, deviceID string) (*VM, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	vm, ok := s.vms[vmID]
+	if !ok {
+		return nil, fmt.Errorf("vm %s: %w", vmID, ErrNotFound)
+	}
+	if !hasDevice(vm, deviceID) {
+		return nil, fmt.Errorf("device %s of vm %s: %w", deviceID, vmID, ErrNotFound)
+	}
+	if err := s.checkDeviceChangeLocked(vm); err != nil {
+		return nil, err
+	}
+	return copyVM(vm), nil
+}
+
+// RemoveVMDevice drops a disk or NIC from a VM, releasing a NIC's port group
+// reference.
+func (s *Stores) RemoveVMDevice(vmID, deviceID string) (*VM, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, ok := s.vms[vmID]
+	if !ok {
+		return nil, fmt.Errorf("vm %s: %w", vmID, ErrNotFound)
+	}
+	if !hasDevice(vm, deviceID) {
+		return nil, fmt.Errorf("device %s of vm %s: %w", deviceID, vmID, ErrNotFound)
+	}
+	disks := vm.Disks[:0:0]
+	for _, d := range vm.Disks {
+		if d.ID != deviceID {
+			disks = append(disks, d)
+		}
+	}
+	nets := vm.Nets[:0:0]
+	for _, n := range vm.Nets {
+		if n.ID == deviceID {
+			s.releaseLocked(n.PortGroupID, nicOwner(vm.ID, n))
+			continue
+		}
+		nets = append(nets, n)
+	}
+	vm.Disks, vm.Nets = disks, nets
+	vm.UpdatedAt = time.Now().UTC()
+	return copyVM(vm), nil
+}
+
+func hasDevice(vm *VM, id string) bool {
+	for _, d := range vm.Disks {
+		if d.ID == id {
+			return true
+		}
+	}
+	for _, n := range vm.Nets {
+		if n.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package stores
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DrainPolicy says what happens to a draining host's running VMs.
+type DrainPolicy string
+
+const (
+	// DrainMigrate live-migrates VMs to hosts picked by the scheduler.
+	DrainMigrate DrainPolicy = "migrate"
+	// DrainStop powers VMs off; they stay assigned to the host.
+	DrainStop DrainPolicy = "stop"
+)
+
+// DrainState is the progress of a host drain.
+type DrainState string
+
+const (
+	DrainInProgress DrainState = "in-progress"
+	// DrainCompleted means no VM is running on the host any more.
+	DrainCompleted DrainState = "completed"
+	// DrainFailed means some VMs could not be evacuated; the host stays cordoned.
+	DrainFailed DrainState = "failed"
+	// DrainCancelled means the host was undrained before the drain finished.
+	DrainCancelled DrainState = "cancelled"
+)
+
+// Status of a single VM within a drain.
+const (
+	DrainVMPending    = "pending"
+	DrainVMInProgress = "in-progress"
+	DrainVMDone       = "done"
+	DrainVMSkipped    = "skipped"
+	DrainVMFailed     = "failed"
+)
+
+// HostDrain is the evacuation workflow of a host entering maintenance.
+type HostDrain struct {
+	HostID string      `json:"hostId"`
+	Policy DrainPolicy `json:"policy"`
+	// MaxParallel caps the VMs evacuated at the same time.
+	MaxParallel int        `json:"maxParallel"`
+	State       DrainState `json:"state"`
+	VMs         []DrainVM  `json:"vms"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"startedAt"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+}
+
+// DrainVM tracks the evacuation of one VM.
+type DrainVM struct {
+	VMID   string `json:"vmId"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	TaskID string `json:"taskId,omitempty"`
+	// Detail explains a skipped or failed VM.
+	Detail string `json:"detail,omitempty"`
+}
+
+// StartHostDrain cordons a host and records a new drain of its VMs,
+// replacing any finished drain. A drain already in progress is a conflict.
+func (s *Stores) StartHostDrain(hostID string, policy DrainPolicy, maxParallel int) (*HostDrain, error) {
+	switch policy {
+	case DrainMigrate, DrainStop:
+	default:
+		return nil, fmt.Errorf("%w: policy must be migrate or stop", ErrInvalid)
+	}
+	if maxParallel < 1 {
+		return nil, fmt.Errorf("%w: maxParallel must be positive", ErrInvalid)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hosts[hostID]
+	if !ok {
+		return nil, fmt.Errorf("host %s: %w", hostID, ErrNotFound)
+	}
+	if d, ok := s.drains[hostID]; ok && d.State == DrainInProgress {
+		return nil, fmt.Errorf("%w: host is already being drained", ErrConflict)
+	}
+	d := &HostDrain{
+		HostID:      hostID,
+		Policy:      policy,
+		MaxParallel: maxParallel,
+		State:       DrainInProgress,
+		VMs:         []DrainVM{},
+		StartedAt:   time.Now().UTC(),
+	}
+	for _, vm := range s.vms {
+		if vm.HostID == hostID {
+			d.VMs = append(d.VMs, DrainVM{VMID: vm.ID, Name: vm.Name, Status: DrainVMPending})
+		}
+	}
+	sort.Slice(d.VMs, func(i, j int) bool { return d.VMs[i].Name < d.VMs[j].Name })
+	s.drains[hostID] = d
+	h.State = HostStateDraining
+	return copyHostDrain(d), nil
+}
+
+// GetHostDrain returns the current or last drain of a host.
+func (s *Stores) GetHostDrain(hostID string) (*HostDrain, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.drains[hostID]
+	if !ok {
+		return nil, fmt.Errorf("drain of host %s: %w", hostID, ErrNotFound)
+	}
+	return copyHostDrain(d), nil
+}
+
+// ActiveHostDrains returns the ids of hosts with a drain in progress.
+func (s *Stores) ActiveHostDrains() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []string
+	for id, d := range s.drains {
+		if d.State == DrainInProgress {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// UpdateHostDrain applies fn to a drain in progress under the store lock. When
+// fn completes the drain, the host is reported as drained.
+func (s *Stores) UpdateHostDrain(hostID string, fn func(d *HostDrain) error) (*HostDrain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.drains[hostID]
+	if !ok {
+		return nil, fmt.Errorf("drain of host %s: %w", hostID, ErrNotFound)
+	}
+	if d.State != DrainInProgress {
+		return nil, fmt.Errorf("%w: drain is %s", ErrConflict, d.State)
+	}
+	next := copyHostDrain(d)
+	if err := fn(next); err != nil {
+		return nil, err
+	}
+	if next.State != DrainInProgress && next.FinishedAt == nil {
+		now := time.Now().UTC()
+		next.FinishedAt = &now
+	}
+	*d = *next
+	if h, ok := s.hosts[hostID]; ok && d.State == DrainCompleted {
+		h.State = HostStateDrained
+	}
+	return copyHostDrain(d), nil
+}
+
+// UndrainHost returns a draining or drained host to service, cancelling a
+// drain in progress. VM operations already sent to agents are not reverted.
+func (s *Stores) UndrainHost(hostID string) (*Host, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hosts[hostID]
+	if !ok {
+		return nil, fmt.Errorf("host %s: %w", hostID, ErrNotFound)
+	}
+	if h.State != HostStateDraining && h.State != HostStateDrained {
+		return nil, fmt.Errorf("%w: host is %s, not in maintenance", ErrConflict, h.State)
+	}
+	if d, ok := s.drains[hostID]; ok && d.State == DrainInProgress {
+		now := time.Now().UTC()
+		d.State = DrainCancelled
+		d.FinishedAt = &now
+	}
+	h.State = HostStateReady
+	return copyHost(h), nil
+}
+
+func copyHostDrain(d *HostDrain) *HostDrain {
+	cp := *d
+	cp.VMs = append([]DrainVM{}, d.VMs...)
+	return &cp
+}
@@ -0,0 +1,350 @@
+package stores
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/VerteraIO/vertera/internal/network"
+)
+
+// Dvs is a distributed virtual switch realised as an OVS bridge on every cluster host.
+type Dvs struct {
+	ID        string    `json:"id"`
+	ClusterID string    `json:"clusterId"`
+	Name      string    `json:"name"`
+	Bridge    string    `json:"bridge"`
+	MTU       int       `json:"mtu"`
+	LacpMode  string    `json:"lacpMode"`
+	Uplinks   int       `json:"uplinks"`
+	Revision  int       `json:"revision"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// DvsCreate holds the fields accepted when creating a DVS.
+type DvsCreate struct {
+	ClusterID string `json:"clusterId"`
+	Name      string `json:"name"`
+	MTU       int    `json:"mtu"`
+	LacpMode  string `json:"lacpMode"`
+	Uplinks   int    `json:"uplinks"`
+}
+
+// DvsUpdate holds the optional fields accepted when updating a DVS.
+type DvsUpdate struct {
+	Name     *string `json:"name"`
+	MTU      *int    `json:"mtu"`
+	LacpMode *string `json:"lacpMode"`
+	Uplinks  *int    `json:"uplinks"`
+}
+
+// PortGroup is a DVS port group. VM NICs reference it by name.
+type PortGroup struct {
+	ID           string           `json:"id"`
+	DvsID        string           `json:"dvsId"`
+	Name         string           `json:"name"`
+	VlanMode     network.VlanMode `json:"vlanMode"`
+	VlanID       *int             `json:"vlanId"`
+	TrunkAllowed []string         `json:"trunkAllowed,omitempty"`
+	Policies     map[string]any   `json:"policies,omitempty"`
+}
+
+// PortGroupCreate holds the fields accepted when creating a port group.
+type PortGroupCreate struct {
+	DvsID        string           `json:"dvsId"`
+	Name         string           `json:"name"`
+	VlanMode     network.VlanMode `json:"vlanMode"`
+	VlanID       *int             `json:"vlanId"`
+	TrunkAllowed []string         `json:"trunkAllowed"`
+	Policies     map[string]any   `json:"policies"`
+}
+
+// PortSpec computes the OVS port settings for a NIC named name attached to this port group.
+func (pg *PortGroup) PortSpec(d *Dvs, name string, typ network.PortType) (network.PortSpec, error) {
+	spec, err := network.NewPortSpec(d.Bridge, name, typ, pg.VlanMode, pg.VlanID, pg.TrunkAllowed)
+	if err != nil {
+		return network.PortSpec{}, err
+	}
+	spec.MTU = d.MTU
+	spec.ExternalIDs = map[string]string{
+		"vertera-dvs":        d.ID,
+		"vertera-port-group": pg.ID,
+	}
+	return spec, nil
+}
+
+func validateDvs(name, lacp string, mtu, uplinks int) error {
+	if name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	switch lacp {
+	case "active", "passive", "off":
+	default:
+		return fmt.Errorf("%w: lacpMode must be active, passive or off", ErrInvalid)
+	}
+	if mtu < 576 || mtu > 9216 {
+		return fmt.Errorf("%w: mtu %d out of range 576-9216", ErrInvalid, mtu)
+	}
+	if uplinks < 1 || uplinks > 8 {
+		return fmt.Errorf("%w: uplinks %d out of range 1-8", ErrInvalid, uplinks)
+	}
+	return nil
+}
+
+// CreateDvs validates and stores a new DVS, applying spec defaults.
+func (s *Stores) CreateDvs(in DvsCreate) (*Dvs, error) {
+	if in.ClusterID == "" {
+		return nil, fmt.Errorf("%w: clusterId is required", ErrInvalid)
+	}
+	if in.MTU == 0 {
+		in.MTU = 1500
+	}
+	if in.LacpMode == "" {
+		in.LacpMode = "passive"
+	}
+	if in.Uplinks == 0 {
+		in.Uplinks = 2
+	}
+	if err := validateDvs(in.Name, in.LacpMode, in.MTU, in.Uplinks); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bridge := network.BridgeName(in.Name)
+	for _, d := range s.dvs {
+		if d.ClusterID == in.ClusterID && d.Name == in.Name {
+			return nil, fmt.Errorf("%w: dvs %q already exists in cluster", ErrConflict, in.Name)
+		}
+		if d.ClusterID == in.ClusterID && d.Bridge == bridge {
+			return nil, fmt.Errorf("%w: bridge name %q already used by dvs %q", ErrConflict, bridge, d.Name)
+		}
+	}
+	now := time.Now().UTC()
+	d := &Dvs{
+		ID:        uuid.NewString(),
+		ClusterID: in.ClusterID,
+		Name:      in.Name,
+		Bridge:    bridge,
+		MTU:       in.MTU,
+		LacpMode:  in.LacpMode,
+		Uplinks:   in.Uplinks,
+		Revision:  1,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.dvs[d.ID] = d
+	cp := *d
+	return &cp, nil
+}
+
+// GetDvs returns a copy of the DVS with the given id.
+func (s *Stores) GetDvs(id string) (*Dvs, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.dvs[id]
+	if !ok {
+		return nil, fmt.Errorf("dvs %s: %w", id, ErrNotFound)
+	}
+	cp := *d
+	return &cp, nil
+}
+
+// ListDvs returns all DVSes, optionally filtered by cluster, ordered by name.
+func (s *Stores) ListDvs(clusterID string) []*Dvs {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]*Dvs, 0, len(s.dvs))
+	for _, d := range s.dvs {
+		if clusterID != "" && d.ClusterID != clusterID {
+			continue
+		}
+		cp := *d
+		out = append(out, &cp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// UpdateDvs applies a partial update and bumps the revision. The bridge name is
+// fixed at creation so renames do not disturb ports already on hosts.
+func (s *Stores) UpdateDvs(id string, in DvsUpdate) (*Dvs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.dvs[id]
+	if !ok {
+		return nil, fmt.Errorf("dvs %s: %w", id, ErrNotFound)
+	}
+	next := *d
+	if in.Name != nil {
+		next.Name = *in.Name
+	}
+	if in.MTU != nil {
+		next.MTU = *in.MTU
+	}
+	if in.LacpMode != nil {
+		next.LacpMode = *in.LacpMode
+	}
+	if in.Uplinks != nil {
+		next.Uplinks = *in.Uplinks
+	}
+	if err := validateDvs(next.Name, next.LacpMode, next.MTU, next.Uplinks); err != nil {
+		return nil, err
+	}
+	if next.Name != d.Name {
+		for _, o := range s.dvs {
+			if o.ID != id && o.ClusterID == d.ClusterID && o.Name == next.Name {
+				return nil, fmt.Errorf("%w: dvs %q already exists in cluster", ErrConflict, next.Name)
+			}
+		}
+	}
+	next.Revision++
+	next.UpdatedAt = time.Now().UTC()
+	*d = next
+	cp := next
+	return &cp, nil
+}
+
+// CreatePortGroup validates VLAN settings and stores a new port group on a DVS.
+func (s *Stores) CreatePortGroup(in PortGroupCreate) (*PortGroup, error) {
+	if in.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	if err := network.ValidatePortGroupVlan(in.VlanMode, in.VlanID, in.TrunkAllowed); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.dvs[in.DvsID]; !ok {
+		return nil, fmt.Errorf("dvs %s: %w", in.DvsID, ErrNotFound)
+	}
+	for _, pg := range s.portGroups {
+		if pg.DvsID == in.DvsID && pg.Name == in.Name {
+			return nil, fmt.Errorf("%w: port group %q already exists on dvs", ErrConflict, in.Name)
+		}
+	}
+	pg := &PortGroup{
+		ID:           uuid.NewString(),
+		DvsID:        in.DvsID,
+		Name:         in.Name,
+		VlanMode:     in.VlanMode,
+		VlanID:       in.VlanID,
+		TrunkAllowed: append([]string(nil), in.TrunkAllowed...),
+		Policies:     in.Policies,
+	}
+	s.portGroups[pg.ID] = pg
+	cp := *pg
+	return &cp, nil
+}
+
+// GetPortGroup returns a copy of the port group with the given id.
+func (s *Stores) GetPortGroup(id string) (*PortGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pg, ok := s.portGroups[id]
+	if !ok {
+		return nil, fmt.Errorf("port group %s: %w", id, ErrNotFound)
+	}
+	cp := *pg
+	return &cp, nil
+}
+
+// ListPortGroups returns the port groups of a DVS ordered by name.
+func (s *Stores) ListPortGroups(dvsID string) []*PortGroup {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []*PortGroup
+	for _, pg := range s.portGroups {
+		if pg.DvsID == dvsID {
+			cp := *pg
+			out = append(out, &cp)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// ResolvePortGroup finds a port group by name among the DVSes of a cluster.
+// With an empty clusterID all DVSes are searched and the name must be unambiguous.
+func (s *Stores) ResolvePortGroup(clusterID, name string) (*PortGroup, *Dvs, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pg, d, err := s.resolvePortGroupLocked(clusterID, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	pgCopy, dvsCopy := *pg, *d
+	return &pgCopy, &dvsCopy, nil
+}
+
+func (s *Stores) resolvePortGroupLocked(clusterID, name string) (*PortGroup, *Dvs, error) {
+	var foundPG *PortGroup
+	var foundDvs *Dvs
+	for _, pg := range s.portGroups {
+		if pg.Name != name {
+			continue
+		}
+		d := s.dvs[pg.DvsID]
+		if d == nil || (clusterID != "" && d.ClusterID != clusterID) {
+			continue
+		}
+		if foundPG != nil {
+			return nil, nil, fmt.Errorf("%w: port group name %q is ambiguous", ErrConflict, name)
+		}
+		foundPG, foundDvs = pg, d
+	}
+	if foundPG == nil {
+		return nil, nil, fmt.Errorf("port group %q: %w", name, ErrNotFound)
+	}
+	return foundPG, foundDvs, nil
+}
+
+// AcquirePortGroup records that owner (e.g. "vm/<id>/nic/0") uses the port group.
+func (s *Stores) AcquirePortGroup(pgID, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.portGroups[pgID]; !ok {
+		return fmt.Errorf("port group %s: %w", pgID, ErrNotFound)
+	}
+	s.acquireLocked(pgID, owner)
+	return nil
+}
+
+func (s *Stores) acquireLocked(pgID, owner string) {
+	if s.pgRefs[pgID] == nil {
+		s.pgRefs[pgID] = make(map[string]struct{})
+	}
+	s.pgRefs[pgID][owner] = struct{}{}
+}
+
+// ReleasePortGroup drops owner's reference to the port group.
+func (s *Stores) ReleasePortGroup(pgID, owner string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked(pgID, owner)
+}
+
+func (s *Stores) releaseLocked(pgID, owner string) {
+	if refs := s.pgRefs[pgID]; refs != nil {
+		delete(refs, owner)
+		if len(refs) == 0 {
+			delete(s.pgRefs, pgID)
+		}
+	}
+}
+
+// DeletePortGroup removes a port group unless something still references it.
+func (s *Stores) DeletePortGroup(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.portGroups[id]; !ok {
+		return fmt.Errorf("port group %s: %w", id, ErrNotFound)
+	}
+	if n := len(s.pgRefs[id]); n > 0 {
+		return fmt.Errorf("%w: port group is in use by %d nic(s)", ErrConflict, n)
+	}
+	delete(s.portGroups, id)
+	return nil
+}
@@ -0,0 +1,271 @@
+package stores
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// FailoverState is the progress of the failover of a host that stopped
+// heartbeating.
+type FailoverState string
+
+const (
+	// FailoverAwaitingFence means the host missed its heartbeats for the
+	// grace period. Its VMs are left alone until fencing confirms it is down.
+	FailoverAwaitingFence FailoverState = "awaiting-fence"
+	// FailoverInProgress means the host was fenced and its HA VMs are being
+	// re-created on other hosts.
+	FailoverInProgress FailoverState = "in-progress"
+	// FailoverCompleted means every HA VM was recovered or given up on.
+	FailoverCompleted FailoverState = "completed"
+	// FailoverCancelled means the host heartbeated again before it was fenced.
+	FailoverCancelled FailoverState = "cancelled"
+)
+
+// Who confirmed that a failed host is down.
+const (
+	FencedByCommand  = "fence-command"
+	FencedByOperator = "operator"
+)
+
+// Status of a single VM within a failover.
+const (
+	FailoverVMPending    = "pending"
+	FailoverVMInProgress = "in-progress"
+	FailoverVMRecovered  = "recovered"
+	FailoverVMSkipped    = "skipped"
+	FailoverVMFailed     = "failed"
+)
+
+// HostFailover is the recovery workflow of a host that stopped heartbeating.
+type HostFailover struct {
+	HostID string        `json:"hostId"`
+	State  FailoverState `json:"state"`
+	// PreviousState is the host state restored when the failover is
+	// cancelled.
+	PreviousState HostState    `json:"previousState"`
+	VMs           []FailoverVM `json:"vms"`
+	// FencedBy is FencedByCommand or FencedByOperator once the host is fenced.
+	FencedBy string `json:"fencedBy,omitempty"`
+	// Error is the last fencing error.
+	Error      string     `json:"error,omitempty"`
+	DetectedAt time.Time  `json:"detectedAt"`
+	FencedAt   *time.Time `json:"fencedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// FailoverVM tracks the recovery of one VM of a failed host.
+type FailoverVM struct {
+	VMID         string `json:"vmId"`
+	Name         string `json:"name"`
+	Status       string `json:"status"`
+	TargetHostID string `json:"targetHostId,omitempty"`
+	TaskID       string `json:"taskId,omitempty"`
+	// Detail explains a skipped, failed or waiting VM.
+	Detail string `json:"detail,omitempty"`
+	// ReleaseTaskID stops the VM on the failed host once the host is back if
+	// the VM moved away; Released is set when it succeeded.
+	ReleaseTaskID string `json:"releaseTaskId,omitempty"`
+	Released      bool   `json:"released"`
+}
+
+// inService reports whether a host in state st is expected to heartbeat.
+func inService(st HostState) bool {
+	return st == HostStateReady || st == HostStateDraining || st == HostStateDrained
+}
+
+// MarkHostUnreachable records a failover of a host in service that has not
+// been seen since cutoff and marks the host unreachable, replacing any
+// finished failover. A host seen since cutoff or never seen is a conflict.
+func (s *Stores) MarkHostUnreachable(hostID string, cutoff time.Time) (*HostFailover, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hosts[hostID]
+	if !ok {
+		return nil, fmt.Errorf("host %s: %w", hostID, ErrNotFound)
+	}
+	if !inService(h.State) {
+		return nil, fmt.Errorf("%w: host is %s", ErrConflict, h.State)
+	}
+	if h.LastSeenAt == nil || !h.LastSeenAt.Before(cutoff) {
+		return nil, fmt.Errorf("%w: host was seen recently", ErrConflict)
+	}
+	f := &HostFailover{
+		HostID:        hostID,
+		State:         FailoverAwaitingFence,
+		PreviousState: h.State,
+		VMs:           []FailoverVM{},
+		DetectedAt:    time.Now().UTC(),
+	}
+	s.failovers[hostID] = f
+	h.State = HostStateUnreachable
+	return copyHostFailover(f), nil
+}
+
+// HostHeartbeat records that a host's agent is alive. An unreachable host
+// returns to its previous state and its failover is cancelled; a failed
+// host stays failed until it rejoins.
+func (s *Stores) HostHeartbeat(hostID string) (*Host, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hosts[hostID]
+	if !ok {
+		return nil, fmt.Errorf("host %s: %w", hostID, ErrNotFound)
+	}
+	now := time.Now().UTC()
+	h.LastSeenAt = &now
+	if h.State == HostStateUnreachable {
+		h.State = HostStateReady
+		if f, ok := s.failovers[hostID]; ok && f.State == FailoverAwaitingFence {
+			h.State = f.PreviousState
+			f.State, f.FinishedAt = FailoverCancelled, &now
+		}
+	}
+	return copyHost(h), nil
+}
+
+// ConfirmHostFenced records that an unreachable host is down, marks it failed
+// and starts its failover. HA VMs that were running are queued for recovery;
+// other running VMs are put in the error state.
+func (s *Stores) ConfirmHostFenced(hostID, by string) (*HostFailover, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hosts[hostID]
+	if !ok {
+		return nil, fmt.Errorf("host %s: %w", hostID, ErrNotFound)
+	}
+	f, ok := s.failovers[hostID]
+	if !ok || f.State != FailoverAwaitingFence || h.State != HostStateUnreachable {
+		return nil, fmt.Errorf("%w: host is %s, only unreachable hosts can be fenced", ErrConflict, h.State)
+	}
+	now := time.Now().UTC()
+	f.State, f.FencedBy, f.FencedAt, f.Error = FailoverInProgress, by, &now, ""
+	f.VMs = []FailoverVM{}
+	for _, vm := range s.vms {
+		if vm.HostID != hostID {
+			continue
+		}
+		e := FailoverVM{VMID: vm.ID, Name: vm.Name, Status: FailoverVMPending}
+		switch {
+		case !vm.HA:
+			e.Status, e.Detail = FailoverVMSkipped, "vm is not highly available"
+		case vm.State != VMStateRunning:
+			e.Status, e.Detail = FailoverVMSkipped, fmt.Sprintf("vm is %s", vm.State)
+		}
+		if vm.State == VMStateRunning && !vm.HA {
+			vm.State, vm.Error, vm.UpdatedAt = VMStateError, fmt.Sprintf("host %s failed", h.Hostname), now
+		}
+		f.VMs = append(f.VMs, e)
+	}
+	sort.Slice(f.VMs, func(i, j int) bool { return f.VMs[i].Name < f.VMs[j].Name })
+	h.State = HostStateFailed
+	return copyHostFailover(f), nil
+}
+
+// GetHostFailover returns the current or last failover of a host.
+func (s *Stores) GetHostFailover(hostID string) (*HostFailover, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, ok := s.failovers[hostID]
+	if !ok {
+		return nil, fmt.Errorf("failover of host %s: %w", hostID, ErrNotFound)
+	}
+	return copyHostFailover(f), nil
+}
+
+// HostFailovers returns the ids of hosts whose failover is in state st.
+func (s *Stores) HostFailovers(st FailoverState) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []string
+	for id, f := range s.failovers {
+		if f.State == st {
+			out = append(out, id)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// UpdateHostFailover applies fn to a host's failover under the store lock.
+func (s *Stores) UpdateHostFailover(hostID string, fn func(f *HostFailover) error) (*HostFailover, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.failovers[hostID]
+	if !ok {
+		return nil, fmt.Errorf("failover of host %s: %w", hostID, ErrNotFound)
+	}
+	next := copyHostFailover(f)
+	if err := fn(next); err != nil {
+		return nil, err
+	}
+	if next.State == FailoverCompleted && next.FinishedAt == nil {
+		now := time.Now().UTC()
+		next.FinishedAt = &now
+	}
+	*f = *next
+	return copyHostFailover(f), nil
+}
+
+// RejoinHost returns a failed host to service. The host must have reconnected
+// since it was fenced and released every VM that moved to another host, so
+// no VM can run twice.
+func (s *Stores) RejoinHost(hostID string) (*Host, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hosts[hostID]
+	if !ok {
+		return nil, fmt.Errorf("host %s: %w", hostID, ErrNotFound)
+	}
+	if h.State != HostStateFailed {
+		return nil, fmt.Errorf("%w: host is %s, not failed", ErrConflict, h.State)
+	}
+	if f, ok := s.failovers[hostID]; ok {
+		if f.State == FailoverInProgress {
+			return nil, fmt.Errorf("%w: failover of the host is still in progress", ErrConflict)
+		}
+		if f.FencedAt != nil && (h.LastSeenAt == nil || h.LastSeenAt.Before(*f.FencedAt)) {
+			return nil, fmt.Errorf("%w: host has not reconnected since it was fenced", ErrConflict)
+		}
+		for _, e := range f.VMs {
+			if e.TaskID != "" && !e.Released {
+				return nil, fmt.Errorf("%w: vm %s moved to another host and is not released on the host yet", ErrConflict, e.VMID)
+			}
+		}
+	}
+	h.State = HostStateReady
+	return copyHost(h), nil
+}
+
+// RecoverVM moves an HA VM of a failed host to targetHostID in the creating
+// state; the VM is re-created there from its disks.
+func (s *Stores) RecoverVM(vmID, failedHostID, targetHostID string) (*VM, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, ok := s.vms[vmID]
+	if !ok {
+		return nil, fmt.Errorf("vm %s: %w", vmID, ErrNotFound)
+	}
+	if vm.HostID != failedHostID {
+		return nil, fmt.Errorf("%w: vm already left host %s", ErrConflict, failedHostID)
+	}
+	if !vm.HA {
+		return nil, fmt.Errorf("%w: vm is not highly available", ErrInvalid)
+	}
+	target, ok := s.hosts[targetHostID]
+	if !ok {
+		return nil, fmt.Errorf("host %s: %w", targetHostID, ErrNotFound)
+	}
+	if target.ClusterID != vm.ClusterID || !s.sharedStorageLocked(vm.ClusterID) {
+		return nil, fmt.Errorf("%w: host %s does not share the vm's storage", ErrInvalid, targetHostID)
+	}
+	vm.HostID, vm.State, vm.Error, vm.UpdatedAt = targetHostID, VMStateCreating, "", time.Now().UTC()
+	return copyVM(vm), nil
+}
+
+func copyHostFailover(f *HostFailover) *HostFailover {
+	cp := *f
+	cp.VMs = append([]FailoverVM{}, f.VMs...)
+	return &cp
+}
@@ -0,0 +1,295 @@
+package stores
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// HostState is the lifecycle state of a hypervisor host. Draining and
+// drained hosts are in maintenance: the scheduler skips them, and a drained
+// host has no running VMs left. An unreachable host missed its heartbeats
+// and awaits fencing; a failed host was fenced and its HA VMs restarted
+// elsewhere.
+type HostState string
+
+const (
+	HostStateEnrolled    HostState = "enrolled"
+	HostStateReady       HostState = "ready"
+	HostStateDraining    HostState = "draining"
+	HostStateDrained     HostState = "drained"
+	HostStateError       HostState = "error"
+	HostStateUnreachable HostState = "unreachable"
+	HostStateFailed      HostState = "failed"
+)
+
+// Host is a hypervisor host running the Vertera agent. Its ID is the id the
+// agent registers and watches tasks with.
+type Host struct {
+	ID         string            `json:"id"`
+	ProjectID  string            `json:"projectId"`
+	ClusterID  string            `json:"clusterId,omitempty"`
+	Hostname   string            `json:"hostname"`
+	State      HostState         `json:"state"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	ElVersion  *string           `json:"elVersion"`
+	ChVersion  *string           `json:"chVersion"`
+	OvsVersion *string           `json:"ovsVersion"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	LastSeenAt *time.Time        `json:"lastSeenAt,omitempty"`
+}
+
+// HostCreate holds the fields accepted when registering a host through the API.
+type HostCreate struct {
+	ProjectID string            `json:"projectId"`
+	ClusterID *string           `json:"clusterId"`
+	Hostname  string            `json:"hostname"`
+	Labels    map[string]string `json:"labels"`
+}
+
+// Inventory is a hardware/software snapshot collected by the agent.
+type Inventory struct {
+	CPU         InventoryCPU      `json:"cpu"`
+	Memory      InventoryMemory   `json:"memory"`
+	Kernel      map[string]any    `json:"kernel,omitempty"`
+	OS          map[string]any    `json:"os,omitempty"`
+	Versions    map[string]string `json:"versions,omitempty"`
+	Disks       []map[string]any  `json:"disks,omitempty"`
+	Nics        []map[string]any  `json:"nics,omitempty"`
+	Services    map[string]string `json:"services,omitempty"`
+	Storage     *InventoryStorage `json:"storage,omitempty"`
+	CollectedAt time.Time         `json:"collectedAt"`
+}
+
+// InventoryStorage describes the filesystem holding VM disks.
+type InventoryStorage struct {
+	Path  string `json:"path"`
+	Total int64  `json:"total"` // bytes
+	Free  int64  `json:"free"`  // bytes
+}
+
+// InventoryCPU describes the host's processors.
+type InventoryCPU struct {
+	Sockets int      `json:"sockets"`
+	Cores   int      `json:"cores"`   // per socket
+	Threads int      `json:"threads"` // per core
+	Model   string   `json:"model"`
+	Flags   []string `json:"flags,omitempty"`
+}
+
+// LogicalCPUs is the number of hardware threads on the host.
+func (c InventoryCPU) LogicalCPUs() int {
+	threads := c.Threads
+	if threads == 0 {
+		threads = 1
+	}
+	return c.Sockets * c.Cores * threads
+}
+
+// InventoryMemory describes host memory. Hugepages is keyed by page size in KiB.
+type InventoryMemory struct {
+	Total     int64                   `json:"total"` // bytes
+	Hugepages map[string]HugepagePool `json:"hugepages,omitempty"`
+}
+
+// HugepagePool counts the pages of one size.
+type HugepagePool struct {
+	Total int64 `json:"total"`
+	Free  int64 `json:"free"`
+}
+
+// maxInventorySnapshots bounds the inventory history kept per host.
+const maxInventorySnapshots = 20
+
+// CreateHost registers a host ahead of its agent connecting.
+func (s *Stores) CreateHost(in HostCreate) (*Host, error) {
+	if in.ProjectID == "" || in.Hostname == "" {
+		return nil, fmt.Errorf("%w: projectId and hostname are required", ErrInvalid)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range s.hosts {
+		if h.Hostname == in.Hostname {
+			return nil, fmt.Errorf("%w: host %q already registered", ErrConflict, in.Hostname)
+		}
+	}
+	h := &Host{
+		ID:        uuid.NewString(),
+		ProjectID: in.ProjectID,
+		Hostname:  in.Hostname,
+		State:     HostStateEnrolled,
+		Labels:    in.Labels,
+		CreatedAt: time.Now().UTC(),
+	}
+	if in.ClusterID != nil {
+		h.ClusterID = *in.ClusterID
+	}
+	s.hosts[h.ID] = h
+	return copyHost(h), nil
+}
+
+// RegisterAgentHost records an agent connecting. A host registered through the
+// API with the same hostname is claimed by the agent; otherwise the host is
+// created. The returned host's ID is the one the agent must use.
+func (s *Stores) RegisterAgentHost(agentID, hostname string) (*Host, error) {
+	if agentID == "" && hostname == "" {
+		return nil, fmt.Errorf("%w: agent id or hostname is required", ErrInvalid)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	h, ok := s.hosts[agentID]
+	if !ok && hostname != "" {
+		for _, cand := range s.hosts {
+			if cand.Hostname == hostname {
+				h, ok = cand, true
+				break
+			}
+		}
+	}
+	if !ok {
+		id := agentID
+		if id == "" {
+			id = hostname
+		}
+		h = &Host{ID: id, Hostname: hostname, CreatedAt: now}
+		s.hosts[id] = h
+	}
+	if h.State == "" || h.State == HostStateEnrolled || h.State == HostStateError {
+		h.State = HostStateReady
+	}
+	h.LastSeenAt = &now
+	return copyHost(h), nil
+}
+
+// GetHost returns a copy of the host with the given id.
+func (s *Stores) GetHost(id string) (*Host, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h, ok := s.hosts[id]
+	if !ok {
+		return nil, fmt.Errorf("host %s: %w", id, ErrNotFound)
+	}
+	return copyHost(h), nil
+}
+
+// ListHosts returns hosts, optionally filtered by project and cluster, ordered by hostname.
+func (s *Stores) ListHosts(projectID, clusterID string) []*Host {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]*Host, 0, len(s.hosts))
+	for _, h := range s.hosts {
+		if projectID != "" && h.ProjectID != projectID {
+			continue
+		}
+		if clusterID != "" && h.ClusterID != clusterID {
+			continue
+		}
+		out = append(out, copyHost(h))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Hostname < out[j].Hostname })
+	return out
+}
+
+// UpdateHost applies fn to the stored host under the store lock.
+func (s *Stores) UpdateHost(id string, fn func(h *Host) error) (*Host, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hosts[id]
+	if !ok {
+		return nil, fmt.Errorf("host %s: %w", id, ErrNotFound)
+	}
+	next := copyHost(h)
+	if err := fn(next); err != nil {
+		return nil, err
+	}
+	*h = *next
+	return copyHost(h), nil
+}
+
+// DeleteHost evicts a host. Hosts that still run VMs cannot be evicted.
+func (s *Stores) DeleteHost(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.hosts[id]; !ok {
+		return fmt.Errorf("host %s: %w", id, ErrNotFound)
+	}
+	n := 0
+	for _, vm := range s.vms {
+		if vm.HostID == id {
+			n++
+		}
+	}
+	if n > 0 {
+		return fmt.Errorf("%w: host still has %d vm(s)", ErrConflict, n)
+	}
+	delete(s.hosts, id)
+	delete(s.inventory, id)
+	delete(s.drains, id)
+	delete(s.failovers, id)
+	return nil
+}
+
+// RecordInventory stores an inventory snapshot for a host and refreshes the
+// host's reported versions.
+func (s *Stores) RecordInventory(hostID string, inv Inventory) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hosts[hostID]
+	if !ok {
+		return fmt.Errorf("host %s: %w", hostID, ErrNotFound)
+	}
+	if inv.CollectedAt.IsZero() {
+		inv.CollectedAt = time.Now().UTC()
+	}
+	snaps := append(s.inventory[hostID], &inv)
+	if len(snaps) > maxInventorySnapshots {
+		snaps = snaps[len(snaps)-maxInventorySnapshots:]
+	}
+	s.inventory[hostID] = snaps
+	for key, dst := range map[string]**string{"el": &h.ElVersion, "cloud-hypervisor": &h.ChVersion, "ovs": &h.OvsVersion} {
+		if v, ok := inv.Versions[key]; ok && v != "" {
+			v := v
+			*dst = &v
+		}
+	}
+	return nil
+}
+
+// LatestInventory returns the most recent inventory snapshot of a host.
+func (s *Stores) LatestInventory(hostID string) (*Inventory, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snaps := s.inventory[hostID]
+	if len(snaps) == 0 {
+		return nil, fmt.Errorf("inventory for host %s: %w", hostID, ErrNotFound)
+	}
+	cp := *snaps[len(snaps)-1]
+	return &cp, nil
+}
+
+// ListInventory returns a host's inventory snapshots, newest first.
+func (s *Stores) ListInventory(hostID string) []*Inventory {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snaps := s.inventory[hostID]
+	out := make([]*Inventory, 0, len(snaps))
+	for i := len(snaps) - 1; i >= 0; i-- {
+		cp := *snaps[i]
+		out = append(out, &cp)
+	}
+	return out
+}
+
+func copyHost(h *Host) *Host {
+	cp := *h
+	if h.Labels != nil {
+		cp.Labels = make(map[string]string, len(h.Labels))
+		for k, v := range h.Labels {
+			cp.Labels[k] = v
+		}
+	}
+	return &cp
+}
@@ -0,0 +1,230 @@
+package stores
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ImageState is the lifecycle state of a disk image.
+type ImageState string
+
+const (
+	// ImagePending images wait for their file to be uploaded.
+	ImagePending   ImageState = "pending"
+	ImageImporting ImageState = "importing"
+	ImageReady     ImageState = "ready"
+	ImageError     ImageState = "error"
+)
+
+// ImageFormat is the on-disk format of an image file.
+type ImageFormat string
+
+const (
+	ImageFormatRaw   ImageFormat = "raw"
+	ImageFormatQcow2 ImageFormat = "qcow2"
+)
+
+// Image is a disk image in the controller's catalog. VM disks are created
+// from ready images; hosts fetch the file when a VM first needs it.
+type Image struct {
+	ID        string `json:"id"`
+	ProjectID string `json:"projectId,omitempty"`
+	Name      string `json:"name"`
+	// OS describes the guest installed in the image.
+	OS    ImageOS    `json:"os"`
+	State ImageState `json:"state"`
+	Error string     `json:"error,omitempty"`
+	// SourceURL is where the image was imported from, if it was not uploaded.
+	SourceURL string `json:"sourceUrl,omitempty"`
+	// Format, SizeBytes, VirtualSizeBytes and SHA256 describe the image file
+	// once it is ready. VirtualSizeBytes is the size of the disk the image
+	// holds, which for qcow2 images is larger than the file.
+	Format           ImageFormat `json:"format,omitempty"`
+	SizeBytes        int64       `json:"sizeBytes"`
+	VirtualSizeBytes int64       `json:"virtualSizeBytes"`
+	SHA256           string      `json:"sha256,omitempty"`
+	CreatedAt        time.Time   `json:"createdAt"`
+	UpdatedAt        time.Time   `json:"updatedAt"`
+}
+
+// ImageOS is the operating system metadata of an image.
+type ImageOS struct {
+	// Family is e.g. "linux" or "windows".
+	Family       string `json:"family,omitempty"`
+	Distribution string `json:"distribution,omitempty"`
+	Version      string `json:"version,omitempty"`
+	Architecture string `json:"architecture,omitempty"`
+}
+
+// ImageCreate holds the fields accepted when creating an image. Without a
+// SourceURL the image waits for its file to be uploaded. SHA256, when set, is
+// the checksum the file must have.
+type ImageCreate struct {
+	ProjectID string  `json:"projectId"`
+	Name      string  `json:"name"`
+	OS        ImageOS `json:"os"`
+	SourceURL string  `json:"sourceUrl"`
+	SHA256    string  `json:"sha256"`
+}
+
+// ImageFile describes an image file that finished importing.
+type ImageFile struct {
+	Format           ImageFormat
+	SizeBytes        int64
+	VirtualSizeBytes int64
+	SHA256           string
+}
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// CreateImage validates and stores an image, pending its upload or, with a
+// SourceURL, importing. Image names are unique per project.
+func (s *Stores) CreateImage(in ImageCreate) (*Image, error) {
+	if in.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	if in.SHA256 != "" && !sha256Hex.MatchString(in.SHA256) {
+		return nil, fmt.Errorf("%w: sha256 must be 64 lowercase hex digits", ErrInvalid)
+	}
+	state := ImagePending
+	if in.SourceURL != "" {
+		u, err := url.Parse(in.SourceURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%w: sourceUrl must be an http(s) URL", ErrInvalid)
+		}
+		state = ImageImporting
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, img := range s.images {
+		if img.ProjectID == in.ProjectID && img.Name == in.Name {
+			return nil, fmt.Errorf("%w: image %q already exists", ErrConflict, in.Name)
+		}
+	}
+	now := time.Now().UTC()
+	img := &Image{
+		ID:        uuid.NewString(),
+		ProjectID: in.ProjectID,
+		Name:      in.Name,
+		OS:        in.OS,
+		State:     state,
+		SourceURL: in.SourceURL,
+		SHA256:    in.SHA256,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.images[img.ID] = img
+	cp := *img
+	return &cp, nil
+}
+
+// GetImage returns a copy of the image with the given id.
+func (s *Stores) GetImage(id string) (*Image, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	img, ok := s.images[id]
+	if !ok {
+		return nil, fmt.Errorf("image %s: %w", id, ErrNotFound)
+	}
+	cp := *img
+	return &cp, nil
+}
+
+// ListImages returns images, optionally filtered by project, ordered by name.
+// Images without a project are shared and listed for every project.
+func (s *Stores) ListImages(projectID string) []*Image {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]*Image, 0, len(s.images))
+	for _, img := range s.images {
+		if projectID != "" && img.ProjectID != "" && img.ProjectID != projectID {
+			continue
+		}
+		cp := *img
+		out = append(out, &cp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// UpdateImage applies fn to the stored image under the store lock. If fn
+// returns an error the image is left untouched.
+func (s *Stores) UpdateImage(id string, fn func(img *Image) error) (*Image, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	img, ok := s.images[id]
+	if !ok {
+		return nil, fmt.Errorf("image %s: %w", id, ErrNotFound)
+	}
+	next := *img
+	if err := fn(&next); err != nil {
+		return nil, err
+	}
+	next.UpdatedAt = time.Now().UTC()
+	*img = next
+	cp := *img
+	return &cp, nil
+}
+
+// FinishImage marks an importing image ready with its file's details.
+func (s *Stores) FinishImage(id string, f ImageFile) (*Image, error) {
+	return s.UpdateImage(id, func(img *Image) error {
+		if img.State != ImageImporting {
+			return fmt.Errorf("%w: image is %s", ErrConflict, img.State)
+		}
+		img.State, img.Error = ImageReady, ""
+		img.Format, img.SizeBytes, img.VirtualSizeBytes, img.SHA256 = f.Format, f.SizeBytes, f.VirtualSizeBytes, f.SHA256
+		return nil
+	})
+}
+
+// DeleteImage removes an image that no VM disk uses.
+func (s *Stores) DeleteImage(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.images[id]; !ok {
+		return fmt.Errorf("image %s: %w", id, ErrNotFound)
+	}
+	for _, vm := range s.vms {
+		for _, d := range vm.Disks {
+			if d.ImageID != nil && *d.ImageID == id {
+				return fmt.Errorf("%w: image is used by vm %s", ErrConflict, vm.Name)
+			}
+		}
+	}
+	delete(s.images, id)
+	return nil
+}
+
+// checkDiskImageLocked checks that a disk of a VM in projectID can be created
+// from its image: the image must be ready, visible to the project and fit
+// into the disk.
+func (s *Stores) checkDiskImageLocked(projectID string, i int, d VMDisk) error {
+	if d.ImageID == nil || *d.ImageID == "" {
+		if d.Clone != "" {
+			return fmt.Errorf("%w: disks[%d].clone needs an imageId", ErrInvalid, i)
+		}
+		return nil
+	}
+	switch d.Clone {
+	case "", DiskCloneCOW, DiskCloneFull:
+	default:
+		return fmt.Errorf("%w: disks[%d].clone must be %q or %q", ErrInvalid, i, DiskCloneCOW, DiskCloneFull)
+	}
+	img, ok := s.images[*d.ImageID]
+	if !ok || (img.ProjectID != "" && img.ProjectID != projectID) {
+		return fmt.Errorf("%w: disks[%d]: image %s not found", ErrInvalid, i, *d.ImageID)
+	}
+	if img.State != ImageReady {
+		return fmt.Errorf("%w: disks[%d]: image %s is %s", ErrInvalid, i, img.Name, img.State)
+	}
+	if int64(d.SizeGiB)<<30 < img.VirtualSizeBytes {
+		return fmt.Errorf("%w: disks[%d].sizeGiB %d is smaller than image %s (%d bytes)", ErrInvalid, i, d.SizeGiB, img.Name, img.VirtualSizeBytes)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package stores
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"time"
+)
+
+// VMMetricsWindow is how long VM metric samples are kept.
+const VMMetricsWindow = time.Hour
+
+// maxVMMetricsSamples bounds the samples kept per VM, e.g. when an agent
+// reports more often than usual.
+const maxVMMetricsSamples = 720
+
+// VMMetricsSample is one reading of a VM's resource use, taken by the agent
+// of the host it runs on.
+type VMMetricsSample struct {
+	VMID string `json:"vmId"`
+	// HostID and ProjectID are filled in by the store.
+	HostID      string    `json:"hostId"`
+	ProjectID   string    `json:"projectId"`
+	CollectedAt time.Time `json:"collectedAt"`
+	// CPUSeconds is the user and system CPU time used by the VM's VMM
+	// process since it started.
+	CPUSeconds float64 `json:"cpuSeconds"`
+	// CPUUsage is the VMM's CPU use since the previous sample, in CPUs; it is
+	// omitted from a VM's first sample and after the VMM restarted.
+	CPUUsage *float64 `json:"cpuUsage,omitempty"`
+	// MemoryRSSBytes is the VMM process's resident memory, which includes
+	// the guest memory it touched.
+	MemoryRSSBytes int64 `json:"memoryRssBytes"`
+	// Counters are Cloud Hypervisor's per-device counters, keyed by device
+	// id and counter name, e.g. Counters["disk0"]["read_bytes"].
+	Counters map[string]map[string]int64 `json:"counters,omitempty"`
+}
+
+// RecordVMMetrics stores samples reported by a host's agent. Samples of VMs
+// that are unknown or placed on another host are dropped, as are samples
+// older than VMMetricsWindow.
+func (s *Stores) RecordVMMetrics(hostID string, samples []VMMetricsSample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.hosts[hostID]; !ok {
+		return fmt.Errorf("host %s: %w", hostID, ErrNotFound)
+	}
+	now := time.Now().UTC()
+	cutoff := now.Add(-VMMetricsWindow)
+	for _, sample := range samples {
+		vm, ok := s.vms[sample.VMID]
+		if !ok || vm.HostID != hostID {
+			continue
+		}
+		sample := sample
+		sample.HostID, sample.ProjectID = hostID, vm.ProjectID
+		if sample.CollectedAt.IsZero() {
+			sample.CollectedAt = now
+		}
+		if !sample.CollectedAt.After(cutoff) {
+			continue
+		}
+		sample.CPUUsage = nil
+		kept := s.vmMetrics[vm.ID]
+		if n := len(kept); n > 0 {
+			prev := kept[n-1]
+			if !sample.CollectedAt.After(prev.CollectedAt) {
+				continue
+			}
+			if prev.HostID == hostID && sample.CPUSeconds >= prev.CPUSeconds {
+				usage := (sample.CPUSeconds - prev.CPUSeconds) / sample.CollectedAt.Sub(prev.CollectedAt).Seconds()
+				sample.CPUUsage = &usage
+			}
+		}
+		kept = append(kept, &sample)
+		i := sort.Search(len(kept), func(i int) bool { return kept[i].CollectedAt.After(cutoff) })
+		i = max(i, len(kept)-maxVMMetricsSamples)
+		s.vmMetrics[vm.ID] = kept[i:]
+	}
+	return nil
+}
+
+// ListVMMetrics returns a VM's samples collected after since, oldest first.
+func (s *Stores) ListVMMetrics(vmID string, since time.Time) ([]*VMMetricsSample, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.vms[vmID]; !ok {
+		return nil, fmt.Errorf("vm %s: %w", vmID, ErrNotFound)
+	}
+	cutoff := time.Now().UTC().Add(-VMMetricsWindow)
+	if since.Before(cutoff) {
+		since = cutoff
+	}
+	out := []*VMMetricsSample{}
+	for _, sample := range s.vmMetrics[vmID] {
+		if sample.CollectedAt.After(since) {
+			out = append(out, copyVMMetricsSample(sample))
+		}
+	}
+	return out, nil
+}
+
+// LatestVMMetrics returns the newest sample of every VM that reported one
+// within VMMetricsWindow from the host it runs on, ordered by VM id.
+func (s *Stores) LatestVMMetrics() []*VMMetricsSample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cutoff := time.Now().UTC().Add(-VMMetricsWindow)
+	out := make([]*VMMetricsSample, 0, len(s.vmMetrics))
+	for vmID, samples := range s.vmMetrics {
+		if len(samples) == 0 {
+			continue
+		}
+		latest := samples[len(samples)-1]
+		if vm, ok := s.vms[vmID]; !ok || vm.HostID != latest.HostID || !latest.CollectedAt.After(cutoff) {
+			continue
+		}
+		out = append(out, copyVMMetricsSample(latest))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].VMID < out[j].VMID })
+	return out
+}
+
+func copyVMMetricsSample(sample *VMMetricsSample) *VMMetricsSample {
+	cp := *sample
+	if sample.CPUUsage != nil {
+		usage := *sample.CPUUsage
+		cp.CPUUsage = &usage
+	}
+	if sample.Counters != nil {
+		cp.Counters = make(map[string]map[string]int64, len(sample.Counters))
+		for dev, counters := range sample.Counters {
+			cp.Counters[dev] = maps.Clone(counters)
+		}
+	}
+	return &cp
+}
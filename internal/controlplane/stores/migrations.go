@@ -0,0 +1,180 @@
+package stores
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+// MigrationState is the progress of a live migration.
+type MigrationState string
+
+const (
+	MigrationInProgress MigrationState = "in-progress"
+	// MigrationCompleted means the VM runs on the target host.
+	MigrationCompleted MigrationState = "completed"
+	// MigrationFailed means the VM kept running on the source host.
+	MigrationFailed MigrationState = "failed"
+)
+
+// MigrationPortBase is the first TCP port a target host receives migrations on.
+const MigrationPortBase = 49152
+
+// VMMigration is a live migration of a VM between two hosts. The target
+// receives the VM on Port while the source sends it.
+type VMMigration struct {
+	SourceHostID  string         `json:"sourceHostId"`
+	TargetHostID  string         `json:"targetHostId"`
+	Port          int            `json:"port"`
+	State         MigrationState `json:"state"`
+	ReceiveTaskID string         `json:"receiveTaskId,omitempty"`
+	SendTaskID    string         `json:"sendTaskId,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	StartedAt     time.Time      `json:"startedAt"`
+	FinishedAt    *time.Time     `json:"finishedAt,omitempty"`
+}
+
+// StartVMMigration marks a running VM as migrating to targetHostID and picks
+// the lowest port not used by another migration into the target. VMs with
+// snapshots cannot migrate.
+func (s *Stores) StartVMMigration(id, targetHostID string) (*VM, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, ok := s.vms[id]
+	if !ok {
+		return nil, fmt.Errorf("vm %s: %w", id, ErrNotFound)
+	}
+	if _, ok := s.hosts[targetHostID]; !ok {
+		return nil, fmt.Errorf("host %s: %w", targetHostID, ErrNotFound)
+	}
+	if vm.State != VMStateRunning {
+		return nil, fmt.Errorf("%w: vm is %s, only running vms can be migrated", ErrConflict, vm.State)
+	}
+	if vm.HostID == targetHostID {
+		return nil, fmt.Errorf("%w: vm already runs on host %s", ErrInvalid, targetHostID)
+	}
+	if vm.Resize != nil && vm.Resize.State == ResizeInProgress {
+		return nil, fmt.Errorf("%w: vm is being resized", ErrConflict)
+	}
+	if s.hasSnapshotsLocked(id) {
+		// Snapshot files stay on the source host.
+		return nil, fmt.Errorf("%w: vm has snapshots, delete them before migrating", ErrConflict)
+	}
+	used := map[int]bool{}
+	for _, other := range s.vms {
+		if m := other.Migration; m != nil && m.State == MigrationInProgress && m.TargetHostID == targetHostID {
+			used[m.Port] = true
+		}
+	}
+	port := MigrationPortBase
+	for used[port] {
+		port++
+	}
+	now := time.Now().UTC()
+	vm.State, vm.Error = VMStateMigrating, ""
+	vm.Migration = &VMMigration{
+		SourceHostID: vm.HostID,
+		TargetHostID: targetHostID,
+		Port:         port,
+		State:        MigrationInProgress,
+		StartedAt:    now,
+	}
+	vm.UpdatedAt = now
+	return copyVM(vm), nil
+}
+
+// VMMigrationSpec builds the spec a migrating VM gets on its target host: its
+// NICs are attached to the same-named port groups of the target's cluster.
+func (s *Stores) VMMigrationSpec(id string) (vmspec.Spec, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	vm, ok := s.vms[id]
+	if !ok {
+		return vmspec.Spec{}, fmt.Errorf("vm %s: %w", id, ErrNotFound)
+	}
+	if vm.Migration == nil || vm.Migration.State != MigrationInProgress {
+		return vmspec.Spec{}, fmt.Errorf("%w: vm is not migrating", ErrConflict)
+	}
+	pgIDs, err := s.targetPortGroupsLocked(vm)
+	if err != nil {
+		return vmspec.Spec{}, err
+	}
+	return s.vmSpecLocked(vm, pgIDs)
+}
+
+// targetPortGroupsLocked resolves the port groups of vm's NICs in the
+// cluster of its migration target.
+func (s *Stores) targetPortGroupsLocked(vm *VM) ([]string, error) {
+	h, ok := s.hosts[vm.Migration.TargetHostID]
+	if !ok {
+		return nil, fmt.Errorf("host %s: %w", vm.Migration.TargetHostID, ErrNotFound)
+	}
+	pgIDs := make([]string, len(vm.Nets))
+	for i, n := range vm.Nets {
+		pg, _, err := s.resolvePortGroupLocked(h.ClusterID, n.PortGroup)
+		if err != nil {
+			return nil, err
+		}
+		pgIDs[i] = pg.ID
+	}
+	return pgIDs, nil
+}
+
+// FinishVMMigration ends a VM's migration. Without errMsg the VM moves to the
+// target host and its NICs to the target cluster's port groups; otherwise
+// the migration is recorded as failed and the VM stays on the source. Either
+// way the VM is running afterwards.
+func (s *Stores) FinishVMMigration(id, errMsg string) (*VM, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, ok := s.vms[id]
+	if !ok {
+		return nil, fmt.Errorf("vm %s: %w", id, ErrNotFound)
+	}
+	if vm.Migration == nil || vm.Migration.State != MigrationInProgress {
+		return nil, fmt.Errorf("%w: vm is not migrating", ErrConflict)
+	}
+	m := *vm.Migration
+	now := time.Now().UTC()
+	m.FinishedAt = &now
+	if errMsg == "" {
+		pgIDs, err := s.targetPortGroupsLocked(vm)
+		if err != nil {
+			return nil, err
+		}
+		for i := range vm.Nets {
+			s.releaseLocked(vm.Nets[i].PortGroupID, nicOwner(vm.ID, vm.Nets[i]))
+			s.acquireLocked(pgIDs[i], nicOwner(vm.ID, vm.Nets[i]))
+			vm.Nets[i].PortGroupID = pgIDs[i]
+		}
+		vm.HostID = m.TargetHostID
+		vm.ClusterID = s.hosts[m.TargetHostID].ClusterID
+		m.State = MigrationCompleted
+	} else {
+		m.State, m.Error = MigrationFailed, errMsg
+	}
+	vm.Migration = &m
+	if vm.State == VMStateMigrating {
+		vm.State, vm.Error = VMStateRunning, ""
+	}
+	vm.UpdatedAt = now
+	return copyVM(vm), nil
+}
+
+// HostVMs returns the VMs holding resources on a host, ordered by name: those
+// placed on it and those being migrated to it.
+func (s *Stores) HostVMs(hostID string) []*VM {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []*VM{}
+	for _, vm := range s.vms {
+		m := vm.Migration
+		if vm.HostID == hostID || (m != nil && m.State == MigrationInProgress && m.TargetHostID == hostID) {
+			out = append(out, copyVM(vm))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
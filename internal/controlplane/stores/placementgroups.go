@@ -0,0 +1,216 @@
+package stores
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PlacementPolicy says whether a group's VMs are kept together or apart.
+type PlacementPolicy string
+
+const (
+	// PolicyAffinity places all members on the same host.
+	PolicyAffinity PlacementPolicy = "affinity"
+	// PolicyAntiAffinity places at most MaxPerHost members on any host.
+	PolicyAntiAffinity PlacementPolicy = "anti-affinity"
+)
+
+// Enforcement says whether the scheduler may break a group's policy.
+type Enforcement string
+
+const (
+	// EnforcementHard rejects placements that would break the policy.
+	EnforcementHard Enforcement = "hard"
+	// EnforcementSoft prefers hosts that keep the policy but never fails a placement.
+	EnforcementSoft Enforcement = "soft"
+)
+
+// PlacementGroup constrains where its member VMs are placed relative to each other.
+type PlacementGroup struct {
+	ID          string          `json:"id"`
+	ProjectID   string          `json:"projectId"`
+	Name        string          `json:"name"`
+	Policy      PlacementPolicy `json:"policy"`
+	Enforcement Enforcement     `json:"enforcement"`
+	// MaxPerHost caps members per host for anti-affinity groups.
+	MaxPerHost int `json:"maxPerHost,omitempty"`
+	// Violations are the policy breaches found by the last reconciliation.
+	Violations []PlacementViolation `json:"violations"`
+	CheckedAt  *time.Time           `json:"checkedAt,omitempty"`
+	CreatedAt  time.Time            `json:"createdAt"`
+}
+
+// PlacementGroupCreate holds the fields accepted when creating a placement group.
+type PlacementGroupCreate struct {
+	ProjectID   string          `json:"projectId"`
+	Name        string          `json:"name"`
+	Policy      PlacementPolicy `json:"policy"`
+	Enforcement Enforcement     `json:"enforcement"`
+	MaxPerHost  int             `json:"maxPerHost"`
+}
+
+// PlacementViolation describes one way a group's current placement breaks its policy.
+type PlacementViolation struct {
+	// Kind is "split" (affinity members on several hosts), "crowded"
+	// (more anti-affinity members on a host than allowed) or
+	// "host-unavailable" (members on a host that is not ready).
+	Kind   string   `json:"kind"`
+	HostID string   `json:"hostId,omitempty"`
+	VMIDs  []string `json:"vmIds"`
+	Detail string   `json:"detail"`
+}
+
+// CreatePlacementGroup validates and stores a placement group. Enforcement
+// defaults to hard and anti-affinity MaxPerHost to 1.
+func (s *Stores) CreatePlacementGroup(in PlacementGroupCreate) (*PlacementGroup, error) {
+	if in.ProjectID == "" || in.Name == "" {
+		return nil, fmt.Errorf("%w: projectId and name are required", ErrInvalid)
+	}
+	switch in.Policy {
+	case PolicyAffinity:
+		if in.MaxPerHost != 0 {
+			return nil, fmt.Errorf("%w: maxPerHost only applies to anti-affinity groups", ErrInvalid)
+		}
+	case PolicyAntiAffinity:
+		if in.MaxPerHost < 0 {
+			return nil, fmt.Errorf("%w: maxPerHost must be positive", ErrInvalid)
+		}
+		if in.MaxPerHost == 0 {
+			in.MaxPerHost = 1
+		}
+	default:
+		return nil, fmt.Errorf("%w: policy must be affinity or anti-affinity", ErrInvalid)
+	}
+	switch in.Enforcement {
+	case "":
+		in.Enforcement = EnforcementHard
+	case EnforcementHard, EnforcementSoft:
+	default:
+		return nil, fmt.Errorf("%w: enforcement must be hard or soft", ErrInvalid)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, g := range s.placementGroups {
+		if g.ProjectID == in.ProjectID && g.Name == in.Name {
+			return nil, fmt.Errorf("%w: placement group %q already exists in project", ErrConflict, in.Name)
+		}
+	}
+	g := &PlacementGroup{
+		ID:          uuid.NewString(),
+		ProjectID:   in.ProjectID,
+		Name:        in.Name,
+		Policy:      in.Policy,
+		Enforcement: in.Enforcement,
+		MaxPerHost:  in.MaxPerHost,
+		Violations:  []PlacementViolation{},
+		CreatedAt:   time.Now().UTC(),
+	}
+	s.placementGroups[g.ID] = g
+	return copyPlacementGroup(g), nil
+}
+
+// GetPlacementGroup returns a copy of the placement group with the given id.
+func (s *Stores) GetPlacementGroup(id string) (*PlacementGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	g, ok := s.placementGroups[id]
+	if !ok {
+		return nil, fmt.Errorf("placement group %s: %w", id, ErrNotFound)
+	}
+	return copyPlacementGroup(g), nil
+}
+
+// JoinablePlacementGroup returns the group a VM of projectID may join.
+func (s *Stores) JoinablePlacementGroup(projectID, id string) (*PlacementGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	g, err := s.joinableGroupLocked(projectID, id)
+	if err != nil {
+		return nil, err
+	}
+	return copyPlacementGroup(g), nil
+}
+
+func (s *Stores) joinableGroupLocked(projectID, id string) (*PlacementGroup, error) {
+	g, ok := s.placementGroups[id]
+	if !ok {
+		return nil, fmt.Errorf("placement group %s: %w", id, ErrNotFound)
+	}
+	if g.ProjectID != projectID {
+		return nil, fmt.Errorf("%w: placement group %s belongs to another project", ErrInvalid, id)
+	}
+	return g, nil
+}
+
+// ListPlacementGroups returns placement groups, optionally filtered by project, ordered by name.
+func (s *Stores) ListPlacementGroups(projectID string) []*PlacementGroup {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]*PlacementGroup, 0, len(s.placementGroups))
+	for _, g := range s.placementGroups {
+		if projectID != "" && g.ProjectID != projectID {
+			continue
+		}
+		out = append(out, copyPlacementGroup(g))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// PlacementGroupMembers returns the VMs that joined the group, ordered by name.
+func (s *Stores) PlacementGroupMembers(id string) []*VM {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []*VM
+	for _, vm := range s.vms {
+		if vm.PlacementGroupID == id {
+			out = append(out, copyVM(vm))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// SetPlacementViolations records the outcome of reconciling a group.
+func (s *Stores) SetPlacementViolations(id string, violations []PlacementViolation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.placementGroups[id]
+	if !ok {
+		return fmt.Errorf("placement group %s: %w", id, ErrNotFound)
+	}
+	now := time.Now().UTC()
+	g.Violations = append([]PlacementViolation{}, violations...)
+	g.CheckedAt = &now
+	return nil
+}
+
+// DeletePlacementGroup removes a placement group that has no members.
+func (s *Stores) DeletePlacementGroup(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.placementGroups[id]; !ok {
+		return fmt.Errorf("placement group %s: %w", id, ErrNotFound)
+	}
+	for _, vm := range s.vms {
+		if vm.PlacementGroupID == id {
+			return fmt.Errorf("%w: placement group still has members", ErrConflict)
+		}
+	}
+	delete(s.placementGroups, id)
+	return nil
+}
+
+func copyPlacementGroup(g *PlacementGroup) *PlacementGroup {
+	cp := *g
+	cp.Violations = make([]PlacementViolation, len(g.Violations))
+	for i, v := range g.Violations {
+		v.VMIDs = append([]string(nil), v.VMIDs...)
+		cp.Violations[i] = v
+	}
+	return &cp
+}
@@ -0,0 +1,154 @@
+package stores
+
+import (
+	"fmt"
+	"time"
+)
+
+// ResizeState is the progress of a VM resize.
+type ResizeState string
+
+const (
+	ResizeInProgress ResizeState = "in-progress"
+	// ResizeApplied means the guest has the new vCPUs and memory.
+	ResizeApplied ResizeState = "applied"
+	// ResizeNextBoot means the VM could not be resized while running; the
+	// new size takes effect when it is next powered on.
+	ResizeNextBoot ResizeState = "next-boot"
+	// ResizeFailed means the VM kept its previous size.
+	ResizeFailed ResizeState = "failed"
+)
+
+// maxVMVcpus is the most vCPUs Cloud Hypervisor gives a VM.
+const maxVMVcpus = 254
+
+// VMResize is a change of a VM's vCPUs or memory.
+type VMResize struct {
+	State ResizeState `json:"state"`
+	// Previous is the VM's size before the resize, which a failed resize
+	// returns to.
+	Previous VMSize `json:"previous"`
+	TaskID   string `json:"taskId,omitempty"`
+	// Reason explains why a resize has to wait for the next boot.
+	Reason     string     `json:"reason,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	// afterNextBoot is set when the resize started while an earlier one was
+	// waiting for the next boot, which it still does if this one fails.
+	afterNextBoot bool
+}
+
+// VMSize is the vCPUs and memory of a VM and how far they can grow live.
+type VMSize struct {
+	Vcpus        int `json:"vcpus"`
+	MemoryMiB    int `json:"memoryMiB"`
+	MaxVcpus     int `json:"maxVcpus"`
+	MaxMemoryMiB int `json:"maxMemoryMiB"`
+}
+
+func checkVMSize(vcpus, memoryMiB, maxVcpus, maxMemoryMiB int) error {
+	if vcpus < 1 || vcpus > maxVMVcpus {
+		return fmt.Errorf("%w: vcpus must be between 1 and %d", ErrInvalid, maxVMVcpus)
+	}
+	if memoryMiB < 128 {
+		return fmt.Errorf("%w: memoryMiB must be at least 128", ErrInvalid)
+	}
+	if maxVcpus < vcpus || maxVcpus > maxVMVcpus {
+		return fmt.Errorf("%w: maxVcpus must be between vcpus and %d", ErrInvalid, maxVMVcpus)
+	}
+	if maxMemoryMiB < memoryMiB {
+		return fmt.Errorf("%w: maxMemoryMiB must be at least memoryMiB", ErrInvalid)
+	}
+	return nil
+}
+
+func (vm *VM) size() VMSize {
+	return VMSize{Vcpus: vm.Vcpus, MemoryMiB: vm.MemoryMiB, MaxVcpus: vm.MaxVcpus, MaxMemoryMiB: vm.MaxMemoryMiB}
+}
+
+// resizePending reports whether the VM runs with another size than stored.
+func (vm *VM) resizePending() bool {
+	return vm.Resize != nil && (vm.Resize.State == ResizeInProgress || vm.Resize.State == ResizeNextBoot)
+}
+
+// StartVMResize gives a running or stopped VM new vCPUs and/or memory (nil
+// keeps the current value) and records the resize as in progress. A size
+// beyond the VM's maximum raises the maximum, so the VM can be resized live
+// that far after its next boot. VMs with snapshots cannot be resized.
+func (s *Stores) StartVMResize(id string, vcpus, memoryMiB *int) (*VM, error) {
+	if vcpus == nil && memoryMiB == nil {
+		return nil, fmt.Errorf("%w: vcpus or memoryMiB is required", ErrInvalid)
+	}
+	return s.UpdateVM(id, func(vm *VM) error {
+		if vm.State != VMStateRunning && vm.State != VMStateStopped {
+			return fmt.Errorf("%w: vm is %s", ErrConflict, vm.State)
+		}
+		if vm.Resize != nil && vm.Resize.State == ResizeInProgress {
+			return fmt.Errorf("%w: vm is being resized", ErrConflict)
+		}
+		if s.hasSnapshotsLocked(vm.ID) {
+			// Snapshots only restore into a VM of the size they were taken at.
+			return fmt.Errorf("%w: vm has snapshots, delete them before resizing", ErrConflict)
+		}
+		prev := vm.size()
+		if vcpus != nil {
+			vm.Vcpus = *vcpus
+		}
+		if memoryMiB != nil {
+			vm.MemoryMiB = *memoryMiB
+		}
+		vm.MaxVcpus, vm.MaxMemoryMiB = max(vm.MaxVcpus, vm.Vcpus), max(vm.MaxMemoryMiB, vm.MemoryMiB)
+		if err := checkVMSize(vm.Vcpus, vm.MemoryMiB, vm.MaxVcpus, vm.MaxMemoryMiB); err != nil {
+			return err
+		}
+		vm.Resize = &VMResize{
+			State: ResizeInProgress, Previous: prev, StartedAt: time.Now().UTC(),
+			afterNextBoot: vm.Resize != nil && vm.Resize.State == ResizeNextBoot,
+		}
+		return nil
+	})
+}
+
+// FinishVMResize records the outcome of a VM's resize: with an errMsg the VM
+// returns to its previous size (and an earlier resize waiting for the next
+// boot keeps waiting), otherwise the new size applies now (live) or
+// on the next boot, for which reason says why.
+func (s *Stores) FinishVMResize(id string, live bool, reason, errMsg string) (*VM, error) {
+	return s.UpdateVM(id, func(vm *VM) error {
+		r := vm.Resize
+		if r == nil || r.State != ResizeInProgress {
+			return fmt.Errorf("%w: vm is not being resized", ErrConflict)
+		}
+		now := time.Now().UTC()
+		r.FinishedAt = &now
+		switch {
+		case errMsg != "":
+			r.State, r.Error = ResizeFailed, errMsg
+			if r.afterNextBoot {
+				r.State = ResizeNextBoot
+			}
+			vm.Vcpus, vm.MemoryMiB = r.Previous.Vcpus, r.Previous.MemoryMiB
+			vm.MaxVcpus, vm.MaxMemoryMiB = r.Previous.MaxVcpus, r.Previous.MaxMemoryMiB
+		case live:
+			r.State = ResizeApplied
+		default:
+			r.State, r.Reason = ResizeNextBoot, reason
+		}
+		return nil
+	})
+}
+
+// VMBooted records that a VM was powered on, which applies a resize waiting
+// for its next boot unless the VM was running already. Call it before the VM
+// is set to running.
+func (s *Stores) VMBooted(id string) (*VM, error) {
+	return s.UpdateVM(id, func(vm *VM) error {
+		if vm.State != VMStateRunning && vm.Resize != nil && vm.Resize.State == ResizeNextBoot {
+			now := time.Now().UTC()
+			vm.Resize.State, vm.Resize.FinishedAt = ResizeApplied, &now
+		}
+		return nil
+	})
+}
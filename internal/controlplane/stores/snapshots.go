@@ -0,0 +1,183 @@
+package stores
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SnapshotState is the lifecycle state of a VM snapshot.
+type SnapshotState string
+
+const (
+	SnapshotCreating SnapshotState = "creating"
+	SnapshotReady    SnapshotState = "ready"
+	SnapshotError    SnapshotState = "error"
+	SnapshotDeleting SnapshotState = "deleting"
+)
+
+// VMSnapshot is a point-in-time copy of a running VM's memory, device state
+// and disks. Its files live on HostID, in the volume directory of the VM it
+// was taken of, and go away with that VM.
+type VMSnapshot struct {
+	ID        string        `json:"id"`
+	VMID      string        `json:"vmId"`
+	ProjectID string        `json:"projectId"`
+	HostID    string        `json:"hostId"`
+	Name      string        `json:"name"`
+	State     SnapshotState `json:"state"`
+	SizeBytes int64         `json:"sizeBytes"`
+	Error     string        `json:"error,omitempty"`
+	// TaskID is the snapshot's latest create or delete task.
+	TaskID string `json:"taskId,omitempty"`
+	// Vcpus, MemoryMiB, their maximums, Nets, Disks and CloudInit are the
+	// VM's shape when the snapshot was taken; a VM restored from it gets the
+	// same shape.
+	Vcpus        int            `json:"vcpus"`
+	MemoryMiB    int            `json:"memoryMiB"`
+	MaxVcpus     int            `json:"maxVcpus"`
+	MaxMemoryMiB int            `json:"maxMemoryMiB"`
+	Nets         []VMNic        `json:"nets"`
+	Disks        []VMDisk       `json:"disks"`
+	Requirements VMRequirements `json:"requirements"`
+	CloudInit    *VMCloudInit   `json:"cloudInit,omitempty"`
+	CreatedAt    time.Time      `json:"createdAt"`
+	UpdatedAt    time.Time      `json:"updatedAt"`
+}
+
+// CreateVMSnapshot records a snapshot of a running VM in the creating state.
+// Snapshot names are unique per VM.
+func (s *Stores) CreateVMSnapshot(vmID, name string) (*VMSnapshot, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, ok := s.vms[vmID]
+	if !ok {
+		return nil, fmt.Errorf("vm %s: %w", vmID, ErrNotFound)
+	}
+	if vm.State != VMStateRunning {
+		return nil, fmt.Errorf("%w: vm is %s, only running vms can be snapshotted", ErrConflict, vm.State)
+	}
+	if vm.resizePending() {
+		// The snapshot would record a size the VM does not run with yet.
+		return nil, fmt.Errorf("%w: vm has a pending resize", ErrConflict)
+	}
+	for _, snap := range s.snapshots {
+		if snap.VMID == vmID && snap.Name == name {
+			return nil, fmt.Errorf("%w: snapshot %q already exists for vm", ErrConflict, name)
+		}
+	}
+	cp := copyVM(vm)
+	now := time.Now().UTC()
+	snap := &VMSnapshot{
+		ID:           uuid.NewString(),
+		VMID:         vm.ID,
+		ProjectID:    vm.ProjectID,
+		HostID:       vm.HostID,
+		Name:         name,
+		State:        SnapshotCreating,
+		Vcpus:        vm.Vcpus,
+		MemoryMiB:    vm.MemoryMiB,
+		MaxVcpus:     vm.MaxVcpus,
+		MaxMemoryMiB: vm.MaxMemoryMiB,
+		Nets:         cp.Nets,
+		Disks:        cp.Disks,
+		Requirements: cp.Requirements,
+		CloudInit:    cp.CloudInit,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	s.snapshots[snap.ID] = snap
+	return copySnapshot(snap), nil
+}
+
+// GetVMSnapshot returns a copy of a VM's snapshot.
+func (s *Stores) GetVMSnapshot(vmID, id string) (*VMSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snap, ok := s.snapshots[id]
+	if !ok || snap.VMID != vmID {
+		return nil, fmt.Errorf("snapshot %s: %w", id, ErrNotFound)
+	}
+	return copySnapshot(snap), nil
+}
+
+// ListVMSnapshots returns a VM's snapshots, oldest first.
+func (s *Stores) ListVMSnapshots(vmID string) ([]*VMSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.vms[vmID]; !ok {
+		return nil, fmt.Errorf("vm %s: %w", vmID, ErrNotFound)
+	}
+	out := []*VMSnapshot{}
+	for _, snap := range s.snapshots {
+		if snap.VMID == vmID {
+			out = append(out, copySnapshot(snap))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+// UpdateVMSnapshot applies fn to the stored snapshot under the store lock. If
+// fn returns an error the snapshot is left untouched.
+func (s *Stores) UpdateVMSnapshot(id string, fn func(snap *VMSnapshot) error) (*VMSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.snapshots[id]
+	if !ok {
+		return nil, fmt.Errorf("snapshot %s: %w", id, ErrNotFound)
+	}
+	next := copySnapshot(snap)
+	if err := fn(next); err != nil {
+		return nil, err
+	}
+	next.UpdatedAt = time.Now().UTC()
+	*snap = *next
+	return copySnapshot(snap), nil
+}
+
+// RemoveVMSnapshot drops a snapshot record.
+func (s *Stores) RemoveVMSnapshot(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.snapshots[id]; !ok {
+		return fmt.Errorf("snapshot %s: %w", id, ErrNotFound)
+	}
+	delete(s.snapshots, id)
+	return nil
+}
+
+// hasSnapshotsLocked reports whether a VM has snapshots.
+func (s *Stores) hasSnapshotsLocked(vmID string) bool {
+	for _, snap := range s.snapshots {
+		if snap.VMID == vmID {
+			return true
+		}
+	}
+	return false
+}
+
+func copySnapshot(snap *VMSnapshot) *VMSnapshot {
+	cp := *snap
+	cp.Nets = append([]VMNic{}, snap.Nets...)
+	for i, n := range cp.Nets {
+		if n.MacAddress != nil {
+			mac := *n.MacAddress
+			cp.Nets[i].MacAddress = &mac
+		}
+	}
+	cp.Disks = append([]VMDisk{}, snap.Disks...)
+	cp.Requirements.CPUFlags = append([]string(nil), snap.Requirements.CPUFlags...)
+	cp.CloudInit = copyCloudInit(snap.CloudInit)
+	return &cp
+}
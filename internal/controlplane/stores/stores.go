@@ -1,13 +1,74 @@
 package stores
 
-import "log"
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+var (
+	// ErrNotFound is returned when a resource does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a change would clash with existing state
+	// (duplicate names, resources still in use, ...).
+	ErrConflict = errors.New("conflict")
+	// ErrInvalid is returned when a resource fails validation.
+	ErrInvalid = errors.New("invalid")
+)
 
 // Stores encapsulates persistence adapters (e.g., Postgres) for
 // resources like Hosts, VMs, Networks, and Tasks.
-// Placeholder: wire real DB connections and migrations.
-type Stores struct{}
+// Resources are currently kept in memory; wire real DB connections and migrations later.
+type Stores struct {
+	mu         sync.RWMutex
+	dvs        map[string]*Dvs
+	portGroups map[string]*PortGroup
+	// pgRefs tracks which owners (e.g. VM NICs) reference a port group: pgID -> owner set.
+	pgRefs map[string]map[string]struct{}
+	vms    map[string]*VM
+	hosts  map[string]*Host
+	// inventory keeps the most recent snapshots per host, oldest first.
+	inventory       map[string][]*Inventory
+	placementGroups map[string]*PlacementGroup
+	clusters        map[string]*Cluster
+	drains          map[string]*HostDrain // hostID -> current or last drain
+	snapshots       map[string]*VMSnapshot
+	images          map[string]*Image
+	// consoleSessions keeps the console audit log per VM, oldest first.
+	consoleSessions map[string][]*ConsoleSession
+	// vmMetrics keeps the metric samples of the last VMMetricsWindow per VM,
+	// oldest first.
+	vmMetrics map[string][]*VMMetricsSample
+	// vmCrashes keeps the crash log per VM, oldest first.
+	vmCrashes map[string][]*VMCrash
+	// failovers keeps the current or last failover per host.
+	failovers map[string]*HostFailover
+	artifacts map[string]*Artifact
+}
+
+func New() *Stores {
+	return &Stores{
+		dvs:             make(map[string]*Dvs),
+		portGroups:      make(map[string]*PortGroup),
+		pgRefs:          make(map[string]map[string]struct{}),
+		vms:             make(map[string]*VM),
+		hosts:           make(map[string]*Host),
+		inventory:       make(map[string][]*Inventory),
+		placementGroups: make(map[string]*PlacementGroup),
+		clusters:        make(map[string]*Cluster),
+		drains:          make(map[string]*HostDrain),
+		snapshots:       make(map[string]*VMSnapshot),
+		images:          make(map[string]*Image),
+		consoleSessions: make(map[string][]*ConsoleSession),
+		vmMetrics:       make(map[string][]*VMMetricsSample),
+		vmCrashes:       make(map[string][]*VMCrash),
+		failovers:       make(map[string]*HostFailover),
+		artifacts:       make(map[string]*Artifact),
+	}
+}
 
-func New() *Stores { return &Stores{} }
+// Default is the process-wide store used by the HTTP and gRPC handlers.
+var Default = New()
 
 func (s *Stores) Start() {
 	// TODO: connect to DB, run migrations
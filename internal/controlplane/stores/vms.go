@@ -0,0 +1,584 @@
+package stores
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/VerteraIO/vertera/internal/network"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+// VMState is the lifecycle state reported for a VM.
+type VMState string
+
+const (
+	VMStateCreating VMState = "creating"
+	VMStateRunning  VMState = "running"
+	VMStateStopped  VMState = "stopped"
+	VMStateError    VMState = "error"
+	VMStateDeleting VMState = "deleting"
+	// VMStateMigrating means the VM keeps running on its host while it is
+	// live-migrated to VM.Migration.TargetHostID.
+	VMStateMigrating VMState = "migrating"
+	// VMStateRestoring means the VM is being restored from a snapshot.
+	VMStateRestoring VMState = "restoring"
+)
+
+// VM is a virtual machine placed on a host.
+type VM struct {
+	ID               string         `json:"id"`
+	ProjectID        string         `json:"projectId"`
+	HostID           string         `json:"hostId"`
+	ClusterID        string         `json:"clusterId,omitempty"`
+	Name             string         `json:"name"`
+	Vcpus            int            `json:"vcpus"`
+	MemoryMiB        int            `json:"memoryMiB"`
+	Nets             []VMNic        `json:"nets"`
+	Disks            []VMDisk       `json:"disks"`
+	Requirements     VMRequirements `json:"requirements"`
+	PlacementGroupID string         `json:"placementGroupId,omitempty"`
+	CloudInit        *VMCloudInit   `json:"cloudInit,omitempty"`
+	State            VMState        `json:"state"`
+	Error            string         `json:"error,omitempty"`
+	// RestartPolicy is enforced by the agent when the VM's VMM exits on its
+	// own; the VM goes to error once it runs out of restarts.
+	RestartPolicy VMRestartPolicy `json:"restartPolicy"`
+	// HA VMs are re-created on another host of their cluster when their
+	// host fails.
+	HA bool `json:"ha"`
+	// Migration is the VM's current or last live migration.
+	Migration *VMMigration `json:"migration,omitempty"`
+	// MaxVcpus and MaxMemoryMiB are how far the VM can be resized while it
+	// runs. Resizes beyond them take effect on the next boot.
+	MaxVcpus     int `json:"maxVcpus"`
+	MaxMemoryMiB int `json:"maxMemoryMiB"`
+	// Resize is the VM's current or last resize.
+	Resize    *VMResize `json:"resize,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// VMNic is a VM network interface attached to a DVS port group.
+type VMNic struct {
+	// ID names the NIC in the VM, e.g. "net0". It stays the same when other
+	// NICs are added or removed.
+	ID          string  `json:"id,omitempty"`
+	PortGroup   string  `json:"portGroup"`
+	PortGroupID string  `json:"portGroupId,omitempty"`
+	MacAddress  *string `json:"macAddress"`
+}
+
+// VMDisk is a VM disk, optionally cloned from an image.
+type VMDisk struct {
+	// ID names the disk in the VM, e.g. "disk0". It stays the same when
+	// other disks are added or removed.
+	ID      string  `json:"id,omitempty"`
+	SizeGiB int     `json:"sizeGiB"`
+	ImageID *string `json:"imageId"`
+	// Clone is how a disk is created from its image: DiskCloneCOW (the
+	// default) or DiskCloneFull.
+	Clone DiskClone `json:"clone,omitempty"`
+}
+
+// DiskClone is how a VM disk is created from an image.
+type DiskClone string
+
+const (
+	// DiskCloneCOW disks are qcow2 overlays on the host's cached image.
+	DiskCloneCOW DiskClone = "cow"
+	// DiskCloneFull disks are independent raw copies of the image.
+	DiskCloneFull DiskClone = "full"
+)
+
+// VMCloudInit is the cloud-init data served to a VM on a NoCloud seed disk.
+// Without MetaData the guest gets the VM's ID as instance-id and its name as
+// hostname.
+type VMCloudInit struct {
+	UserData      string `json:"userData,omitempty"`
+	MetaData      string `json:"metaData,omitempty"`
+	NetworkConfig string `json:"networkConfig,omitempty"`
+}
+
+// maxCloudInitBytes bounds the cloud-init data of a VM.
+const maxCloudInitBytes = 1 << 20
+
+func (c *VMCloudInit) validate() error {
+	if len(c.UserData)+len(c.MetaData)+len(c.NetworkConfig) > maxCloudInitBytes {
+		return fmt.Errorf("%w: cloudInit exceeds %d bytes", ErrInvalid, maxCloudInitBytes)
+	}
+	return nil
+}
+
+// spec is the agent-facing form of the cloud-init data.
+func (c *VMCloudInit) spec() *vmspec.CloudInit {
+	if c == nil {
+		return nil
+	}
+	return &vmspec.CloudInit{UserData: c.UserData, MetaData: c.MetaData, NetworkConfig: c.NetworkConfig}
+}
+
+// VMRequirements constrain where a VM may be placed.
+type VMRequirements struct {
+	// CPUFlags must all be present in the host's /proc/cpuinfo flags.
+	CPUFlags []string `json:"cpuFlags,omitempty"`
+	// Hugepages backs guest memory with hugepages.
+	Hugepages bool `json:"hugepages,omitempty"`
+}
+
+// VMCreate holds the fields accepted when creating a VM. When HostID is nil
+// the scheduler picks a host, optionally limited to ClusterID.
+type VMCreate struct {
+	ProjectID        string          `json:"projectId"`
+	HostID           *string         `json:"hostId"`
+	ClusterID        *string         `json:"clusterId"`
+	Name             string          `json:"name"`
+	Vcpus            int             `json:"vcpus"`
+	MemoryMiB        int             `json:"memoryMiB"`
+	Nets             []VMNic         `json:"nets"`
+	Disks            []VMDisk        `json:"disks"`
+	Requirements     *VMRequirements `json:"requirements"`
+	PlacementGroupID *string         `json:"placementGroupId"`
+	CloudInit        *VMCloudInit    `json:"cloudInit"`
+	// MaxVcpus and MaxMemoryMiB default to twice Vcpus (at most 254) and
+	// twice MemoryMiB.
+	MaxVcpus     *int `json:"maxVcpus"`
+	MaxMemoryMiB *int `json:"maxMemoryMiB"`
+	// RestartPolicy defaults to never.
+	RestartPolicy *VMRestartPolicy `json:"restartPolicy"`
+	// HA needs the VM's cluster to have shared or replicated storage.
+	HA bool `json:"ha"`
+}
+
+// nicOwner is the port group reference owner for a VM NIC.
+func nicOwner(vmID string, n VMNic) string {
+	return fmt.Sprintf("vm/%s/nic/%d", vmID, nicIndex(n))
+}
+
+// Device id prefixes of VM NICs and disks.
+const (
+	nicIDPrefix  = "net"
+	diskIDPrefix = "disk"
+)
+
+// deviceIndex returns the number in a device id such as "net2".
+func deviceIndex(prefix, id string) (int, bool) {
+	num, ok := strings.CutPrefix(id, prefix)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil || n < 0 || strconv.Itoa(n) != num {
+		return 0, false
+	}
+	return n, true
+}
+
+func nicIndex(n VMNic) int {
+	idx, _ := deviceIndex(nicIDPrefix, n.ID)
+	return idx
+}
+
+// assignDeviceIDs checks the given device ids, which must be unique and of
+// the form <prefix><n>, and numbers the devices without one after the
+// highest id in use.
+func assignDeviceIDs(prefix, field string, ids []string) error {
+	next := 0
+	seen := map[string]bool{}
+	for i, id := range ids {
+		if id == "" {
+			continue
+		}
+		n, ok := deviceIndex(prefix, id)
+		if !ok {
+			return fmt.Errorf("%w: %s[%d].id %q must look like %s0", ErrInvalid, field, i, id, prefix)
+		}
+		if seen[id] {
+			return fmt.Errorf("%w: %s[%d].id %q is used twice", ErrInvalid, field, i, id)
+		}
+		seen[id] = true
+		next = max(next, n+1)
+	}
+	for i := range ids {
+		if ids[i] == "" {
+			ids[i] = fmt.Sprintf("%s%d", prefix, next)
+			next++
+		}
+	}
+	return nil
+}
+
+// CreateVM validates a VM, resolves its NICs' port groups (taking a reference
+// on each) and stores it in the creating state.
+func (s *Stores) CreateVM(in VMCreate) (*VM, error) {
+	if in.ProjectID == "" || in.Name == "" {
+		return nil, fmt.Errorf("%w: projectId and name are required", ErrInvalid)
+	}
+	if in.HostID == nil || *in.HostID == "" {
+		return nil, fmt.Errorf("%w: hostId is required", ErrInvalid)
+	}
+	if in.Vcpus < 1 {
+		return nil, fmt.Errorf("%w: vcpus must be at least 1", ErrInvalid)
+	}
+	if in.MemoryMiB < 128 {
+		return nil, fmt.Errorf("%w: memoryMiB must be at least 128", ErrInvalid)
+	}
+	maxVcpus, maxMemoryMiB := min(2*in.Vcpus, maxVMVcpus), 2*in.MemoryMiB
+	if in.MaxVcpus != nil {
+		maxVcpus = *in.MaxVcpus
+	}
+	if in.MaxMemoryMiB != nil {
+		maxMemoryMiB = *in.MaxMemoryMiB
+	}
+	if err := checkVMSize(in.Vcpus, in.MemoryMiB, maxVcpus, maxMemoryMiB); err != nil {
+		return nil, err
+	}
+	for i, d := range in.Disks {
+		if d.SizeGiB < 1 {
+			return nil, fmt.Errorf("%w: disks[%d].sizeGiB must be at least 1", ErrInvalid, i)
+		}
+	}
+	if in.CloudInit != nil {
+		if err := in.CloudInit.validate(); err != nil {
+			return nil, err
+		}
+	}
+	restart, err := in.RestartPolicy.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	nets := make([]VMNic, len(in.Nets))
+	for i, n := range in.Nets {
+		if n.PortGroup == "" {
+			return nil, fmt.Errorf("%w: nets[%d].portGroup is required", ErrInvalid, i)
+		}
+		mac, err := nicMAC(fmt.Sprintf("nets[%d]", i), n.MacAddress)
+		if err != nil {
+			return nil, err
+		}
+		nets[i] = VMNic{ID: n.ID, PortGroup: n.PortGroup, MacAddress: &mac}
+	}
+	nicIDs := make([]string, len(nets))
+	for i, n := range nets {
+		nicIDs[i] = n.ID
+	}
+	if err := assignDeviceIDs(nicIDPrefix, "nets", nicIDs); err != nil {
+		return nil, err
+	}
+	for i := range nets {
+		nets[i].ID = nicIDs[i]
+	}
+
+	host, err := s.GetHost(*in.HostID)
+	if err != nil {
+		return nil, err
+	}
+	if in.ClusterID != nil && *in.ClusterID != "" && *in.ClusterID != host.ClusterID {
+		return nil, fmt.Errorf("%w: host %s is not in cluster %s", ErrInvalid, host.ID, *in.ClusterID)
+	}
+
+	// Resolve port groups before taking the write lock; ResolvePortGroup locks itself.
+	for i := range nets {
+		pg, _, err := s.ResolvePortGroup(host.ClusterID, nets[i].PortGroup)
+		if err != nil {
+			return nil, err
+		}
+		nets[i].PortGroupID = pg.ID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if in.HA && !s.sharedStorageLocked(host.ClusterID) {
+		return nil, fmt.Errorf("%w: ha vms need a cluster with shared or replicated storage", ErrInvalid)
+	}
+	groupID := ""
+	if in.PlacementGroupID != nil && *in.PlacementGroupID != "" {
+		g, err := s.joinableGroupLocked(in.ProjectID, *in.PlacementGroupID)
+		if err != nil {
+			return nil, err
+		}
+		groupID = g.ID
+	}
+	disks := append([]VMDisk{}, in.Disks...)
+	diskIDs := make([]string, len(disks))
+	for i, d := range disks {
+		diskIDs[i] = d.ID
+	}
+	if err := assignDeviceIDs(diskIDPrefix, "disks", diskIDs); err != nil {
+		return nil, err
+	}
+	for i, d := range disks {
+		disks[i].ID = diskIDs[i]
+		if err := s.checkDiskImageLocked(in.ProjectID, i, d); err != nil {
+			return nil, err
+		}
+		if d.ImageID != nil && *d.ImageID != "" && d.Clone == "" {
+			disks[i].Clone = DiskCloneCOW
+		}
+	}
+	for _, vm := range s.vms {
+		if vm.ProjectID == in.ProjectID && vm.Name == in.Name {
+			return nil, fmt.Errorf("%w: vm %q already exists in project", ErrConflict, in.Name)
+		}
+	}
+	now := time.Now().UTC()
+	vm := &VM{
+		ID:               uuid.NewString(),
+		ProjectID:        in.ProjectID,
+		HostID:           host.ID,
+		ClusterID:        host.ClusterID,
+		Name:             in.Name,
+		Vcpus:            in.Vcpus,
+		MemoryMiB:        in.MemoryMiB,
+		MaxVcpus:         maxVcpus,
+		MaxMemoryMiB:     maxMemoryMiB,
+		Nets:             nets,
+		Disks:            disks,
+		State:            VMStateCreating,
+		PlacementGroupID: groupID,
+		CloudInit:        copyCloudInit(in.CloudInit),
+		RestartPolicy:    restart,
+		HA:               in.HA,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if in.Requirements != nil {
+		vm.Requirements = VMRequirements{
+			CPUFlags:  append([]string(nil), in.Requirements.CPUFlags...),
+			Hugepages: in.Requirements.Hugepages,
+		}
+	}
+	for _, n := range nets {
+		if _, ok := s.portGroups[n.PortGroupID]; !ok {
+			return nil, fmt.Errorf("port group %q: %w", n.PortGroup, ErrNotFound)
+		}
+		s.acquireLocked(n.PortGroupID, nicOwner(vm.ID, n))
+	}
+	s.vms[vm.ID] = vm
+	return copyVM(vm), nil
+}
+
+// GetVM returns a copy of the VM with the given id.
+func (s *Stores) GetVM(id string) (*VM, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	vm, ok := s.vms[id]
+	if !ok {
+		return nil, fmt.Errorf("vm %s: %w", id, ErrNotFound)
+	}
+	return copyVM(vm), nil
+}
+
+// ListVMs returns VMs, optionally filtered by project and host, ordered by name.
+func (s *Stores) ListVMs(projectID, hostID string) []*VM {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]*VM, 0, len(s.vms))
+	for _, vm := range s.vms {
+		if projectID != "" && vm.ProjectID != projectID {
+			continue
+		}
+		if hostID != "" && vm.HostID != hostID {
+			continue
+		}
+		out = append(out, copyVM(vm))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// UpdateVM applies fn to the stored VM under the store lock. If fn returns an
+// error the VM is left untouched.
+func (s *Stores) UpdateVM(id string, fn func(vm *VM) error) (*VM, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, ok := s.vms[id]
+	if !ok {
+		return nil, fmt.Errorf("vm %s: %w", id, ErrNotFound)
+	}
+	next := copyVM(vm)
+	if err := fn(next); err != nil {
+		return nil, err
+	}
+	next.UpdatedAt = time.Now().UTC()
+	*vm = *next
+	return copyVM(vm), nil
+}
+
+// SetVMState records a state (and error message for VMStateError) reported for a VM.
+func (s *Stores) SetVMState(id string, state VMState, errMsg string) (*VM, error) {
+	return s.UpdateVM(id, func(vm *VM) error {
+		vm.State = state
+		vm.Error = ""
+		if state == VMStateError {
+			vm.Error = errMsg
+		}
+		return nil
+	})
+}
+
+// DeleteVM removes a VM, its snapshots and console audit log and drops its
+// port group references.
+func (s *Stores) DeleteVM(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, ok := s.vms[id]
+	if !ok {
+		return fmt.Errorf("vm %s: %w", id, ErrNotFound)
+	}
+	for _, n := range vm.Nets {
+		s.releaseLocked(n.PortGroupID, nicOwner(vm.ID, n))
+	}
+	for snapID, snap := range s.snapshots {
+		if snap.VMID == id {
+			delete(s.snapshots, snapID)
+		}
+	}
+	delete(s.consoleSessions, id)
+	delete(s.vmMetrics, id)
+	delete(s.vmCrashes, id)
+	delete(s.vms, id)
+	return nil
+}
+
+// SetVMCloudInit replaces the cloud-init data of a running or stopped VM; nil
+// removes it.
+func (s *Stores) SetVMCloudInit(id string, ci *VMCloudInit) (*VM, error) {
+	if ci != nil {
+		if err := ci.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return s.UpdateVM(id, func(vm *VM) error {
+		if vm.State != VMStateRunning && vm.State != VMStateStopped {
+			return fmt.Errorf("%w: vm is %s", ErrConflict, vm.State)
+		}
+		vm.CloudInit = copyCloudInit(ci)
+		return nil
+	})
+}
+
+// VMSpec builds the agent-facing spec of a VM, computing each NIC's OVS port
+// from its port group. Port groups with policies["portType"] = "vhost-user"
+// get vhost-user ports; everything else uses taps.
+func (s *Stores) VMSpec(id string) (vmspec.Spec, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	vm, ok := s.vms[id]
+	if !ok {
+		return vmspec.Spec{}, fmt.Errorf("vm %s: %w", id, ErrNotFound)
+	}
+	pgIDs := make([]string, len(vm.Nets))
+	for i, n := range vm.Nets {
+		pgIDs[i] = n.PortGroupID
+	}
+	return s.vmSpecLocked(vm, pgIDs)
+}
+
+// vmSpecLocked builds the spec of vm with its NICs on the given port groups.
+func (s *Stores) vmSpecLocked(vm *VM, pgIDs []string) (vmspec.Spec, error) {
+	spec := vmspec.Spec{
+		ID: vm.ID, Name: vm.Name, Vcpus: vm.Vcpus, MemoryMiB: vm.MemoryMiB,
+		MaxVcpus: vm.MaxVcpus, MaxMemoryMiB: vm.MaxMemoryMiB,
+		Hugepages: vm.Requirements.Hugepages, CloudInit: vm.CloudInit.spec(),
+		Restart: vm.RestartPolicy.spec(),
+	}
+	for i, n := range vm.Nets {
+		pg, ok := s.portGroups[pgIDs[i]]
+		if !ok {
+			return vmspec.Spec{}, fmt.Errorf("port group %q: %w", n.PortGroup, ErrNotFound)
+		}
+		d, ok := s.dvs[pg.DvsID]
+		if !ok {
+			return vmspec.Spec{}, fmt.Errorf("dvs %s: %w", pg.DvsID, ErrNotFound)
+		}
+		typ := network.PortTypeTap
+		if t, _ := pg.Policies["portType"].(string); t == string(network.PortTypeVhostUser) {
+			typ = network.PortTypeVhostUser
+		}
+		port, err := pg.PortSpec(d, network.TapName(vm.ID, nicIndex(n)), typ)
+		if err != nil {
+			return vmspec.Spec{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		port.ExternalIDs["vertera-vm"] = vm.ID
+		mac := ""
+		if n.MacAddress != nil {
+			mac = *n.MacAddress
+		}
+		spec.Nics = append(spec.Nics, vmspec.Nic{ID: n.ID, MAC: mac, Port: port})
+	}
+	for _, d := range vm.Disks {
+		disk := vmspec.Disk{ID: d.ID, SizeGiB: d.SizeGiB}
+		if d.ImageID != nil && *d.ImageID != "" {
+			img, ok := s.images[*d.ImageID]
+			if !ok {
+				return vmspec.Spec{}, fmt.Errorf("image %s: %w", *d.ImageID, ErrNotFound)
+			}
+			disk.Image = &vmspec.Image{ID: img.ID, Format: string(img.Format), SHA256: img.SHA256}
+			disk.Clone = vmspec.CloneCOW
+			if d.Clone == DiskCloneFull {
+				disk.Clone = vmspec.CloneFull
+			}
+		}
+		spec.Disks = append(spec.Disks, disk)
+	}
+	return spec, nil
+}
+
+func copyVM(vm *VM) *VM {
+	cp := *vm
+	cp.Nets = append([]VMNic{}, vm.Nets...)
+	for i, n := range cp.Nets {
+		if n.MacAddress != nil {
+			mac := *n.MacAddress
+			cp.Nets[i].MacAddress = &mac
+		}
+	}
+	cp.Disks = append([]VMDisk{}, vm.Disks...)
+	cp.Requirements.CPUFlags = append([]string(nil), vm.Requirements.CPUFlags...)
+	if vm.Migration != nil {
+		m := *vm.Migration
+		cp.Migration = &m
+	}
+	if vm.Resize != nil {
+		r := *vm.Resize
+		cp.Resize = &r
+	}
+	cp.CloudInit = copyCloudInit(vm.CloudInit)
+	return &cp
+}
+
+func copyCloudInit(ci *VMCloudInit) *VMCloudInit {
+	if ci == nil {
+		return nil
+	}
+	cp := *ci
+	return &cp
+}
+
+// nicMAC validates the MAC address requested for a NIC, generating one when
+// none was given.
+func nicMAC(field string, mac *string) (string, error) {
+	if mac == nil || *mac == "" {
+		return randomMAC(), nil
+	}
+	hw, err := net.ParseMAC(*mac)
+	if err != nil || len(hw) != 6 || hw[0]&1 == 1 {
+		return "", fmt.Errorf("%w: %s.macAddress %q is not a unicast MAC", ErrInvalid, field, *mac)
+	}
+	return hw.String(), nil
+}
+
+// randomMAC returns a locally administered unicast MAC address.
+func randomMAC() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	b[0] = (b[0] | 0x02) &^ 0x01
+	return net.HardwareAddr(b).String()
+}
@@ -5,12 +5,37 @@ import (
 	"sync"
 	"time"
 	"github.com/google/uuid"
+
+	"github.com/VerteraIO/vertera/internal/packages"
+	"github.com/VerteraIO/vertera/internal/vmspec"
 )
 
 type Type string
 
 const (
 	TypeInstallPackages Type = "INSTALL_PACKAGES"
+	TypeCreateVM        Type = "CREATE_VM"
+	TypeDeleteVM        Type = "DELETE_VM"
+	TypePowerVM         Type = "POWER_VM"
+	// TypeReceiveVMMigration and TypeSendVMMigration are the target and
+	// source halves of a live migration.
+	TypeReceiveVMMigration Type = "RECEIVE_VM_MIGRATION"
+	TypeSendVMMigration    Type = "SEND_VM_MIGRATION"
+	TypeSnapshotVM         Type = "SNAPSHOT_VM"
+	TypeDeleteVMSnapshot   Type = "DELETE_VM_SNAPSHOT"
+	TypeRestoreVM          Type = "RESTORE_VM"
+	TypeUpdateVMCloudInit  Type = "UPDATE_VM_CLOUD_INIT"
+	// TypeAttachVMDevice and TypeDetachVMDevice hot-plug and unplug a VM's
+	// disks and NICs.
+	TypeAttachVMDevice Type = "ATTACH_VM_DEVICE"
+	TypeDetachVMDevice Type = "DETACH_VM_DEVICE"
+	TypeResizeVM       Type = "RESIZE_VM"
+	// TypeReleaseVM stops a VM on a failed host it was recovered away from.
+	TypeReleaseVM Type = "RELEASE_VM"
+	// TypeUninstallPackages and TypeRollbackPackages remove host packages
+	// and return them to the release installed before the last change.
+	TypeUninstallPackages Type = "UNINSTALL_PACKAGES"
+	TypeRollbackPackages  Type = "ROLLBACK_PACKAGES"
 )
 
 type Status string
@@ -33,6 +58,8 @@ type Task struct {
 	StartedAt *time.Time      `json:"startedAt,omitempty"`
 	FinishedAt *time.Time     `json:"finishedAt,omitempty"`
 	Error     string          `json:"error,omitempty"`
+	// Result is the task's JSON-encoded output, e.g. SnapshotVMResult.
+	Result json.RawMessage `json:"result,omitempty"`
 }
 
 // UpdateLogs sets/overwrites the last log snippet for a task.
@@ -44,9 +71,45 @@ func (m *Manager) UpdateLogs(id string, logs string) {
     }
 }
 
+// SetResult records the output an agent reported for a task. Call it before
+// marking the task succeeded so listeners see the result.
+func (m *Manager) SetResult(id string, result json.RawMessage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.tasks[id]; ok {
+		t.Result = append(json.RawMessage(nil), result...)
+	}
+}
+
 type Manager struct {
-	mu    sync.RWMutex
-	tasks map[string]*Task
+	mu        sync.RWMutex
+	tasks     map[string]*Task
+	listeners []func(Task)
+}
+
+// AddListener registers fn to be called with a copy of a task each time its
+// status changes. Listeners run outside the manager lock, so they may call
+// back into the manager.
+func (m *Manager) AddListener(fn func(Task)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, fn)
+}
+
+// notify snapshots the task and listeners under the lock, then calls the listeners.
+func (m *Manager) notify(id string) {
+	m.mu.RLock()
+	t, ok := m.tasks[id]
+	if !ok {
+		m.mu.RUnlock()
+		return
+	}
+	snap := *t
+	ls := append([]func(Task){}, m.listeners...)
+	m.mu.RUnlock()
+	for _, fn := range ls {
+		fn(snap)
+	}
 }
 
 func NewManager() *Manager {
@@ -55,19 +118,214 @@ func NewManager() *Manager {
 
 var Default = NewManager()
 
+// InstallPackagesParams asks the agent to install host packages. Artifacts
+// holds the files the controller resolved from its artifact registry for
+// each package.
 type InstallPackagesParams struct {
-	Packages  []string `json:"packages"`
-	Version   string   `json:"version,omitempty"`
-	OSVersion string   `json:"os_version,omitempty"`
+	Packages  []string          `json:"packages"`
+	Version   string            `json:"version,omitempty"`
+	OSVersion string            `json:"os_version,omitempty"`
+	Artifacts packages.Resolved `json:"artifacts,omitempty"`
+}
+
+// PackageTypesParams names the host packages an uninstall or rollback task
+// acts on.
+type PackageTypesParams struct {
+	Packages []string `json:"packages"`
+}
+
+// CreateVMParams asks the agent to create and boot a VM from its spec. With
+// Recover the VM is re-created after its host failed: its disks must already
+// exist on shared or replicated storage and are never provisioned afresh.
+type CreateVMParams struct {
+	Spec    vmspec.Spec `json:"spec"`
+	Recover bool        `json:"recover,omitempty"`
+}
+
+// DeleteVMParams asks the agent to destroy a VM, its VMM and its ports.
+type DeleteVMParams struct {
+	VMID string `json:"vmId"`
+}
+
+// Power operations accepted by PowerVMParams.Op.
+const (
+	PowerOn     = "on"
+	PowerOff    = "off"
+	PowerReboot = "reboot"
+)
+
+// PowerVMParams asks the agent to change a VM's power state.
+type PowerVMParams struct {
+	VMID string `json:"vmId"`
+	Op   string `json:"op"`
+}
+
+// ReceiveVMMigrationParams asks the target agent to prepare the VM's ports
+// and a VMM and to wait for the VM on Port.
+type ReceiveVMMigrationParams struct {
+	Spec vmspec.Spec `json:"spec"`
+	Port int         `json:"port"`
+}
+
+// ReceiveVMMigrationResult is what the target agent reports while a receive
+// task is running. Listening is set once the VMM is up and waiting for the
+// VM; the source is only asked to send from then on.
+type ReceiveVMMigrationResult struct {
+	Listening bool `json:"listening"`
+}
+
+// SendVMMigrationParams asks the source agent to send a running VM to
+// DestinationURL (tcp:<host>:<port>) and to clean up once it arrived.
+type SendVMMigrationParams struct {
+	VMID           string `json:"vmId"`
+	DestinationURL string `json:"destinationUrl"`
+}
+
+// SnapshotVMParams asks the agent to snapshot a running VM, including copies
+// of its disks.
+type SnapshotVMParams struct {
+	VMID       string `json:"vmId"`
+	SnapshotID string `json:"snapshotId"`
+}
+
+// SnapshotVMResult is the output of a snapshot task.
+type SnapshotVMResult struct {
+	SizeBytes int64 `json:"sizeBytes"`
+}
+
+// DeleteVMSnapshotParams asks the agent to remove a snapshot's files.
+type DeleteVMSnapshotParams struct {
+	VMID       string `json:"vmId"`
+	SnapshotID string `json:"snapshotId"`
+}
+
+// RestoreVMParams asks the agent to restore the VM described by Spec from a
+// snapshot of SourceVMID (Spec's VM itself or, for a restore into a new VM,
+// the VM the snapshot was taken of).
+type RestoreVMParams struct {
+	Spec       vmspec.Spec `json:"spec"`
+	SourceVMID string      `json:"sourceVmId"`
+	SnapshotID string      `json:"snapshotId"`
+}
+
+// UpdateVMCloudInitParams asks the agent to replace a VM's cloud-init data
+// and regenerate its seed image; a nil CloudInit removes the seed.
+type UpdateVMCloudInitParams struct {
+	VMID      string            `json:"vmId"`
+	CloudInit *vmspec.CloudInit `json:"cloudInit,omitempty"`
+}
+
+// AttachVMDeviceParams asks the agent to add a disk or a NIC, exactly one of
+// which is set, to a VM.
+type AttachVMDeviceParams struct {
+	VMID string       `json:"vmId"`
+	Disk *vmspec.Disk `json:"disk,omitempty"`
+	Nic  *vmspec.Nic  `json:"nic,omitempty"`
+}
+
+// DetachVMDeviceParams asks the agent to remove a disk or NIC from a VM.
+type DetachVMDeviceParams struct {
+	VMID     string `json:"vmId"`
+	DeviceID string `json:"deviceId"`
+}
+
+// ResizeVMParams asks the agent to give a VM a new size, live if it can.
+type ResizeVMParams struct {
+	VMID         string `json:"vmId"`
+	Vcpus        int    `json:"vcpus"`
+	MemoryMiB    int    `json:"memoryMiB"`
+	MaxVcpus     int    `json:"maxVcpus"`
+	MaxMemoryMiB int    `json:"maxMemoryMiB"`
+}
+
+// ReleaseVMParams asks the agent of a failed host that came back to stop a
+// VM that now runs elsewhere, keeping its files.
+type ReleaseVMParams struct {
+	VMID string `json:"vmId"`
+}
+
+// ResizeVMResult is the output of a resize task. Without Live the new size
+// takes effect on the VM's next boot, for the given Reason.
+type ResizeVMResult struct {
+	Live   bool   `json:"live"`
+	Reason string `json:"reason,omitempty"`
 }
 
 func (m *Manager) EnqueueInstallPackages(hostID string, p InstallPackagesParams) (*Task, error) {
-	bytes, _ := json.Marshal(p)
+	return m.Enqueue(hostID, TypeInstallPackages, p)
+}
+
+func (m *Manager) EnqueueUninstallPackages(hostID string, p PackageTypesParams) (*Task, error) {
+	return m.Enqueue(hostID, TypeUninstallPackages, p)
+}
+
+func (m *Manager) EnqueueRollbackPackages(hostID string, p PackageTypesParams) (*Task, error) {
+	return m.Enqueue(hostID, TypeRollbackPackages, p)
+}
+
+func (m *Manager) EnqueueCreateVM(hostID string, p CreateVMParams) (*Task, error) {
+	return m.Enqueue(hostID, TypeCreateVM, p)
+}
+
+func (m *Manager) EnqueueDeleteVM(hostID string, p DeleteVMParams) (*Task, error) {
+	return m.Enqueue(hostID, TypeDeleteVM, p)
+}
+
+func (m *Manager) EnqueuePowerVM(hostID string, p PowerVMParams) (*Task, error) {
+	return m.Enqueue(hostID, TypePowerVM, p)
+}
+
+func (m *Manager) EnqueueReceiveVMMigration(hostID string, p ReceiveVMMigrationParams) (*Task, error) {
+	return m.Enqueue(hostID, TypeReceiveVMMigration, p)
+}
+
+func (m *Manager) EnqueueSendVMMigration(hostID string, p SendVMMigrationParams) (*Task, error) {
+	return m.Enqueue(hostID, TypeSendVMMigration, p)
+}
+
+func (m *Manager) EnqueueSnapshotVM(hostID string, p SnapshotVMParams) (*Task, error) {
+	return m.Enqueue(hostID, TypeSnapshotVM, p)
+}
+
+func (m *Manager) EnqueueDeleteVMSnapshot(hostID string, p DeleteVMSnapshotParams) (*Task, error) {
+	return m.Enqueue(hostID, TypeDeleteVMSnapshot, p)
+}
+
+func (m *Manager) EnqueueRestoreVM(hostID string, p RestoreVMParams) (*Task, error) {
+	return m.Enqueue(hostID, TypeRestoreVM, p)
+}
+
+func (m *Manager) EnqueueUpdateVMCloudInit(hostID string, p UpdateVMCloudInitParams) (*Task, error) {
+	return m.Enqueue(hostID, TypeUpdateVMCloudInit, p)
+}
+
+func (m *Manager) EnqueueAttachVMDevice(hostID string, p AttachVMDeviceParams) (*Task, error) {
+	return m.Enqueue(hostID, TypeAttachVMDevice, p)
+}
+
+func (m *Manager) EnqueueDetachVMDevice(hostID string, p DetachVMDeviceParams) (*Task, error) {
+	return m.Enqueue(hostID, TypeDetachVMDevice, p)
+}
+
+func (m *Manager) EnqueueResizeVM(hostID string, p ResizeVMParams) (*Task, error) {
+	return m.Enqueue(hostID, TypeResizeVM, p)
+}
+
+func (m *Manager) EnqueueReleaseVM(hostID string, p ReleaseVMParams) (*Task, error) {
+	return m.Enqueue(hostID, TypeReleaseVM, p)
+}
+
+// Enqueue records a queued task of the given type with JSON-encoded params.
+func (m *Manager) Enqueue(hostID string, typ Type, params any) (*Task, error) {
+	bytes, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
 	id := uuid.NewString()
 	t := &Task{
 		ID:        id,
 		HostID:    hostID,
-		Type:      TypeInstallPackages,
+		Type:      typ,
 		Params:    bytes,
 		Status:    StatusQueued,
 		CreatedAt: time.Now().UTC(),
@@ -87,6 +345,7 @@ func (m *Manager) Get(id string) (*Task, bool) {
 
 // UpdateStatusRunning sets a task to running and stamps StartedAt if not already set.
 func (m *Manager) UpdateStatusRunning(id string) {
+	defer m.notify(id)
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if t, ok := m.tasks[id]; ok {
@@ -100,6 +359,7 @@ func (m *Manager) UpdateStatusRunning(id string) {
 
 // UpdateStatusSucceeded sets a task to succeeded and stamps FinishedAt.
 func (m *Manager) UpdateStatusSucceeded(id string) {
+	defer m.notify(id)
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if t, ok := m.tasks[id]; ok {
@@ -112,6 +372,7 @@ func (m *Manager) UpdateStatusSucceeded(id string) {
 
 // UpdateStatusFailed sets a task to failed with an error and stamps FinishedAt.
 func (m *Manager) UpdateStatusFailed(id string, errMsg string) {
+	defer m.notify(id)
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if t, ok := m.tasks[id]; ok {
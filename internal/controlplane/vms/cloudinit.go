@@ -0,0 +1,27 @@
+package vms
+
+import (
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+)
+
+// UpdateCloudInit replaces a VM's cloud-init data (nil removes it) and sends
+// a task regenerating the VM's seed image to its host. The guest picks the
+// new data up the next time cloud-init runs, which for most modules means a
+// new instance-id in the meta-data.
+func (s *Service) UpdateCloudInit(vmID string, ci *stores.VMCloudInit) (*tasks.Task, error) {
+	vm, err := s.store.SetVMCloudInit(vmID, ci)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := s.store.VMSpec(vm.ID)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.tasks.EnqueueUpdateVMCloudInit(vm.HostID, tasks.UpdateVMCloudInitParams{VMID: vm.ID, CloudInit: spec.CloudInit})
+	if err != nil {
+		return nil, err
+	}
+	s.dispatch.AddPending(vm.HostID, t)
+	return t, nil
+}
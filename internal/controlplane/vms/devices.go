@@ -0,0 +1,117 @@
+package vms
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/capacity"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+// AttachDisk adds a disk to a VM and sends a task creating and hot-plugging
+// it to the VM's host; a stopped VM gets the disk when it next boots. The disk
+// is part of the VM right away and is dropped again if the task fails. A host
+// that reports its storage must have room for the disk.
+func (s *Service) AttachDisk(vmID string, d stores.VMDisk) (*stores.VM, *tasks.Task, error) {
+	s.placeMu.Lock()
+	vm, err := s.store.GetVM(vmID)
+	if err == nil {
+		var hc *capacity.Host
+		hc, err = capacity.HostFor(s.store, vm.HostID)
+		if err == nil && hc.HasStorage && hc.Free.DiskGiB < int64(d.SizeGiB) {
+			err = fmt.Errorf("%w: host %s has %d GiB of disk free, %d requested", stores.ErrConflict, vm.HostID, hc.Free.DiskGiB, d.SizeGiB)
+		}
+	}
+	var id string
+	if err == nil {
+		vm, id, err = s.store.AddVMDisk(vmID, d)
+	}
+	s.placeMu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.attach(vm, id)
+}
+
+// AttachNic adds a NIC on one of the cluster's port groups to a VM and sends
+// a task plugging its port into the port group's DVS and hot-plugging it to
+// the VM's host. Like with AttachDisk, a failed task drops the NIC again.
+func (s *Service) AttachNic(vmID string, n stores.VMNic) (*stores.VM, *tasks.Task, error) {
+	vm, id, err := s.store.AddVMNic(vmID, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.attach(vm, id)
+}
+
+// attach sends the task attaching the VM's device id, which the store has
+// just added, and drops the device if that is not possible.
+func (s *Service) attach(vm *stores.VM, id string) (*stores.VM, *tasks.Task, error) {
+	p := tasks.AttachVMDeviceParams{VMID: vm.ID}
+	spec, err := s.store.VMSpec(vm.ID)
+	if err == nil {
+		if err = spec.Validate(); err != nil {
+			err = fmt.Errorf("%w: %v", stores.ErrInvalid, err)
+		}
+	}
+	if err == nil {
+		p.Disk, p.Nic = specDevice(spec, id)
+	}
+	var t *tasks.Task
+	if err == nil {
+		t, err = s.tasks.EnqueueAttachVMDevice(vm.HostID, p)
+	}
+	if err != nil {
+		_, _ = s.store.RemoveVMDevice(vm.ID, id)
+		return nil, nil, err
+	}
+	s.dispatch.AddPending(vm.HostID, t)
+	return vm, t, nil
+}
+
+// DetachDevice sends a task unplugging a disk or NIC from a VM and deleting
+// the disk's contents or the NIC's port. The device stays part of the VM
+// until the task succeeds.
+func (s *Service) DetachDevice(vmID, deviceID string) (*tasks.Task, error) {
+	vm, err := s.store.CheckVMDeviceRemoval(vmID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.tasks.EnqueueDetachVMDevice(vm.HostID, tasks.DetachVMDeviceParams{VMID: vm.ID, DeviceID: deviceID})
+	if err != nil {
+		return nil, err
+	}
+	s.dispatch.AddPending(vm.HostID, t)
+	return t, nil
+}
+
+// handleDeviceTask drops a device from the VM once it was detached or when
+// attaching it failed. Either way the VM itself is unaffected.
+func (s *Service) handleDeviceTask(t tasks.Task, vmID, deviceID string) {
+	if (t.Type == tasks.TypeAttachVMDevice) != (t.Status == tasks.StatusFailed) {
+		return
+	}
+	if t.Status == tasks.StatusFailed {
+		log.Printf("vms: attaching %s to %s failed: %s", deviceID, vmID, t.Error)
+	}
+	if _, err := s.store.RemoveVMDevice(vmID, deviceID); err != nil {
+		log.Printf("vms: remove device %s of %s: %v", deviceID, vmID, err)
+	}
+}
+
+// specDevice picks the disk or NIC with the given id from spec.
+func specDevice(spec vmspec.Spec, id string) (*vmspec.Disk, *vmspec.Nic) {
+	for _, d := range spec.Disks {
+		if d.ID == id {
+			return &d, nil
+		}
+	}
+	for _, n := range spec.Nics {
+		if n.ID == id {
+			return nil, &n
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,57 @@
+package vms
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/scheduler"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+)
+
+// Recover re-creates an HA VM of the fenced host failedHostID on another host
+// of its cluster that shares the VM's storage. The VM boots there from its
+// existing disks; the returned create task succeeds once it runs.
+func (s *Service) Recover(id, failedHostID string) (*tasks.Task, error) {
+	vm, err := s.store.GetVM(id)
+	if err != nil {
+		return nil, err
+	}
+	if vm.HostID != failedHostID {
+		return nil, fmt.Errorf("%w: vm already left host %s", stores.ErrConflict, failedHostID)
+	}
+	// Unlike a live migration the guest boots afresh, so the target only
+	// needs the CPU flags the VM asked for.
+	req := s.migrationRequest(vm)
+	req.CPUFlags = append([]string(nil), vm.Requirements.CPUFlags...)
+	req.ClusterID = vm.ClusterID
+	req.SharedStorage = true
+
+	s.placeMu.Lock()
+	d, err := s.scheduler.Place(req)
+	if err == nil {
+		vm, err = s.store.RecoverVM(id, failedHostID, d.HostID)
+	}
+	s.placeMu.Unlock()
+	if errors.Is(err, scheduler.ErrNoHost) {
+		return nil, fmt.Errorf("%w: %v", stores.ErrConflict, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.scheduler.Record(vm.ID, d)
+
+	spec, err := s.store.VMSpec(vm.ID)
+	var t *tasks.Task
+	if err == nil {
+		t, err = s.tasks.EnqueueCreateVM(vm.HostID, tasks.CreateVMParams{Spec: spec, Recover: true})
+	}
+	if err != nil {
+		s.setState(vm.ID, stores.VMStateError, err.Error())
+		return nil, err
+	}
+	log.Printf("vms: recovering %s from failed host %s on %s", vm.ID, failedHostID, vm.HostID)
+	s.dispatch.AddPending(vm.HostID, t)
+	return t, nil
+}
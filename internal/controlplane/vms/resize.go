@@ -0,0 +1,89 @@
+package vms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/scheduler"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+)
+
+// Resize gives a VM new vCPUs and/or memory (nil keeps the current value) and
+// sends a task resizing it on its host. Growing the VM requires the host to
+// pass the placement filters for the added vCPUs and memory. The host resizes
+// a running VM live when it can; otherwise the new size takes effect on the
+// next boot. The VM has the new size right away and returns to its previous
+// one if the task fails.
+func (s *Service) Resize(vmID string, vcpus, memoryMiB *int) (*stores.VM, *tasks.Task, error) {
+	s.placeMu.Lock()
+	vm, err := s.store.GetVM(vmID)
+	if err == nil {
+		req := scheduler.Request{
+			ProjectID:        vm.ProjectID,
+			Hugepages:        vm.Requirements.Hugepages,
+			PlacementGroupID: vm.PlacementGroupID,
+			VMID:             vm.ID,
+		}
+		if vcpus != nil {
+			req.Vcpus = max(*vcpus-vm.Vcpus, 0)
+		}
+		if memoryMiB != nil {
+			req.MemoryMiB = max(*memoryMiB-vm.MemoryMiB, 0)
+		}
+		if req.Vcpus > 0 || req.MemoryMiB > 0 {
+			_, err = s.scheduler.Check(req, vm.HostID)
+		}
+	}
+	if err == nil {
+		vm, err = s.store.StartVMResize(vmID, vcpus, memoryMiB)
+	}
+	s.placeMu.Unlock()
+	if errors.Is(err, scheduler.ErrNoHost) {
+		return nil, nil, fmt.Errorf("%w: %v", stores.ErrConflict, err)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t, err := s.tasks.EnqueueResizeVM(vm.HostID, tasks.ResizeVMParams{
+		VMID: vm.ID, Vcpus: vm.Vcpus, MemoryMiB: vm.MemoryMiB, MaxVcpus: vm.MaxVcpus, MaxMemoryMiB: vm.MaxMemoryMiB,
+	})
+	if err == nil {
+		vm, err = s.store.UpdateVM(vm.ID, func(vm *stores.VM) error {
+			vm.Resize.TaskID = t.ID
+			return nil
+		})
+	}
+	if err != nil {
+		s.finishResize(vmID, false, "", err.Error())
+		return nil, nil, err
+	}
+	log.Printf("vms: resizing %s to %d vcpus, %d MiB", vm.ID, vm.Vcpus, vm.MemoryMiB)
+	s.dispatch.AddPending(vm.HostID, t)
+	return vm, t, nil
+}
+
+// handleResizeTask records whether a resize applied live, waits for the next
+// boot or failed. A failed resize leaves the VM's state alone.
+func (s *Service) handleResizeTask(t tasks.Task, vmID string) {
+	if t.Status == tasks.StatusFailed {
+		s.finishResize(vmID, false, "", t.Error)
+		return
+	}
+	var res tasks.ResizeVMResult
+	if len(t.Result) > 0 {
+		if err := json.Unmarshal(t.Result, &res); err != nil {
+			log.Printf("vms: task %s: bad result: %v", t.ID, err)
+		}
+	}
+	s.finishResize(vmID, res.Live, res.Reason, "")
+}
+
+func (s *Service) finishResize(vmID string, live bool, reason, errMsg string) {
+	if _, err := s.store.FinishVMResize(vmID, live, reason, errMsg); err != nil {
+		log.Printf("vms: finish resize of %s: %v", vmID, err)
+	}
+}
@@ -0,0 +1,188 @@
+package vms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+)
+
+// errStaleTask marks a task result that no longer applies.
+var errStaleTask = errors.New("stale task")
+
+// Snapshot records a snapshot of a running VM and sends the snapshot task to
+// the VM's host. The snapshot is ready once the agent reports its size.
+func (s *Service) Snapshot(vmID, name string) (*stores.VMSnapshot, error) {
+	snap, err := s.store.CreateVMSnapshot(vmID, name)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.tasks.EnqueueSnapshotVM(snap.HostID, tasks.SnapshotVMParams{VMID: vmID, SnapshotID: snap.ID})
+	var updated *stores.VMSnapshot
+	if err == nil {
+		updated, err = s.store.UpdateVMSnapshot(snap.ID, func(snap *stores.VMSnapshot) error {
+			snap.TaskID = t.ID
+			return nil
+		})
+	}
+	if err != nil {
+		_ = s.store.RemoveVMSnapshot(snap.ID)
+		return nil, err
+	}
+	s.dispatch.AddPending(snap.HostID, t)
+	return updated, nil
+}
+
+// DeleteSnapshot marks a snapshot as deleting and sends a task removing its
+// files to its host. The snapshot is dropped once the agent reports success.
+func (s *Service) DeleteSnapshot(vmID, snapshotID string) (*tasks.Task, error) {
+	snap, err := s.store.GetVMSnapshot(vmID, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.store.UpdateVMSnapshot(snap.ID, func(snap *stores.VMSnapshot) error {
+		if snap.State == stores.SnapshotCreating {
+			return fmt.Errorf("%w: snapshot is still being taken", stores.ErrConflict)
+		}
+		snap.State, snap.Error = stores.SnapshotDeleting, ""
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.tasks.EnqueueDeleteVMSnapshot(snap.HostID, tasks.DeleteVMSnapshotParams{VMID: vmID, SnapshotID: snap.ID})
+	if err == nil {
+		_, err = s.store.UpdateVMSnapshot(snap.ID, func(snap *stores.VMSnapshot) error {
+			snap.TaskID = t.ID
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.dispatch.AddPending(snap.HostID, t)
+	return t, nil
+}
+
+// Restore brings a VM back to one of its snapshots. With a name the snapshot
+// is restored into a new VM of that name instead, which gets the snapshot's
+// shape and device ids, port groups and cloud-init data, fresh MAC addresses
+// and is placed on the host that holds the snapshot. The new VM is returned along with the
+// restore task; for an in-place restore the VM is nil.
+func (s *Service) Restore(vmID, snapshotID, name string) (*stores.VM, *tasks.Task, error) {
+	snap, err := s.store.GetVMSnapshot(vmID, snapshotID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if snap.State != stores.SnapshotReady {
+		return nil, nil, fmt.Errorf("%w: snapshot is %s", stores.ErrConflict, snap.State)
+	}
+	var created *stores.VM
+	targetID := vmID
+	if name == "" {
+		_, err = s.store.UpdateVM(vmID, func(vm *stores.VM) error {
+			switch vm.State {
+			case stores.VMStateRunning, stores.VMStateStopped, stores.VMStateError:
+			default:
+				return fmt.Errorf("%w: vm is %s", stores.ErrConflict, vm.State)
+			}
+			vm.State, vm.Error = stores.VMStateRestoring, ""
+			return nil
+		})
+	} else {
+		created, err = s.placeRestore(snap, name)
+		if created != nil {
+			targetID = created.ID
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spec, err := s.store.VMSpec(targetID)
+	if err == nil {
+		if err = spec.Validate(); err != nil {
+			err = fmt.Errorf("%w: %v", stores.ErrInvalid, err)
+		}
+	}
+	var t *tasks.Task
+	if err == nil {
+		t, err = s.tasks.EnqueueRestoreVM(snap.HostID, tasks.RestoreVMParams{Spec: spec, SourceVMID: vmID, SnapshotID: snap.ID})
+	}
+	if err != nil {
+		if created != nil {
+			s.remove(created.ID)
+		} else {
+			s.setState(vmID, stores.VMStateError, err.Error())
+		}
+		return nil, nil, err
+	}
+	s.dispatch.AddPending(snap.HostID, t)
+	return created, t, nil
+}
+
+// placeRestore stores the new VM a snapshot is restored into, in the
+// restoring state. It has to run where the snapshot's files are.
+func (s *Service) placeRestore(snap *stores.VMSnapshot, name string) (*stores.VM, error) {
+	in := stores.VMCreate{
+		ProjectID:    snap.ProjectID,
+		HostID:       &snap.HostID,
+		Name:         name,
+		Vcpus:        snap.Vcpus,
+		MemoryMiB:    snap.MemoryMiB,
+		Disks:        snap.Disks,
+		Requirements: &snap.Requirements,
+		CloudInit:    snap.CloudInit,
+	}
+	if snap.MaxVcpus > 0 {
+		// The restored VMM keeps the snapshot's resize headroom.
+		in.MaxVcpus, in.MaxMemoryMiB = &snap.MaxVcpus, &snap.MaxMemoryMiB
+	}
+	for _, n := range snap.Nets {
+		in.Nets = append(in.Nets, stores.VMNic{ID: n.ID, PortGroup: n.PortGroup})
+	}
+	vm, err := s.place(in)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.SetVMState(vm.ID, stores.VMStateRestoring, "")
+}
+
+// handleSnapshotTask applies the outcome of a snapshot or snapshot delete
+// task to the snapshot. Results of superseded tasks are ignored.
+func (s *Service) handleSnapshotTask(t tasks.Task, snapshotID string) {
+	_, err := s.store.UpdateVMSnapshot(snapshotID, func(snap *stores.VMSnapshot) error {
+		if snap.TaskID != t.ID {
+			return errStaleTask
+		}
+		if t.Status == tasks.StatusFailed {
+			snap.State, snap.Error = stores.SnapshotError, t.Error
+			return nil
+		}
+		if t.Type == tasks.TypeSnapshotVM {
+			var res tasks.SnapshotVMResult
+			if len(t.Result) > 0 {
+				if err := json.Unmarshal(t.Result, &res); err != nil {
+					return fmt.Errorf("bad result: %w", err)
+				}
+			}
+			snap.State, snap.SizeBytes = stores.SnapshotReady, res.SizeBytes
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errStaleTask):
+		return
+	case err != nil:
+		log.Printf("vms: snapshot %s: %v", snapshotID, err)
+		return
+	}
+	if t.Type == tasks.TypeDeleteVMSnapshot && t.Status == tasks.StatusSucceeded {
+		if err := s.store.RemoveVMSnapshot(snapshotID); err != nil {
+			log.Printf("vms: remove snapshot %s: %v", snapshotID, err)
+		}
+	}
+}
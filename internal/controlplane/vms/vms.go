@@ -0,0 +1,445 @@
+// Package vms drives VM lifecycle operations: it records the desired VM in the
+// store, turns API actions into agent tasks for the VM's host and folds task
+// results and agent state reports back into the VM's state.
+package vms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/dispatch"
+	"github.com/VerteraIO/vertera/internal/controlplane/scheduler"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+)
+
+// Service coordinates VM operations between the API, the store and the agents.
+type Service struct {
+	store     *stores.Stores
+	tasks     *tasks.Manager
+	dispatch  *dispatch.Manager
+	scheduler *scheduler.Scheduler
+
+	// placeMu serialises placement and VM creation so concurrent creates
+	// see each other's allocations.
+	placeMu sync.Mutex
+	// sendMu makes sure each migration sends its VM once.
+	sendMu sync.Mutex
+}
+
+// NewService wires a Service and subscribes it to task status changes.
+func NewService(st *stores.Stores, tm *tasks.Manager, dm *dispatch.Manager, sch *scheduler.Scheduler) *Service {
+	s := &Service{store: st, tasks: tm, dispatch: dm, scheduler: sch}
+	tm.AddListener(s.HandleTask)
+	return s
+}
+
+// Default is the process-wide service backed by the default store, tasks,
+// dispatcher and scheduler.
+var Default = NewService(stores.Default, tasks.Default, dispatch.Default, scheduler.Default)
+
+// Create stores the VM and sends a create task to its host. Without a hostId
+// the scheduler picks the host.
+func (s *Service) Create(in stores.VMCreate) (*stores.VM, *tasks.Task, error) {
+	vm, err := s.place(in)
+	if err != nil {
+		return nil, nil, err
+	}
+	spec, err := s.store.VMSpec(vm.ID)
+	if err == nil {
+		err = spec.Validate()
+		if err != nil {
+			err = fmt.Errorf("%w: %v", stores.ErrInvalid, err)
+		}
+	}
+	if err != nil {
+		s.remove(vm.ID)
+		return nil, nil, err
+	}
+	t, err := s.tasks.EnqueueCreateVM(vm.HostID, tasks.CreateVMParams{Spec: spec})
+	if err != nil {
+		s.remove(vm.ID)
+		return nil, nil, err
+	}
+	s.dispatch.AddPending(vm.HostID, t)
+	return vm, t, nil
+}
+
+// place stores the VM on the requested host or, without one, on the host
+// chosen by the scheduler, whose decision is then recorded for the VM. A
+// requested host must still satisfy the VM's hard placement group policy.
+func (s *Service) place(in stores.VMCreate) (*stores.VM, error) {
+	if in.PlacementGroupID != nil && *in.PlacementGroupID != "" {
+		if _, err := s.store.JoinablePlacementGroup(in.ProjectID, *in.PlacementGroupID); err != nil {
+			return nil, err
+		}
+	}
+	s.placeMu.Lock()
+	defer s.placeMu.Unlock()
+	if in.HostID != nil && *in.HostID != "" {
+		if err := s.scheduler.CheckHost(scheduler.RequestFor(in), *in.HostID); err != nil {
+			return nil, err
+		}
+		return s.store.CreateVM(in)
+	}
+	d, err := s.scheduler.Place(scheduler.RequestFor(in))
+	if errors.Is(err, scheduler.ErrNoHost) {
+		return nil, fmt.Errorf("%w: %v", stores.ErrConflict, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	in.HostID = &d.HostID
+	vm, err := s.store.CreateVM(in)
+	if err != nil {
+		return nil, err
+	}
+	s.scheduler.Record(vm.ID, d)
+	return vm, nil
+}
+
+// remove drops a VM and its placement decision.
+func (s *Service) remove(vmID string) {
+	_ = s.store.DeleteVM(vmID)
+	s.scheduler.Forget(vmID)
+}
+
+// Delete marks the VM as deleting and sends a delete task to its host. The
+// VM is removed from the store once the agent reports success.
+func (s *Service) Delete(id string) (*tasks.Task, error) {
+	vm, err := s.store.UpdateVM(id, func(vm *stores.VM) error {
+		if vm.State == stores.VMStateMigrating {
+			return fmt.Errorf("%w: vm is being migrated", stores.ErrConflict)
+		}
+		vm.State = stores.VMStateDeleting
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.tasks.EnqueueDeleteVM(vm.HostID, tasks.DeleteVMParams{VMID: vm.ID})
+	if err != nil {
+		return nil, err
+	}
+	s.dispatch.AddPending(vm.HostID, t)
+	return t, nil
+}
+
+// Power sends an on/off/reboot task for the VM to its host.
+func (s *Service) Power(id, op string) (*tasks.Task, error) {
+	switch op {
+	case tasks.PowerOn, tasks.PowerOff, tasks.PowerReboot:
+	default:
+		return nil, fmt.Errorf("%w: op must be one of on, off, reboot", stores.ErrInvalid)
+	}
+	vm, err := s.store.GetVM(id)
+	if err != nil {
+		return nil, err
+	}
+	switch vm.State {
+	case stores.VMStateDeleting:
+		return nil, fmt.Errorf("%w: vm is being deleted", stores.ErrConflict)
+	case stores.VMStateMigrating:
+		return nil, fmt.Errorf("%w: vm is being migrated", stores.ErrConflict)
+	case stores.VMStateRestoring:
+		return nil, fmt.Errorf("%w: vm is being restored", stores.ErrConflict)
+	}
+	t, err := s.tasks.EnqueuePowerVM(vm.HostID, tasks.PowerVMParams{VMID: vm.ID, Op: op})
+	if err != nil {
+		return nil, err
+	}
+	s.dispatch.AddPending(vm.HostID, t)
+	return t, nil
+}
+
+// Migrate live-migrates a running VM to targetHostID or, without one, to the
+// host the scheduler picks in the VM's cluster. Either way the target has to
+// pass the placement filters, which check that it carries the VM's port
+// groups, has room for it and offers every CPU flag of the source host. The
+// target agent is asked to receive the VM first; the source agent is asked to
+// send it once the target reports it is listening. The returned receive task
+// succeeds when the VM runs on the target.
+func (s *Service) Migrate(id, targetHostID string) (*tasks.Task, error) {
+	vm, err := s.store.GetVM(id)
+	if err != nil {
+		return nil, err
+	}
+	if vm.State != stores.VMStateRunning {
+		return nil, fmt.Errorf("%w: vm is %s, only running vms can be migrated", stores.ErrConflict, vm.State)
+	}
+	if targetHostID == vm.HostID {
+		return nil, fmt.Errorf("%w: vm already runs on host %s", stores.ErrInvalid, targetHostID)
+	}
+	req := s.migrationRequest(vm)
+
+	s.placeMu.Lock()
+	var d *scheduler.Decision
+	if targetHostID != "" {
+		d, err = s.scheduler.Check(req, targetHostID)
+	} else {
+		req.ClusterID = vm.ClusterID
+		d, err = s.scheduler.Place(req)
+	}
+	if err == nil {
+		vm, err = s.store.StartVMMigration(id, d.HostID)
+	}
+	s.placeMu.Unlock()
+	if errors.Is(err, scheduler.ErrNoHost) {
+		return nil, fmt.Errorf("%w: %v", stores.ErrConflict, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.scheduler.Record(vm.ID, d)
+
+	m := vm.Migration
+	spec, err := s.store.VMMigrationSpec(vm.ID)
+	if err == nil {
+		if err = spec.Validate(); err != nil {
+			err = fmt.Errorf("%w: %v", stores.ErrInvalid, err)
+		}
+	}
+	var t *tasks.Task
+	if err == nil {
+		t, err = s.tasks.EnqueueReceiveVMMigration(m.TargetHostID, tasks.ReceiveVMMigrationParams{Spec: spec, Port: m.Port})
+	}
+	if err == nil {
+		_, err = s.store.UpdateVM(vm.ID, func(vm *stores.VM) error {
+			vm.Migration.ReceiveTaskID = t.ID
+			return nil
+		})
+	}
+	if err != nil {
+		s.finishMigration(vm.ID, err.Error())
+		return nil, err
+	}
+	log.Printf("vms: migrating %s from host %s to %s", vm.ID, m.SourceHostID, m.TargetHostID)
+	s.dispatch.AddPending(m.TargetHostID, t)
+	return t, nil
+}
+
+// migrationRequest builds the placement request of a migration target. Guests
+// see the source host's CPU, so the target must offer all of its flags.
+func (s *Service) migrationRequest(vm *stores.VM) scheduler.Request {
+	req := scheduler.Request{
+		ProjectID:        vm.ProjectID,
+		Vcpus:            vm.Vcpus,
+		MemoryMiB:        vm.MemoryMiB,
+		Hugepages:        vm.Requirements.Hugepages,
+		CPUFlags:         append([]string(nil), vm.Requirements.CPUFlags...),
+		PlacementGroupID: vm.PlacementGroupID,
+		VMID:             vm.ID,
+		ExcludeHostIDs:   []string{vm.HostID},
+	}
+	if inv, err := s.store.LatestInventory(vm.HostID); err == nil {
+		for _, f := range inv.CPU.Flags {
+			if !slices.Contains(req.CPUFlags, f) {
+				req.CPUFlags = append(req.CPUFlags, f)
+			}
+		}
+	}
+	for _, d := range vm.Disks {
+		req.DiskGiB += d.SizeGiB
+	}
+	for _, n := range vm.Nets {
+		req.PortGroups = append(req.PortGroups, n.PortGroup)
+	}
+	return req
+}
+
+// handleMigrationTask moves a migration along as its receive and send tasks
+// progress: the source sends once the target is listening, the VM
+// moves once the target received it and stays on the source if either
+// side fails.
+func (s *Service) handleMigrationTask(t tasks.Task, vmID string) {
+	vm, err := s.store.GetVM(vmID)
+	if err != nil || vm.Migration == nil || vm.Migration.State != stores.MigrationInProgress {
+		return
+	}
+	m := vm.Migration
+	switch {
+	case t.Type == tasks.TypeReceiveVMMigration && t.ID == m.ReceiveTaskID:
+		switch t.Status {
+		case tasks.StatusRunning:
+			// Running alone only means the target got the task; it may still
+			// be fetching images and starting its VMM.
+			var r tasks.ReceiveVMMigrationResult
+			if json.Unmarshal(t.Result, &r) == nil && r.Listening {
+				s.sendMigration(vmID)
+			}
+		case tasks.StatusSucceeded:
+			s.finishMigration(vmID, "")
+		case tasks.StatusFailed:
+			s.finishMigration(vmID, "receive on target failed: "+t.Error)
+		}
+	case t.Type == tasks.TypeSendVMMigration && t.ID == m.SendTaskID && t.Status == tasks.StatusFailed:
+		s.finishMigration(vmID, "send from source failed: "+t.Error)
+	}
+}
+
+// sendMigration asks the source to send the VM unless it was asked already.
+func (s *Service) sendMigration(vmID string) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	vm, err := s.store.GetVM(vmID)
+	if err != nil || vm.Migration == nil || vm.Migration.State != stores.MigrationInProgress || vm.Migration.SendTaskID != "" {
+		return
+	}
+	m := vm.Migration
+	target, err := s.store.GetHost(m.TargetHostID)
+	if err != nil {
+		s.finishMigration(vmID, err.Error())
+		return
+	}
+	p := tasks.SendVMMigrationParams{VMID: vmID, DestinationURL: fmt.Sprintf("tcp:%s:%d", target.Hostname, m.Port)}
+	t, err := s.tasks.EnqueueSendVMMigration(m.SourceHostID, p)
+	if err == nil {
+		_, err = s.store.UpdateVM(vmID, func(vm *stores.VM) error {
+			vm.Migration.SendTaskID = t.ID
+			return nil
+		})
+	}
+	if err != nil {
+		s.finishMigration(vmID, err.Error())
+		return
+	}
+	s.dispatch.AddPending(m.SourceHostID, t)
+}
+
+// finishMigration records the outcome of a migration; errMsg empty means the
+// VM now runs on the target.
+func (s *Service) finishMigration(vmID, errMsg string) {
+	vm, err := s.store.FinishVMMigration(vmID, errMsg)
+	switch {
+	case err != nil:
+		log.Printf("vms: finish migration of %s: %v", vmID, err)
+	case errMsg != "":
+		log.Printf("vms: migration of %s failed, vm stays on host %s: %s", vmID, vm.HostID, errMsg)
+	default:
+		log.Printf("vms: %s migrated to host %s", vmID, vm.HostID)
+	}
+}
+
+// HandleTask updates VM, snapshot, device and resize state when a VM task
+// finishes and drives migrations. Other task types are ignored.
+func (s *Service) HandleTask(t tasks.Task) {
+	migration := t.Type == tasks.TypeReceiveVMMigration || t.Type == tasks.TypeSendVMMigration
+	if t.Status != tasks.StatusSucceeded && t.Status != tasks.StatusFailed && !(migration && t.Status == tasks.StatusRunning) {
+		return
+	}
+	var ref struct {
+		VMID       string `json:"vmId"`
+		Op         string `json:"op"`
+		SnapshotID string `json:"snapshotId"`
+		DeviceID   string `json:"deviceId"`
+		Spec       struct {
+			ID string `json:"id"`
+		} `json:"spec"`
+		Disk *struct {
+			ID string `json:"id"`
+		} `json:"disk"`
+		Nic *struct {
+			ID string `json:"id"`
+		} `json:"nic"`
+	}
+	switch t.Type {
+	case tasks.TypeCreateVM, tasks.TypeDeleteVM, tasks.TypePowerVM, tasks.TypeReceiveVMMigration, tasks.TypeSendVMMigration,
+		tasks.TypeSnapshotVM, tasks.TypeDeleteVMSnapshot, tasks.TypeRestoreVM, tasks.TypeAttachVMDevice, tasks.TypeDetachVMDevice,
+		tasks.TypeResizeVM:
+	default:
+		return
+	}
+	if err := json.Unmarshal(t.Params, &ref); err != nil {
+		log.Printf("vms: task %s: bad params: %v", t.ID, err)
+		return
+	}
+	vmID := ref.VMID
+	if t.Type == tasks.TypeCreateVM || t.Type == tasks.TypeReceiveVMMigration || t.Type == tasks.TypeRestoreVM {
+		vmID = ref.Spec.ID
+	}
+	if migration {
+		s.handleMigrationTask(t, vmID)
+		return
+	}
+	if t.Type == tasks.TypeSnapshotVM || t.Type == tasks.TypeDeleteVMSnapshot {
+		s.handleSnapshotTask(t, ref.SnapshotID)
+		return
+	}
+	if t.Type == tasks.TypeAttachVMDevice || t.Type == tasks.TypeDetachVMDevice {
+		deviceID := ref.DeviceID
+		if ref.Disk != nil {
+			deviceID = ref.Disk.ID
+		} else if ref.Nic != nil {
+			deviceID = ref.Nic.ID
+		}
+		s.handleDeviceTask(t, vmID, deviceID)
+		return
+	}
+	if t.Type == tasks.TypeResizeVM {
+		s.handleResizeTask(t, vmID)
+		return
+	}
+
+	if t.Status == tasks.StatusFailed {
+		s.setState(vmID, stores.VMStateError, t.Error)
+		return
+	}
+	switch t.Type {
+	case tasks.TypeCreateVM, tasks.TypeRestoreVM:
+		s.setState(vmID, stores.VMStateRunning, "")
+	case tasks.TypeDeleteVM:
+		if err := s.store.DeleteVM(vmID); err != nil {
+			log.Printf("vms: delete %s: %v", vmID, err)
+		}
+		s.scheduler.Forget(vmID)
+	case tasks.TypePowerVM:
+		if ref.Op == tasks.PowerOn {
+			// Powering on recreates a VM that was not running from its spec;
+			// a reboot keeps it as it is.
+			if _, err := s.store.VMBooted(vmID); err != nil {
+				log.Printf("vms: record boot of %s: %v", vmID, err)
+			}
+		}
+		if ref.Op == tasks.PowerOff {
+			s.setState(vmID, stores.VMStateStopped, "")
+		} else {
+			s.setState(vmID, stores.VMStateRunning, "")
+		}
+	}
+}
+
+// HandleStateReport applies a state reported by hostID's agent outside of a
+// task, e.g. after the guest powered itself off or the VMM died. Reports
+// about a VM that is being migrated or restored or runs elsewhere are
+// ignored; an empty hostID is not checked.
+func (s *Service) HandleStateReport(vmID, hostID string, state stores.VMState, errMsg string) error {
+	switch state {
+	case stores.VMStateRunning, stores.VMStateStopped, stores.VMStateError:
+	default:
+		return fmt.Errorf("%w: unsupported reported state %q", stores.ErrInvalid, state)
+	}
+	vm, err := s.store.GetVM(vmID)
+	if err != nil {
+		return err
+	}
+	switch vm.State {
+	case stores.VMStateDeleting, stores.VMStateMigrating, stores.VMStateRestoring:
+		// The delete, migration or restore task results decide what happens next.
+		return nil
+	}
+	if hostID != "" && hostID != vm.HostID {
+		return nil
+	}
+	_, err = s.store.SetVMState(vmID, state, errMsg)
+	return err
+}
+
+func (s *Service) setState(vmID string, state stores.VMState, errMsg string) {
+	if _, err := s.store.SetVMState(vmID, state, errMsg); err != nil {
+		log.Printf("vms: set state of %s to %s: %v", vmID, state, err)
+	}
+}
@@ -4,9 +4,11 @@ package agent
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"encoding/json"
 	"os"
+	"strconv"
 	"path/filepath"
 
 	verterapb "github.com/VerteraIO/vertera/api/proto/v1"
@@ -29,71 +31,187 @@ func Run(ctx context.Context, addr, agentID, hostname string, dialOpts ...grpc.D
 
 	// Register
 	reg := &verterapb.RegisterRequest{AgentId: agentID, Hostname: hostname}
-	if _, err := cli.Register(ctx, reg); err != nil {
+	resp, err := cli.Register(ctx, reg)
+	if err != nil {
 		return err
 	}
-	log.Printf("agent registered id=%s host=%s", agentID, hostname)
+	// The controller may map us onto a host registered through the API.
+	if resp.AssignedId != "" {
+		reg.AgentId = resp.AssignedId
+	}
+	log.Printf("agent registered id=%s host=%s", reg.AgentId, hostname)
 
-	// Watch tasks (placeholder)
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	go reportInventory(ctx, cli, reg.AgentId)
+	go heartbeat(ctx, cli, reg)
+
+	// Watch tasks
 	stream, err := cli.WatchTasks(ctx, reg)
 	if err != nil {
 		return err
 	}
+	a := newAgent(ctx, cli, reg.AgentId)
+	go a.serveConsoles(ctx)
+	go a.reportVMMetrics(ctx)
 	for {
 		msg, err := stream.Recv()
 		if err != nil {
 			return err
 		}
 		log.Printf("received task: %s type=%v", msg.Id, msg.Type)
-		// Decode params from JSON payload (controller sends json.RawMessage)
-		var params struct {
-			Packages  []string `json:"packages"`
-			Version   string   `json:"version"`
-			OSVersion string   `json:"os_version"`
-		}
-		if len(msg.Params) > 0 {
-			_ = json.Unmarshal(msg.Params, &params)
-		}
-		log.Printf("task %s params: packages=%v version=%s os=%s", msg.Id, params.Packages, params.Version, params.OSVersion)
 
 		// Report running
 		_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running"})
 
-		// Prepare package service with cache directory
-		cacheDir := os.Getenv("VERTERA_CACHE_DIR")
-		if cacheDir == "" { cacheDir = "/tmp/vertera/packages" }
-		pkgSvc := packages.NewService(cacheDir)
+		var result []byte
+		var taskErr error
+		switch msg.Type {
+		case verterapb.TaskType_TASK_TYPE_INSTALL_PACKAGES:
+			taskErr = installPackages(ctx, cli, msg)
+		case verterapb.TaskType_TASK_TYPE_UNINSTALL_PACKAGES, verterapb.TaskType_TASK_TYPE_ROLLBACK_PACKAGES:
+			taskErr = changePackages(ctx, cli, msg)
+		case verterapb.TaskType_TASK_TYPE_CREATE_VM, verterapb.TaskType_TASK_TYPE_DELETE_VM, verterapb.TaskType_TASK_TYPE_POWER_VM,
+			verterapb.TaskType_TASK_TYPE_SNAPSHOT_VM, verterapb.TaskType_TASK_TYPE_DELETE_VM_SNAPSHOT, verterapb.TaskType_TASK_TYPE_RESTORE_VM,
+			verterapb.TaskType_TASK_TYPE_UPDATE_VM_CLOUD_INIT, verterapb.TaskType_TASK_TYPE_ATTACH_VM_DEVICE, verterapb.TaskType_TASK_TYPE_DETACH_VM_DEVICE,
+			verterapb.TaskType_TASK_TYPE_RESIZE_VM, verterapb.TaskType_TASK_TYPE_RELEASE_VM:
+			a.queueVMTask(ctx, msg)
+			continue
+		case verterapb.TaskType_TASK_TYPE_RECEIVE_VM_MIGRATION, verterapb.TaskType_TASK_TYPE_SEND_VM_MIGRATION:
+			// Migration halves wait on the peer host, so they must not hold up
+			// the tasks queued behind them.
+			go func() {
+				result, err := a.runVMTask(ctx, msg)
+				reportResult(ctx, cli, msg.Id, result, err)
+			}()
+			continue
+		default:
+			taskErr = fmt.Errorf("unsupported task type %v", msg.Type)
+		}
+		reportResult(ctx, cli, msg.Id, result, taskErr)
+	}
+}
+
+// reportResult reports a finished task as succeeded, with its JSON-encoded
+// result if it has one, or failed.
+func reportResult(ctx context.Context, cli verterapb.AgentServiceClient, id string, result []byte, taskErr error) {
+	if taskErr != nil {
+		_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: id, Status: "failed", Error: taskErr.Error()})
+		return
+	}
+	_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: id, Status: "succeeded", Result: result})
+}
+
+// newPackageService prepares the package service for a task: packages are
+// cached in VERTERA_CACHE_DIR, which defaults to persistent storage because
+// the install state and the files a rollback reinstalls live there too, and
+// download progress and notes such as a skipped signature check go to the
+// task log.
+func newPackageService(ctx context.Context, cli verterapb.AgentServiceClient, taskID string, artifacts packages.Resolved) *packages.Service {
+	cacheDir := os.Getenv("VERTERA_CACHE_DIR")
+	if cacheDir == "" { cacheDir = "/var/lib/vertera/packages" }
+	pkgSvc := packages.NewService(cacheDir, artifacts)
+	pkgSvc.Keyring = os.Getenv("VERTERA_PACKAGE_KEYRING")
+	pkgSvc.SkipSignatures, _ = strconv.ParseBool(os.Getenv("VERTERA_PACKAGE_SKIP_SIGNATURES"))
+	// Packages come from the controller's mirror; upstream URLs are only
+	// tried when VERTERA_PACKAGE_UPSTREAM_FALLBACK allows it.
+	pkgSvc.Mirror = os.Getenv("VERTERA_CONTROLLER_HTTP")
+	if pkgSvc.Mirror == "" {
+		pkgSvc.Mirror = "http://localhost:8080"
+	}
+	pkgSvc.MirrorToken = os.Getenv("VERTERA_MIRROR_TOKEN")
+	pkgSvc.UpstreamFallback, _ = strconv.ParseBool(os.Getenv("VERTERA_PACKAGE_UPSTREAM_FALLBACK"))
+	pkgSvc.Progress = func(name string, done, total int64) {
+		progress := fmt.Sprintf("downloading %s: %.1f MiB", name, float64(done)/(1<<20))
+		if total > 0 {
+			progress += fmt.Sprintf(" of %.1f MiB (%d%%)", float64(total)/(1<<20), done*100/total)
+		}
+		_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: taskID, Status: "running", Logs: progress})
+	}
+	pkgSvc.Log = func(msg string) {
+		_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: taskID, Status: "running", Logs: msg})
+	}
+	return pkgSvc
+}
+
+// installPackages resolves, downloads and installs the requested host packages.
+func installPackages(ctx context.Context, cli verterapb.AgentServiceClient, msg *verterapb.Task) error {
+	// Decode params from JSON payload (controller sends json.RawMessage)
+	var params struct {
+		Packages  []string          `json:"packages"`
+		Version   string            `json:"version"`
+		OSVersion string            `json:"os_version"`
+		Artifacts packages.Resolved `json:"artifacts"`
+	}
+	if len(msg.Params) > 0 {
+		_ = json.Unmarshal(msg.Params, &params)
+	}
+	// Tasks from older controllers may not name the OS; use this host's.
+	if params.OSVersion == "" {
+		if r, err := packages.ReadOSRelease("/etc/os-release"); err == nil {
+			params.OSVersion = r.OSVersion()
+		}
+	}
+	log.Printf("task %s params: packages=%v version=%s os=%s", msg.Id, params.Packages, params.Version, params.OSVersion)
 
-		// For each requested package type, resolve download URLs, fetch required artifacts, and install
-		var overallErr error
-		for _, p := range params.Packages {
-			pkgType := packages.PackageType(p)
-			_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running", Logs: "resolving package info: " + p})
-			infos, err := pkgSvc.GetPackageInfo(pkgType, params.Version, params.OSVersion)
+	pkgSvc := newPackageService(ctx, cli, msg.Id, params.Artifacts)
+
+	// For each requested package type, resolve download URLs, fetch required artifacts, and install
+	var overallErr error
+	for _, p := range params.Packages {
+		pkgType := packages.PackageType(p)
+		_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running", Logs: "resolving package info: " + p})
+		infos, err := pkgSvc.GetPackageInfo(pkgType, params.Version, params.OSVersion)
+		if err != nil { overallErr = err; break }
+		var paths []string
+		for _, info := range infos {
+			if !info.Required { continue }
+			_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running", Logs: "downloading: " + info.Name})
+			path, err := pkgSvc.DownloadPackage(info)
 			if err != nil { overallErr = err; break }
-			var paths []string
-			for _, info := range infos {
-				if !info.Required { continue }
-				_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running", Logs: "downloading: " + info.Name})
-				path, err := pkgSvc.DownloadPackage(info)
-				if err != nil { overallErr = err; break }
-				_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running", Logs: "downloaded: " + filepath.Base(path)})
-				paths = append(paths, path)
-			}
-			if overallErr != nil { break }
-			_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running", Logs: "installing: " + p})
-			instReq := packages.InstallRequest{PackageType: pkgType, Packages: paths, OSVersion: params.OSVersion}
-			if err := pkgSvc.Install(instReq); err != nil { overallErr = err; break }
-			_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running", Logs: "installed: " + p})
+			_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running", Logs: "downloaded and verified: " + filepath.Base(path)})
+			paths = append(paths, path)
+		}
+		if overallErr != nil { break }
+		_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running", Logs: "installing: " + p})
+		instReq := packages.InstallRequest{PackageType: pkgType, Packages: paths, Version: params.Version, OSVersion: params.OSVersion}
+		if len(infos) > 0 {
+			instReq.Version = infos[0].Version
 		}
+		if err := pkgSvc.Install(instReq); err != nil { overallErr = err; break }
+		_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running", Logs: "installed: " + p})
+	}
+	return overallErr
+}
 
-		if overallErr != nil {
-			_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "failed", Error: overallErr.Error()})
-			continue
+// changePackages uninstalls host packages or rolls them back to the release
+// installed before their last change.
+func changePackages(ctx context.Context, cli verterapb.AgentServiceClient, msg *verterapb.Task) error {
+	var params struct {
+		Packages []string `json:"packages"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return fmt.Errorf("decode params: %w", err)
+	}
+	pkgSvc := newPackageService(ctx, cli, msg.Id, nil)
+	for _, p := range params.Packages {
+		pkgType := packages.PackageType(p)
+		var err error
+		if msg.Type == verterapb.TaskType_TASK_TYPE_UNINSTALL_PACKAGES {
+			_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running", Logs: "uninstalling: " + p})
+			err = pkgSvc.Uninstall(pkgType)
+		} else {
+			_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running", Logs: "rolling back: " + p})
+			err = pkgSvc.Rollback(pkgType)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		state := "not installed"
+		if v := pkgSvc.InstalledVersion(pkgType); v != "" {
+			state = "at version " + v
 		}
-		// Report succeeded
-		_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "succeeded"})
+		_, _ = cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running", Logs: p + " is " + state})
 	}
+	return nil
 }
@@ -0,0 +1,79 @@
+//go:build grpcgen
+
+package agent
+
+import (
+	"context"
+	"log"
+	"time"
+
+	verterapb "github.com/VerteraIO/vertera/api/proto/v1"
+)
+
+// serveConsoles watches for console sessions opened for this host's VMs and
+// serves each on its own Console stream. The watch is re-established until
+// ctx ends.
+func (a *agent) serveConsoles(ctx context.Context) {
+	for ctx.Err() == nil {
+		stream, err := a.cli.WatchConsoles(ctx, &verterapb.RegisterRequest{AgentId: a.hostID})
+		if err == nil {
+			for {
+				req, err := stream.Recv()
+				if err != nil {
+					break
+				}
+				go a.serveConsole(ctx, req)
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// serveConsole attaches to a VM's console and relays it on a Console stream
+// until either side hangs up.
+func (a *agent) serveConsole(ctx context.Context, req *verterapb.ConsoleRequest) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := a.cli.Console(ctx)
+	if err != nil {
+		log.Printf("console of vm %s: %v", req.VmId, err)
+		return
+	}
+	v, err := a.consoles.Attach(req.VmId)
+	if err != nil {
+		_ = stream.Send(&verterapb.ConsoleFrame{SessionId: req.SessionId, Error: err.Error()})
+		_ = stream.CloseSend()
+		_, _ = stream.Recv() // wait for the controller to take the rejection
+		return
+	}
+	defer v.Close()
+	if err := stream.Send(&verterapb.ConsoleFrame{SessionId: req.SessionId, Data: v.History}); err != nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer v.Close()
+		for {
+			frame, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			if _, err := v.Write(frame.Data); err != nil {
+				return
+			}
+		}
+	}()
+	for data := range v.Output() {
+		if err := stream.Send(&verterapb.ConsoleFrame{SessionId: req.SessionId, Data: data}); err != nil {
+			return
+		}
+	}
+	// The console went away; let the controller end the session before
+	// tearing the stream down.
+	_ = stream.CloseSend()
+	<-done
+}
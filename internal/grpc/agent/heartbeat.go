@@ -0,0 +1,43 @@
+//go:build grpcgen
+
+package agent
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	verterapb "github.com/VerteraIO/vertera/api/proto/v1"
+)
+
+// heartbeat tells the controller the agent is alive every
+// VERTERA_HEARTBEAT_INTERVAL (default 10s) until ctx is done. Registering
+// counted as the first heartbeat.
+func heartbeat(ctx context.Context, cli verterapb.AgentServiceClient, reg *verterapb.RegisterRequest) {
+	interval := 10 * time.Second
+	if v := os.Getenv("VERTERA_HEARTBEAT_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			interval = d
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	state := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ack, err := cli.Heartbeat(ctx, reg)
+		if err != nil {
+			log.Printf("heartbeat: %v", err)
+			continue
+		}
+		if ack.HostState != state && ack.HostState == "failed" {
+			log.Printf("heartbeat: controller failed this host over, waiting to rejoin")
+		}
+		state = ack.HostState
+	}
+}
@@ -0,0 +1,57 @@
+//go:build grpcgen
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	verterapb "github.com/VerteraIO/vertera/api/proto/v1"
+	"github.com/VerteraIO/vertera/internal/agent/collector"
+	"github.com/VerteraIO/vertera/internal/agent/supervisor"
+)
+
+// reportInventory collects the host inventory, including the filesystem
+// holding VM disks, and sends it to the controller right away and then every
+// VERTERA_INVENTORY_INTERVAL (default 5m) until ctx is done.
+func reportInventory(ctx context.Context, cli verterapb.AgentServiceClient, hostID string) {
+	interval := 5 * time.Minute
+	if v := os.Getenv("VERTERA_INVENTORY_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			interval = d
+		}
+	}
+	storage := os.Getenv("VERTERA_VOLUME_DIR")
+	if storage == "" {
+		storage = supervisor.DefaultVolumeDir
+	}
+	c := &collector.Host{StoragePath: storage}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := sendInventory(ctx, cli, c, hostID); err != nil {
+			log.Printf("report inventory: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sendInventory(ctx context.Context, cli verterapb.AgentServiceClient, c collector.Collector, hostID string) error {
+	inv, err := c.Collect()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	_, err = cli.ReportInventory(ctx, &verterapb.InventoryReport{HostId: hostID, Inventory: data})
+	return err
+}
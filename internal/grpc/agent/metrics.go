@@ -0,0 +1,52 @@
+//go:build grpcgen
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	verterapb "github.com/VerteraIO/vertera/api/proto/v1"
+	"github.com/VerteraIO/vertera/internal/agent/collector"
+)
+
+// reportVMMetrics samples the resource use of the host's VMs and sends it to
+// the controller every VERTERA_METRICS_INTERVAL (default 15s) until ctx is
+// done.
+func (a *agent) reportVMMetrics(ctx context.Context) {
+	interval := 15 * time.Second
+	if v := os.Getenv("VERTERA_METRICS_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			interval = d
+		}
+	}
+	c := &collector.VMs{VMMs: a.vmms}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := sendVMMetrics(ctx, a.cli, c, a.hostID); err != nil {
+			log.Printf("report vm metrics: %v", err)
+		}
+	}
+}
+
+func sendVMMetrics(ctx context.Context, cli verterapb.AgentServiceClient, c collector.Collector, hostID string) error {
+	metrics, err := c.Collect()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(metrics)
+	if err != nil {
+		return err
+	}
+	_, err = cli.ReportVmMetrics(ctx, &verterapb.VmMetricsReport{HostId: hostID, Metrics: data})
+	return err
+}
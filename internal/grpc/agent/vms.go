@@ -0,0 +1,325 @@
+//go:build grpcgen
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
+
+	verterapb "github.com/VerteraIO/vertera/api/proto/v1"
+	"github.com/VerteraIO/vertera/internal/agent/console"
+	"github.com/VerteraIO/vertera/internal/agent/executor"
+	"github.com/VerteraIO/vertera/internal/agent/imagecache"
+	"github.com/VerteraIO/vertera/internal/agent/supervisor"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+	"github.com/VerteraIO/vertera/internal/ovs"
+	"github.com/VerteraIO/vertera/internal/qemuimg"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+// agent holds the host runtimes used to execute VM tasks.
+type agent struct {
+	cli      verterapb.AgentServiceClient
+	hostID   string
+	vmms     *supervisor.Supervisor
+	ovs      *ovs.Client
+	images   *imagecache.Cache
+	disks    *qemuimg.Client
+	consoles *console.Manager
+	firmware string
+
+	mu sync.Mutex
+	// queued holds, per VM, a channel closed when the VM's last queued task
+	// is done.
+	queued map[string]chan struct{}
+}
+
+// newAgent sets up the VMM supervisor from the environment and adopts VMMs
+// left running by a previous agent process.
+func newAgent(ctx context.Context, cli verterapb.AgentServiceClient, hostID string) *agent {
+	a := &agent{cli: cli, hostID: hostID, ovs: ovs.NewClient(), disks: qemuimg.NewClient(), queued: map[string]chan struct{}{}}
+	imageDir := os.Getenv("VERTERA_IMAGE_CACHE_DIR")
+	if imageDir == "" {
+		imageDir = "/var/lib/vertera/images"
+	}
+	controller := os.Getenv("VERTERA_CONTROLLER_HTTP")
+	if controller == "" {
+		controller = "http://localhost:8080"
+	}
+	a.images = imagecache.New(imageDir, controller)
+	a.firmware = os.Getenv("VERTERA_CH_FIRMWARE")
+	if a.firmware == "" {
+		a.firmware = "/usr/share/cloud-hypervisor/CLOUDHV.fd"
+	}
+	a.vmms = supervisor.New(supervisor.Config{
+		RuntimeDir: os.Getenv("VERTERA_RUNTIME_DIR"),
+		VolumeDir:  os.Getenv("VERTERA_VOLUME_DIR"),
+		Binary:     os.Getenv("VERTERA_CH_BINARY"),
+		Restart:    a.restartDelay,
+		OnExit:     func(ev supervisor.Event) { a.onVMMExit(ctx, ev) },
+	})
+
+	a.consoles = console.NewManager(func(vmID string) string {
+		return vmspec.Layout{Dir: a.vmms.Dir(vmID)}.SerialSocketPath()
+	}, console.DefaultHistorySize)
+
+	adopted, err := a.vmms.Rediscover(ctx)
+	if err != nil {
+		log.Printf("rediscover VMMs: %v", err)
+	}
+	for _, id := range adopted {
+		a.reportObservedState(ctx, id)
+		a.consoles.Watch(id)
+	}
+	return a
+}
+
+// queueVMTask runs a VM task in the background, after the tasks queued
+// earlier for the same VM, and reports its result. Tasks of different VMs
+// run concurrently, so a slow create does not hold up the other VMs.
+func (a *agent) queueVMTask(ctx context.Context, msg *verterapb.Task) {
+	var p struct {
+		VMID string `json:"vmId"`
+		Spec struct {
+			ID string `json:"id"`
+		} `json:"spec"`
+	}
+	_ = json.Unmarshal(msg.Params, &p)
+	vmID := p.VMID
+	if vmID == "" {
+		vmID = p.Spec.ID
+	}
+
+	done := make(chan struct{})
+	a.mu.Lock()
+	prev := a.queued[vmID]
+	a.queued[vmID] = done
+	a.mu.Unlock()
+	go func() {
+		defer func() {
+			a.mu.Lock()
+			if a.queued[vmID] == done {
+				delete(a.queued, vmID)
+			}
+			a.mu.Unlock()
+			close(done)
+		}()
+		if prev != nil {
+			<-prev
+		}
+		result, err := a.runVMTask(ctx, msg)
+		reportResult(ctx, a.cli, msg.Id, result, err)
+	}()
+}
+
+// runVMTask executes a create/delete/power VM task, one half of a live
+// migration, a snapshot task or a cloud-init update, and returns the task's
+// JSON-encoded result if it has one.
+func (a *agent) runVMTask(ctx context.Context, msg *verterapb.Task) ([]byte, error) {
+	var ex executor.Executor
+	// started is the VM whose VMM the task (re)starts; its console is
+	// watched from boot.
+	var started string
+	switch msg.Type {
+	case verterapb.TaskType_TASK_TYPE_CREATE_VM:
+		var p tasks.CreateVMParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, fmt.Errorf("decode params: %w", err)
+		}
+		ex = &executor.CreateVM{VMMs: a.vmms, OVS: a.ovs, Images: a.images, DiskImages: a.disks, Spec: p.Spec, Firmware: a.firmware, Recover: p.Recover}
+		started = p.Spec.ID
+	case verterapb.TaskType_TASK_TYPE_DELETE_VM:
+		var p tasks.DeleteVMParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, fmt.Errorf("decode params: %w", err)
+		}
+		ex = &executor.DeleteVM{VMMs: a.vmms, OVS: a.ovs, VMID: p.VMID}
+	case verterapb.TaskType_TASK_TYPE_POWER_VM:
+		var p tasks.PowerVMParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, fmt.Errorf("decode params: %w", err)
+		}
+		ex = &executor.PowerVM{VMMs: a.vmms, OVS: a.ovs, VMID: p.VMID, Op: p.Op, Firmware: a.firmware}
+		if p.Op != tasks.PowerOff {
+			started = p.VMID
+		}
+	case verterapb.TaskType_TASK_TYPE_RECEIVE_VM_MIGRATION:
+		var p tasks.ReceiveVMMigrationParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, fmt.Errorf("decode params: %w", err)
+		}
+		ex = &executor.ReceiveMigration{VMMs: a.vmms, OVS: a.ovs, Images: a.images, Spec: p.Spec, Port: p.Port, Listening: func() {
+			result, _ := json.Marshal(tasks.ReceiveVMMigrationResult{Listening: true})
+			_, _ = a.cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running", Logs: fmt.Sprintf("listening on port %d", p.Port), Result: result})
+		}}
+		started = p.Spec.ID
+	case verterapb.TaskType_TASK_TYPE_SEND_VM_MIGRATION:
+		var p tasks.SendVMMigrationParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, fmt.Errorf("decode params: %w", err)
+		}
+		ex = &executor.SendMigration{VMMs: a.vmms, OVS: a.ovs, VMID: p.VMID, DestinationURL: p.DestinationURL}
+	case verterapb.TaskType_TASK_TYPE_SNAPSHOT_VM:
+		var p tasks.SnapshotVMParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, fmt.Errorf("decode params: %w", err)
+		}
+		ex = &executor.SnapshotVM{VMMs: a.vmms, VMID: p.VMID, SnapshotID: p.SnapshotID}
+	case verterapb.TaskType_TASK_TYPE_DELETE_VM_SNAPSHOT:
+		var p tasks.DeleteVMSnapshotParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, fmt.Errorf("decode params: %w", err)
+		}
+		ex = &executor.DeleteSnapshot{VMMs: a.vmms, VMID: p.VMID, SnapshotID: p.SnapshotID}
+	case verterapb.TaskType_TASK_TYPE_RESTORE_VM:
+		var p tasks.RestoreVMParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, fmt.Errorf("decode params: %w", err)
+		}
+		ex = &executor.RestoreVM{VMMs: a.vmms, OVS: a.ovs, Spec: p.Spec, SourceVMID: p.SourceVMID, SnapshotID: p.SnapshotID, Firmware: a.firmware}
+		started = p.Spec.ID
+	case verterapb.TaskType_TASK_TYPE_UPDATE_VM_CLOUD_INIT:
+		var p tasks.UpdateVMCloudInitParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, fmt.Errorf("decode params: %w", err)
+		}
+		ex = &executor.UpdateCloudInit{VMMs: a.vmms, VMID: p.VMID, CloudInit: p.CloudInit}
+	case verterapb.TaskType_TASK_TYPE_ATTACH_VM_DEVICE:
+		var p tasks.AttachVMDeviceParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, fmt.Errorf("decode params: %w", err)
+		}
+		ex = &executor.AttachDevice{VMMs: a.vmms, OVS: a.ovs, Images: a.images, DiskImages: a.disks, VMID: p.VMID, Disk: p.Disk, Nic: p.Nic}
+	case verterapb.TaskType_TASK_TYPE_DETACH_VM_DEVICE:
+		var p tasks.DetachVMDeviceParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, fmt.Errorf("decode params: %w", err)
+		}
+		ex = &executor.DetachDevice{VMMs: a.vmms, OVS: a.ovs, VMID: p.VMID, DeviceID: p.DeviceID}
+	case verterapb.TaskType_TASK_TYPE_RESIZE_VM:
+		var p tasks.ResizeVMParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, fmt.Errorf("decode params: %w", err)
+		}
+		ex = &executor.ResizeVM{VMMs: a.vmms, VMID: p.VMID, Vcpus: p.Vcpus, MemoryMiB: p.MemoryMiB, MaxVcpus: p.MaxVcpus, MaxMemoryMiB: p.MaxMemoryMiB}
+	case verterapb.TaskType_TASK_TYPE_RELEASE_VM:
+		var p tasks.ReleaseVMParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, fmt.Errorf("decode params: %w", err)
+		}
+		ex = &executor.ReleaseVM{VMMs: a.vmms, OVS: a.ovs, VMID: p.VMID}
+	default:
+		return nil, fmt.Errorf("not a VM task: %v", msg.Type)
+	}
+	_, _ = a.cli.ReportTaskResult(ctx, &verterapb.TaskResult{Id: msg.Id, Status: "running", Logs: "executing: " + ex.Name()})
+	if err := ex.Run(); err != nil {
+		return nil, err
+	}
+	if started != "" {
+		a.consoles.Watch(started)
+	}
+	if msg.Type == verterapb.TaskType_TASK_TYPE_DELETE_VM || msg.Type == verterapb.TaskType_TASK_TYPE_RELEASE_VM {
+		var p tasks.DeleteVMParams
+		_ = json.Unmarshal(msg.Params, &p)
+		a.consoles.Forget(p.VMID)
+	}
+	switch ex := ex.(type) {
+	case *executor.SnapshotVM:
+		return json.Marshal(tasks.SnapshotVMResult{SizeBytes: ex.SizeBytes})
+	case *executor.ResizeVM:
+		return json.Marshal(tasks.ResizeVMResult{Live: ex.Live, Reason: ex.Reason})
+	}
+	return nil, nil
+}
+
+// consoleTailLines is how many lines of console output a crash report
+// carries.
+const consoleTailLines = 20
+
+// restartDelay applies the restart policy of the VM's persisted spec to a
+// VMM that exited on its own.
+func (a *agent) restartDelay(ev supervisor.Event) (time.Duration, bool) {
+	spec, err := vmspec.Load(a.vmms.Dir(ev.VMID))
+	if err != nil {
+		return 0, false
+	}
+	return spec.Restart.Delay(ev.ExitCode, ev.Restarts)
+}
+
+// onVMMExit reports a VMM that exited on its own. A clean exit means the guest
+// powered itself off; anything else is a crash. A VMM restarted by the VM's
+// restart policy comes back empty and has its VM booted again here.
+func (a *agent) onVMMExit(ctx context.Context, ev supervisor.Event) {
+	report := &verterapb.VmStateReport{VmId: ev.VMID, State: "stopped", HostId: a.hostID}
+	// applies is true if the policy called for a restart; left is true if
+	// it had restarts left, so a VMM that was not restarted failed to spawn.
+	applies, left := false, false
+	if spec, err := vmspec.Load(a.vmms.Dir(ev.VMID)); err == nil {
+		applies = spec.Restart.Applies(ev.ExitCode)
+		_, left = spec.Restart.Delay(ev.ExitCode, ev.Restarts)
+	}
+	if ev.ExitCode != 0 || ev.Restarted {
+		report.Crash = &verterapb.VmCrash{
+			ExitCode:    int32(ev.ExitCode),
+			Restarts:    int32(ev.Restarts),
+			Restarted:   ev.Restarted,
+			ConsoleTail: consoleTail(a.consoles.LastOutput(ev.VMID), consoleTailLines),
+		}
+		if ev.Err != nil {
+			report.Crash.Error = ev.Err.Error()
+		}
+	}
+	switch {
+	case ev.Restarted:
+		boot := &executor.PowerVM{VMMs: a.vmms, OVS: a.ovs, VMID: ev.VMID, Op: tasks.PowerOn, Firmware: a.firmware}
+		if err := boot.Run(); err != nil {
+			report.State = "error"
+			report.Error = fmt.Sprintf("boot after vmm restart %d: %v", ev.Restarts, err)
+			break
+		}
+		report.State = "running"
+		a.consoles.Watch(ev.VMID)
+	case applies && left:
+		report.State = "error"
+		report.Error = fmt.Sprintf("vmm exited unexpectedly (code %d) and could not be restarted: %v", ev.ExitCode, ev.Err)
+	case applies:
+		report.State = "error"
+		report.Error = fmt.Sprintf("vmm exited unexpectedly (code %d), restarts exhausted after %d restarts: %v", ev.ExitCode, ev.Restarts, ev.Err)
+	case ev.ExitCode != 0:
+		report.State = "error"
+		report.Error = fmt.Sprintf("vmm exited unexpectedly (code %d): %v", ev.ExitCode, ev.Err)
+	}
+	if _, err := a.cli.ReportVmState(ctx, report); err != nil {
+		log.Printf("report state of vm %s: %v", ev.VMID, err)
+	}
+}
+
+// consoleTail returns the last n lines of console output.
+func consoleTail(out []byte, n int) string {
+	lines := strings.Split(strings.TrimRight(string(out), "\r\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// reportObservedState reports the state of an adopted VMM's VM.
+func (a *agent) reportObservedState(ctx context.Context, vmID string) {
+	report := &verterapb.VmStateReport{VmId: vmID, State: "stopped", HostId: a.hostID}
+	if c, err := a.vmms.Client(vmID); err == nil {
+		if info, err := c.GetVMInfo(ctx); err == nil && (info.State == ch.Running || info.State == ch.Paused) {
+			report.State = "running"
+		}
+	}
+	if _, err := a.cli.ReportVmState(ctx, report); err != nil {
+		log.Printf("report state of vm %s: %v", vmID, err)
+	}
+}
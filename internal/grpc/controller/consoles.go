@@ -0,0 +1,81 @@
+//go:build grpcgen
+
+package controller
+
+import (
+	"errors"
+	"log"
+
+	verterapb "github.com/VerteraIO/vertera/api/proto/v1"
+	"github.com/VerteraIO/vertera/internal/controlplane/consoles"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WatchConsoles streams the console sessions opened for the agent's VMs.
+func (s *AgentServiceServer) WatchConsoles(req *verterapb.RegisterRequest, stream verterapb.AgentService_WatchConsolesServer) error {
+	hostID := req.AgentId
+	if hostID == "" {
+		hostID = req.Hostname
+	}
+	reqs, unsubscribe := consoles.Default.Subscribe(hostID)
+	defer unsubscribe()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case r := <-reqs:
+			if err := stream.Send(&verterapb.ConsoleRequest{SessionId: r.SessionID, VmId: r.VMID}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Console joins an agent's console stream to the viewer waiting for it.
+func (s *AgentServiceServer) Console(stream verterapb.AgentService_ConsoleServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.Error != "" {
+		if err := consoles.Default.Reject(first.SessionId, errors.New(first.Error)); err != nil {
+			return status.Error(codes.NotFound, err.Error())
+		}
+		return nil
+	}
+	conn, err := consoles.Default.Attach(first.SessionId)
+	if err != nil {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	defer conn.Close()
+	if len(first.Data) > 0 {
+		if _, err := conn.Write(first.Data); err != nil {
+			return nil
+		}
+	}
+
+	go func() {
+		defer conn.Close()
+		for {
+			frame, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			if _, err := conn.Write(frame.Data); err != nil {
+				return
+			}
+		}
+	}()
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			log.Printf("console: session %s ended", first.SessionId)
+			return nil
+		}
+		if err := stream.Send(&verterapb.ConsoleFrame{SessionId: first.SessionId, Data: append([]byte(nil), buf[:n]...)}); err != nil {
+			return err
+		}
+	}
+}
@@ -4,14 +4,20 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"net"
 	"time"
 
 	verterapb "github.com/VerteraIO/vertera/api/proto/v1"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
 	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
 	"github.com/VerteraIO/vertera/internal/controlplane/dispatch"
+	"github.com/VerteraIO/vertera/internal/controlplane/ha"
+	"github.com/VerteraIO/vertera/internal/controlplane/vms"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
     "google.golang.org/grpc/credentials"
 )
 
@@ -33,12 +39,30 @@ func RunTLS(addr string, creds credentials.TransportCredentials) error {
 }
 
 func (s *AgentServiceServer) Register(ctx context.Context, req *verterapb.RegisterRequest) (*verterapb.RegisterResponse, error) {
-	assigned := req.AgentId
-	if assigned == "" {
-		assigned = req.Hostname
+	h, err := stores.Default.RegisterAgentHost(req.AgentId, req.Hostname)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	log.Printf("agent registered: %s (host=%s)", h.ID, req.Hostname)
+	// Registering counts as a heartbeat, e.g. after an agent restart.
+	if _, err := ha.Default.Heartbeat(h.ID); err != nil {
+		log.Printf("Register: host %s: heartbeat: %v", h.ID, err)
 	}
-	log.Printf("agent registered: %s (host=%s)", assigned, req.Hostname)
-	return &verterapb.RegisterResponse{AssignedId: assigned}, nil
+	return &verterapb.RegisterResponse{AssignedId: h.ID}, nil
+}
+
+// Heartbeat records that the agent is alive and returns its host's state. A
+// failed host that is back is asked to release the VMs that moved away.
+func (s *AgentServiceServer) Heartbeat(ctx context.Context, req *verterapb.RegisterRequest) (*verterapb.HeartbeatAck, error) {
+	hostID := req.AgentId
+	if hostID == "" {
+		hostID = req.Hostname
+	}
+	h, err := ha.Default.Heartbeat(hostID)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &verterapb.HeartbeatAck{HostId: h.ID, HostState: string(h.State)}, nil
 }
 
 func (s *AgentServiceServer) WatchTasks(req *verterapb.RegisterRequest, stream verterapb.AgentService_WatchTasksServer) error {
@@ -49,8 +73,7 @@ func (s *AgentServiceServer) WatchTasks(req *verterapb.RegisterRequest, stream v
 	// First drain any pending tasks for this host
 	pending := dispatch.Default.DrainPending(hostID)
 	for _, t := range pending {
-		pb := &verterapb.Task{Id: t.ID, HostId: t.HostID, Type: verterapb.TaskType_TASK_TYPE_INSTALL_PACKAGES, Params: t.Params}
-		if err := stream.Send(pb); err != nil {
+		if err := stream.Send(taskToProto(t)); err != nil {
 			return err
 		}
 	}
@@ -65,8 +88,7 @@ func (s *AgentServiceServer) WatchTasks(req *verterapb.RegisterRequest, stream v
 			if t == nil { // channel closed
 				return nil
 			}
-			pb := &verterapb.Task{Id: t.ID, HostId: t.HostID, Type: verterapb.TaskType_TASK_TYPE_INSTALL_PACKAGES, Params: t.Params}
-			if err := stream.Send(pb); err != nil {
+			if err := stream.Send(taskToProto(t)); err != nil {
 				return err
 			}
 		}
@@ -78,6 +100,9 @@ func (s *AgentServiceServer) ReportTaskResult(ctx context.Context, result *verte
     if result.Logs != "" {
         tasks.Default.UpdateLogs(result.Id, result.Logs)
     }
+    if len(result.Result) > 0 {
+        tasks.Default.SetResult(result.Id, result.Result)
+    }
     switch result.Status {
     case "running":
         tasks.Default.UpdateStatusRunning(result.Id)
@@ -95,6 +120,70 @@ func (s *AgentServiceServer) ReportTaskResult(ctx context.Context, result *verte
 	return &verterapb.TaskAck{Id: result.Id}, nil
 }
 
+// ReportVmState applies a VM state change the agent observed outside of a task
+// and records the VMM crash that caused it, if any.
+func (s *AgentServiceServer) ReportVmState(ctx context.Context, report *verterapb.VmStateReport) (*verterapb.VmStateAck, error) {
+	if c := report.Crash; c != nil {
+		crash := stores.VMCrash{ExitCode: int(c.ExitCode), Error: c.Error, Restarted: c.Restarted, Restarts: int(c.Restarts), ConsoleTail: c.ConsoleTail}
+		if err := stores.Default.RecordVMCrash(report.VmId, report.HostId, crash); err != nil {
+			log.Printf("ReportVmState: vm %s: record crash: %v", report.VmId, err)
+		}
+	}
+	if err := vms.Default.HandleStateReport(report.VmId, report.HostId, stores.VMState(report.State), report.Error); err != nil {
+		log.Printf("ReportVmState: vm %s: %v", report.VmId, err)
+	}
+	return &verterapb.VmStateAck{VmId: report.VmId}, nil
+}
+
+// ReportInventory records a host inventory snapshot collected by the agent.
+func (s *AgentServiceServer) ReportInventory(ctx context.Context, report *verterapb.InventoryReport) (*verterapb.InventoryAck, error) {
+	var inv stores.Inventory
+	if err := json.Unmarshal(report.Inventory, &inv); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "decode inventory: %v", err)
+	}
+	if err := stores.Default.RecordInventory(report.HostId, inv); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &verterapb.InventoryAck{HostId: report.HostId}, nil
+}
+
+// ReportVmMetrics records the metric samples an agent collected from its VMs.
+func (s *AgentServiceServer) ReportVmMetrics(ctx context.Context, report *verterapb.VmMetricsReport) (*verterapb.VmMetricsAck, error) {
+	var metrics struct {
+		VMs []stores.VMMetricsSample `json:"vms"`
+	}
+	if err := json.Unmarshal(report.Metrics, &metrics); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "decode metrics: %v", err)
+	}
+	if err := stores.Default.RecordVMMetrics(report.HostId, metrics.VMs); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &verterapb.VmMetricsAck{HostId: report.HostId}, nil
+}
+
+var taskTypes = map[tasks.Type]verterapb.TaskType{
+	tasks.TypeInstallPackages:    verterapb.TaskType_TASK_TYPE_INSTALL_PACKAGES,
+	tasks.TypeCreateVM:           verterapb.TaskType_TASK_TYPE_CREATE_VM,
+	tasks.TypeDeleteVM:           verterapb.TaskType_TASK_TYPE_DELETE_VM,
+	tasks.TypePowerVM:            verterapb.TaskType_TASK_TYPE_POWER_VM,
+	tasks.TypeReceiveVMMigration: verterapb.TaskType_TASK_TYPE_RECEIVE_VM_MIGRATION,
+	tasks.TypeSendVMMigration:    verterapb.TaskType_TASK_TYPE_SEND_VM_MIGRATION,
+	tasks.TypeSnapshotVM:         verterapb.TaskType_TASK_TYPE_SNAPSHOT_VM,
+	tasks.TypeDeleteVMSnapshot:   verterapb.TaskType_TASK_TYPE_DELETE_VM_SNAPSHOT,
+	tasks.TypeRestoreVM:          verterapb.TaskType_TASK_TYPE_RESTORE_VM,
+	tasks.TypeUpdateVMCloudInit:  verterapb.TaskType_TASK_TYPE_UPDATE_VM_CLOUD_INIT,
+	tasks.TypeAttachVMDevice:     verterapb.TaskType_TASK_TYPE_ATTACH_VM_DEVICE,
+	tasks.TypeDetachVMDevice:     verterapb.TaskType_TASK_TYPE_DETACH_VM_DEVICE,
+	tasks.TypeResizeVM:           verterapb.TaskType_TASK_TYPE_RESIZE_VM,
+	tasks.TypeReleaseVM:          verterapb.TaskType_TASK_TYPE_RELEASE_VM,
+	tasks.TypeUninstallPackages:  verterapb.TaskType_TASK_TYPE_UNINSTALL_PACKAGES,
+	tasks.TypeRollbackPackages:   verterapb.TaskType_TASK_TYPE_ROLLBACK_PACKAGES,
+}
+
+func taskToProto(t *tasks.Task) *verterapb.Task {
+	return &verterapb.Task{Id: t.ID, HostId: t.HostID, Type: taskTypes[t.Type], Params: t.Params}
+}
+
 // Run starts the gRPC server on addr (e.g., ":9090").
 func Run(addr string) error {
 	lis, err := net.Listen("tcp", addr)
@@ -0,0 +1,76 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+)
+
+// metricFamily is one metric in the Prometheus text format.
+type metricFamily struct {
+	help, typ string
+	samples   []string
+}
+
+// serveMetrics renders the newest sample of every VM in the Prometheus text
+// exposition format, labelled by VM, host and project.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	families := map[string]*metricFamily{}
+	add := func(name, typ, help, labels string, value any) {
+		f, ok := families[name]
+		if !ok {
+			f = &metricFamily{help: help, typ: typ}
+			families[name] = f
+		}
+		f.samples = append(f.samples, fmt.Sprintf("%s{%s} %v", name, labels, value))
+	}
+	for _, s := range stores.Default.LatestVMMetrics() {
+		labels := fmt.Sprintf(`vm_id="%s",host_id="%s",project_id="%s"`, escapeLabel(s.VMID), escapeLabel(s.HostID), escapeLabel(s.ProjectID))
+		add("vertera_vm_cpu_seconds_total", "counter", "CPU time used by the VM's VMM process.", labels, s.CPUSeconds)
+		if s.CPUUsage != nil {
+			add("vertera_vm_cpu_usage", "gauge", "CPUs used by the VM's VMM process between its last two samples.", labels, *s.CPUUsage)
+		}
+		add("vertera_vm_memory_rss_bytes", "gauge", "Resident memory of the VM's VMM process.", labels, s.MemoryRSSBytes)
+		for dev, counters := range s.Counters {
+			for counter, v := range counters {
+				add("vertera_vm_device_"+metricName(counter)+"_total", "counter", "Cloud Hypervisor device counter "+counter+".",
+					labels+fmt.Sprintf(`,device="%s"`, escapeLabel(dev)), v)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		f := families[name]
+		sort.Strings(f.samples)
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.typ)
+		for _, s := range f.samples {
+			b.WriteString(s)
+			b.WriteByte('\n')
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// metricName turns a counter name into a valid metric name part.
+func metricName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -24,6 +24,9 @@ func NewServer() http.Handler {
 	// Root-level docs: redirect to Swagger UI for v1
 	r.Get("/docs", serveRootDocs)
 
+	// Prometheus scrape endpoint for VM metrics
+	r.Get("/metrics", serveMetrics)
+
 	// Default 404: nudge callers toward versioned paths
 	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
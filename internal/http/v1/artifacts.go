@@ -0,0 +1,71 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/go-chi/chi/v5"
+)
+
+// listArtifacts handles GET /artifacts
+func listArtifacts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	items := stores.Default.ListArtifacts(stores.ArtifactFilter{
+		Type:    stores.ArtifactType(q.Get("type")),
+		Channel: stores.ArtifactChannel(q.Get("channel")),
+		Version: q.Get("version"),
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// createArtifact handles POST /artifacts
+func createArtifact(w http.ResponseWriter, r *http.Request) {
+	var req stores.ArtifactCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	a, err := stores.Default.CreateArtifact(req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/artifacts/%s", a.ID))
+	writeJSON(w, http.StatusCreated, a)
+}
+
+// getArtifact handles GET /artifacts/{artifactId}
+func getArtifact(w http.ResponseWriter, r *http.Request) {
+	a, err := stores.Default.GetArtifact(chi.URLParam(r, "artifactId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+// updateArtifact handles PATCH /artifacts/{artifactId}
+func updateArtifact(w http.ResponseWriter, r *http.Request) {
+	var req stores.ArtifactUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	a, err := stores.Default.UpdateArtifact(chi.URLParam(r, "artifactId"), req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+// deleteArtifact handles DELETE /artifacts/{artifactId}
+func deleteArtifact(w http.ResponseWriter, r *http.Request) {
+	if err := stores.Default.DeleteArtifact(chi.URLParam(r, "artifactId")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,109 @@
+package v1_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	httpserver "github.com/VerteraIO/vertera/internal/http"
+	"github.com/VerteraIO/vertera/internal/packages"
+)
+
+// registerArtifact registers an artifact file whose digest is made of fill.
+func registerArtifact(t *testing.T, baseURL, typ, version, channel, osVersion, name string, fill byte) stores.Artifact {
+	t.Helper()
+	body := `{"type":"` + typ + `","version":"` + version + `","channel":"` + channel + `","osVersion":"` + osVersion +
+		`","name":"` + name + `","url":"https://releases.example.com/` + name + `","digest":"sha256:` + strings.Repeat(string(fill), 64) + `"}`
+	var a stores.Artifact
+	decodeBody(t, postJSON(t, baseURL+"/api/v1/artifacts", body), http.StatusCreated, &a)
+	return a
+}
+
+func TestArtifactRegistry(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+	base := ts.URL + "/api/v1/artifacts"
+
+	decodeBody(t, postJSON(t, base, `{"type":"ovs","version":"9.1.0","name":"ovs.rpm","url":"https://example.com/ovs.rpm","digest":"md5:abc"}`), http.StatusBadRequest, nil)
+	decodeBody(t, postJSON(t, base, `{"type":"kernel","version":"1","name":"k.rpm","url":"https://example.com/k.rpm","digest":"sha256:`+strings.Repeat("a", 64)+`"}`), http.StatusBadRequest, nil)
+	decodeBody(t, postJSON(t, base, `{"type":"ovs","version":"9.1.0","name":"../ovs.rpm","url":"https://example.com/ovs.rpm","digest":"sha256:`+strings.Repeat("a", 64)+`"}`), http.StatusBadRequest, nil)
+
+	decodeBody(t, postJSON(t, base, `{"type":"ovs","version":"9.1.0","osVersion":"rhel8","name":"ovs.rpm","digest":"sha256:`+strings.Repeat("a", 64)+`"}`), http.StatusBadRequest, nil)
+	decodeBody(t, postJSON(t, base, `{"type":"ovs","version":"9.1.0","osVersion":"ubuntu24.04","name":"ovs.rpm","digest":"sha256:`+strings.Repeat("a", 64)+`"}`), http.StatusBadRequest, nil)
+
+	registerArtifact(t, ts.URL, "ovs", "9.1.0", "", "el8", "openvswitch-9.1.0-1.el8.x86_64.rpm", 'a')
+	decodeBody(t, postJSON(t, base, `{"type":"ovs","version":"9.1.0","osVersion":"el8","name":"python3-openvswitch-9.1.0-1.el8.noarch.rpm","optional":true,"url":"https://example.com/py.rpm","digest":"sha256:`+strings.Repeat("b", 64)+`"}`), http.StatusCreated, nil)
+	registerArtifact(t, ts.URL, "ovs", "9.1.0", "", "fc40", "openvswitch-9.1.0-1.fc40.x86_64.rpm", 'c')
+	candidate := registerArtifact(t, ts.URL, "ovs", "9.10.0", "candidate", "el8", "openvswitch-9.10.0-1.el8.x86_64.rpm", 'd')
+	decodeBody(t, postJSON(t, base, `{"type":"ovs","version":"9.1.0","osVersion":"el8","name":"openvswitch-9.1.0-1.el8.x86_64.rpm","url":"https://example.com/ovs.rpm","digest":"sha256:`+strings.Repeat("e", 64)+`"}`), http.StatusConflict, nil)
+
+	// Newest version first, compared numerically
+	var list struct {
+		Items []stores.Artifact `json:"items"`
+	}
+	resp, err := http.Get(base + "?type=ovs&channel=candidate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &list)
+	if len(list.Items) == 0 || list.Items[0].ID != candidate.ID || list.Items[0].Digest != "sha256:"+strings.Repeat("d", 64) {
+		t.Fatalf("unexpected artifacts: %+v", list.Items)
+	}
+
+	// Package info resolves the newest stable release from the registry
+	var info struct {
+		Packages []packages.PackageInfo `json:"packages"`
+	}
+	resp, err = http.Get(ts.URL + "/api/v1/packages/info?type=ovs&os_version=el8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &info)
+	if len(info.Packages) != 2 || info.Packages[0].Version != "9.1.0" || !info.Packages[0].Required || info.Packages[1].Required {
+		t.Fatalf("unexpected package info: %+v", info.Packages)
+	}
+	if info.Packages[0].URL != "https://releases.example.com/openvswitch-9.1.0-1.el8.x86_64.rpm" {
+		t.Fatalf("package url not taken from the registry: %s", info.Packages[0].URL)
+	}
+	resp, err = http.Get(ts.URL + "/api/v1/packages/info?type=ovs&version=9.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusBadRequest, nil)
+	resp, err = http.Get(ts.URL + "/api/v1/packages/info?type=cloud-hypervisor&os_version=el8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusNotFound, nil)
+
+	// Promoting the candidate makes it the release hosts get
+	req, _ := http.NewRequest(http.MethodPatch, base+"/"+candidate.ID, bytes.NewBufferString(`{"channel":"stable"}`))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, nil)
+	resp, err = http.Get(ts.URL + "/api/v1/packages/info?type=ovs&os_version=el8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &info)
+	if len(info.Packages) != 1 || info.Packages[0].Version != "9.10.0" {
+		t.Fatalf("expected promoted release, got %+v", info.Packages)
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, base+"/"+candidate.ID, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusNoContent, nil)
+	resp, err = http.Get(base + "/" + candidate.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusNotFound, nil)
+}
@@ -0,0 +1,76 @@
+package v1_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/dispatch"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+	httpserver "github.com/VerteraIO/vertera/internal/http"
+)
+
+func TestVmCloudInit(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+	host := addReadyHost(t, ts.URL, "c-ci", "ci-a", 8)
+
+	var vm stores.VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+host.ID+`","name":"ci-1","vcpus":1,"memoryMiB":512,
+		"cloudInit":{"userData":"#cloud-config\nssh_authorized_keys: [ssh-ed25519 AAAA]\n","networkConfig":"version: 2\n"}}`), http.StatusCreated, &vm)
+	if vm.CloudInit == nil || !strings.HasPrefix(vm.CloudInit.UserData, "#cloud-config") {
+		t.Fatalf("cloud-init not stored: %+v", vm.CloudInit)
+	}
+	vmURL := ts.URL + "/api/v1/vms/" + vm.ID
+
+	// The create task carries the data for the agent's seed image
+	pending := dispatch.Default.DrainPending(host.ID)
+	var create tasks.CreateVMParams
+	if err := json.Unmarshal(pending[0].Params, &create); err != nil || create.Spec.CloudInit == nil || create.Spec.CloudInit.NetworkConfig != "version: 2\n" {
+		t.Fatalf("unexpected create params: %+v %v", create.Spec.CloudInit, err)
+	}
+
+	put := func(body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPut, vmURL+"/cloud-init", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	decodeBody(t, put(`{"userData":"#cloud-config\n"}`), http.StatusConflict, nil)
+	finishTask(t, *pending[0], "")
+
+	// Updating the data regenerates the seed on the host
+	var task tasks.Task
+	decodeBody(t, put(`{"userData":"#cloud-config\nhostname: ci-one\n","metaData":"instance-id: ci-1-v2\n"}`), http.StatusAccepted, &task)
+	var p tasks.UpdateVMCloudInitParams
+	if err := json.Unmarshal(task.Params, &p); err != nil || task.Type != tasks.TypeUpdateVMCloudInit || p.VMID != vm.ID || p.CloudInit.MetaData != "instance-id: ci-1-v2\n" {
+		t.Fatalf("unexpected update task: %+v %v", task, err)
+	}
+	if got := fetchVm(t, vmURL, http.StatusOK); got.CloudInit.MetaData != "instance-id: ci-1-v2\n" || got.CloudInit.NetworkConfig != "" {
+		t.Fatalf("cloud-init not replaced: %+v", got.CloudInit)
+	}
+	decodeBody(t, put(`{"userData":"`+strings.Repeat("x", 1<<20+1)+`"}`), http.StatusBadRequest, nil)
+
+	req, _ := http.NewRequest(http.MethodDelete, vmURL+"/cloud-init", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusAccepted, &task)
+	var removal tasks.UpdateVMCloudInitParams
+	if err := json.Unmarshal(task.Params, &removal); err != nil || removal.CloudInit != nil {
+		t.Fatalf("expected removal task, got %+v %v", removal, err)
+	}
+	if got := fetchVm(t, vmURL, http.StatusOK); got.CloudInit != nil || got.State != stores.VMStateRunning {
+		t.Fatalf("unexpected vm after removal: %+v", got)
+	}
+	if n := len(dispatch.Default.DrainPending(host.ID)); n != 2 {
+		t.Fatalf("expected two update tasks, got %d", n)
+	}
+}
@@ -0,0 +1,87 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/capacity"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/go-chi/chi/v5"
+)
+
+// listClusters handles GET /clusters
+func listClusters(w http.ResponseWriter, r *http.Request) {
+	items := stores.Default.ListClusters(r.URL.Query().Get("projectId"))
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// createCluster handles POST /clusters
+func createCluster(w http.ResponseWriter, r *http.Request) {
+	var req stores.ClusterCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	c, err := stores.Default.CreateCluster(req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/clusters/%s", c.ID))
+	writeJSON(w, http.StatusCreated, c)
+}
+
+// getCluster handles GET /clusters/{clusterId}
+func getCluster(w http.ResponseWriter, r *http.Request) {
+	c, err := stores.Default.GetCluster(chi.URLParam(r, "clusterId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// updateCluster handles PATCH /clusters/{clusterId}
+func updateCluster(w http.ResponseWriter, r *http.Request) {
+	var req stores.ClusterUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	c, err := stores.Default.UpdateCluster(chi.URLParam(r, "clusterId"), req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// deleteCluster handles DELETE /clusters/{clusterId}
+func deleteCluster(w http.ResponseWriter, r *http.Request) {
+	if err := stores.Default.DeleteCluster(chi.URLParam(r, "clusterId")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getClusterCapacity handles GET /clusters/{clusterId}/capacity
+func getClusterCapacity(w http.ResponseWriter, r *http.Request) {
+	c, err := capacity.ForCluster(stores.Default, chi.URLParam(r, "clusterId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// getHostCapacity handles GET /hosts/{hostId}/capacity
+func getHostCapacity(w http.ResponseWriter, r *http.Request) {
+	h, err := capacity.HostFor(stores.Default, chi.URLParam(r, "hostId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h)
+}
@@ -0,0 +1,68 @@
+package v1_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/capacity"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	httpserver "github.com/VerteraIO/vertera/internal/http"
+)
+
+func TestClusterCapacity(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/clusters", `{"projectId":"p1","name":"bad","capacityPolicy":{"cpuOvercommit":0.5,"memoryOvercommit":1}}`), http.StatusBadRequest, nil)
+	var c stores.Cluster
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/clusters", `{"projectId":"p1","name":"capacity","capacityPolicy":{"cpuOvercommit":4,"memoryOvercommit":1,"reservedVcpus":2,"reservedMemoryMiB":1024}}`), http.StatusCreated, &c)
+
+	// 8 CPUs with 2 reserved and 4x overcommit leave 24 allocatable vCPUs;
+	// 8 GiB with 1 GiB reserved leave 7 GiB.
+	h := addReadyHost(t, ts.URL, c.ID, "capacity-a", 8)
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","clusterId":"`+c.ID+`","name":"wide","vcpus":20,"memoryMiB":4096,"disks":[{"sizeGiB":10}]}`), http.StatusCreated, nil)
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","clusterId":"`+c.ID+`","name":"too-wide","vcpus":8,"memoryMiB":512}`), http.StatusConflict, nil)
+
+	var cc capacity.Cluster
+	resp, err := http.Get(ts.URL + "/api/v1/clusters/" + c.ID + "/capacity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &cc)
+	want := capacity.Resources{Vcpus: 24, MemoryMiB: 7168}
+	if len(cc.Hosts) != 1 || cc.Allocatable != want || cc.Used.Vcpus != 20 || cc.Used.DiskGiB != 10 || cc.Free.Vcpus != 4 || cc.Free.MemoryMiB != 3072 {
+		t.Fatalf("unexpected capacity: %+v", cc)
+	}
+
+	// Tightening the policy leaves the host overcommitted
+	req, _ := http.NewRequest(http.MethodPatch, ts.URL+"/api/v1/clusters/"+c.ID, bytes.NewBufferString(`{"capacityPolicy":{"cpuOvercommit":2,"memoryOvercommit":1}}`))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &c)
+	var hc capacity.Host
+	resp, err = http.Get(ts.URL + "/api/v1/hosts/" + h.ID + "/capacity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &hc)
+	if hc.Allocatable.Vcpus != 16 || hc.Free.Vcpus != -4 || hc.Reserved.MemoryMiB != 0 {
+		t.Fatalf("unexpected host capacity: %+v", hc)
+	}
+
+	// Clusters with hosts cannot be deleted; unknown clusters have no capacity
+	req, _ = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/clusters/"+c.ID, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusConflict, nil)
+	resp, err = http.Get(ts.URL + "/api/v1/clusters/does-not-exist/capacity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusNotFound, nil)
+}
@@ -0,0 +1,70 @@
+package v1
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/net/websocket"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/consoles"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+)
+
+// vmConsole handles GET /vms/{vmId}/console
+//
+// The request is upgraded to a WebSocket once the host's agent attached to the
+// VM's serial console. The first message holds the recent console history;
+// after that, messages from the client are typed into the console and console
+// output is sent back as binary messages.
+func vmConsole(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		http.Error(w, "console requires a websocket upgrade", http.StatusUpgradeRequired)
+		return
+	}
+	vmID := chi.URLParam(r, "vmId")
+	sess, err := stores.Default.StartConsoleSession(vmID, r.RemoteAddr, r.UserAgent())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	log.Printf("console: session %s for vm %s opened from %s", sess.ID, vmID, sess.RemoteAddr)
+	conn, err := consoles.Default.Open(r.Context(), sess.HostID, sess.ID, vmID)
+	if err != nil {
+		_ = stores.Default.EndConsoleSession(vmID, sess.ID, 0, 0, err.Error())
+		log.Printf("console: session %s for vm %s failed: %v", sess.ID, vmID, err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer conn.Close()
+
+	// The handshake is not origin-checked: like the rest of the API, the
+	// console relies on the network in front of the controller.
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.BinaryFrame
+		var in int64
+		done := make(chan struct{})
+		go func() {
+			in, _ = io.Copy(conn, ws)
+			conn.Close()
+			close(done)
+		}()
+		out, _ := io.Copy(ws, conn)
+		ws.Close()
+		<-done
+		_ = stores.Default.EndConsoleSession(vmID, sess.ID, in, out, "")
+		log.Printf("console: session %s for vm %s closed (%d bytes in, %d out)", sess.ID, vmID, in, out)
+	}}.ServeHTTP(w, r)
+}
+
+// listVmConsoleSessions handles GET /vms/{vmId}/console/sessions
+func listVmConsoleSessions(w http.ResponseWriter, r *http.Request) {
+	items, err := stores.Default.ListConsoleSessions(chi.URLParam(r, "vmId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
@@ -0,0 +1,112 @@
+package v1_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/consoles"
+	"github.com/VerteraIO/vertera/internal/controlplane/dispatch"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	httpserver "github.com/VerteraIO/vertera/internal/http"
+)
+
+func TestVmConsole(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+	host := addReadyHost(t, ts.URL, "c-con", "con-a", 8)
+
+	var vm stores.VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+host.ID+`","name":"con-1","vcpus":1,"memoryMiB":512}`), http.StatusCreated, &vm)
+	vmURL := ts.URL + "/api/v1/vms/" + vm.ID
+	wsURL := "ws" + strings.TrimPrefix(vmURL, "http") + "/console"
+	if _, err := websocket.Dial(wsURL, "", ts.URL); err == nil {
+		t.Fatal("expected the console of a creating vm to be refused")
+	}
+	finishTask(t, *dispatch.Default.DrainPending(host.ID)[0], "")
+
+	resp, err := http.Get(vmURL + "/console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusUpgradeRequired, nil)
+	if _, err := websocket.Dial(wsURL, "", ts.URL); err == nil {
+		t.Fatal("expected the console to be unavailable without an agent")
+	}
+
+	// Play the host's agent: send the history, then echo input in upper case
+	reqs, unsubscribe := consoles.Default.Subscribe(host.ID)
+	defer unsubscribe()
+	go func() {
+		for req := range reqs {
+			conn, err := consoles.Default.Attach(req.SessionID)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = conn.Write([]byte("con-1 login: "))
+				buf := make([]byte, 64)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					_, _ = conn.Write(bytes.ToUpper(buf[:n]))
+				}
+			}()
+		}
+	}()
+
+	ws, err := websocket.Dial(wsURL, "", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(want string) {
+		t.Helper()
+		_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(ws, got); err != nil || string(got) != want {
+			t.Fatalf("got %q, %v; want %q", got, err, want)
+		}
+	}
+	read("con-1 login: ")
+	if _, err := ws.Write([]byte("root\n")); err != nil {
+		t.Fatal(err)
+	}
+	read("ROOT\n")
+	ws.Close()
+
+	// Every access is audited, including the refused one
+	var list struct {
+		Items []stores.ConsoleSession `json:"items"`
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp, err := http.Get(vmURL + "/console/sessions")
+		if err != nil {
+			t.Fatal(err)
+		}
+		decodeBody(t, resp, http.StatusOK, &list)
+		if len(list.Items) == 2 && list.Items[0].EndedAt != nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(list.Items) != 2 {
+		t.Fatalf("expected two sessions, got %+v", list.Items)
+	}
+	if s := list.Items[0]; s.EndedAt == nil || s.BytesIn != 5 || s.BytesOut != 18 || s.Error != "" || s.HostID != host.ID {
+		t.Fatalf("unexpected session: %+v", s)
+	}
+	if s := list.Items[1]; s.Error == "" || s.EndedAt == nil {
+		t.Fatalf("expected the refused session to record its error: %+v", s)
+	}
+}
@@ -0,0 +1,19 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+)
+
+// listVmCrashes handles GET /vms/{vmId}/crashes
+func listVmCrashes(w http.ResponseWriter, r *http.Request) {
+	items, err := stores.Default.ListVMCrashes(chi.URLParam(r, "vmId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
@@ -0,0 +1,81 @@
+package v1_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/dispatch"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+	httpserver "github.com/VerteraIO/vertera/internal/http"
+)
+
+func TestVmRestartPolicyAndCrashes(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+	host := addReadyHost(t, ts.URL, "c-crash", "crash-a", 4)
+	other := addReadyHost(t, ts.URL, "c-crash", "crash-b", 4)
+
+	resp := postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+host.ID+`","name":"crash-bad","vcpus":1,"memoryMiB":512,"restartPolicy":{"policy":"sometimes"}}`)
+	decodeBody(t, resp, http.StatusBadRequest, nil)
+
+	// Without a policy the VM is never restarted
+	var plain stores.VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+host.ID+`","name":"crash-0","vcpus":1,"memoryMiB":512}`), http.StatusCreated, &plain)
+	if plain.RestartPolicy.Policy != stores.RestartNever {
+		t.Fatalf("unexpected default policy: %+v", plain.RestartPolicy)
+	}
+
+	// A restarting policy gets default limits and reaches the agent in the spec
+	var vm stores.VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+host.ID+`","name":"crash-1","vcpus":1,"memoryMiB":512,"restartPolicy":{"policy":"on-failure"}}`), http.StatusCreated, &vm)
+	if p := vm.RestartPolicy; p.Policy != stores.RestartOnFailure || p.MaxRestarts != 3 || p.BackoffSeconds != 5 {
+		t.Fatalf("unexpected policy: %+v", p)
+	}
+	for _, task := range dispatch.Default.DrainPending(host.ID) {
+		var create tasks.CreateVMParams
+		if err := json.Unmarshal(task.Params, &create); err != nil {
+			t.Fatal(err)
+		}
+		switch create.Spec.ID {
+		case plain.ID:
+			if create.Spec.Restart != nil {
+				t.Fatalf("unexpected restart policy in spec: %+v", create.Spec.Restart)
+			}
+		case vm.ID:
+			if r := create.Spec.Restart; r == nil || r.Policy != "on-failure" || r.MaxRestarts != 3 {
+				t.Fatalf("unexpected restart policy in spec: %+v", r)
+			}
+		}
+		finishTask(t, *task, "")
+	}
+
+	// Crashes are listed newest first; reports from another host are ignored
+	if err := stores.Default.RecordVMCrash(vm.ID, host.ID, stores.VMCrash{ExitCode: -1, Restarted: true, Restarts: 1, ConsoleTail: "Kernel panic"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores.Default.RecordVMCrash(vm.ID, other.ID, stores.VMCrash{ExitCode: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores.Default.RecordVMCrash(vm.ID, host.ID, stores.VMCrash{ExitCode: 1, Restarts: 3, Error: "exit status 1"}); err != nil {
+		t.Fatal(err)
+	}
+	var list struct {
+		Items []stores.VMCrash `json:"items"`
+	}
+	resp, err := http.Get(ts.URL + "/api/v1/vms/" + vm.ID + "/crashes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &list)
+	if len(list.Items) != 2 || list.Items[0].ExitCode != 1 || list.Items[0].Restarted || list.Items[1].ConsoleTail != "Kernel panic" || list.Items[1].HostID != host.ID {
+		t.Fatalf("unexpected crashes: %+v", list.Items)
+	}
+	resp, err = http.Get(ts.URL + "/api/v1/vms/missing/crashes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusNotFound, nil)
+}
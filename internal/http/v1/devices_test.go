@@ -0,0 +1,114 @@
+package v1_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/dispatch"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+	httpserver "github.com/VerteraIO/vertera/internal/http"
+)
+
+func TestVmDeviceHotplug(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+
+	var dvs stores.Dvs
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/dvs", `{"clusterId":"c-hp","name":"hpnet"}`), http.StatusCreated, &dvs)
+	var pg stores.PortGroup
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/dvs/"+dvs.ID+"/port-groups", `{"name":"hp-db","vlanMode":"access","vlanId":60}`), http.StatusCreated, &pg)
+	host := addReadyHost(t, ts.URL, "c-hp", "hp-a", 8)
+	inv := stores.Inventory{CPU: stores.InventoryCPU{Sockets: 1, Cores: 8, Threads: 1}, Memory: stores.InventoryMemory{Total: 8 << 30},
+		Storage: &stores.InventoryStorage{Path: "/var/lib/vertera", Total: 100 << 30, Free: 90 << 30}}
+	if err := stores.Default.RecordInventory(host.ID, inv); err != nil {
+		t.Fatal(err)
+	}
+
+	var vm stores.VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+host.ID+`","name":"hp-1","vcpus":1,"memoryMiB":512,
+		"disks":[{"sizeGiB":10}]}`), http.StatusCreated, &vm)
+	if vm.Disks[0].ID != "disk0" {
+		t.Fatalf("expected a disk id, got %+v", vm.Disks)
+	}
+	vmURL := ts.URL + "/api/v1/vms/" + vm.ID
+	decodeBody(t, postJSON(t, vmURL+"/disks", `{"sizeGiB":5}`), http.StatusConflict, nil)
+	finishTask(t, *dispatch.Default.DrainPending(host.ID)[0], "")
+	del := func(url string, status int, v any) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodDelete, url, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decodeBody(t, resp, status, v)
+	}
+
+	// Attaching a disk adds it to the VM and asks the host to hot-plug it
+	decodeBody(t, postJSON(t, vmURL+"/disks", `{"sizeGiB":0}`), http.StatusBadRequest, nil)
+	decodeBody(t, postJSON(t, vmURL+"/disks", `{"sizeGiB":95}`), http.StatusConflict, nil)
+	var task tasks.Task
+	decodeBody(t, postJSON(t, vmURL+"/disks", `{"sizeGiB":5}`), http.StatusAccepted, &task)
+	var attach tasks.AttachVMDeviceParams
+	if err := json.Unmarshal(task.Params, &attach); err != nil || task.Type != tasks.TypeAttachVMDevice || attach.Disk == nil || attach.Disk.ID != "disk1" || attach.Nic != nil {
+		t.Fatalf("unexpected attach task: %+v %v", task, err)
+	}
+	if got := fetchVm(t, vmURL, http.StatusOK); len(got.Disks) != 2 || got.Disks[1].ID != "disk1" || got.State != stores.VMStateRunning {
+		t.Fatalf("disk not added: %+v", got)
+	}
+	finishTask(t, task, "")
+
+	// A NIC gets a port on its port group, which stays referenced while attached
+	decodeBody(t, postJSON(t, vmURL+"/nics", `{"portGroup":"missing"}`), http.StatusNotFound, nil)
+	decodeBody(t, postJSON(t, vmURL+"/nics", `{"portGroup":"hp-db"}`), http.StatusAccepted, &task)
+	attach = tasks.AttachVMDeviceParams{}
+	if err := json.Unmarshal(task.Params, &attach); err != nil || attach.Nic == nil || attach.Nic.ID != "net0" || attach.Nic.Port.Tag != 60 || attach.Nic.MAC == "" {
+		t.Fatalf("unexpected attach task: %+v %v", attach, err)
+	}
+	finishTask(t, task, "")
+	pgURL := ts.URL + "/api/v1/dvs/" + dvs.ID + "/port-groups/" + pg.ID
+	del(pgURL, http.StatusConflict, nil)
+
+	// A failed attach drops the device again without touching the VM
+	decodeBody(t, postJSON(t, vmURL+"/nics", `{"portGroup":"hp-db"}`), http.StatusAccepted, &task)
+	finishTask(t, task, "hot-plug refused")
+	if got := fetchVm(t, vmURL, http.StatusOK); len(got.Nets) != 1 || got.State != stores.VMStateRunning {
+		t.Fatalf("failed nic not dropped: %+v", got)
+	}
+
+	// Detaching removes the device once the host unplugged it
+	del(vmURL+"/disks/net0", http.StatusNotFound, nil)
+	del(vmURL+"/nics/net9", http.StatusNotFound, nil)
+	del(vmURL+"/nics/net0", http.StatusAccepted, &task)
+	var detach tasks.DetachVMDeviceParams
+	if err := json.Unmarshal(task.Params, &detach); err != nil || task.Type != tasks.TypeDetachVMDevice || detach.DeviceID != "net0" {
+		t.Fatalf("unexpected detach task: %+v %v", task, err)
+	}
+	if got := fetchVm(t, vmURL, http.StatusOK); len(got.Nets) != 1 {
+		t.Fatal("nic removed before the host unplugged it")
+	}
+	finishTask(t, task, "")
+	if got := fetchVm(t, vmURL, http.StatusOK); len(got.Nets) != 0 {
+		t.Fatalf("nic not removed: %+v", got.Nets)
+	}
+	del(vmURL+"/disks/disk0", http.StatusAccepted, &task)
+	finishTask(t, task, "")
+
+	// New devices are numbered after the highest id in use
+	decodeBody(t, postJSON(t, vmURL+"/disks", `{"sizeGiB":1}`), http.StatusAccepted, &task)
+	finishTask(t, task, "")
+	if got := fetchVm(t, vmURL, http.StatusOK); len(got.Disks) != 2 || got.Disks[0].ID != "disk1" || got.Disks[1].ID != "disk2" {
+		t.Fatalf("unexpected disks: %+v", got.Disks)
+	}
+
+	// Devices are fixed while the VM has snapshots
+	var snap stores.VMSnapshot
+	decodeBody(t, postJSON(t, vmURL+"/snapshots", `{"name":"s1"}`), http.StatusCreated, &snap)
+	decodeBody(t, postJSON(t, vmURL+"/disks", `{"sizeGiB":1}`), http.StatusConflict, nil)
+	del(vmURL+"/disks/disk1", http.StatusConflict, nil)
+
+	dispatch.Default.DrainPending(host.ID)
+	del(pgURL, http.StatusNoContent, nil)
+}
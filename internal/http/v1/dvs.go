@@ -0,0 +1,117 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/go-chi/chi/v5"
+)
+
+// listDvs handles GET /dvs
+func listDvs(w http.ResponseWriter, r *http.Request) {
+	items := stores.Default.ListDvs(r.URL.Query().Get("clusterId"))
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// createDvs handles POST /dvs
+func createDvs(w http.ResponseWriter, r *http.Request) {
+	var req stores.DvsCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	d, err := stores.Default.CreateDvs(req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, d)
+}
+
+// getDvs handles GET /dvs/{dvsId}
+func getDvs(w http.ResponseWriter, r *http.Request) {
+	d, err := stores.Default.GetDvs(chi.URLParam(r, "dvsId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// updateDvs handles PATCH /dvs/{dvsId}
+func updateDvs(w http.ResponseWriter, r *http.Request) {
+	var req stores.DvsUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	d, err := stores.Default.UpdateDvs(chi.URLParam(r, "dvsId"), req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// listPortGroups handles GET /dvs/{dvsId}/port-groups
+func listPortGroups(w http.ResponseWriter, r *http.Request) {
+	dvsID := chi.URLParam(r, "dvsId")
+	if _, err := stores.Default.GetDvs(dvsID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	items := stores.Default.ListPortGroups(dvsID)
+	if items == nil {
+		items = []*stores.PortGroup{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// createPortGroup handles POST /dvs/{dvsId}/port-groups
+func createPortGroup(w http.ResponseWriter, r *http.Request) {
+	var req stores.PortGroupCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	dvsID := chi.URLParam(r, "dvsId")
+	if req.DvsID != "" && req.DvsID != dvsID {
+		http.Error(w, "dvsId in body does not match path", http.StatusBadRequest)
+		return
+	}
+	req.DvsID = dvsID
+	pg, err := stores.Default.CreatePortGroup(req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, pg)
+}
+
+// getPortGroup handles GET /dvs/{dvsId}/port-groups/{portGroupId}
+func getPortGroup(w http.ResponseWriter, r *http.Request) {
+	pg, err := stores.Default.GetPortGroup(chi.URLParam(r, "portGroupId"))
+	if err != nil || pg.DvsID != chi.URLParam(r, "dvsId") {
+		http.Error(w, "port group not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, pg)
+}
+
+// deletePortGroup handles DELETE /dvs/{dvsId}/port-groups/{portGroupId}
+// Port groups still referenced by VM NICs are rejected with 409.
+func deletePortGroup(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "portGroupId")
+	pg, err := stores.Default.GetPortGroup(id)
+	if err != nil || pg.DvsID != chi.URLParam(r, "dvsId") {
+		http.Error(w, "port group not found", http.StatusNotFound)
+		return
+	}
+	if err := stores.Default.DeletePortGroup(id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,78 @@
+package v1_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	httpserver "github.com/VerteraIO/vertera/internal/http"
+)
+
+func postJSON(t *testing.T, url, body string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("post %s: %v", url, err)
+	}
+	return resp
+}
+
+func decodeBody(t *testing.T, resp *http.Response, wantStatus int, v any) {
+	t.Helper()
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != wantStatus {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected %d, got %d: %s", wantStatus, resp.StatusCode, string(b))
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+	}
+}
+
+func TestPortGroupLifecycle(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+
+	var dvs stores.Dvs
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/dvs", `{"clusterId":"c-pg","name":"prod"}`), http.StatusCreated, &dvs)
+	if dvs.Bridge != "br-prod" || dvs.MTU != 1500 || dvs.Revision != 1 {
+		t.Fatalf("unexpected dvs: %+v", dvs)
+	}
+	base := ts.URL + "/api/v1/dvs/" + dvs.ID + "/port-groups"
+
+	var pg stores.PortGroup
+	decodeBody(t, postJSON(t, base, `{"name":"db","vlanMode":"trunk","trunkAllowed":["100-120","200"]}`), http.StatusCreated, &pg)
+
+	// Invalid and overlapping VLAN ranges are rejected
+	decodeBody(t, postJSON(t, base, `{"name":"bad","vlanMode":"access","vlanId":4095}`), http.StatusBadRequest, nil)
+	decodeBody(t, postJSON(t, base, `{"name":"bad","vlanMode":"trunk","trunkAllowed":["100-120","110"]}`), http.StatusBadRequest, nil)
+	// Duplicate names on the same DVS conflict
+	decodeBody(t, postJSON(t, base, `{"name":"db","vlanMode":"access","vlanId":10}`), http.StatusConflict, nil)
+
+	// Port groups in use cannot be deleted
+	if err := stores.Default.AcquirePortGroup(pg.ID, "vm/test/nic/0"); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	del := func() int {
+		req, _ := http.NewRequest(http.MethodDelete, base+"/"+pg.ID, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := del(); code != http.StatusConflict {
+		t.Fatalf("expected 409 deleting in-use port group, got %d", code)
+	}
+	stores.Default.ReleasePortGroup(pg.ID, "vm/test/nic/0")
+	if code := del(); code != http.StatusNoContent {
+		t.Fatalf("expected 204 after release, got %d", code)
+	}
+}
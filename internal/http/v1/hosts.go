@@ -0,0 +1,145 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/ha"
+	"github.com/VerteraIO/vertera/internal/controlplane/maintenance"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/go-chi/chi/v5"
+)
+
+// listHosts handles GET /hosts
+func listHosts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	items := stores.Default.ListHosts(q.Get("projectId"), q.Get("clusterId"))
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// createHost handles POST /hosts
+func createHost(w http.ResponseWriter, r *http.Request) {
+	var req stores.HostCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	h, err := stores.Default.CreateHost(req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/hosts/%s", h.ID))
+	writeJSON(w, http.StatusCreated, h)
+}
+
+// getHost handles GET /hosts/{hostId}
+func getHost(w http.ResponseWriter, r *http.Request) {
+	h, err := stores.Default.GetHost(chi.URLParam(r, "hostId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h)
+}
+
+// deleteHost handles DELETE /hosts/{hostId}
+func deleteHost(w http.ResponseWriter, r *http.Request) {
+	if err := stores.Default.DeleteHost(chi.URLParam(r, "hostId")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getLatestInventory handles GET /hosts/{hostId}/inventory/latest
+func getLatestInventory(w http.ResponseWriter, r *http.Request) {
+	inv, err := stores.Default.LatestInventory(chi.URLParam(r, "hostId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, inv)
+}
+
+// listInventory handles GET /hosts/{hostId}/inventory
+func listInventory(w http.ResponseWriter, r *http.Request) {
+	hostID := chi.URLParam(r, "hostId")
+	if _, err := stores.Default.GetHost(hostID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": stores.Default.ListInventory(hostID)})
+}
+
+// drainHost handles POST /hosts/{hostId}/actions/drain
+func drainHost(w http.ResponseWriter, r *http.Request) {
+	var req maintenance.DrainOptions
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	hostID := chi.URLParam(r, "hostId")
+	d, err := maintenance.Default.Drain(hostID, req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/hosts/%s/drain", hostID))
+	writeJSON(w, http.StatusAccepted, d)
+}
+
+// undrainHost handles POST /hosts/{hostId}/actions/undrain
+func undrainHost(w http.ResponseWriter, r *http.Request) {
+	h, err := maintenance.Default.Undrain(chi.URLParam(r, "hostId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h)
+}
+
+// getHostDrain handles GET /hosts/{hostId}/drain
+func getHostDrain(w http.ResponseWriter, r *http.Request) {
+	d, err := stores.Default.GetHostDrain(chi.URLParam(r, "hostId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// confirmHostFenced handles POST /hosts/{hostId}/actions/confirm-fenced. The
+// operator confirms that an unreachable host is down, which starts its
+// failover.
+func confirmHostFenced(w http.ResponseWriter, r *http.Request) {
+	f, err := ha.Default.ConfirmFenced(chi.URLParam(r, "hostId"), stores.FencedByOperator)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, f)
+}
+
+// rejoinHost handles POST /hosts/{hostId}/actions/rejoin
+func rejoinHost(w http.ResponseWriter, r *http.Request) {
+	h, err := ha.Default.Rejoin(chi.URLParam(r, "hostId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h)
+}
+
+// getHostFailover handles GET /hosts/{hostId}/failover
+func getHostFailover(w http.ResponseWriter, r *http.Request) {
+	f, err := stores.Default.GetHostFailover(chi.URLParam(r, "hostId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, f)
+}
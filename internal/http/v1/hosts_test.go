@@ -0,0 +1,226 @@
+package v1_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/dispatch"
+	"github.com/VerteraIO/vertera/internal/controlplane/ha"
+	"github.com/VerteraIO/vertera/internal/controlplane/scheduler"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+	httpserver "github.com/VerteraIO/vertera/internal/http"
+)
+
+// addReadyHost registers a host through the API, then plays its agent:
+// it connects and reports an inventory with the given vCPUs and 8 GiB memory.
+func addReadyHost(t *testing.T, baseURL, clusterID, hostname string, cpus int) stores.Host {
+	t.Helper()
+	var h stores.Host
+	decodeBody(t, postJSON(t, baseURL+"/api/v1/hosts", `{"projectId":"p1","clusterId":"`+clusterID+`","hostname":"`+hostname+`"}`), http.StatusCreated, &h)
+	if _, err := stores.Default.RegisterAgentHost("", hostname); err != nil {
+		t.Fatal(err)
+	}
+	inv := stores.Inventory{CPU: stores.InventoryCPU{Sockets: 1, Cores: cpus, Threads: 1}, Memory: stores.InventoryMemory{Total: 8 << 30}}
+	if err := stores.Default.RecordInventory(h.ID, inv); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHostsAndScheduledPlacement(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/hosts", `{"projectId":"p1"}`), http.StatusBadRequest, nil)
+	var hosts [2]stores.Host
+	for i, name := range []string{"sched-a", "sched-b"} {
+		decodeBody(t, postJSON(t, ts.URL+"/api/v1/hosts", `{"projectId":"p1","clusterId":"c-sched","hostname":"`+name+`"}`), http.StatusCreated, &hosts[i])
+		if hosts[i].State != stores.HostStateEnrolled {
+			t.Fatalf("expected enrolled host, got %+v", hosts[i])
+		}
+	}
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/hosts", `{"projectId":"p1","hostname":"sched-a"}`), http.StatusConflict, nil)
+
+	// Enrolled hosts without inventory are not schedulable
+	var d scheduler.Decision
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/scheduler/preview", `{"projectId":"p1","clusterId":"c-sched","vcpus":1,"memoryMiB":512}`), http.StatusOK, &d)
+	if d.HostID != "" || len(d.Rejected) == 0 {
+		t.Fatalf("expected no placement, got %+v", d)
+	}
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","clusterId":"c-sched","name":"sched-1","vcpus":1,"memoryMiB":512}`), http.StatusConflict, nil)
+
+	// Agents connect and report inventory; sched-b has more room
+	for i, cpus := range []int{2, 8} {
+		if _, err := stores.Default.RegisterAgentHost("", hosts[i].Hostname); err != nil {
+			t.Fatal(err)
+		}
+		inv := stores.Inventory{CPU: stores.InventoryCPU{Sockets: 1, Cores: cpus, Threads: 1}, Memory: stores.InventoryMemory{Total: 8 << 30}}
+		if err := stores.Default.RecordInventory(hosts[i].ID, inv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var inv stores.Inventory
+	resp, err := http.Get(ts.URL + "/api/v1/hosts/" + hosts[1].ID + "/inventory/latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &inv)
+	if inv.CPU.Cores != 8 || inv.CollectedAt.IsZero() {
+		t.Fatalf("unexpected inventory: %+v", inv)
+	}
+
+	var vm stores.VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","clusterId":"c-sched","name":"sched-1","vcpus":2,"memoryMiB":512}`), http.StatusCreated, &vm)
+	if vm.HostID != hosts[1].ID || vm.ClusterID != "c-sched" {
+		t.Fatalf("expected vm on sched-b, got %+v", vm)
+	}
+	if pending := dispatch.Default.DrainPending(hosts[1].ID); len(pending) != 1 {
+		t.Fatalf("expected create task for sched-b, got %+v", pending)
+	}
+
+	resp, err = http.Get(ts.URL + "/api/v1/vms/" + vm.ID + "/placement")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &d)
+	if d.VMID != vm.ID || d.HostID != hosts[1].ID || len(d.Ranked) != 2 {
+		t.Fatalf("unexpected placement decision: %+v", d)
+	}
+
+	// sched-a has only 2 vCPUs
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/scheduler/preview", `{"projectId":"p1","clusterId":"c-sched","vcpus":4,"memoryMiB":512}`), http.StatusOK, &d)
+	rejected := ""
+	for _, r := range d.Rejected {
+		if r.HostID == hosts[0].ID {
+			rejected = r.Filter
+		}
+	}
+	if d.HostID != hosts[1].ID || rejected != "resources" {
+		t.Fatalf("expected sched-a rejected for resources, got %+v", d)
+	}
+
+	// Hosts with VMs cannot be evicted
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/hosts/"+hosts[1].ID, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusConflict, nil)
+}
+
+func TestHostDrain(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+
+	h := addReadyHost(t, ts.URL, "c-drain", "drain-a", 8)
+	var vm stores.VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+h.ID+`","name":"drain-vm","vcpus":1,"memoryMiB":512}`), http.StatusCreated, &vm)
+	for _, task := range dispatch.Default.DrainPending(h.ID) {
+		finishTask(t, *task, "")
+	}
+
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/hosts/"+h.ID+"/actions/drain", `{"policy":"evict"}`), http.StatusBadRequest, nil)
+	var d stores.HostDrain
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/hosts/"+h.ID+"/actions/drain", `{"policy":"stop"}`), http.StatusAccepted, &d)
+	if d.State != stores.DrainInProgress || len(d.VMs) != 1 || d.VMs[0].Status != stores.DrainVMInProgress {
+		t.Fatalf("unexpected drain: %+v", d)
+	}
+
+	// The host is cordoned: neither the scheduler nor explicit placement uses it
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+h.ID+`","name":"late","vcpus":1,"memoryMiB":512}`), http.StatusConflict, nil)
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","clusterId":"c-drain","name":"late","vcpus":1,"memoryMiB":512}`), http.StatusConflict, nil)
+
+	// The host only reports drained once the power-off task finished
+	pending := dispatch.Default.DrainPending(h.ID)
+	if len(pending) != 1 || pending[0].Type != tasks.TypePowerVM {
+		t.Fatalf("expected one power task, got %+v", pending)
+	}
+	finishTask(t, *pending[0], "")
+	resp, err := http.Get(ts.URL + "/api/v1/hosts/" + h.ID + "/drain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &d)
+	if d.State != stores.DrainCompleted || d.VMs[0].Status != stores.DrainVMDone {
+		t.Fatalf("expected completed drain, got %+v", d)
+	}
+	if got, _ := stores.Default.GetHost(h.ID); got.State != stores.HostStateDrained {
+		t.Fatalf("expected drained host, got %s", got.State)
+	}
+
+	var back stores.Host
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/hosts/"+h.ID+"/actions/undrain", ``), http.StatusOK, &back)
+	if back.State != stores.HostStateReady {
+		t.Fatalf("expected ready host after undrain, got %s", back.State)
+	}
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/hosts/"+h.ID+"/actions/undrain", ``), http.StatusConflict, nil)
+}
+
+func TestHostFailover(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+
+	var local, shared stores.Cluster
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/clusters", `{"projectId":"p1","name":"ha-local"}`), http.StatusCreated, &local)
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/clusters", `{"projectId":"p1","name":"ha-shared","storage":"shared"}`), http.StatusCreated, &shared)
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/clusters", `{"projectId":"p1","name":"ha-bad","storage":"tape"}`), http.StatusBadRequest, nil)
+	l := addReadyHost(t, ts.URL, local.ID, "ha-local-a", 8)
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+l.ID+`","name":"ha-vm","vcpus":1,"memoryMiB":512,"ha":true}`), http.StatusBadRequest, nil)
+
+	a := addReadyHost(t, ts.URL, shared.ID, "ha-a", 8)
+	b := addReadyHost(t, ts.URL, shared.ID, "ha-b", 8)
+	var vm stores.VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+a.ID+`","name":"ha-vm","vcpus":1,"memoryMiB":512,"ha":true}`), http.StatusCreated, &vm)
+	for _, task := range dispatch.Default.DrainPending(a.ID) {
+		finishTask(t, *task, "")
+	}
+
+	// Only unreachable hosts can be fenced
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/hosts/"+a.ID+"/actions/confirm-fenced", ``), http.StatusConflict, nil)
+	if _, err := stores.Default.MarkHostUnreachable(a.ID, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	var f stores.HostFailover
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/hosts/"+a.ID+"/actions/confirm-fenced", ``), http.StatusAccepted, &f)
+	if f.State != stores.FailoverInProgress || len(f.VMs) != 1 || f.VMs[0].TargetHostID != b.ID {
+		t.Fatalf("unexpected failover: %+v", f)
+	}
+
+	// The VM is re-created on the survivor from its existing disks
+	pending := dispatch.Default.DrainPending(b.ID)
+	if len(pending) != 1 || pending[0].Type != tasks.TypeCreateVM {
+		t.Fatalf("expected one create task, got %+v", pending)
+	}
+	finishTask(t, *pending[0], "")
+	if got := fetchVm(t, ts.URL+"/api/v1/vms/"+vm.ID, http.StatusOK); got.HostID != b.ID || got.State != stores.VMStateRunning {
+		t.Fatalf("expected vm running on the survivor, got %+v", got)
+	}
+	resp, err := http.Get(ts.URL + "/api/v1/hosts/" + a.ID + "/failover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &f)
+	if f.State != stores.FailoverCompleted || f.VMs[0].Status != stores.FailoverVMRecovered {
+		t.Fatalf("expected completed failover, got %+v", f)
+	}
+
+	// The failed host rejoins only after it released the VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/hosts/"+a.ID+"/actions/rejoin", ``), http.StatusConflict, nil)
+	if _, err := ha.Default.Heartbeat(a.ID); err != nil {
+		t.Fatal(err)
+	}
+	pending = dispatch.Default.DrainPending(a.ID)
+	if len(pending) != 1 || pending[0].Type != tasks.TypeReleaseVM {
+		t.Fatalf("expected one release task, got %+v", pending)
+	}
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/hosts/"+a.ID+"/actions/rejoin", ``), http.StatusConflict, nil)
+	finishTask(t, *pending[0], "")
+	var back stores.Host
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/hosts/"+a.ID+"/actions/rejoin", ``), http.StatusOK, &back)
+	if back.State != stores.HostStateReady {
+		t.Fatalf("expected ready host after rejoin, got %s", back.State)
+	}
+}
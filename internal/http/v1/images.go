@@ -0,0 +1,75 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/images"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/go-chi/chi/v5"
+)
+
+// listImages handles GET /images
+func listImages(w http.ResponseWriter, r *http.Request) {
+	items := stores.Default.ListImages(r.URL.Query().Get("projectId"))
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// createImage handles POST /images
+func createImage(w http.ResponseWriter, r *http.Request) {
+	var in stores.ImageCreate
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	img, err := images.Default.Create(in)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/images/%s", img.ID))
+	writeJSON(w, http.StatusCreated, img)
+}
+
+// getImage handles GET /images/{imageId}
+func getImage(w http.ResponseWriter, r *http.Request) {
+	img, err := stores.Default.GetImage(chi.URLParam(r, "imageId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, img)
+}
+
+// deleteImage handles DELETE /images/{imageId}
+func deleteImage(w http.ResponseWriter, r *http.Request) {
+	if err := images.Default.Delete(chi.URLParam(r, "imageId")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadImageFile handles PUT /images/{imageId}/file
+func uploadImageFile(w http.ResponseWriter, r *http.Request) {
+	img, err := images.Default.Upload(chi.URLParam(r, "imageId"), r.Body)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, img)
+}
+
+// downloadImageFile handles GET /images/{imageId}/file
+func downloadImageFile(w http.ResponseWriter, r *http.Request) {
+	f, img, err := images.Default.Open(chi.URLParam(r, "imageId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", `"`+img.SHA256+`"`)
+	http.ServeContent(w, r, img.ID, img.UpdatedAt, f)
+}
@@ -0,0 +1,125 @@
+package v1_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/dispatch"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+	httpserver "github.com/VerteraIO/vertera/internal/http"
+	"github.com/VerteraIO/vertera/internal/vmspec"
+)
+
+func TestImages(t *testing.T) {
+	t.Setenv("VERTERA_IMAGE_DIR", t.TempDir())
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+	host := addReadyHost(t, ts.URL, "c-img", "img-a", 8)
+
+	data := bytes.Repeat([]byte{0xeb, 0x63, 0x90}, 1<<18)
+	sum := sha256.Sum256(data)
+	var img stores.Image
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/images", `{"name":"img-debian","sha256":"nothex"}`), http.StatusBadRequest, nil)
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/images", `{"name":"img-debian","sha256":"`+hex.EncodeToString(sum[:])+`",
+		"os":{"family":"linux","distribution":"debian","version":"12","architecture":"x86_64"}}`), http.StatusCreated, &img)
+	if img.State != stores.ImagePending || img.OS.Distribution != "debian" {
+		t.Fatalf("unexpected image: %+v", img)
+	}
+	imgURL := ts.URL + "/api/v1/images/" + img.ID
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/images", `{"name":"img-debian"}`), http.StatusConflict, nil)
+
+	// Only ready images can be used or downloaded
+	vmBody := `{"projectId":"p1","hostId":"` + host.ID + `","name":"img-vm","vcpus":1,"memoryMiB":512,"disks":[{"sizeGiB":1,"imageId":"` + img.ID + `"}]}`
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", vmBody), http.StatusBadRequest, nil)
+	resp, err := http.Get(imgURL + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusConflict, nil)
+
+	upload := func(body []byte) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPut, imgURL+"/file", bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	decodeBody(t, upload(data[:100]), http.StatusBadRequest, nil)
+	decodeBody(t, upload(data), http.StatusOK, &img)
+	if img.State != stores.ImageReady || img.Format != stores.ImageFormatRaw || img.VirtualSizeBytes != int64(len(data)) {
+		t.Fatalf("unexpected uploaded image: %+v", img)
+	}
+	resp, err = http.Get(imgURL + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(got, data) || resp.Header.Get("ETag") != `"`+img.SHA256+`"` {
+		t.Fatalf("download: %s, %d bytes, etag %s", resp.Status, len(got), resp.Header.Get("ETag"))
+	}
+
+	// VM disks reference the image in their spec
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+host.ID+`","name":"img-vm","vcpus":1,"memoryMiB":512,
+		"disks":[{"sizeGiB":1,"imageId":"`+img.ID+`","clone":"linked"}]}`), http.StatusBadRequest, nil)
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/images", `{"name":"img-other","projectId":"p2"}`), http.StatusCreated, nil)
+	var vm stores.VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+host.ID+`","name":"img-vm","vcpus":1,"memoryMiB":512,
+		"disks":[{"sizeGiB":1,"imageId":"`+img.ID+`"},{"sizeGiB":2,"imageId":"`+img.ID+`","clone":"full"}]}`), http.StatusCreated, &vm)
+	if vm.Disks[0].Clone != stores.DiskCloneCOW || vm.Disks[1].Clone != stores.DiskCloneFull {
+		t.Fatalf("unexpected disks: %+v", vm.Disks)
+	}
+	pending := dispatch.Default.DrainPending(host.ID)
+	var p tasks.CreateVMParams
+	if err := json.Unmarshal(pending[0].Params, &p); err != nil {
+		t.Fatal(err)
+	}
+	want := vmspec.Image{ID: img.ID, Format: "raw", SHA256: img.SHA256}
+	if d := p.Spec.Disks; *d[0].Image != want || d[0].Clone != vmspec.CloneCOW || d[1].Clone != vmspec.CloneFull {
+		t.Fatalf("unexpected disk specs: %+v", d)
+	}
+	finishTask(t, *pending[0], "")
+
+	var list struct {
+		Items []stores.Image `json:"items"`
+	}
+	resp, err = http.Get(ts.URL + "/api/v1/images?projectId=p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &list)
+	for _, i := range list.Items {
+		if i.Name == "img-other" {
+			t.Fatal("listed another project's image")
+		}
+	}
+
+	// Images in use cannot be deleted
+	del := func(url string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodDelete, url, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	decodeBody(t, del(imgURL), http.StatusConflict, nil)
+	decodeBody(t, del(ts.URL+"/api/v1/vms/"+vm.ID), http.StatusAccepted, nil)
+	finishTask(t, *dispatch.Default.DrainPending(host.ID)[0], "")
+	decodeBody(t, del(imgURL), http.StatusNoContent, nil)
+	resp, err = http.Get(imgURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusNotFound, nil)
+}
@@ -0,0 +1,127 @@
+package v1_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/dispatch"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	httpserver "github.com/VerteraIO/vertera/internal/http"
+)
+
+func TestVmMetrics(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+
+	host := addReadyHost(t, ts.URL, "c-met", "met-a", 4)
+	other := addReadyHost(t, ts.URL, "c-met", "met-b", 4)
+	var vm stores.VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p-met","hostId":"`+host.ID+`","name":"met-1","vcpus":1,"memoryMiB":512}`), http.StatusCreated, &vm)
+	finishTask(t, *dispatch.Default.DrainPending(host.ID)[0], "")
+	vmURL := ts.URL + "/api/v1/vms/" + vm.ID
+
+	start := time.Now().UTC().Add(-time.Minute)
+	samples := []stores.VMMetricsSample{
+		{VMID: vm.ID, CollectedAt: start, CPUSeconds: 10, MemoryRSSBytes: 256 << 20},
+		{VMID: "vm-unknown", CollectedAt: start, CPUSeconds: 1},
+	}
+	if err := stores.Default.RecordVMMetrics(host.ID, samples); err != nil {
+		t.Fatal(err)
+	}
+	// Samples from a host the VM does not run on are dropped
+	if err := stores.Default.RecordVMMetrics(other.ID, []stores.VMMetricsSample{{VMID: vm.ID, CollectedAt: start.Add(10 * time.Second), CPUSeconds: 99}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores.Default.RecordVMMetrics("missing", samples); err == nil {
+		t.Fatal("expected samples of an unknown host to be rejected")
+	}
+	samples = []stores.VMMetricsSample{{VMID: vm.ID, CollectedAt: start.Add(30 * time.Second), CPUSeconds: 25, MemoryRSSBytes: 300 << 20,
+		Counters: map[string]map[string]int64{"disk0": {"read_bytes": 4096}, "net0": {"rx_bytes": 100}}}}
+	if err := stores.Default.RecordVMMetrics(host.ID, samples); err != nil {
+		t.Fatal(err)
+	}
+
+	// The VM's window holds its samples oldest first, with the CPU usage in between
+	var list struct {
+		Items []stores.VMMetricsSample `json:"items"`
+	}
+	resp, err := http.Get(vmURL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &list)
+	if len(list.Items) != 2 || list.Items[0].CPUUsage != nil || list.Items[1].CPUUsage == nil || *list.Items[1].CPUUsage != 0.5 {
+		t.Fatalf("unexpected samples: %+v", list.Items)
+	}
+	if s := list.Items[1]; s.HostID != host.ID || s.ProjectID != "p-met" || s.Counters["disk0"]["read_bytes"] != 4096 {
+		t.Fatalf("unexpected sample: %+v", s)
+	}
+	resp, err = http.Get(vmURL + "/metrics?since=" + start.Add(time.Second).Format(time.RFC3339))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &list)
+	if len(list.Items) != 1 {
+		t.Fatalf("since not applied: %+v", list.Items)
+	}
+	resp, err = http.Get(vmURL + "/metrics?since=yesterday")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusBadRequest, nil)
+	resp, err = http.Get(ts.URL + "/api/v1/vms/missing/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusNotFound, nil)
+
+	// Prometheus scrapes the newest sample of each VM
+	resp, err = http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	text := string(body)
+	labels := `vm_id="` + vm.ID + `",host_id="` + host.ID + `",project_id="p-met"`
+	for _, want := range []string{
+		"# TYPE vertera_vm_cpu_seconds_total counter\n",
+		"vertera_vm_cpu_seconds_total{" + labels + "} 25\n",
+		"vertera_vm_cpu_usage{" + labels + "} 0.5\n",
+		"vertera_vm_memory_rss_bytes{" + labels + "} 314572800\n",
+		"vertera_vm_device_read_bytes_total{" + labels + `,device="disk0"} 4096` + "\n",
+		"vertera_vm_device_rx_bytes_total{" + labels + `,device="net0"} 100` + "\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("missing %q in:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "vm-unknown") {
+		t.Fatalf("unknown vm exported:\n%s", text)
+	}
+
+	// A VM whose only sample is older than the window is neither kept nor exported
+	var stale stores.VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p-met","hostId":"`+host.ID+`","name":"met-2","vcpus":1,"memoryMiB":512}`), http.StatusCreated, &stale)
+	finishTask(t, *dispatch.Default.DrainPending(host.ID)[0], "")
+	old := []stores.VMMetricsSample{{VMID: stale.ID, CollectedAt: time.Now().UTC().Add(-2 * time.Hour), CPUSeconds: 1}}
+	if err := stores.Default.RecordVMMetrics(host.ID, old); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := stores.Default.ListVMMetrics(stale.ID, time.Time{}); err != nil || len(got) != 0 {
+		t.Fatalf("stale sample kept: %+v %v", got, err)
+	}
+	resp, err = http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || strings.Contains(string(body), stale.ID) {
+		t.Fatalf("unexpected scrape with a stale sample: %d\n%s", resp.StatusCode, body)
+	}
+}
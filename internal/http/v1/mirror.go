@@ -0,0 +1,53 @@
+package v1
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/mirror"
+)
+
+// importMirrorBundle handles POST /mirror/import
+func importMirrorBundle(w http.ResponseWriter, r *http.Request) {
+	items, err := mirror.Default.Import(r.Body)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// getMirrorFile handles GET /mirror/files/{digest}. Hosts authenticate with
+// the bearer token in VERTERA_MIRROR_TOKEN; the endpoint is disabled
+// without one.
+func getMirrorFile(w http.ResponseWriter, r *http.Request) {
+	token := os.Getenv("VERTERA_MIRROR_TOKEN")
+	if token == "" {
+		http.Error(w, "package mirror disabled: VERTERA_MIRROR_TOKEN not set", http.StatusForbidden)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="vertera-mirror"`)
+		http.Error(w, "invalid mirror token", http.StatusUnauthorized)
+		return
+	}
+	digest := chi.URLParam(r, "digest")
+	f, err := mirror.Default.Open(digest)
+	if errors.Is(err, mirror.ErrUpstream) {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", `"`+digest+`"`)
+	http.ServeContent(w, r, digest, time.Time{}, f)
+}
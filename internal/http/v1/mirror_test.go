@@ -0,0 +1,84 @@
+package v1_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	httpserver "github.com/VerteraIO/vertera/internal/http"
+)
+
+func TestPackageMirror(t *testing.T) {
+	t.Setenv("VERTERA_MIRROR_DIR", t.TempDir())
+	t.Setenv("VERTERA_MIRROR_TOKEN", "")
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+
+	data := []byte("edk2 firmware build")
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	manifest := []byte(`[{"type":"firmware","version":"mirror-1","name":"CLOUDHV.fd","digest":"` + digest + `"}]`)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, body := range map[string][]byte{"manifest.json": manifest, "CLOUDHV.fd": data} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Post(ts.URL+"/api/v1/mirror/import", "application/x-tar", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imported struct {
+		Items []stores.Artifact `json:"items"`
+	}
+	decodeBody(t, res, http.StatusOK, &imported)
+	if len(imported.Items) != 1 || imported.Items[0].Digest != digest || imported.Items[0].URL != "" {
+		t.Fatalf("unexpected import result: %+v", imported.Items)
+	}
+	res, err = http.Post(ts.URL+"/api/v1/mirror/import", "application/x-tar", bytes.NewReader([]byte("not a tarball")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, res, http.StatusBadRequest, nil)
+
+	get := func(token string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/mirror/files/"+digest, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	decodeBody(t, get("secret"), http.StatusForbidden, nil)
+	t.Setenv("VERTERA_MIRROR_TOKEN", "secret")
+	decodeBody(t, get(""), http.StatusUnauthorized, nil)
+	decodeBody(t, get("wrong"), http.StatusUnauthorized, nil)
+
+	res = get("secret")
+	defer res.Body.Close()
+	got, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || !bytes.Equal(got, data) {
+		t.Fatalf("expected the mirrored file, got %s %q", res.Status, got)
+	}
+}
@@ -2,14 +2,17 @@ package v1
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/VerteraIO/vertera/internal/packages"
 	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
 	"github.com/VerteraIO/vertera/internal/controlplane/dispatch"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
 )
 
 // installPackages handles POST /hosts/{hostId}/packages/install
@@ -31,6 +34,26 @@ func installPackages(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "at least one package must be specified", http.StatusBadRequest)
 		return
 	}
+	if err := packages.ValidateOSVersion(req.OSVersion); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.OSVersion == "" {
+		req.OSVersion = hostOSVersion(hostID)
+	}
+
+	// Resolve the packages from the artifact registry so the agent downloads
+	// exactly the registered files.
+	pkgService := packages.NewService(packageCacheDir(), stores.Default)
+	resolved := packages.Resolved{}
+	for _, t := range req.Packages {
+		infos, err := pkgService.GetPackageInfo(t, req.Version, req.OSVersion)
+		if err != nil {
+			writePackageError(w, err)
+			return
+		}
+		resolved[t] = infos
+	}
 
 	// Enqueue a task for the agent via the controller's in-memory task manager.
 	// Convert package types to strings for the task params.
@@ -42,6 +65,7 @@ func installPackages(w http.ResponseWriter, r *http.Request) {
 		Packages:  pkgs,
 		Version:   req.Version,
 		OSVersion: req.OSVersion,
+		Artifacts: resolved,
 	}
 	t, err := tasks.Default.EnqueueInstallPackages(hostID, params)
 	if err != nil {
@@ -59,6 +83,52 @@ func installPackages(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(t)
 }
 
+// uninstallPackages handles POST /hosts/{hostId}/packages/uninstall
+func uninstallPackages(w http.ResponseWriter, r *http.Request) {
+	enqueuePackageTask(w, r, tasks.Default.EnqueueUninstallPackages)
+}
+
+// rollbackPackages handles POST /hosts/{hostId}/packages/rollback
+func rollbackPackages(w http.ResponseWriter, r *http.Request) {
+	enqueuePackageTask(w, r, tasks.Default.EnqueueRollbackPackages)
+}
+
+// enqueuePackageTask validates a {"packages": [...]} body and hands the
+// packages to the host in a task made by enqueue.
+func enqueuePackageTask(w http.ResponseWriter, r *http.Request, enqueue func(string, tasks.PackageTypesParams) (*tasks.Task, error)) {
+	hostID := chi.URLParam(r, "hostId")
+	var req struct {
+		Packages []packages.PackageType `json:"packages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Packages) == 0 {
+		http.Error(w, "at least one package must be specified", http.StatusBadRequest)
+		return
+	}
+	var params tasks.PackageTypesParams
+	for _, t := range req.Packages {
+		if err := t.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		params.Packages = append(params.Packages, string(t))
+	}
+	if _, err := stores.Default.GetHost(hostID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	t, err := enqueue(hostID, params)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to enqueue task: %v", err), http.StatusInternalServerError)
+		return
+	}
+	dispatch.Default.AddPending(hostID, t)
+	writeTaskAccepted(w, t)
+}
+
 // getPackageInfo handles GET /packages/info
 func getPackageInfo(w http.ResponseWriter, r *http.Request) {
 	pkgType := r.URL.Query().Get("type")
@@ -69,16 +139,15 @@ func getPackageInfo(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "type parameter is required", http.StatusBadRequest)
 		return
 	}
-
-	cacheDir := os.Getenv("VERTERA_CACHE_DIR")
-	if cacheDir == "" {
-		cacheDir = "/tmp/vertera/packages"
+	if err := packages.ValidateOSVersion(osVersion); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	pkgService := packages.NewService(cacheDir)
-	
+
+	pkgService := packages.NewService(packageCacheDir(), stores.Default)
 	packageInfos, err := pkgService.GetPackageInfo(packages.PackageType(pkgType), version, osVersion)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to get package info: %v", err), http.StatusInternalServerError)
+		writePackageError(w, err)
 		return
 	}
 
@@ -96,6 +165,36 @@ func getPackageInfo(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// hostOSVersion derives the OS version artifacts are picked for from the
+// host's last inventory; empty when the host or its OS is unknown.
+func hostOSVersion(hostID string) string {
+	inv, err := stores.Default.LatestInventory(hostID)
+	if err != nil {
+		return ""
+	}
+	id, _ := inv.OS["id"].(string)
+	like, _ := inv.OS["id_like"].(string)
+	versionID, _ := inv.OS["version_id"].(string)
+	return packages.OSRelease{ID: id, IDLike: strings.Fields(like), VersionID: versionID}.OSVersion()
+}
+
+func packageCacheDir() string {
+	if dir := os.Getenv("VERTERA_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	return "/tmp/vertera/packages"
+}
+
+// writePackageError maps registry lookup errors to store status codes; an
+// unsupported package type is a bad request.
+func writePackageError(w http.ResponseWriter, err error) {
+	if errors.Is(err, stores.ErrNotFound) || errors.Is(err, stores.ErrInvalid) {
+		writeStoreError(w, err)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
 // getTaskStatus handles GET /tasks/{taskId}
 func getTaskStatus(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "taskId")
@@ -9,14 +9,18 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
 	httpserver "github.com/VerteraIO/vertera/internal/http"
+	"github.com/VerteraIO/vertera/internal/packages"
 )
 
 type taskResp struct {
-	ID     string `json:"id"`
-	HostID string `json:"hostId"`
-	Type   string `json:"type"`
-	Status string `json:"status"`
+	ID     string          `json:"id"`
+	HostID string          `json:"hostId"`
+	Type   string          `json:"type"`
+	Status string          `json:"status"`
+	Params json.RawMessage `json:"params"`
 }
 
 func TestInstallPackagesEnqueue(t *testing.T) {
@@ -24,7 +28,11 @@ func TestInstallPackagesEnqueue(t *testing.T) {
 	ts := httptest.NewServer(httpserver.NewServer())
 	defer ts.Close()
 
+	// Packages that are not registered cannot be installed
 	body := `{"packages":["ovs"],"version":"3.6.0","os_version":"el9"}`
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/hosts/host-123/packages/install", body), http.StatusNotFound, nil)
+	registerArtifact(t, ts.URL, "ovs", "3.6.0", "stable", "el9", "openvswitch-3.6.0-1.el9.x86_64.rpm", '3')
+
 	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/hosts/host-123/packages/install", bytes.NewBufferString(body))
 	if err != nil {
 		t.Fatalf("new request: %v", err)
@@ -54,6 +62,13 @@ func TestInstallPackagesEnqueue(t *testing.T) {
 	if tr.ID == "" || tr.HostID != "host-123" || tr.Status != "queued" {
 		t.Fatalf("unexpected task body: %+v", tr)
 	}
+	var params tasks.InstallPackagesParams
+	if err := json.Unmarshal(tr.Params, &params); err != nil {
+		t.Fatalf("decode params: %v", err)
+	}
+	if infos := params.Artifacts[packages.PackageTypeOVS]; len(infos) != 1 || infos[0].Name != "openvswitch-3.6.0-1.el9.x86_64.rpm" || infos[0].SHA256 != strings.Repeat("3", 64) {
+		t.Fatalf("expected the task to carry the resolved artifacts, got %+v", params.Artifacts)
+	}
 
 	// Fetch task status
 	res2, err := http.Get(ts.URL + loc)
@@ -66,3 +81,57 @@ func TestInstallPackagesEnqueue(t *testing.T) {
 		t.Fatalf("expected 200, got %d: %s", res2.StatusCode, string(b))
 	}
 }
+
+func TestUninstallAndRollbackPackages(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+	host := addReadyHost(t, ts.URL, "c-pkg", "pkg-a", 4)
+	base := ts.URL + "/api/v1/hosts/" + host.ID + "/packages/"
+
+	decodeBody(t, postJSON(t, base+"uninstall", `{"packages":[]}`), http.StatusBadRequest, nil)
+	decodeBody(t, postJSON(t, base+"rollback", `{"packages":["kernel"]}`), http.StatusBadRequest, nil)
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/hosts/missing/packages/rollback", `{"packages":["ovs"]}`), http.StatusNotFound, nil)
+
+	for path, typ := range map[string]tasks.Type{"uninstall": tasks.TypeUninstallPackages, "rollback": tasks.TypeRollbackPackages} {
+		res := postJSON(t, base+path, `{"packages":["ovs","cloud-hypervisor"]}`)
+		if !strings.HasPrefix(res.Header.Get("Location"), "/api/v1/tasks/") {
+			t.Fatalf("expected a task location, got %q", res.Header.Get("Location"))
+		}
+		var task tasks.Task
+		decodeBody(t, res, http.StatusAccepted, &task)
+		var params tasks.PackageTypesParams
+		if err := json.Unmarshal(task.Params, &params); err != nil {
+			t.Fatal(err)
+		}
+		if task.Type != typ || task.HostID != host.ID || len(params.Packages) != 2 {
+			t.Fatalf("unexpected %s task: %+v", path, task)
+		}
+	}
+}
+
+func TestInstallPackagesForHostOS(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+	host := addReadyHost(t, ts.URL, "c-deb", "deb-a", 4)
+	url := ts.URL + "/api/v1/hosts/" + host.ID + "/packages/install"
+
+	decodeBody(t, postJSON(t, url, `{"packages":["ovs"],"os_version":"rhel9"}`), http.StatusBadRequest, nil)
+
+	registerArtifact(t, ts.URL, "ovs", "3.3.1", "stable", "el9", "openvswitch-3.3.1-1.el9.x86_64.rpm", '5')
+	registerArtifact(t, ts.URL, "ovs", "3.3.1", "stable", "debian12", "openvswitch-switch_3.3.1-1_amd64.deb", '6')
+	inv := stores.Inventory{OS: map[string]any{"id": "debian", "version_id": "12"}}
+	if err := stores.Default.RecordInventory(host.ID, inv); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without an os_version the host's distribution picks the artifacts
+	var task tasks.Task
+	decodeBody(t, postJSON(t, url, `{"packages":["ovs"],"version":"3.3.1"}`), http.StatusAccepted, &task)
+	var params tasks.InstallPackagesParams
+	if err := json.Unmarshal(task.Params, &params); err != nil {
+		t.Fatal(err)
+	}
+	if infos := params.Artifacts[packages.PackageTypeOVS]; params.OSVersion != "debian12" || len(infos) != 1 || infos[0].Name != "openvswitch-switch_3.3.1-1_amd64.deb" {
+		t.Fatalf("expected the debian12 package, got %s %+v", params.OSVersion, params.Artifacts)
+	}
+}
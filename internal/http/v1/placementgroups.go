@@ -0,0 +1,69 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/go-chi/chi/v5"
+)
+
+// placementGroupView is a placement group with its member VM ids.
+type placementGroupView struct {
+	*stores.PlacementGroup
+	Members []string `json:"members"`
+}
+
+func viewPlacementGroup(g *stores.PlacementGroup) placementGroupView {
+	members := []string{}
+	for _, vm := range stores.Default.PlacementGroupMembers(g.ID) {
+		members = append(members, vm.ID)
+	}
+	return placementGroupView{PlacementGroup: g, Members: members}
+}
+
+// listPlacementGroups handles GET /placement-groups
+func listPlacementGroups(w http.ResponseWriter, r *http.Request) {
+	groups := stores.Default.ListPlacementGroups(r.URL.Query().Get("projectId"))
+	items := make([]placementGroupView, 0, len(groups))
+	for _, g := range groups {
+		items = append(items, viewPlacementGroup(g))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// createPlacementGroup handles POST /placement-groups
+func createPlacementGroup(w http.ResponseWriter, r *http.Request) {
+	var req stores.PlacementGroupCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	g, err := stores.Default.CreatePlacementGroup(req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/placement-groups/%s", g.ID))
+	writeJSON(w, http.StatusCreated, viewPlacementGroup(g))
+}
+
+// getPlacementGroup handles GET /placement-groups/{groupId}
+func getPlacementGroup(w http.ResponseWriter, r *http.Request) {
+	g, err := stores.Default.GetPlacementGroup(chi.URLParam(r, "groupId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, viewPlacementGroup(g))
+}
+
+// deletePlacementGroup handles DELETE /placement-groups/{groupId}
+func deletePlacementGroup(w http.ResponseWriter, r *http.Request) {
+	if err := stores.Default.DeletePlacementGroup(chi.URLParam(r, "groupId")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
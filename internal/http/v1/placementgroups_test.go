@@ -0,0 +1,89 @@
+package v1_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/reconciler"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	httpserver "github.com/VerteraIO/vertera/internal/http"
+)
+
+type placementGroupResp struct {
+	stores.PlacementGroup
+	Members []string `json:"members"`
+}
+
+func TestPlacementGroups(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+
+	a := addReadyHost(t, ts.URL, "c-pgroups", "pgroups-a", 8)
+	b := addReadyHost(t, ts.URL, "c-pgroups", "pgroups-b", 8)
+
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/placement-groups", `{"projectId":"p1","name":"bad","policy":"together"}`), http.StatusBadRequest, nil)
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/placement-groups", `{"projectId":"p1","name":"bad","policy":"affinity","maxPerHost":2}`), http.StatusBadRequest, nil)
+
+	var db placementGroupResp
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/placement-groups", `{"projectId":"p1","name":"db","policy":"anti-affinity"}`), http.StatusCreated, &db)
+	if db.Enforcement != stores.EnforcementHard || db.MaxPerHost != 1 {
+		t.Fatalf("unexpected defaults: %+v", db)
+	}
+	var app placementGroupResp
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/placement-groups", `{"projectId":"p1","name":"app","policy":"affinity","enforcement":"soft"}`), http.StatusCreated, &app)
+
+	createVm := func(name, group string, want int) stores.VM {
+		var vm stores.VM
+		body := `{"projectId":"p1","clusterId":"c-pgroups","name":"` + name + `","vcpus":1,"memoryMiB":512,"placementGroupId":"` + group + `"}`
+		if want == http.StatusCreated {
+			decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", body), want, &vm)
+		} else {
+			decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", body), want, nil)
+		}
+		return vm
+	}
+
+	// Hard anti-affinity: one replica per host, a third one does not fit
+	db1 := createVm("db-1", db.ID, http.StatusCreated)
+	db2 := createVm("db-2", db.ID, http.StatusCreated)
+	if db1.HostID == db2.HostID || db1.PlacementGroupID != db.ID {
+		t.Fatalf("replicas share host %s", db1.HostID)
+	}
+	createVm("db-3", db.ID, http.StatusConflict)
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+db1.HostID+`","name":"db-3","vcpus":1,"memoryMiB":512,"placementGroupId":"`+db.ID+`"}`), http.StatusConflict, nil)
+
+	// Soft affinity keeps the tier together even though the other host is emptier
+	web := createVm("app-web", app.ID, http.StatusCreated)
+	api := createVm("app-api", app.ID, http.StatusCreated)
+	if web.HostID != api.HostID {
+		t.Fatalf("affinity group split across %s and %s", web.HostID, api.HostID)
+	}
+
+	// Groups in another project cannot be joined; groups with members cannot be deleted
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p2","clusterId":"c-pgroups","name":"x","vcpus":1,"memoryMiB":512,"placementGroupId":"`+db.ID+`"}`), http.StatusBadRequest, nil)
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/placement-groups/"+db.ID, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusConflict, nil)
+
+	// A manual move breaks the anti-affinity policy; the reconciler reports it
+	other := a.ID
+	if db1.HostID == a.ID {
+		other = b.ID
+	}
+	if _, err := stores.Default.UpdateVM(db1.ID, func(vm *stores.VM) error { vm.HostID = other; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	reconciler.New(stores.Default).Reconcile()
+	resp, err = http.Get(ts.URL + "/api/v1/placement-groups/" + db.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &db)
+	if len(db.Members) != 2 || len(db.Violations) != 1 || db.Violations[0].Kind != "crowded" || db.Violations[0].HostID != other || db.CheckedAt == nil {
+		t.Fatalf("expected crowded violation on %s, got %+v", other, db)
+	}
+}
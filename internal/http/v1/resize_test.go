@@ -0,0 +1,93 @@
+package v1_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/dispatch"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+	httpserver "github.com/VerteraIO/vertera/internal/http"
+)
+
+func TestVmResize(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+
+	host := addReadyHost(t, ts.URL, "c-rs", "rs-a", 4)
+	var vm stores.VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+host.ID+`","name":"rs-1","vcpus":2,"memoryMiB":1024}`), http.StatusCreated, &vm)
+	if vm.MaxVcpus != 4 || vm.MaxMemoryMiB != 2048 {
+		t.Fatalf("unexpected resize headroom: %d %d", vm.MaxVcpus, vm.MaxMemoryMiB)
+	}
+	vmURL := ts.URL + "/api/v1/vms/" + vm.ID
+	patch := func(body string, status int, v any) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPatch, vmURL, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decodeBody(t, resp, status, v)
+	}
+	finishResize := func(task tasks.Task, result string) {
+		t.Helper()
+		tasks.Default.SetResult(task.ID, json.RawMessage(result))
+		finishTask(t, task, "")
+	}
+
+	// Only running or stopped VMs are resized
+	patch(`{"vcpus":3}`, http.StatusConflict, nil)
+	finishTask(t, *dispatch.Default.DrainPending(host.ID)[0], "")
+
+	// The host must have room for what the VM grows by
+	patch(`{}`, http.StatusBadRequest, nil)
+	patch(`{"vcpus":0}`, http.StatusBadRequest, nil)
+	patch(`{"memoryMiB":16384}`, http.StatusConflict, nil)
+
+	// Within the headroom the VM is resized live
+	var task tasks.Task
+	patch(`{"vcpus":4,"memoryMiB":2048}`, http.StatusAccepted, &task)
+	var p tasks.ResizeVMParams
+	if err := json.Unmarshal(task.Params, &p); err != nil || task.Type != tasks.TypeResizeVM || p.Vcpus != 4 || p.MemoryMiB != 2048 || p.MaxVcpus != 4 {
+		t.Fatalf("unexpected resize task: %+v %v", task, err)
+	}
+	got := fetchVm(t, vmURL, http.StatusOK)
+	if got.Vcpus != 4 || got.Resize == nil || got.Resize.State != stores.ResizeInProgress || got.Resize.TaskID != task.ID || got.Resize.Previous.Vcpus != 2 {
+		t.Fatalf("resize not recorded: %+v %+v", got, got.Resize)
+	}
+	patch(`{"vcpus":3}`, http.StatusConflict, nil)
+	decodeBody(t, postJSON(t, vmURL+"/snapshots", `{"name":"s1"}`), http.StatusConflict, nil)
+	finishResize(task, `{"live":true}`)
+	if got := fetchVm(t, vmURL, http.StatusOK); got.Resize.State != stores.ResizeApplied || got.State != stores.VMStateRunning {
+		t.Fatalf("resize not applied: %+v", got.Resize)
+	}
+
+	// Beyond it the VM gets more headroom and the new size on its next boot
+	patch(`{"memoryMiB":3072}`, http.StatusAccepted, &task)
+	finishResize(task, `{"live":false,"reason":"vm was booted with at most 2048 MiB of memory"}`)
+	got = fetchVm(t, vmURL, http.StatusOK)
+	if got.MemoryMiB != 3072 || got.MaxMemoryMiB != 3072 || got.Resize.State != stores.ResizeNextBoot || got.Resize.Reason == "" {
+		t.Fatalf("unexpected next-boot resize: %+v %+v", got, got.Resize)
+	}
+	// A failed resize returns to the previous size and keeps waiting for the boot
+	patch(`{"vcpus":1}`, http.StatusAccepted, &task)
+	finishTask(t, task, "spec unreadable")
+	got = fetchVm(t, vmURL, http.StatusOK)
+	if got.Vcpus != 4 || got.MemoryMiB != 3072 || got.Resize.State != stores.ResizeNextBoot || got.Resize.Error == "" || got.State != stores.VMStateRunning {
+		t.Fatalf("failed resize not reverted: %+v %+v", got, got.Resize)
+	}
+
+	// Powering the VM off and on applies it
+	for _, op := range []string{"off", "on"} {
+		decodeBody(t, postJSON(t, vmURL+"/actions/power", `{"op":"`+op+`"}`), http.StatusAccepted, &task)
+		finishTask(t, task, "")
+	}
+	if got := fetchVm(t, vmURL, http.StatusOK); got.Resize.State != stores.ResizeApplied {
+		t.Fatalf("resize not applied on boot: %+v", got.Resize)
+	}
+	dispatch.Default.DrainPending(host.ID)
+}
@@ -2,11 +2,13 @@ package v1
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	openapi "github.com/VerteraIO/vertera/api/openapi"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
@@ -20,9 +22,41 @@ func Router() chi.Router {
 	))
 	r.Get("/openapi.yaml", serveOpenAPIStaticAsset)
 
-	// Package management endpoints
+	// Hosts and inventory
+	r.Get("/hosts", listHosts)
+	r.Post("/hosts", createHost)
+	r.Get("/hosts/{hostId}", getHost)
+	r.Delete("/hosts/{hostId}", deleteHost)
+	r.Get("/hosts/{hostId}/inventory/latest", getLatestInventory)
+	r.Get("/hosts/{hostId}/inventory", listInventory)
+	r.Get("/hosts/{hostId}/capacity", getHostCapacity)
+	r.Post("/hosts/{hostId}/actions/drain", drainHost)
+	r.Post("/hosts/{hostId}/actions/undrain", undrainHost)
+	r.Get("/hosts/{hostId}/drain", getHostDrain)
+	r.Post("/hosts/{hostId}/actions/confirm-fenced", confirmHostFenced)
+	r.Post("/hosts/{hostId}/actions/rejoin", rejoinHost)
+	r.Get("/hosts/{hostId}/failover", getHostFailover)
+
+	// Clusters and capacity
+	r.Get("/clusters", listClusters)
+	r.Post("/clusters", createCluster)
+	r.Get("/clusters/{clusterId}", getCluster)
+	r.Patch("/clusters/{clusterId}", updateCluster)
+	r.Delete("/clusters/{clusterId}", deleteCluster)
+	r.Get("/clusters/{clusterId}/capacity", getClusterCapacity)
+
+	// Artifact registry and package management endpoints
+	r.Get("/artifacts", listArtifacts)
+	r.Post("/artifacts", createArtifact)
+	r.Get("/artifacts/{artifactId}", getArtifact)
+	r.Patch("/artifacts/{artifactId}", updateArtifact)
+	r.Delete("/artifacts/{artifactId}", deleteArtifact)
+	r.Post("/mirror/import", importMirrorBundle)
+	r.Get("/mirror/files/{digest}", getMirrorFile)
 	r.Get("/packages/info", getPackageInfo)
 	r.Post("/hosts/{hostId}/packages/install", installPackages)
+	r.Post("/hosts/{hostId}/packages/uninstall", uninstallPackages)
+	r.Post("/hosts/{hostId}/packages/rollback", rollbackPackages)
 
 	// Task endpoints
 	r.Get("/tasks/{taskId}", getTaskStatus)
@@ -34,6 +68,58 @@ func Router() chi.Router {
 	// API endpoints
 	r.Get("/projects", listProjects)
 
+	// Distributed switches and port groups
+	r.Get("/dvs", listDvs)
+	r.Post("/dvs", createDvs)
+	r.Get("/dvs/{dvsId}", getDvs)
+	r.Patch("/dvs/{dvsId}", updateDvs)
+	r.Get("/dvs/{dvsId}/port-groups", listPortGroups)
+	r.Post("/dvs/{dvsId}/port-groups", createPortGroup)
+	r.Get("/dvs/{dvsId}/port-groups/{portGroupId}", getPortGroup)
+	r.Delete("/dvs/{dvsId}/port-groups/{portGroupId}", deletePortGroup)
+
+	// Virtual machines
+	r.Get("/vms", listVms)
+	r.Post("/vms", createVm)
+	r.Get("/vms/{vmId}", getVm)
+	r.Patch("/vms/{vmId}", patchVm)
+	r.Delete("/vms/{vmId}", deleteVm)
+	r.Post("/vms/{vmId}/actions/power", powerVm)
+	r.Post("/vms/{vmId}/actions/migrate", migrateVm)
+	r.Put("/vms/{vmId}/cloud-init", putVmCloudInit)
+	r.Delete("/vms/{vmId}/cloud-init", deleteVmCloudInit)
+	r.Post("/vms/{vmId}/disks", attachVmDisk)
+	r.Delete("/vms/{vmId}/disks/{diskId}", detachVmDisk)
+	r.Post("/vms/{vmId}/nics", attachVmNic)
+	r.Delete("/vms/{vmId}/nics/{nicId}", detachVmNic)
+	r.Get("/vms/{vmId}/crashes", listVmCrashes)
+	r.Get("/vms/{vmId}/console", vmConsole)
+	r.Get("/vms/{vmId}/console/sessions", listVmConsoleSessions)
+	r.Get("/vms/{vmId}/metrics", listVmMetrics)
+	r.Get("/vms/{vmId}/placement", getVmPlacement)
+	r.Get("/vms/{vmId}/snapshots", listVmSnapshots)
+	r.Post("/vms/{vmId}/snapshots", createVmSnapshot)
+	r.Get("/vms/{vmId}/snapshots/{snapshotId}", getVmSnapshot)
+	r.Delete("/vms/{vmId}/snapshots/{snapshotId}", deleteVmSnapshot)
+	r.Post("/vms/{vmId}/snapshots/{snapshotId}/actions/restore", restoreVmSnapshot)
+
+	// Image library
+	r.Get("/images", listImages)
+	r.Post("/images", createImage)
+	r.Get("/images/{imageId}", getImage)
+	r.Delete("/images/{imageId}", deleteImage)
+	r.Put("/images/{imageId}/file", uploadImageFile)
+	r.Get("/images/{imageId}/file", downloadImageFile)
+
+	// Placement groups
+	r.Get("/placement-groups", listPlacementGroups)
+	r.Post("/placement-groups", createPlacementGroup)
+	r.Get("/placement-groups/{groupId}", getPlacementGroup)
+	r.Delete("/placement-groups/{groupId}", deletePlacementGroup)
+
+	// Placement debugging
+	r.Post("/scheduler/preview", previewPlacement)
+
 	return r
 }
 
@@ -57,3 +143,24 @@ func listProjects(w http.ResponseWriter, r *http.Request) {
 	}
 	_ = json.NewEncoder(w).Encode(resp)
 }
+
+// writeJSON encodes v as the JSON response body with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeStoreError maps store sentinel errors onto HTTP status codes.
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, stores.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, stores.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, stores.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
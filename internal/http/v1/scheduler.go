@@ -0,0 +1,34 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/scheduler"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/go-chi/chi/v5"
+)
+
+// getVmPlacement handles GET /vms/{vmId}/placement
+func getVmPlacement(w http.ResponseWriter, r *http.Request) {
+	d, err := scheduler.Default.Decision(chi.URLParam(r, "vmId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// previewPlacement handles POST /scheduler/preview. It runs placement for a VM
+// create request without creating anything and returns the decision, including
+// when no host fits.
+func previewPlacement(w http.ResponseWriter, r *http.Request) {
+	var req stores.VMCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	d, _ := scheduler.Default.Place(scheduler.RequestFor(req))
+	writeJSON(w, http.StatusOK, d)
+}
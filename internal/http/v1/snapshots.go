@@ -0,0 +1,83 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/vms"
+	"github.com/go-chi/chi/v5"
+)
+
+// listVmSnapshots handles GET /vms/{vmId}/snapshots
+func listVmSnapshots(w http.ResponseWriter, r *http.Request) {
+	items, err := stores.Default.ListVMSnapshots(chi.URLParam(r, "vmId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// createVmSnapshot handles POST /vms/{vmId}/snapshots
+func createVmSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	snap, err := vms.Default.Snapshot(chi.URLParam(r, "vmId"), req.Name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/vms/%s/snapshots/%s", snap.VMID, snap.ID))
+	writeJSON(w, http.StatusCreated, snap)
+}
+
+// getVmSnapshot handles GET /vms/{vmId}/snapshots/{snapshotId}
+func getVmSnapshot(w http.ResponseWriter, r *http.Request) {
+	snap, err := stores.Default.GetVMSnapshot(chi.URLParam(r, "vmId"), chi.URLParam(r, "snapshotId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, snap)
+}
+
+// deleteVmSnapshot handles DELETE /vms/{vmId}/snapshots/{snapshotId}
+func deleteVmSnapshot(w http.ResponseWriter, r *http.Request) {
+	t, err := vms.Default.DeleteSnapshot(chi.URLParam(r, "vmId"), chi.URLParam(r, "snapshotId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeTaskAccepted(w, t)
+}
+
+// restoreVmSnapshot handles POST /vms/{vmId}/snapshots/{snapshotId}/actions/restore
+func restoreVmSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	vm, t, err := vms.Default.Restore(chi.URLParam(r, "vmId"), chi.URLParam(r, "snapshotId"), req.Name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if vm == nil {
+		writeTaskAccepted(w, t)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/vms/%s", vm.ID))
+	writeJSON(w, http.StatusCreated, vm)
+}
@@ -0,0 +1,143 @@
+package v1_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/dispatch"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+	httpserver "github.com/VerteraIO/vertera/internal/http"
+)
+
+func TestVmSnapshots(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+
+	var dvs stores.Dvs
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/dvs", `{"clusterId":"c-snap","name":"snapnet"}`), http.StatusCreated, &dvs)
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/dvs/"+dvs.ID+"/port-groups", `{"name":"snap-web","vlanMode":"access","vlanId":50}`), http.StatusCreated, nil)
+	host := addReadyHost(t, ts.URL, "c-snap", "snap-a", 8)
+	other := addReadyHost(t, ts.URL, "c-snap", "snap-b", 8)
+
+	var vm stores.VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+host.ID+`","name":"snap-1","vcpus":2,"memoryMiB":1024,
+		"nets":[{"portGroup":"snap-web"}],"disks":[{"sizeGiB":10}]}`), http.StatusCreated, &vm)
+	vmURL := ts.URL + "/api/v1/vms/" + vm.ID
+	decodeBody(t, postJSON(t, vmURL+"/snapshots", `{"name":"s1"}`), http.StatusConflict, nil)
+	finishTask(t, *dispatch.Default.DrainPending(host.ID)[0], "")
+
+	// Taking a snapshot records it and asks the VM's host for it
+	decodeBody(t, postJSON(t, vmURL+"/snapshots", `{"name":""}`), http.StatusBadRequest, nil)
+	var snap stores.VMSnapshot
+	decodeBody(t, postJSON(t, vmURL+"/snapshots", `{"name":"s1"}`), http.StatusCreated, &snap)
+	if snap.State != stores.SnapshotCreating || snap.HostID != host.ID || snap.Vcpus != 2 || snap.TaskID == "" {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+	snapURL := vmURL + "/snapshots/" + snap.ID
+	pending := dispatch.Default.DrainPending(host.ID)
+	if len(pending) != 1 || pending[0].Type != tasks.TypeSnapshotVM || pending[0].ID != snap.TaskID {
+		t.Fatalf("expected one snapshot task, got %+v", pending)
+	}
+	decodeBody(t, postJSON(t, vmURL+"/snapshots", `{"name":"s1"}`), http.StatusConflict, nil)
+	decodeBody(t, postJSON(t, snapURL+"/actions/restore", ``), http.StatusConflict, nil)
+	req, _ := http.NewRequest(http.MethodDelete, snapURL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusConflict, nil)
+
+	// The agent reports the snapshot's size with the result
+	tasks.Default.SetResult(pending[0].ID, json.RawMessage(`{"sizeBytes":10737418240}`))
+	finishTask(t, *pending[0], "")
+	resp, err = http.Get(snapURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &snap)
+	if snap.State != stores.SnapshotReady || snap.SizeBytes != 10<<30 {
+		t.Fatalf("expected ready snapshot with its size, got %+v", snap)
+	}
+
+	// Snapshot files stay on the host, so the VM cannot migrate away
+	decodeBody(t, postJSON(t, vmURL+"/actions/migrate", `{"targetHostId":"`+other.ID+`"}`), http.StatusConflict, nil)
+
+	// Restoring in place
+	var task tasks.Task
+	decodeBody(t, postJSON(t, snapURL+"/actions/restore", ``), http.StatusAccepted, &task)
+	var p tasks.RestoreVMParams
+	if err := json.Unmarshal(task.Params, &p); err != nil || task.Type != tasks.TypeRestoreVM || p.Spec.ID != vm.ID || p.SourceVMID != vm.ID || p.SnapshotID != snap.ID {
+		t.Fatalf("unexpected restore task: %+v %v", task, err)
+	}
+	if got := fetchVm(t, vmURL, http.StatusOK); got.State != stores.VMStateRestoring {
+		t.Fatalf("expected restoring vm, got %s", got.State)
+	}
+	decodeBody(t, postJSON(t, vmURL+"/actions/power", `{"op":"off"}`), http.StatusConflict, nil)
+	finishTask(t, *dispatch.Default.DrainPending(host.ID)[0], "")
+	if got := fetchVm(t, vmURL, http.StatusOK); got.State != stores.VMStateRunning {
+		t.Fatalf("expected running vm after restore, got %s", got.State)
+	}
+
+	// Restoring into a new VM on the snapshot's host
+	var clone stores.VM
+	decodeBody(t, postJSON(t, snapURL+"/actions/restore", `{"name":"snap-1"}`), http.StatusConflict, nil)
+	decodeBody(t, postJSON(t, snapURL+"/actions/restore", `{"name":"snap-clone"}`), http.StatusCreated, &clone)
+	if clone.HostID != host.ID || clone.State != stores.VMStateRestoring || clone.Vcpus != 2 || len(clone.Disks) != 1 ||
+		clone.Nets[0].PortGroup != "snap-web" || *clone.Nets[0].MacAddress == *vm.Nets[0].MacAddress {
+		t.Fatalf("unexpected clone: %+v", clone)
+	}
+	pending = dispatch.Default.DrainPending(host.ID)
+	if err := json.Unmarshal(pending[0].Params, &p); err != nil || p.Spec.ID != clone.ID || p.SourceVMID != vm.ID {
+		t.Fatalf("unexpected clone restore params: %+v %v", p, err)
+	}
+	finishTask(t, *pending[0], "snapshot is gone")
+	if got := fetchVm(t, ts.URL+"/api/v1/vms/"+clone.ID, http.StatusOK); got.State != stores.VMStateError || got.Error != "snapshot is gone" {
+		t.Fatalf("expected failed clone, got %+v", got)
+	}
+
+	// A failed snapshot can be deleted
+	var s2 stores.VMSnapshot
+	decodeBody(t, postJSON(t, vmURL+"/snapshots", `{"name":"s2"}`), http.StatusCreated, &s2)
+	finishTask(t, *dispatch.Default.DrainPending(host.ID)[0], "disk copy failed")
+	req, _ = http.NewRequest(http.MethodDelete, vmURL+"/snapshots/"+s2.ID, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusAccepted, &task)
+	if got, _ := stores.Default.GetVMSnapshot(vm.ID, s2.ID); got.State != stores.SnapshotDeleting {
+		t.Fatalf("expected deleting snapshot, got %+v", got)
+	}
+	finishTask(t, *dispatch.Default.DrainPending(host.ID)[0], "")
+	resp, err = http.Get(vmURL + "/snapshots/" + s2.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusNotFound, nil)
+	var list struct {
+		Items []stores.VMSnapshot `json:"items"`
+	}
+	resp, err = http.Get(vmURL + "/snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusOK, &list)
+	if len(list.Items) != 1 || list.Items[0].ID != snap.ID {
+		t.Fatalf("unexpected snapshots: %+v", list.Items)
+	}
+
+	// Deleting the VM deletes its snapshots
+	req, _ = http.NewRequest(http.MethodDelete, vmURL, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeBody(t, resp, http.StatusAccepted, nil)
+	finishTask(t, *dispatch.Default.DrainPending(host.ID)[0], "")
+	if _, err := stores.Default.GetVMSnapshot(vm.ID, snap.ID); err == nil {
+		t.Fatal("snapshot survived its vm")
+	}
+}
@@ -0,0 +1,203 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+	"github.com/VerteraIO/vertera/internal/controlplane/vms"
+	"github.com/go-chi/chi/v5"
+)
+
+// listVms handles GET /vms
+func listVms(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	items := stores.Default.ListVMs(q.Get("projectId"), q.Get("hostId"))
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// createVm handles POST /vms
+func createVm(w http.ResponseWriter, r *http.Request) {
+	var req stores.VMCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	vm, _, err := vms.Default.Create(req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/vms/%s", vm.ID))
+	writeJSON(w, http.StatusCreated, vm)
+}
+
+// getVm handles GET /vms/{vmId}
+func getVm(w http.ResponseWriter, r *http.Request) {
+	vm, err := stores.Default.GetVM(chi.URLParam(r, "vmId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, vm)
+}
+
+// deleteVm handles DELETE /vms/{vmId}
+func deleteVm(w http.ResponseWriter, r *http.Request) {
+	t, err := vms.Default.Delete(chi.URLParam(r, "vmId"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeTaskAccepted(w, t)
+}
+
+// patchVm handles PATCH /vms/{vmId}
+func patchVm(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Vcpus     *int `json:"vcpus"`
+		MemoryMiB *int `json:"memoryMiB"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	_, t, err := vms.Default.Resize(chi.URLParam(r, "vmId"), req.Vcpus, req.MemoryMiB)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeTaskAccepted(w, t)
+}
+
+// powerVm handles POST /vms/{vmId}/actions/power
+func powerVm(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Op string `json:"op"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	t, err := vms.Default.Power(chi.URLParam(r, "vmId"), req.Op)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeTaskAccepted(w, t)
+}
+
+// putVmCloudInit handles PUT /vms/{vmId}/cloud-init
+func putVmCloudInit(w http.ResponseWriter, r *http.Request) {
+	var req stores.VMCloudInit
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	t, err := vms.Default.UpdateCloudInit(chi.URLParam(r, "vmId"), &req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeTaskAccepted(w, t)
+}
+
+// deleteVmCloudInit handles DELETE /vms/{vmId}/cloud-init
+func deleteVmCloudInit(w http.ResponseWriter, r *http.Request) {
+	t, err := vms.Default.UpdateCloudInit(chi.URLParam(r, "vmId"), nil)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeTaskAccepted(w, t)
+}
+
+// attachVmDisk handles POST /vms/{vmId}/disks
+func attachVmDisk(w http.ResponseWriter, r *http.Request) {
+	var req stores.VMDisk
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	_, t, err := vms.Default.AttachDisk(chi.URLParam(r, "vmId"), req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeTaskAccepted(w, t)
+}
+
+// detachVmDisk handles DELETE /vms/{vmId}/disks/{diskId}
+func detachVmDisk(w http.ResponseWriter, r *http.Request) {
+	detachVmDevice(w, r, chi.URLParam(r, "diskId"), func(vm *stores.VM, id string) bool {
+		return slices.ContainsFunc(vm.Disks, func(d stores.VMDisk) bool { return d.ID == id })
+	})
+}
+
+// attachVmNic handles POST /vms/{vmId}/nics
+func attachVmNic(w http.ResponseWriter, r *http.Request) {
+	var req stores.VMNic
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	_, t, err := vms.Default.AttachNic(chi.URLParam(r, "vmId"), req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeTaskAccepted(w, t)
+}
+
+// detachVmNic handles DELETE /vms/{vmId}/nics/{nicId}
+func detachVmNic(w http.ResponseWriter, r *http.Request) {
+	detachVmDevice(w, r, chi.URLParam(r, "nicId"), func(vm *stores.VM, id string) bool {
+		return slices.ContainsFunc(vm.Nets, func(n stores.VMNic) bool { return n.ID == id })
+	})
+}
+
+// detachVmDevice detaches device id from the VM if has reports it is a device
+// of the kind the route is for.
+func detachVmDevice(w http.ResponseWriter, r *http.Request, id string, has func(*stores.VM, string) bool) {
+	vm, err := stores.Default.GetVM(chi.URLParam(r, "vmId"))
+	if err == nil && !has(vm, id) {
+		err = fmt.Errorf("device %s of vm %s: %w", id, vm.ID, stores.ErrNotFound)
+	}
+	var t *tasks.Task
+	if err == nil {
+		t, err = vms.Default.DetachDevice(vm.ID, id)
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeTaskAccepted(w, t)
+}
+
+// migrateVm handles POST /vms/{vmId}/actions/migrate
+func migrateVm(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TargetHostID string `json:"targetHostId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	t, err := vms.Default.Migrate(chi.URLParam(r, "vmId"), req.TargetHostID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeTaskAccepted(w, t)
+}
+
+// writeTaskAccepted returns 202 with the task and a Location header pointing at it.
+func writeTaskAccepted(w http.ResponseWriter, t *tasks.Task) {
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/tasks/%s", t.ID))
+	writeJSON(w, http.StatusAccepted, t)
+}
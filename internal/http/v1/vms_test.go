@@ -0,0 +1,249 @@
+package v1_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/controlplane/dispatch"
+	"github.com/VerteraIO/vertera/internal/controlplane/stores"
+	"github.com/VerteraIO/vertera/internal/controlplane/tasks"
+	"github.com/VerteraIO/vertera/internal/controlplane/vms"
+	httpserver "github.com/VerteraIO/vertera/internal/http"
+)
+
+// finishTask plays the agent: it marks the task as done, which drives the VM state.
+func finishTask(t *testing.T, task tasks.Task, errMsg string) {
+	t.Helper()
+	if errMsg != "" {
+		tasks.Default.UpdateStatusFailed(task.ID, errMsg)
+		return
+	}
+	tasks.Default.UpdateStatusSucceeded(task.ID)
+}
+
+// listening reports a receive task's target as ready for the VM, as the
+// agent does once its VMM waits for the source.
+func listening(t *testing.T, task tasks.Task) {
+	t.Helper()
+	result, err := json.Marshal(tasks.ReceiveVMMigrationResult{Listening: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks.Default.SetResult(task.ID, result)
+	tasks.Default.UpdateStatusRunning(task.ID)
+}
+
+func fetchVm(t *testing.T, url string, wantStatus int) stores.VM {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("get %s: %v", url, err)
+	}
+	var vm stores.VM
+	if wantStatus == http.StatusOK {
+		decodeBody(t, resp, wantStatus, &vm)
+	} else {
+		decodeBody(t, resp, wantStatus, nil)
+	}
+	return vm
+}
+
+func TestVmLifecycle(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+
+	var dvs stores.Dvs
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/dvs", `{"clusterId":"c-vm","name":"vmnet"}`), http.StatusCreated, &dvs)
+	var pg stores.PortGroup
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/dvs/"+dvs.ID+"/port-groups", `{"name":"vm-lifecycle-web","vlanMode":"access","vlanId":30}`), http.StatusCreated, &pg)
+
+	// The host is registered through the API and claimed by its agent
+	var host stores.Host
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/hosts", `{"projectId":"p1","clusterId":"c-vm","hostname":"vm-host-1"}`), http.StatusCreated, &host)
+	if _, err := stores.Default.RegisterAgentHost("", "vm-host-1"); err != nil {
+		t.Fatalf("register agent: %v", err)
+	}
+
+	// Unknown port groups and unknown hosts are rejected
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+host.ID+`","name":"x","vcpus":1,"memoryMiB":512,"nets":[{"portGroup":"nope"}]}`), http.StatusNotFound, nil)
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"nope","name":"x","vcpus":1,"memoryMiB":512}`), http.StatusNotFound, nil)
+
+	var vm stores.VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+host.ID+`","name":"web-1","vcpus":2,"memoryMiB":1024,
+		"nets":[{"portGroup":"vm-lifecycle-web"}],"disks":[{"sizeGiB":10}]}`), http.StatusCreated, &vm)
+	if vm.State != stores.VMStateCreating || vm.Nets[0].MacAddress == nil || vm.Nets[0].PortGroupID != pg.ID {
+		t.Fatalf("unexpected vm: %+v", vm)
+	}
+	vmURL := ts.URL + "/api/v1/vms/" + vm.ID
+
+	// The create task is queued for the VM's host and carries the translated spec
+	pending := dispatch.Default.DrainPending(host.ID)
+	if len(pending) != 1 || pending[0].Type != tasks.TypeCreateVM {
+		t.Fatalf("expected one create task, got %+v", pending)
+	}
+	finishTask(t, *pending[0], "")
+	if got := fetchVm(t, vmURL, http.StatusOK); got.State != stores.VMStateRunning {
+		t.Fatalf("expected running after create, got %s", got.State)
+	}
+
+	// Power off, then a failed power on surfaces the agent's error
+	var task tasks.Task
+	decodeBody(t, postJSON(t, vmURL+"/actions/power", `{"op":"off"}`), http.StatusAccepted, &task)
+	finishTask(t, task, "")
+	if got := fetchVm(t, vmURL, http.StatusOK); got.State != stores.VMStateStopped {
+		t.Fatalf("expected stopped, got %s", got.State)
+	}
+	decodeBody(t, postJSON(t, vmURL+"/actions/power", `{"op":"on"}`), http.StatusAccepted, &task)
+	finishTask(t, task, "boot failed")
+	if got := fetchVm(t, vmURL, http.StatusOK); got.State != stores.VMStateError || got.Error != "boot failed" {
+		t.Fatalf("expected error state, got %+v", got)
+	}
+	decodeBody(t, postJSON(t, vmURL+"/actions/power", `{"op":"suspend"}`), http.StatusBadRequest, nil)
+
+	// The port group stays referenced until the VM is gone
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/dvs/"+dvs.ID+"/port-groups/"+pg.ID, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("delete pg: %v", err)
+	}
+	decodeBody(t, resp, http.StatusConflict, nil)
+
+	req, _ = http.NewRequest(http.MethodDelete, vmURL, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("delete vm: %v", err)
+	}
+	decodeBody(t, resp, http.StatusAccepted, &task)
+	if got := fetchVm(t, vmURL, http.StatusOK); got.State != stores.VMStateDeleting {
+		t.Fatalf("expected deleting, got %s", got.State)
+	}
+	decodeBody(t, postJSON(t, vmURL+"/actions/power", `{"op":"on"}`), http.StatusConflict, nil)
+	finishTask(t, task, "")
+	fetchVm(t, vmURL, http.StatusNotFound)
+	if err := stores.Default.DeletePortGroup(pg.ID); err != nil {
+		t.Fatalf("port group should be free after vm delete: %v", err)
+	}
+}
+
+func TestVmMigration(t *testing.T) {
+	ts := httptest.NewServer(httpserver.NewServer())
+	defer ts.Close()
+
+	// Two clusters carry the port group, each on its own DVS bridge
+	var dvsA, dvsB stores.Dvs
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/dvs", `{"clusterId":"c-mig","name":"mignet"}`), http.StatusCreated, &dvsA)
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/dvs", `{"clusterId":"c-mig-b","name":"mignet-b"}`), http.StatusCreated, &dvsB)
+	var pgB stores.PortGroup
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/dvs/"+dvsA.ID+"/port-groups", `{"name":"mig-web","vlanMode":"access","vlanId":40}`), http.StatusCreated, nil)
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/dvs/"+dvsB.ID+"/port-groups", `{"name":"mig-web","vlanMode":"access","vlanId":40}`), http.StatusCreated, &pgB)
+
+	hosts := map[string]stores.Host{}
+	for _, h := range []struct{ cluster, name, flags string }{
+		{"c-mig", "mig-a", "avx2"}, {"c-mig", "mig-b", ""}, {"c-mig", "mig-c", "avx2"}, {"c-mig-b", "mig-d", "avx2"},
+	} {
+		hosts[h.name] = addReadyHost(t, ts.URL, h.cluster, h.name, 8)
+		inv := stores.Inventory{CPU: stores.InventoryCPU{Sockets: 1, Cores: 8, Threads: 1}, Memory: stores.InventoryMemory{Total: 8 << 30}}
+		if h.flags != "" {
+			inv.CPU.Flags = []string{h.flags}
+		}
+		if err := stores.Default.RecordInventory(hosts[h.name].ID, inv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var vm stores.VM
+	decodeBody(t, postJSON(t, ts.URL+"/api/v1/vms", `{"projectId":"p1","hostId":"`+hosts["mig-a"].ID+`","name":"mig-1","vcpus":2,"memoryMiB":1024,"nets":[{"portGroup":"mig-web"}]}`), http.StatusCreated, &vm)
+	vmURL := ts.URL + "/api/v1/vms/" + vm.ID
+	migrate := func(body string, status int) tasks.Task {
+		t.Helper()
+		var task tasks.Task
+		if status == http.StatusAccepted {
+			decodeBody(t, postJSON(t, vmURL+"/actions/migrate", body), status, &task)
+		} else {
+			decodeBody(t, postJSON(t, vmURL+"/actions/migrate", body), status, nil)
+		}
+		return task
+	}
+	migrate(`{}`, http.StatusConflict)
+	finishTask(t, *dispatch.Default.DrainPending(hosts["mig-a"].ID)[0], "")
+
+	// The target must offer the source's CPU flags and differ from the source
+	migrate(`{"targetHostId":"`+hosts["mig-b"].ID+`"}`, http.StatusConflict)
+	migrate(`{"targetHostId":"`+hosts["mig-a"].ID+`"}`, http.StatusBadRequest)
+	migrate(`{"targetHostId":"nope"}`, http.StatusNotFound)
+
+	// Without a target the scheduler picks the only compatible host in the cluster
+	recv := migrate(``, http.StatusAccepted)
+	if recv.Type != tasks.TypeReceiveVMMigration || recv.HostID != hosts["mig-c"].ID {
+		t.Fatalf("expected a receive task on mig-c, got %+v", recv)
+	}
+	if pending := dispatch.Default.DrainPending(hosts["mig-c"].ID); len(pending) != 1 || pending[0].ID != recv.ID {
+		t.Fatalf("receive task not dispatched to mig-c: %+v", pending)
+	}
+	got := fetchVm(t, vmURL, http.StatusOK)
+	if got.State != stores.VMStateMigrating || got.Migration.Port != stores.MigrationPortBase || got.Migration.ReceiveTaskID != recv.ID {
+		t.Fatalf("unexpected migrating vm: %+v %+v", got, got.Migration)
+	}
+	decodeBody(t, postJSON(t, vmURL+"/actions/power", `{"op":"off"}`), http.StatusConflict, nil)
+	migrate(`{}`, http.StatusConflict)
+
+	// The source sends once the target is listening; its VMM exiting meanwhile is not a power-off
+	if len(dispatch.Default.DrainPending(hosts["mig-a"].ID)) != 0 {
+		t.Fatal("send must wait for the receiver")
+	}
+	tasks.Default.UpdateStatusRunning(recv.ID)
+	if len(dispatch.Default.DrainPending(hosts["mig-a"].ID)) != 0 {
+		t.Fatal("send must wait until the receiver is listening")
+	}
+	listening(t, recv)
+	listening(t, recv)
+	sends := dispatch.Default.DrainPending(hosts["mig-a"].ID)
+	if len(sends) != 1 || sends[0].Type != tasks.TypeSendVMMigration {
+		t.Fatalf("expected one send task, got %+v", sends)
+	}
+	var p tasks.SendVMMigrationParams
+	if err := json.Unmarshal(sends[0].Params, &p); err != nil || p.VMID != vm.ID || p.DestinationURL != "tcp:mig-c:49152" {
+		t.Fatalf("unexpected send params: %+v %v", p, err)
+	}
+	if err := vms.Default.HandleStateReport(vm.ID, hosts["mig-a"].ID, stores.VMStateStopped, ""); err != nil {
+		t.Fatal(err)
+	}
+	finishTask(t, *sends[0], "")
+	finishTask(t, recv, "")
+	got = fetchVm(t, vmURL, http.StatusOK)
+	if got.State != stores.VMStateRunning || got.HostID != hosts["mig-c"].ID || got.Migration.State != stores.MigrationCompleted {
+		t.Fatalf("expected vm running on mig-c, got %+v %+v", got, got.Migration)
+	}
+	// Reports from the old host no longer apply
+	if err := vms.Default.HandleStateReport(vm.ID, hosts["mig-a"].ID, stores.VMStateError, "gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed send rolls back: the VM keeps running on its host
+	recv = migrate(`{"targetHostId":"`+hosts["mig-a"].ID+`"}`, http.StatusAccepted)
+	dispatch.Default.DrainPending(hosts["mig-a"].ID)
+	listening(t, recv)
+	finishTask(t, *dispatch.Default.DrainPending(hosts["mig-c"].ID)[0], "connection reset")
+	got = fetchVm(t, vmURL, http.StatusOK)
+	if got.State != stores.VMStateRunning || got.HostID != hosts["mig-c"].ID || got.Migration.State != stores.MigrationFailed || got.Migration.Error != "send from source failed: connection reset" {
+		t.Fatalf("expected rolled back migration, got %+v %+v", got, got.Migration)
+	}
+	finishTask(t, recv, "receive timed out")
+	if got = fetchVm(t, vmURL, http.StatusOK); got.Migration.Error != "send from source failed: connection reset" {
+		t.Fatalf("late receive result changed the migration: %+v", got.Migration)
+	}
+
+	// Across clusters the NIC moves to the target cluster's port group and bridge
+	recv = migrate(`{"targetHostId":"`+hosts["mig-d"].ID+`"}`, http.StatusAccepted)
+	var rp tasks.ReceiveVMMigrationParams
+	if err := json.Unmarshal(recv.Params, &rp); err != nil || rp.Spec.Nics[0].Port.Bridge != dvsB.Bridge {
+		t.Fatalf("receive spec not on the target bridge: %+v %v", rp.Spec.Nics, err)
+	}
+	finishTask(t, recv, "")
+	got = fetchVm(t, vmURL, http.StatusOK)
+	if got.HostID != hosts["mig-d"].ID || got.ClusterID != "c-mig-b" || got.Nets[0].PortGroupID != pgB.ID {
+		t.Fatalf("vm did not move to the target cluster: %+v", got)
+	}
+	dispatch.Default.DrainPending(hosts["mig-d"].ID)
+}
@@ -0,0 +1,757 @@
+// Package chfake is an in-process stand-in for a Cloud Hypervisor VMM. It
+// serves the CH REST API over a unix socket, models the VM state machine
+// (not created → Created → Running ⇄ Paused → Shutdown), validates VM configs
+// the way the VMM does for the fields Vertera sets, and can inject failures.
+// Live migration between two fakes transfers the VM config over the
+// migration URL; snapshots write the files of a CH snapshot directory
+// (config.json, state.json, memory-ranges) and restore reads config.json back.
+//
+// It backs the tests of the hypervisor client, the agent executors and the
+// supervisor; RunVMM lets a test binary pose as the cloud-hypervisor binary.
+package chfake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
+)
+
+// notCreated is the state before vm.create and after vm.delete.
+const notCreated ch.VmInfoState = ""
+
+// Failure makes an endpoint answer with a fixed status instead of acting.
+type Failure struct {
+	Status int
+	Body   string
+	// Times is how many requests fail; 0 means until ClearFailures.
+	Times int
+	// Delay is applied before answering, e.g. to trip client timeouts.
+	Delay time.Duration
+}
+
+// Server is a fake VMM managing a single VM.
+type Server struct {
+	// ExitOnPowerOff mirrors the real VMM, which exits once the guest powers
+	// off (here: after vm.power-button). Done is closed when that happens.
+	ExitOnPowerOff bool
+
+	mu       sync.Mutex
+	state    ch.VmInfoState
+	config   *ch.VmConfig
+	devices  map[string]string // device id -> kind (disk, net, fs, vsock)
+	nextSlot int
+	counters ch.VmCounters
+	failures map[string]*Failure
+	calls    []string
+
+	lis      net.Listener
+	srv      *http.Server
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// New returns a fake VMM with no VM created.
+func New() *Server {
+	return &Server{
+		devices:  make(map[string]string),
+		failures: make(map[string]*Failure),
+		done:     make(chan struct{}),
+		nextSlot: 1,
+	}
+}
+
+// Start creates a fake VMM listening on socketPath.
+func Start(socketPath string) (*Server, error) {
+	s := New()
+	if err := s.Listen(socketPath); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Listen serves the API on a unix socket in the background.
+func (s *Server) Listen(socketPath string) error {
+	_ = os.Remove(socketPath)
+	lis, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	s.lis = lis
+	s.srv = &http.Server{Handler: s}
+	go func() { _ = s.srv.Serve(lis) }()
+	return nil
+}
+
+// Close stops serving and removes the socket.
+func (s *Server) Close() error {
+	if s.srv == nil {
+		return nil
+	}
+	err := s.srv.Close()
+	_ = os.Remove(s.lis.Addr().String())
+	return err
+}
+
+// Done is closed after vmm.shutdown (or a guest power-off with ExitOnPowerOff).
+func (s *Server) Done() <-chan struct{} { return s.done }
+
+// State returns the VM state; the empty string means not created.
+func (s *Server) State() ch.VmInfoState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Config returns a copy of the current VM config, or nil if no VM exists.
+func (s *Server) Config() *ch.VmConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.config == nil {
+		return nil
+	}
+	cp := *s.config
+	return &cp
+}
+
+// Devices returns the ids of the VM's devices, sorted.
+func (s *Server) Devices() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.devices))
+	for id := range s.devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Calls returns the endpoints called so far, e.g. ["vm.create", "vm.boot"].
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+// SetCounters sets what vm.counters returns.
+func (s *Server) SetCounters(c ch.VmCounters) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters = c
+}
+
+// InjectFailure makes endpoint (e.g. "vm.boot") fail as described by f.
+func (s *Server) InjectFailure(endpoint string, f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[endpoint] = &f
+}
+
+// ClearFailures removes all injected failures.
+func (s *Server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = make(map[string]*Failure)
+}
+
+func (s *Server) shutdown() {
+	s.doneOnce.Do(func() { close(s.done) })
+}
+
+// apiErr is an error answer: status plus a body in the VMM's wording.
+type apiErr struct {
+	status int
+	body   string
+}
+
+func fail(status int, format string, args ...any) *apiErr {
+	return &apiErr{status: status, body: fmt.Sprintf(format, args...)}
+}
+
+// ServeHTTP dispatches /api/v1/<endpoint> requests.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.TrimPrefix(r.URL.Path, "/api/v1/")
+
+	s.mu.Lock()
+	s.calls = append(s.calls, endpoint)
+	f := s.failures[endpoint]
+	var injected Failure
+	if f != nil {
+		injected = *f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				delete(s.failures, endpoint)
+			}
+		}
+	}
+	s.mu.Unlock()
+	if f != nil {
+		if injected.Delay > 0 {
+			time.Sleep(injected.Delay)
+		}
+		if injected.Status != 0 {
+			http.Error(w, injected.Body, injected.Status)
+			return
+		}
+	}
+
+	body, aerr := s.handle(endpoint, r)
+	switch {
+	case aerr != nil:
+		http.Error(w, aerr.body, aerr.status)
+	case body != nil:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(body)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	// Exit only once the answer is on the wire, like the VMM does.
+	if aerr == nil && (endpoint == "vmm.shutdown" || (endpoint == "vm.power-button" && s.ExitOnPowerOff)) {
+		if fl, ok := w.(http.Flusher); ok {
+			fl.Flush()
+		}
+		s.shutdown()
+	}
+}
+
+func decode(r *http.Request, v any) *apiErr {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fail(http.StatusBadRequest, "Error from API: invalid request body: %v", err)
+	}
+	return nil
+}
+
+// handle applies a request and returns a JSON body (nil for 204) or an error.
+func (s *Server) handle(endpoint string, r *http.Request) (any, *apiErr) {
+	switch endpoint {
+	case "vmm.ping":
+		pid := int64(os.Getpid())
+		build := "chfake"
+		return ch.VmmPingResponse{Version: "v0.0.0-chfake", BuildVersion: &build, Pid: &pid}, nil
+	case "vmm.shutdown":
+		return nil, nil
+	case "vm.create":
+		var cfg ch.VmConfig
+		if err := decode(r, &cfg); err != nil {
+			return nil, err
+		}
+		return nil, s.create(cfg)
+	case "vm.receive-migration":
+		var req ch.ReceiveMigrationData
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		return nil, s.receiveMigration(r, req.ReceiverUrl)
+	case "vm.send-migration":
+		var req ch.SendMigrationData
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		return nil, s.sendMigration(req.DestinationUrl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if (strings.HasPrefix(endpoint, "vm.add-") || endpoint == "vm.remove-device") && s.state == notCreated {
+		return nil, fail(http.StatusInternalServerError, "Error from API: VmNotCreated")
+	}
+	switch endpoint {
+	case "vm.info":
+		if s.state == notCreated {
+			return nil, fail(http.StatusNotFound, "Error from API: VmNotCreated")
+		}
+		size := s.config.Memory.Size
+		return ch.VmInfo{Config: *s.config, State: s.state, MemoryActualSize: &size}, nil
+	case "vm.boot":
+		switch s.state {
+		case notCreated:
+			return nil, fail(http.StatusNotFound, "Error from API: The VM could not boot: VmNotCreated")
+		case ch.Created, ch.Shutdown:
+			s.state = ch.Running
+			return nil, nil
+		default:
+			return nil, fail(http.StatusMethodNotAllowed, "Error from API: The VM could not boot: InvalidStateTransition(%s, Running)", s.state)
+		}
+	case "vm.shutdown":
+		return nil, s.transition([]ch.VmInfoState{ch.Running, ch.Paused}, ch.Shutdown)
+	case "vm.reboot":
+		return nil, s.transition([]ch.VmInfoState{ch.Running}, ch.Running)
+	case "vm.pause":
+		return nil, s.transition([]ch.VmInfoState{ch.Running}, ch.Paused)
+	case "vm.resume":
+		if s.state == notCreated {
+			return nil, fail(http.StatusNotFound, "Error from API: VmNotCreated")
+		}
+		if s.state != ch.Paused {
+			return nil, fail(http.StatusMethodNotAllowed, "Error from API: The VM could not be resumed: VM is %s, not Paused", s.state)
+		}
+		s.state = ch.Running
+		return nil, nil
+	case "vm.power-button":
+		return nil, s.transition([]ch.VmInfoState{ch.Running}, ch.Shutdown)
+	case "vm.delete":
+		s.state, s.config = notCreated, nil
+		s.devices = make(map[string]string)
+		return nil, nil
+	case "vm.resize":
+		var req ch.VmResize
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		return nil, s.resize(req)
+	case "vm.add-disk":
+		var d ch.DiskConfig
+		if err := decode(r, &d); err != nil {
+			return nil, err
+		}
+		if d.Path == nil && d.VhostSocket == nil {
+			return nil, fail(http.StatusInternalServerError, "Error from API: The disk could not be added: InvalidConfig(missing path)")
+		}
+		return s.addDevice("disk", &d.Id, func() {
+			disks := appendTo(s.config.Disks, d)
+			s.config.Disks = &disks
+		})
+	case "vm.add-net":
+		var n ch.NetConfig
+		if err := decode(r, &n); err != nil {
+			return nil, err
+		}
+		if err := validateNet(n, s.config.Memory); err != nil {
+			return nil, err
+		}
+		return s.addDevice("net", &n.Id, func() {
+			nets := appendTo(s.config.Net, n)
+			s.config.Net = &nets
+		})
+	case "vm.add-fs":
+		var fs ch.FsConfig
+		if err := decode(r, &fs); err != nil {
+			return nil, err
+		}
+		return s.addDevice("fs", &fs.Id, func() {
+			fss := appendTo(s.config.Fs, fs)
+			s.config.Fs = &fss
+		})
+	case "vm.add-vsock":
+		var v ch.VsockConfig
+		if err := decode(r, &v); err != nil {
+			return nil, err
+		}
+		return s.addDevice("vsock", &v.Id, func() { s.config.Vsock = &v })
+	case "vm.remove-device":
+		var req ch.VmRemoveDevice
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		return nil, s.removeDevice(req)
+	case "vm.snapshot":
+		var req ch.VmSnapshotConfig
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		return nil, s.snapshot(req)
+	case "vm.restore":
+		var req ch.RestoreConfig
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		return nil, s.restore(req)
+	case "vm.counters":
+		if s.state != ch.Running && s.state != ch.Paused {
+			return nil, fail(http.StatusInternalServerError, "Error from API: VmNotRunning")
+		}
+		if s.counters == nil {
+			return ch.VmCounters{}, nil
+		}
+		return s.counters, nil
+	}
+	return nil, fail(http.StatusNotFound, "unknown endpoint %s", endpoint)
+}
+
+// transition moves the VM from one of the allowed states to next.
+func (s *Server) transition(from []ch.VmInfoState, next ch.VmInfoState) *apiErr {
+	if s.state == notCreated {
+		return fail(http.StatusNotFound, "Error from API: VmNotCreated")
+	}
+	for _, st := range from {
+		if s.state == st {
+			s.state = next
+			return nil
+		}
+	}
+	if s.state == ch.Created || s.state == ch.Shutdown {
+		return fail(http.StatusMethodNotAllowed, "Error from API: VmNotRunning")
+	}
+	return fail(http.StatusMethodNotAllowed, "Error from API: InvalidStateTransition(%s, %s)", s.state, next)
+}
+
+func (s *Server) create(cfg ch.VmConfig) *apiErr {
+	if err := ValidateConfig(cfg); err != nil {
+		return fail(http.StatusBadRequest, "Error from API: The VM could not be created: InvalidConfig(%v)", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != notCreated {
+		return fail(http.StatusInternalServerError, "Error from API: The VM could not be created: VmAlreadyCreated")
+	}
+	if cfg.Cpus == nil {
+		cfg.Cpus = &ch.CpusConfig{BootVcpus: 1, MaxVcpus: 1}
+	}
+	if cfg.Memory == nil {
+		cfg.Memory = &ch.MemoryConfig{Size: 512 << 20}
+	}
+	s.config = &cfg
+	s.devices = make(map[string]string)
+	for _, id := range deviceIDs(cfg) {
+		s.devices[id.id] = id.kind
+	}
+	s.state = ch.Created
+	return nil
+}
+
+// migrationAddr splits a CH migration URL (tcp:<host>:<port> or unix:<path>).
+func migrationAddr(url string) (network, addr string, err error) {
+	if addr, ok := strings.CutPrefix(url, "tcp:"); ok {
+		return "tcp", addr, nil
+	}
+	if addr, ok := strings.CutPrefix(url, "unix:"); ok {
+		return "unix", addr, nil
+	}
+	return "", "", fmt.Errorf("unsupported migration url %q", url)
+}
+
+// receiveMigration waits for one sender on url and takes over the VM it
+// sends: the fake migration stream is the sender's VM config followed by a
+// one-byte acknowledgement from the receiver.
+func (s *Server) receiveMigration(r *http.Request, url string) *apiErr {
+	s.mu.Lock()
+	created := s.state != notCreated
+	s.mu.Unlock()
+	if created {
+		return fail(http.StatusInternalServerError, "Error from API: The VM could not receive the migration: VmAlreadyCreated")
+	}
+	network, addr, err := migrationAddr(url)
+	if err != nil {
+		return fail(http.StatusBadRequest, "Error from API: The VM could not receive the migration: %v", err)
+	}
+	lis, err := net.Listen(network, addr)
+	if err != nil {
+		return fail(http.StatusInternalServerError, "Error from API: The VM could not receive the migration: MigrateReceive(%v)", err)
+	}
+	stop := context.AfterFunc(r.Context(), func() { _ = lis.Close() })
+	defer stop()
+	conn, err := lis.Accept()
+	_ = lis.Close()
+	if err != nil {
+		return fail(http.StatusInternalServerError, "Error from API: The VM could not receive the migration: MigrateReceive(%v)", err)
+	}
+	defer conn.Close()
+	var cfg ch.VmConfig
+	if err := json.NewDecoder(conn).Decode(&cfg); err != nil {
+		return fail(http.StatusInternalServerError, "Error from API: The VM could not receive the migration: MigrateReceive(%v)", err)
+	}
+	if aerr := s.create(cfg); aerr != nil {
+		return aerr
+	}
+	s.mu.Lock()
+	s.state = ch.Running
+	s.mu.Unlock()
+	_, _ = conn.Write([]byte{1})
+	return nil
+}
+
+// sendMigration sends the VM to the receiver at url. Once the receiver
+// acknowledged it the VM is gone from this VMM; on failure it keeps running.
+func (s *Server) sendMigration(url string) *apiErr {
+	s.mu.Lock()
+	if s.state != ch.Running && s.state != ch.Paused {
+		s.mu.Unlock()
+		return fail(http.StatusInternalServerError, "Error from API: The VM could not be migrated: VmNotRunning")
+	}
+	cfg := *s.config
+	s.mu.Unlock()
+	network, addr, err := migrationAddr(url)
+	if err != nil {
+		return fail(http.StatusBadRequest, "Error from API: The VM could not be migrated: %v", err)
+	}
+	conn, err := net.DialTimeout(network, addr, 5*time.Second)
+	if err != nil {
+		return fail(http.StatusInternalServerError, "Error from API: The VM could not be migrated: MigrateSend(%v)", err)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(cfg); err != nil {
+		return fail(http.StatusInternalServerError, "Error from API: The VM could not be migrated: MigrateSend(%v)", err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		return fail(http.StatusInternalServerError, "Error from API: The VM could not be migrated: MigrateSend(receiver did not acknowledge: %v)", err)
+	}
+	s.mu.Lock()
+	s.state, s.config = notCreated, nil
+	s.devices = make(map[string]string)
+	s.mu.Unlock()
+	return nil
+}
+
+// snapshotDir returns the directory of a file:// snapshot URL.
+func snapshotDir(url string) (string, error) {
+	dir, ok := strings.CutPrefix(url, "file://")
+	if !ok || dir == "" {
+		return "", fmt.Errorf("unsupported snapshot url %q", url)
+	}
+	return dir, nil
+}
+
+// snapshot writes the paused VM's snapshot files into the destination
+// directory, which must exist.
+func (s *Server) snapshot(req ch.VmSnapshotConfig) *apiErr {
+	if s.state == notCreated {
+		return fail(http.StatusNotFound, "Error from API: VmNotCreated")
+	}
+	if s.state != ch.Paused {
+		return fail(http.StatusMethodNotAllowed, "Error from API: The VM could not be snapshotted: VmNotPaused")
+	}
+	if req.DestinationUrl == nil {
+		return fail(http.StatusBadRequest, "Error from API: missing destination_url")
+	}
+	dir, err := snapshotDir(*req.DestinationUrl)
+	if err != nil {
+		return fail(http.StatusBadRequest, "Error from API: The VM could not be snapshotted: %v", err)
+	}
+	cfg, _ := json.Marshal(s.config)
+	files := map[string][]byte{
+		"config.json":   cfg,
+		"state.json":    []byte(`{"chfake":true}`),
+		"memory-ranges": make([]byte, 4096),
+	}
+	for name, b := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o640); err != nil {
+			return fail(http.StatusInternalServerError, "Error from API: The VM could not be snapshotted: SnapshotSend(%v)", err)
+		}
+	}
+	return nil
+}
+
+// restore creates the VM from a snapshot directory's config.json, paused.
+func (s *Server) restore(req ch.RestoreConfig) *apiErr {
+	if s.state != notCreated {
+		return fail(http.StatusInternalServerError, "Error from API: The VM could not be restored: VmAlreadyCreated")
+	}
+	dir, err := snapshotDir(req.SourceUrl)
+	if err != nil {
+		return fail(http.StatusBadRequest, "Error from API: The VM could not be restored: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "state.json")); err != nil {
+		return fail(http.StatusInternalServerError, "Error from API: The VM could not be restored: Restore(%v)", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return fail(http.StatusInternalServerError, "Error from API: The VM could not be restored: Restore(%v)", err)
+	}
+	var cfg ch.VmConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return fail(http.StatusInternalServerError, "Error from API: The VM could not be restored: Restore(%v)", err)
+	}
+	if err := ValidateConfig(cfg); err != nil {
+		return fail(http.StatusInternalServerError, "Error from API: The VM could not be restored: InvalidConfig(%v)", err)
+	}
+	s.config = &cfg
+	s.devices = make(map[string]string)
+	for _, id := range deviceIDs(cfg) {
+		s.devices[id.id] = id.kind
+	}
+	s.state = ch.Paused
+	return nil
+}
+
+func (s *Server) resize(req ch.VmResize) *apiErr {
+	if s.state != ch.Running {
+		return fail(http.StatusMethodNotAllowed, "Error from API: VmNotRunning")
+	}
+	if req.DesiredVcpus != nil {
+		if *req.DesiredVcpus < 1 || *req.DesiredVcpus > s.config.Cpus.MaxVcpus {
+			return fail(http.StatusInternalServerError, "Error from API: The VM could not be resized: DesiredVCpuCountExceedsMax")
+		}
+		s.config.Cpus.BootVcpus = *req.DesiredVcpus
+	}
+	if req.DesiredRam != nil {
+		hotplug := int64(0)
+		if s.config.Memory.HotplugSize != nil {
+			hotplug = *s.config.Memory.HotplugSize
+		}
+		if *req.DesiredRam > s.config.Memory.Size+hotplug {
+			return fail(http.StatusInternalServerError, "Error from API: The VM could not be resized: InsufficientHotplugRam")
+		}
+		if s.config.Memory.HotplugSize != nil {
+			left := s.config.Memory.Size + hotplug - *req.DesiredRam
+			s.config.Memory.HotplugSize = &left
+		}
+		s.config.Memory.Size = *req.DesiredRam
+	}
+	return nil
+}
+
+// addDevice registers a device. Before boot it is a cold add (204); on a
+// running VM it is a hot-plug that reports the device's PCI address.
+func (s *Server) addDevice(kind string, id **string, apply func()) (any, *apiErr) {
+	if *id == nil || **id == "" {
+		gen := fmt.Sprintf("_%s%d", kind, len(s.devices))
+		*id = &gen
+	}
+	if _, dup := s.devices[**id]; dup {
+		return nil, fail(http.StatusInternalServerError, "Error from API: The device could not be added: IdentifierNotUnique(%q)", **id)
+	}
+	apply()
+	s.devices[**id] = kind
+	if s.state == ch.Created || s.state == ch.Shutdown {
+		return nil, nil
+	}
+	slot := s.nextSlot
+	s.nextSlot++
+	return ch.PciDeviceInfo{Id: **id, Bdf: fmt.Sprintf("0000:00:%02x.0", slot)}, nil
+}
+
+func (s *Server) removeDevice(req ch.VmRemoveDevice) *apiErr {
+	if req.Id == nil {
+		return fail(http.StatusBadRequest, "Error from API: missing device id")
+	}
+	kind, ok := s.devices[*req.Id]
+	if !ok {
+		return fail(http.StatusNotFound, "Error from API: The device could not be removed: UnknownDeviceId(%q)", *req.Id)
+	}
+	delete(s.devices, *req.Id)
+	id := *req.Id
+	switch kind {
+	case "disk":
+		s.config.Disks = removeByID(s.config.Disks, func(d ch.DiskConfig) *string { return d.Id }, id)
+	case "net":
+		s.config.Net = removeByID(s.config.Net, func(n ch.NetConfig) *string { return n.Id }, id)
+	case "fs":
+		s.config.Fs = removeByID(s.config.Fs, func(f ch.FsConfig) *string { return f.Id }, id)
+	case "vsock":
+		s.config.Vsock = nil
+	}
+	return nil
+}
+
+// ValidateConfig checks the parts of a VM config the real VMM rejects at create time.
+func ValidateConfig(cfg ch.VmConfig) error {
+	if cfg.Payload.Kernel == nil && cfg.Payload.Firmware == nil && cfg.Payload.Igvm == nil {
+		return errors.New("payload requires a kernel or firmware")
+	}
+	if c := cfg.Cpus; c != nil {
+		if c.BootVcpus < 1 || c.MaxVcpus < c.BootVcpus {
+			return fmt.Errorf("cpus: boot_vcpus %d must be between 1 and max_vcpus %d", c.BootVcpus, c.MaxVcpus)
+		}
+	}
+	if m := cfg.Memory; m != nil {
+		if m.Size <= 0 || m.Size%(4<<10) != 0 {
+			return fmt.Errorf("memory: size %d must be a positive multiple of 4 KiB", m.Size)
+		}
+		if m.HotplugSize != nil && m.HotplugMethod != nil && *m.HotplugMethod == "VirtioMem" && *m.HotplugSize%(128<<20) != 0 {
+			return fmt.Errorf("memory: virtio-mem hotplug_size must be a multiple of 128 MiB")
+		}
+	}
+	if cfg.Disks != nil {
+		for _, d := range *cfg.Disks {
+			if (d.Path == nil || *d.Path == "") && (d.VhostUser == nil || !*d.VhostUser) {
+				return errors.New("disk: path is required")
+			}
+		}
+	}
+	if cfg.Net != nil {
+		for _, n := range *cfg.Net {
+			if err := validateNet(n, cfg.Memory); err != nil {
+				return errors.New(err.body)
+			}
+		}
+	}
+	seen := map[string]bool{}
+	for _, d := range deviceIDs(cfg) {
+		if seen[d.id] {
+			return fmt.Errorf("duplicate device id %q", d.id)
+		}
+		seen[d.id] = true
+	}
+	return nil
+}
+
+func validateNet(n ch.NetConfig, mem *ch.MemoryConfig) *apiErr {
+	if n.VhostUser != nil && *n.VhostUser {
+		if n.VhostSocket == nil || *n.VhostSocket == "" {
+			return fail(http.StatusBadRequest, "net: vhost_user requires vhost_socket")
+		}
+		if mem == nil || mem.Shared == nil || !*mem.Shared {
+			return fail(http.StatusBadRequest, "net: vhost_user requires shared memory")
+		}
+	}
+	if n.Mac != nil {
+		if _, err := net.ParseMAC(*n.Mac); err != nil {
+			return fail(http.StatusBadRequest, "net: invalid mac %q", *n.Mac)
+		}
+	}
+	return nil
+}
+
+type deviceID struct{ id, kind string }
+
+func deviceIDs(cfg ch.VmConfig) []deviceID {
+	var out []deviceID
+	if cfg.Disks != nil {
+		for _, d := range *cfg.Disks {
+			if d.Id != nil {
+				out = append(out, deviceID{*d.Id, "disk"})
+			}
+		}
+	}
+	if cfg.Net != nil {
+		for _, n := range *cfg.Net {
+			if n.Id != nil {
+				out = append(out, deviceID{*n.Id, "net"})
+			}
+		}
+	}
+	if cfg.Fs != nil {
+		for _, f := range *cfg.Fs {
+			if f.Id != nil {
+				out = append(out, deviceID{*f.Id, "fs"})
+			}
+		}
+	}
+	if cfg.Vsock != nil && cfg.Vsock.Id != nil {
+		out = append(out, deviceID{*cfg.Vsock.Id, "vsock"})
+	}
+	return out
+}
+
+func appendTo[T any](list *[]T, v T) []T {
+	if list == nil {
+		return []T{v}
+	}
+	return append(*list, v)
+}
+
+func removeByID[T any](list *[]T, id func(T) *string, want string) *[]T {
+	if list == nil {
+		return nil
+	}
+	out := make([]T, 0, len(*list))
+	for _, v := range *list {
+		if p := id(v); p == nil || *p != want {
+			out = append(out, v)
+		}
+	}
+	return &out
+}
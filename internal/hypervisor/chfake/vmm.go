@@ -0,0 +1,48 @@
+package chfake
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// RunVMM behaves like the cloud-hypervisor binary for the flags the agent
+// passes (--api-socket path=<sock>): it serves the fake API until vmm.shutdown,
+// a guest power-off or SIGTERM, and returns the process exit code. Tests use it
+// from a helper process so the supervisor can spawn and kill a real process.
+func RunVMM(args []string) int {
+	var sock string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--api-socket" && i+1 < len(args) {
+			for _, kv := range strings.Split(args[i+1], ",") {
+				if v, ok := strings.CutPrefix(kv, "path="); ok {
+					sock = v
+				}
+			}
+			i++
+		}
+	}
+	if sock == "" {
+		fmt.Fprintln(os.Stderr, "chfake: --api-socket path=<socket> is required")
+		return 2
+	}
+	s := New()
+	s.ExitOnPowerOff = true
+	if err := s.Listen(sock); err != nil {
+		fmt.Fprintf(os.Stderr, "chfake: listen %s: %v\n", sock, err)
+		return 1
+	}
+	defer s.Close()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case <-s.Done():
+		return 0
+	case sig := <-sigs:
+		fmt.Fprintf(os.Stderr, "chfake: exiting on %v\n", sig)
+		return 0
+	}
+}
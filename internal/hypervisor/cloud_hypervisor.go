@@ -8,24 +8,37 @@ import (
 
 	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
 	"github.com/VerteraIO/cloud-hypervisor-go/unixhttp"
+
+	"github.com/VerteraIO/vertera/internal/agent/runtime"
 )
 
 // CloudHypervisorClient wraps the generated Cloud Hypervisor client with Vertera-specific logic.
+// A VMM manages a single VM, so one client maps to one VM's API socket.
+//
+// Errors are either *TransportError (the VMM could not be reached) or *APIError,
+// which wraps one of the ErrVM* sentinels when the failure is state related.
 type CloudHypervisorClient struct {
 	client *ch.ClientWithResponses
+	// untimed has no overall timeout, for migrations and snapshots: they take
+	// as long as the guest's memory needs, bounded only by the caller's context.
+	untimed *ch.ClientWithResponses
 }
 
 // NewCloudHypervisorClient creates a new Cloud Hypervisor client.
 // For local development, it uses a Unix socket. For remote hosts, use NewCloudHypervisorClientHTTP.
 func NewCloudHypervisorClient(socketPath string) (*CloudHypervisorClient, error) {
 	httpc := unixhttp.NewClientWithTimeout(socketPath, 30*time.Second)
-	
+
 	client, err := ch.NewClientWithResponses("http://unix/api/v1", ch.WithHTTPClient(httpc))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create CH client: %w", err)
 	}
+	untimed, err := ch.NewClientWithResponses("http://unix/api/v1", ch.WithHTTPClient(unixhttp.NewClientWithTimeout(socketPath, 0)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CH client: %w", err)
+	}
 
-	return &CloudHypervisorClient{client: client}, nil
+	return &CloudHypervisorClient{client: client, untimed: untimed}, nil
 }
 
 // NewCloudHypervisorClientHTTP creates a new Cloud Hypervisor client for HTTP endpoints.
@@ -35,17 +48,17 @@ func NewCloudHypervisorClientHTTP(endpoint string) (*CloudHypervisorClient, erro
 		return nil, fmt.Errorf("failed to create CH HTTP client: %w", err)
 	}
 
-	return &CloudHypervisorClient{client: client}, nil
+	return &CloudHypervisorClient{client: client, untimed: client}, nil
 }
 
 // Ping checks if the Cloud Hypervisor VMM is responsive.
 func (c *CloudHypervisorClient) Ping(ctx context.Context) (*ch.VmmPingResponse, error) {
 	resp, err := c.client.GetVmmPingWithResponse(ctx)
 	if err != nil {
-		return nil, err
+		return nil, &TransportError{Op: "ping", Err: err}
 	}
 	if resp.StatusCode() != http.StatusOK {
-		return nil, fmt.Errorf("ping failed with status %d", resp.StatusCode())
+		return nil, apiError("ping", resp.StatusCode(), resp.Body)
 	}
 	return resp.JSON200, nil
 }
@@ -54,10 +67,10 @@ func (c *CloudHypervisorClient) Ping(ctx context.Context) (*ch.VmmPingResponse,
 func (c *CloudHypervisorClient) CreateVM(ctx context.Context, config ch.VmConfig) error {
 	resp, err := c.client.CreateVMWithResponse(ctx, config)
 	if err != nil {
-		return err
+		return &TransportError{Op: "create VM", Err: err}
 	}
 	if resp.StatusCode() != http.StatusNoContent {
-		return fmt.Errorf("create VM failed with status %d", resp.StatusCode())
+		return apiError("create VM", resp.StatusCode(), resp.Body)
 	}
 	return nil
 }
@@ -66,10 +79,10 @@ func (c *CloudHypervisorClient) CreateVM(ctx context.Context, config ch.VmConfig
 func (c *CloudHypervisorClient) BootVM(ctx context.Context) error {
 	resp, err := c.client.BootVMWithResponse(ctx)
 	if err != nil {
-		return err
+		return &TransportError{Op: "boot VM", Err: err}
 	}
 	if resp.StatusCode() != http.StatusNoContent {
-		return fmt.Errorf("boot VM failed with status %d", resp.StatusCode())
+		return apiError("boot VM", resp.StatusCode(), resp.Body)
 	}
 	return nil
 }
@@ -78,10 +91,10 @@ func (c *CloudHypervisorClient) BootVM(ctx context.Context) error {
 func (c *CloudHypervisorClient) ShutdownVM(ctx context.Context) error {
 	resp, err := c.client.ShutdownVMWithResponse(ctx)
 	if err != nil {
-		return err
+		return &TransportError{Op: "shutdown VM", Err: err}
 	}
 	if resp.StatusCode() != http.StatusNoContent {
-		return fmt.Errorf("shutdown VM failed with status %d", resp.StatusCode())
+		return apiError("shutdown VM", resp.StatusCode(), resp.Body)
 	}
 	return nil
 }
@@ -90,10 +103,220 @@ func (c *CloudHypervisorClient) ShutdownVM(ctx context.Context) error {
 func (c *CloudHypervisorClient) GetVMInfo(ctx context.Context) (*ch.VmInfo, error) {
 	resp, err := c.client.GetVmInfoWithResponse(ctx)
 	if err != nil {
-		return nil, err
+		return nil, &TransportError{Op: "get VM info", Err: err}
 	}
-	if resp.StatusCode() != http.StatusOK {
-		return nil, fmt.Errorf("get VM info failed with status %d", resp.StatusCode())
+	if resp.StatusCode() != http.StatusOK || resp.JSON200 == nil {
+		return nil, apiError("get VM info", resp.StatusCode(), resp.Body)
 	}
 	return resp.JSON200, nil
 }
+
+// DeleteVM deletes the VM from the VMM; the VMM process itself keeps running.
+func (c *CloudHypervisorClient) DeleteVM(ctx context.Context) error {
+	resp, err := c.client.DeleteVMWithResponse(ctx)
+	if err != nil {
+		return &TransportError{Op: "delete VM", Err: err}
+	}
+	if resp.StatusCode() != http.StatusNoContent {
+		return apiError("delete VM", resp.StatusCode(), resp.Body)
+	}
+	return nil
+}
+
+// PauseVM pauses a running VM.
+func (c *CloudHypervisorClient) PauseVM(ctx context.Context) error {
+	resp, err := c.client.PauseVMWithResponse(ctx)
+	if err != nil {
+		return &TransportError{Op: "pause VM", Err: err}
+	}
+	if resp.StatusCode() != http.StatusNoContent {
+		return apiError("pause VM", resp.StatusCode(), resp.Body)
+	}
+	return nil
+}
+
+// ResumeVM resumes a paused VM.
+func (c *CloudHypervisorClient) ResumeVM(ctx context.Context) error {
+	resp, err := c.client.ResumeVMWithResponse(ctx)
+	if err != nil {
+		return &TransportError{Op: "resume VM", Err: err}
+	}
+	if resp.StatusCode() != http.StatusNoContent {
+		return apiError("resume VM", resp.StatusCode(), resp.Body)
+	}
+	return nil
+}
+
+// RebootVM reboots a running VM.
+func (c *CloudHypervisorClient) RebootVM(ctx context.Context) error {
+	resp, err := c.client.RebootVMWithResponse(ctx)
+	if err != nil {
+		return &TransportError{Op: "reboot VM", Err: err}
+	}
+	if resp.StatusCode() != http.StatusNoContent {
+		return apiError("reboot VM", resp.StatusCode(), resp.Body)
+	}
+	return nil
+}
+
+// PowerButton presses the virtual ACPI power button so the guest can shut down cleanly.
+func (c *CloudHypervisorClient) PowerButton(ctx context.Context) error {
+	resp, err := c.client.PowerButtonVMWithResponse(ctx)
+	if err != nil {
+		return &TransportError{Op: "power button", Err: err}
+	}
+	if resp.StatusCode() != http.StatusNoContent {
+		return apiError("power button", resp.StatusCode(), resp.Body)
+	}
+	return nil
+}
+
+// ShutdownVMM asks the VMM process to exit.
+func (c *CloudHypervisorClient) ShutdownVMM(ctx context.Context) error {
+	resp, err := c.client.ShutdownVMMWithResponse(ctx)
+	if err != nil {
+		return &TransportError{Op: "shutdown VMM", Err: err}
+	}
+	if resp.StatusCode() != http.StatusNoContent {
+		return apiError("shutdown VMM", resp.StatusCode(), resp.Body)
+	}
+	return nil
+}
+
+// Resize changes the vCPU count and/or RAM size (bytes) of the VM. Nil values are left unchanged.
+func (c *CloudHypervisorClient) Resize(ctx context.Context, vcpus *int, ramBytes *int64) error {
+	resp, err := c.client.PutVmResizeWithResponse(ctx, ch.VmResize{DesiredVcpus: vcpus, DesiredRam: ramBytes})
+	if err != nil {
+		return &TransportError{Op: "resize VM", Err: err}
+	}
+	if resp.StatusCode() != http.StatusNoContent {
+		return apiError("resize VM", resp.StatusCode(), resp.Body)
+	}
+	return nil
+}
+
+// AddDisk hot-plugs (or cold-adds, before boot) a disk. The PCI info is nil for cold adds.
+func (c *CloudHypervisorClient) AddDisk(ctx context.Context, disk ch.DiskConfig) (*ch.PciDeviceInfo, error) {
+	resp, err := c.client.PutVmAddDiskWithResponse(ctx, disk)
+	if err != nil {
+		return nil, &TransportError{Op: "add disk", Err: err}
+	}
+	return addDeviceResult("add disk", resp.StatusCode(), resp.Body, resp.JSON200)
+}
+
+// AddNet hot-plugs (or cold-adds, before boot) a network device.
+func (c *CloudHypervisorClient) AddNet(ctx context.Context, net ch.NetConfig) (*ch.PciDeviceInfo, error) {
+	resp, err := c.client.PutVmAddNetWithResponse(ctx, net)
+	if err != nil {
+		return nil, &TransportError{Op: "add net", Err: err}
+	}
+	return addDeviceResult("add net", resp.StatusCode(), resp.Body, resp.JSON200)
+}
+
+// AddFs hot-plugs (or cold-adds, before boot) a virtio-fs device.
+func (c *CloudHypervisorClient) AddFs(ctx context.Context, fs ch.FsConfig) (*ch.PciDeviceInfo, error) {
+	resp, err := c.client.PutVmAddFsWithResponse(ctx, fs)
+	if err != nil {
+		return nil, &TransportError{Op: "add fs", Err: err}
+	}
+	return addDeviceResult("add fs", resp.StatusCode(), resp.Body, resp.JSON200)
+}
+
+// AddVsock hot-plugs (or cold-adds, before boot) a vsock device.
+func (c *CloudHypervisorClient) AddVsock(ctx context.Context, vsock ch.VsockConfig) (*ch.PciDeviceInfo, error) {
+	resp, err := c.client.PutVmAddVsockWithResponse(ctx, vsock)
+	if err != nil {
+		return nil, &TransportError{Op: "add vsock", Err: err}
+	}
+	return addDeviceResult("add vsock", resp.StatusCode(), resp.Body, resp.JSON200)
+}
+
+// RemoveDevice unplugs the device with the given id (as set in its config or reported by Add*).
+func (c *CloudHypervisorClient) RemoveDevice(ctx context.Context, id string) error {
+	resp, err := c.client.PutVmRemoveDeviceWithResponse(ctx, ch.VmRemoveDevice{Id: &id})
+	if err != nil {
+		return &TransportError{Op: "remove device", Err: err}
+	}
+	if resp.StatusCode() != http.StatusNoContent {
+		return apiError("remove device", resp.StatusCode(), resp.Body)
+	}
+	return nil
+}
+
+// Counters returns per-device counters, e.g. counters["_disk0"]["read_bytes"].
+func (c *CloudHypervisorClient) Counters(ctx context.Context) (ch.VmCounters, error) {
+	resp, err := c.client.GetVmCountersWithResponse(ctx)
+	if err != nil {
+		return nil, &TransportError{Op: "get counters", Err: err}
+	}
+	if resp.StatusCode() != http.StatusOK || resp.JSON200 == nil {
+		return nil, apiError("get counters", resp.StatusCode(), resp.Body)
+	}
+	return *resp.JSON200, nil
+}
+
+// ReceiveMigration makes the VMM wait for a VM sent to receiverURL (e.g.
+// tcp:0.0.0.0:49152) and returns once the VM runs here.
+func (c *CloudHypervisorClient) ReceiveMigration(ctx context.Context, receiverURL string) error {
+	resp, err := c.untimed.PutVmReceiveMigrationWithResponse(ctx, ch.ReceiveMigrationData{ReceiverUrl: receiverURL})
+	if err != nil {
+		return &TransportError{Op: "receive migration", Err: err}
+	}
+	if resp.StatusCode() != http.StatusNoContent {
+		return apiError("receive migration", resp.StatusCode(), resp.Body)
+	}
+	return nil
+}
+
+// SendMigration sends the running VM to destinationURL (e.g.
+// tcp:host:49152) and returns once the receiver took it over.
+func (c *CloudHypervisorClient) SendMigration(ctx context.Context, destinationURL string) error {
+	resp, err := c.untimed.PutVmSendMigrationWithResponse(ctx, ch.SendMigrationData{DestinationUrl: destinationURL})
+	if err != nil {
+		return &TransportError{Op: "send migration", Err: err}
+	}
+	if resp.StatusCode() != http.StatusNoContent {
+		return apiError("send migration", resp.StatusCode(), resp.Body)
+	}
+	return nil
+}
+
+// SnapshotVM writes a snapshot of the paused VM into destinationURL (e.g.
+// file:///var/lib/vertera/vms/<id>/snapshots/<snapshot>). The VM's disks are
+// not part of it.
+func (c *CloudHypervisorClient) SnapshotVM(ctx context.Context, destinationURL string) error {
+	resp, err := c.untimed.PutVmSnapshotWithResponse(ctx, ch.VmSnapshotConfig{DestinationUrl: &destinationURL})
+	if err != nil {
+		return &TransportError{Op: "snapshot VM", Err: err}
+	}
+	if resp.StatusCode() != http.StatusNoContent {
+		return apiError("snapshot VM", resp.StatusCode(), resp.Body)
+	}
+	return nil
+}
+
+// RestoreVM restores the snapshot at sourceURL into the VMM, which must not
+// have a VM yet. The restored VM is paused.
+func (c *CloudHypervisorClient) RestoreVM(ctx context.Context, sourceURL string) error {
+	resp, err := c.untimed.PutVmRestoreWithResponse(ctx, ch.RestoreConfig{SourceUrl: sourceURL})
+	if err != nil {
+		return &TransportError{Op: "restore VM", Err: err}
+	}
+	if resp.StatusCode() != http.StatusNoContent {
+		return apiError("restore VM", resp.StatusCode(), resp.Body)
+	}
+	return nil
+}
+
+func addDeviceResult(op string, status int, body []byte, info *ch.PciDeviceInfo) (*ch.PciDeviceInfo, error) {
+	switch status {
+	case http.StatusOK:
+		return info, nil
+	case http.StatusNoContent:
+		return nil, nil
+	default:
+		return nil, apiError(op, status, body)
+	}
+}
+
+var _ runtime.CloudHypervisor = (*CloudHypervisorClient)(nil)
@@ -0,0 +1,248 @@
+package hypervisor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
+
+	"github.com/VerteraIO/vertera/internal/hypervisor/chfake"
+)
+
+func newFakeClient(t *testing.T) (*CloudHypervisorClient, *chfake.Server) {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "api.sock")
+	fake, err := chfake.Start(sock)
+	if err != nil {
+		t.Fatalf("start fake: %v", err)
+	}
+	t.Cleanup(func() { _ = fake.Close() })
+	c, err := NewCloudHypervisorClient(sock)
+	if err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	return c, fake
+}
+
+func testConfig() ch.VmConfig {
+	fw, disk, id := "/fw", "/disk0.raw", "disk0"
+	return ch.VmConfig{
+		Payload: ch.PayloadConfig{Firmware: &fw},
+		Cpus:    &ch.CpusConfig{BootVcpus: 1, MaxVcpus: 2},
+		Memory:  &ch.MemoryConfig{Size: 512 << 20},
+		Disks:   &[]ch.DiskConfig{{Id: &id, Path: &disk}},
+	}
+}
+
+func TestClientLifecycle(t *testing.T) {
+	c, fake := newFakeClient(t)
+	ctx := context.Background()
+
+	if _, err := c.Ping(ctx); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	if err := c.BootVM(ctx); !errors.Is(err, ErrVMNotCreated) {
+		t.Fatalf("boot before create: got %v", err)
+	}
+	bad := testConfig()
+	bad.Payload = ch.PayloadConfig{}
+	var apiErr *APIError
+	if err := c.CreateVM(ctx, bad); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for config without payload, got %v", err)
+	}
+
+	if err := c.CreateVM(ctx, testConfig()); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := c.CreateVM(ctx, testConfig()); !errors.Is(err, ErrVMAlreadyCreated) {
+		t.Fatalf("second create: got %v", err)
+	}
+	if err := c.BootVM(ctx); err != nil {
+		t.Fatalf("boot: %v", err)
+	}
+	if err := c.BootVM(ctx); !errors.Is(err, ErrVMAlreadyBooted) {
+		t.Fatalf("second boot: got %v", err)
+	}
+	if err := c.ResumeVM(ctx); !errors.Is(err, ErrVMNotPaused) {
+		t.Fatalf("resume running vm: got %v", err)
+	}
+	if err := c.PauseVM(ctx); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	if info, err := c.GetVMInfo(ctx); err != nil || info.State != ch.Paused {
+		t.Fatalf("info after pause: %+v %v", info, err)
+	}
+	if err := c.ResumeVM(ctx); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if err := c.ShutdownVM(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := c.PauseVM(ctx); !errors.Is(err, ErrVMNotBooted) {
+		t.Fatalf("pause after shutdown: got %v", err)
+	}
+	if err := c.DeleteVM(ctx); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := c.GetVMInfo(ctx); !errors.Is(err, ErrVMNotCreated) {
+		t.Fatalf("info after delete: got %v", err)
+	}
+	if fake.State() != "" {
+		t.Fatalf("fake still has a vm in state %q", fake.State())
+	}
+}
+
+func TestClientDevicesAndResize(t *testing.T) {
+	c, fake := newFakeClient(t)
+	ctx := context.Background()
+	if err := c.CreateVM(ctx, testConfig()); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// Before boot devices are cold-added and report no PCI address.
+	netID, tap := "net0", "vt0"
+	if info, err := c.AddNet(ctx, ch.NetConfig{Id: &netID, Tap: &tap}); err != nil || info != nil {
+		t.Fatalf("cold add net: %+v %v", info, err)
+	}
+	if err := c.BootVM(ctx); err != nil {
+		t.Fatalf("boot: %v", err)
+	}
+
+	id, path := "disk1", "/disk1.raw"
+	info, err := c.AddDisk(ctx, ch.DiskConfig{Id: &id, Path: &path})
+	if err != nil || info == nil || info.Id != "disk1" || info.Bdf == "" {
+		t.Fatalf("hot-plug disk: %+v %v", info, err)
+	}
+	if _, err := c.AddDisk(ctx, ch.DiskConfig{Id: &id, Path: &path}); err == nil {
+		t.Fatalf("expected duplicate device id to fail")
+	}
+	if err := c.RemoveDevice(ctx, "disk1"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := c.RemoveDevice(ctx, "disk1"); err == nil || errors.Is(err, ErrVMNotCreated) {
+		t.Fatalf("removing unknown device: got %v", err)
+	}
+	if got := fake.Devices(); len(got) != 2 {
+		t.Fatalf("devices: %v", got)
+	}
+
+	vcpus := 2
+	if err := c.Resize(ctx, &vcpus, nil); err != nil {
+		t.Fatalf("resize: %v", err)
+	}
+	if fake.Config().Cpus.BootVcpus != 2 {
+		t.Fatalf("vcpus not resized: %+v", fake.Config().Cpus)
+	}
+	fake.InjectFailure("vm.resize", chfake.Failure{Status: http.StatusTooManyRequests, Times: 1})
+	if err := c.Resize(ctx, &vcpus, nil); !errors.Is(err, ErrResizePending) {
+		t.Fatalf("injected 429: got %v", err)
+	}
+
+	fake.SetCounters(ch.VmCounters{"disk0": {"read_bytes": 42}})
+	counters, err := c.Counters(ctx)
+	if err != nil || counters["disk0"]["read_bytes"] != 42 {
+		t.Fatalf("counters: %v %v", counters, err)
+	}
+}
+
+func TestClientTransportErrors(t *testing.T) {
+	c, fake := newFakeClient(t)
+	fake.InjectFailure("vmm.ping", chfake.Failure{Delay: 200 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Ping(ctx); !IsTransportError(err) {
+		t.Fatalf("timed out ping should be a transport error, got %v", err)
+	}
+
+	missing, err := NewCloudHypervisorClient(filepath.Join(t.TempDir(), "missing.sock"))
+	if err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	if _, err := missing.Ping(context.Background()); !IsTransportError(err) {
+		t.Fatalf("missing socket should be a transport error, got %v", err)
+	}
+}
+
+func TestClientMigration(t *testing.T) {
+	src, srcFake := newFakeClient(t)
+	dst, dstFake := newFakeClient(t)
+	ctx := context.Background()
+	url := "unix:" + filepath.Join(t.TempDir(), "migration.sock")
+
+	if err := src.SendMigration(ctx, url); err == nil {
+		t.Fatal("expected send without a running vm to fail")
+	}
+	if err := src.CreateVM(ctx, testConfig()); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := src.BootVM(ctx); err != nil {
+		t.Fatalf("boot: %v", err)
+	}
+	if err := src.SendMigration(ctx, url); err == nil || srcFake.State() != ch.Running {
+		t.Fatalf("send without a receiver should fail and keep the vm running: %v, %q", err, srcFake.State())
+	}
+
+	received := make(chan error, 1)
+	go func() { received <- dst.ReceiveMigration(ctx, url) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := src.SendMigration(ctx, url)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("send: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := <-received; err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if srcFake.State() != "" || dstFake.State() != ch.Running || dstFake.Config().Cpus.MaxVcpus != 2 {
+		t.Fatalf("vm did not move: source %q, target %q", srcFake.State(), dstFake.State())
+	}
+	if err := dst.ReceiveMigration(ctx, url); err == nil {
+		t.Fatal("expected receive into a vmm with a vm to fail")
+	}
+}
+
+func TestClientSnapshotRestore(t *testing.T) {
+	src, _ := newFakeClient(t)
+	dst, dstFake := newFakeClient(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	url := "file://" + dir
+
+	if err := src.CreateVM(ctx, testConfig()); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := src.BootVM(ctx); err != nil {
+		t.Fatalf("boot: %v", err)
+	}
+	if err := src.SnapshotVM(ctx, url); err == nil {
+		t.Fatal("expected snapshot of a running vm to fail")
+	}
+	if err := src.PauseVM(ctx); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	if err := src.SnapshotVM(ctx, url); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+
+	if err := dst.RestoreVM(ctx, "file://"+t.TempDir()); err == nil {
+		t.Fatal("expected restore from an empty dir to fail")
+	}
+	if err := dst.RestoreVM(ctx, url); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if dstFake.State() != ch.Paused || dstFake.Config().Cpus.MaxVcpus != 2 {
+		t.Fatalf("unexpected restored vm: %q %+v", dstFake.State(), dstFake.Config())
+	}
+	if err := dst.RestoreVM(ctx, url); !errors.Is(err, ErrVMAlreadyCreated) {
+		t.Fatalf("expected restore into a vmm with a vm to fail with ErrVMAlreadyCreated, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package hypervisor
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors for the VM states Cloud Hypervisor rejects an operation in.
+// Use errors.Is to test for them; the returned error is an *APIError.
+var (
+	ErrVMNotCreated     = errors.New("vm not created")
+	ErrVMAlreadyCreated = errors.New("vm already created")
+	ErrVMAlreadyBooted  = errors.New("vm already booted")
+	ErrVMNotBooted      = errors.New("vm not booted")
+	ErrVMNotPaused      = errors.New("vm not paused")
+	ErrResizePending    = errors.New("vm resize already in progress")
+)
+
+// APIError is a non-success HTTP response from the Cloud Hypervisor API.
+type APIError struct {
+	Op         string
+	StatusCode int
+	Body       string
+	// Kind is one of the sentinel errors above, or nil if the failure is not classified.
+	Kind error
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s failed with status %d", e.Op, e.StatusCode)
+	if e.Kind != nil {
+		msg += ": " + e.Kind.Error()
+	}
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+func (e *APIError) Unwrap() error { return e.Kind }
+
+// TransportError means the VMM could not be reached (socket missing, connection
+// refused, timeout) as opposed to the VMM rejecting the request.
+type TransportError struct {
+	Op  string
+	Err error
+}
+
+func (e *TransportError) Error() string { return fmt.Sprintf("%s: transport error: %v", e.Op, e.Err) }
+
+func (e *TransportError) Unwrap() error { return e.Err }
+
+// IsTransportError reports whether err (or anything it wraps) is a *TransportError.
+func IsTransportError(err error) bool {
+	var te *TransportError
+	return errors.As(err, &te)
+}
+
+// apiError classifies a failed response. The CH spec uses 404 for "not created"
+// and 405 for "wrong state"; older VMMs answer 500 with the Rust error name in
+// the body, so the body is inspected as well.
+func apiError(op string, status int, body []byte) error {
+	text := strings.TrimSpace(string(body))
+	e := &APIError{Op: op, StatusCode: status, Body: text}
+	switch {
+	case strings.Contains(text, "VmNotCreated"):
+		e.Kind = ErrVMNotCreated
+	case strings.Contains(text, "VmAlreadyCreated"):
+		e.Kind = ErrVMAlreadyCreated
+	case strings.Contains(text, "VmNotRunning"), strings.Contains(text, "VmNotBooted"):
+		e.Kind = ErrVMNotBooted
+	case strings.Contains(text, "VmAlreadyBooted"), strings.Contains(text, "InvalidStateTransition(Running, Running)"):
+		e.Kind = ErrVMAlreadyBooted
+	case status == http.StatusNotFound:
+		switch op {
+		case "restore VM":
+			e.Kind = ErrVMAlreadyCreated
+		case "resume VM":
+			e.Kind = ErrVMNotBooted
+		case "remove device", "add disk", "add net", "add fs", "add vsock":
+			// 404 here means the device, not the VM, is unknown.
+		default:
+			e.Kind = ErrVMNotCreated
+		}
+	case status == http.StatusMethodNotAllowed:
+		switch op {
+		case "resume VM":
+			e.Kind = ErrVMNotPaused
+		case "boot VM":
+			e.Kind = ErrVMAlreadyBooted
+		default:
+			e.Kind = ErrVMNotBooted
+		}
+	case status == http.StatusTooManyRequests:
+		e.Kind = ErrResizePending
+	}
+	return e
+}
@@ -0,0 +1,45 @@
+package hypervisor
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestAPIErrorClassification(t *testing.T) {
+	cases := []struct {
+		op     string
+		status int
+		body   string
+		want   error
+	}{
+		{"boot VM", 404, "", ErrVMNotCreated},
+		{"boot VM", 500, "Error from API: The VM could not boot: VmNotCreated", ErrVMNotCreated},
+		{"boot VM", 500, "InvalidStateTransition(Running, Running)", ErrVMAlreadyBooted},
+		{"create VM", 500, "VmAlreadyCreated", ErrVMAlreadyCreated},
+		{"pause VM", 405, "", ErrVMNotBooted},
+		{"resume VM", 405, "", ErrVMNotPaused},
+		{"resize VM", 429, "", ErrResizePending},
+		{"restore VM", 404, "", ErrVMAlreadyCreated},
+	}
+	for _, tc := range cases {
+		err := apiError(tc.op, tc.status, []byte(tc.body))
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s %d %q: got %v, want %v", tc.op, tc.status, tc.body, err, tc.want)
+		}
+		if IsTransportError(err) {
+			t.Errorf("%s: API error misreported as transport error", tc.op)
+		}
+	}
+
+	for _, op := range []string{"remove device", "add disk", "add net", "add fs", "add vsock"} {
+		if err := apiError(op, 404, nil); errors.Is(err, ErrVMNotCreated) {
+			t.Errorf("%s 404 should not mean VM not created: %v", op, err)
+		}
+	}
+
+	te := fmt.Errorf("wrapped: %w", &TransportError{Op: "ping", Err: errors.New("connection refused")})
+	if !IsTransportError(te) {
+		t.Errorf("expected wrapped transport error to be detected")
+	}
+}
@@ -0,0 +1,87 @@
+package network
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PortType selects how a VM NIC is attached to the DVS bridge.
+type PortType string
+
+const (
+	// PortTypeTap is a kernel tap device added to the bridge as a system port.
+	PortTypeTap PortType = "tap"
+	// PortTypeVhostUser is an OVS-DPDK dpdkvhostuserclient port; Cloud Hypervisor
+	// acts as the vhost-user server on SocketPath.
+	PortTypeVhostUser PortType = "vhost-user"
+)
+
+// maxIfNameLen is IFNAMSIZ minus the trailing NUL.
+const maxIfNameLen = 15
+
+// PortSpec is everything the agent needs to realise a NIC on the DVS bridge.
+// It is computed by the controller from the port group and sent with VM tasks.
+type PortSpec struct {
+	Bridge      string            `json:"bridge"`
+	Name        string            `json:"name"`
+	Type        PortType          `json:"type"`
+	SocketPath  string            `json:"socketPath,omitempty"`
+	MTU         int               `json:"mtu,omitempty"`
+	VlanMode    VlanMode          `json:"vlanMode"`
+	Tag         int               `json:"tag,omitempty"`
+	Trunks      []int             `json:"trunks,omitempty"`
+	ExternalIDs map[string]string `json:"externalIds,omitempty"`
+}
+
+// NewPortSpec builds the OVS port settings for a NIC in a port group.
+// In access mode the port is tagged with vlanID. In trunk mode the allowed
+// ranges become Port.trunks and an optional vlanID is the native, untagged VLAN.
+func NewPortSpec(bridge, name string, typ PortType, mode VlanMode, vlanID *int, trunkAllowed []string) (PortSpec, error) {
+	if err := ValidatePortGroupVlan(mode, vlanID, trunkAllowed); err != nil {
+		return PortSpec{}, err
+	}
+	if len(name) > maxIfNameLen {
+		return PortSpec{}, fmt.Errorf("port name %q longer than %d characters", name, maxIfNameLen)
+	}
+	spec := PortSpec{Bridge: bridge, Name: name, Type: typ, VlanMode: mode}
+	if vlanID != nil {
+		spec.Tag = *vlanID
+	}
+	if mode == VlanModeTrunk {
+		ranges, _ := ParseTrunks(trunkAllowed)
+		spec.Trunks = ExpandTrunks(ranges)
+	}
+	return spec, nil
+}
+
+// BridgeName derives the OVS bridge name for a DVS.
+// Bridge names double as kernel interface names so they are capped at 15 chars.
+func BridgeName(dvsName string) string {
+	n := "br-" + sanitizeIfName(dvsName)
+	if len(n) > maxIfNameLen {
+		n = n[:maxIfNameLen]
+	}
+	return n
+}
+
+// TapName returns a stable tap name for NIC index idx of a VM, e.g. "vt1a2b3c4d-0".
+func TapName(vmID string, idx int) string {
+	id := sanitizeIfName(strings.ReplaceAll(vmID, "-", ""))
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return fmt.Sprintf("vt%s-%d", id, idx)
+}
+
+func sanitizeIfName(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
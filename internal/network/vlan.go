@@ -0,0 +1,136 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VlanMode is how a DVS port group presents VLANs to attached NICs.
+type VlanMode string
+
+const (
+	VlanModeAccess VlanMode = "access"
+	VlanModeTrunk  VlanMode = "trunk"
+)
+
+// Valid 802.1Q VLAN IDs (0 and 4095 are reserved).
+const (
+	MinVlanID = 1
+	MaxVlanID = 4094
+)
+
+// VlanRange is an inclusive range of VLAN IDs.
+type VlanRange struct {
+	Start int
+	End   int
+}
+
+func (r VlanRange) String() string {
+	if r.Start == r.End {
+		return strconv.Itoa(r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// Overlaps reports whether r and o share at least one VLAN ID.
+func (r VlanRange) Overlaps(o VlanRange) bool {
+	return r.Start <= o.End && o.Start <= r.End
+}
+
+// ValidateVlanID checks that id is a usable 802.1Q VLAN ID.
+func ValidateVlanID(id int) error {
+	if id < MinVlanID || id > MaxVlanID {
+		return fmt.Errorf("vlan id %d out of range %d-%d", id, MinVlanID, MaxVlanID)
+	}
+	return nil
+}
+
+// ParseVlanRange parses a single VLAN ("100") or an inclusive range ("100-120").
+func ParseVlanRange(s string) (VlanRange, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return VlanRange{}, fmt.Errorf("empty vlan range")
+	}
+	lo, hi, isRange := strings.Cut(s, "-")
+	start, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return VlanRange{}, fmt.Errorf("invalid vlan range %q", s)
+	}
+	end := start
+	if isRange {
+		end, err = strconv.Atoi(strings.TrimSpace(hi))
+		if err != nil {
+			return VlanRange{}, fmt.Errorf("invalid vlan range %q", s)
+		}
+	}
+	if err := ValidateVlanID(start); err != nil {
+		return VlanRange{}, fmt.Errorf("invalid vlan range %q: %w", s, err)
+	}
+	if err := ValidateVlanID(end); err != nil {
+		return VlanRange{}, fmt.Errorf("invalid vlan range %q: %w", s, err)
+	}
+	if end < start {
+		return VlanRange{}, fmt.Errorf("invalid vlan range %q: end before start", s)
+	}
+	return VlanRange{Start: start, End: end}, nil
+}
+
+// ParseTrunks parses a trunk allow-list and rejects overlapping entries.
+// The result is sorted by start VLAN.
+func ParseTrunks(specs []string) ([]VlanRange, error) {
+	ranges := make([]VlanRange, 0, len(specs))
+	for _, s := range specs {
+		r, err := ParseVlanRange(s)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i-1].Overlaps(ranges[i]) {
+			return nil, fmt.Errorf("vlan ranges %s and %s overlap", ranges[i-1], ranges[i])
+		}
+	}
+	return ranges, nil
+}
+
+// ExpandTrunks flattens ranges into the list of VLAN IDs OVS expects in Port.trunks.
+func ExpandTrunks(ranges []VlanRange) []int {
+	var out []int
+	for _, r := range ranges {
+		for v := r.Start; v <= r.End; v++ {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// ValidatePortGroupVlan checks the VLAN settings of a port group.
+// Access mode requires vlanID and no trunk list. Trunk mode accepts an
+// optional native vlanID and a non-overlapping trunk list; an empty list
+// trunks every VLAN, matching OVS semantics.
+func ValidatePortGroupVlan(mode VlanMode, vlanID *int, trunkAllowed []string) error {
+	switch mode {
+	case VlanModeAccess:
+		if vlanID == nil {
+			return fmt.Errorf("vlanId is required for access port groups")
+		}
+		if len(trunkAllowed) > 0 {
+			return fmt.Errorf("trunkAllowed is only valid for trunk port groups")
+		}
+		return ValidateVlanID(*vlanID)
+	case VlanModeTrunk:
+		if vlanID != nil {
+			if err := ValidateVlanID(*vlanID); err != nil {
+				return fmt.Errorf("native %w", err)
+			}
+		}
+		_, err := ParseTrunks(trunkAllowed)
+		return err
+	default:
+		return fmt.Errorf("unsupported vlanMode %q (want access or trunk)", mode)
+	}
+}
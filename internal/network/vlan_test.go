@@ -0,0 +1,61 @@
+package network
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTrunks(t *testing.T) {
+	ranges, err := ParseTrunks([]string{"200", "100-102"})
+	if err != nil {
+		t.Fatalf("ParseTrunks: %v", err)
+	}
+	if got := ExpandTrunks(ranges); !reflect.DeepEqual(got, []int{100, 101, 102, 200}) {
+		t.Fatalf("unexpected expansion: %v", got)
+	}
+
+	for _, bad := range [][]string{
+		{"0"},
+		{"4095"},
+		{"120-100"},
+		{"abc"},
+		{"100-120", "110-130"},
+		{"100", "100"},
+	} {
+		if _, err := ParseTrunks(bad); err == nil {
+			t.Fatalf("expected error for %v", bad)
+		}
+	}
+}
+
+func TestValidatePortGroupVlan(t *testing.T) {
+	vid := 100
+	if err := ValidatePortGroupVlan(VlanModeAccess, &vid, nil); err != nil {
+		t.Fatalf("access: %v", err)
+	}
+	if err := ValidatePortGroupVlan(VlanModeAccess, nil, nil); err == nil {
+		t.Fatal("expected access without vlanId to fail")
+	}
+	if err := ValidatePortGroupVlan(VlanModeAccess, &vid, []string{"1-10"}); err == nil {
+		t.Fatal("expected access with trunkAllowed to fail")
+	}
+	if err := ValidatePortGroupVlan(VlanModeTrunk, nil, []string{"100-120"}); err != nil {
+		t.Fatalf("trunk: %v", err)
+	}
+	if err := ValidatePortGroupVlan("hybrid", nil, nil); err == nil {
+		t.Fatal("expected unknown mode to fail")
+	}
+}
+
+func TestNewPortSpec(t *testing.T) {
+	spec, err := NewPortSpec("br-prod", TapName("1a2b3c4d-5e6f", 0), PortTypeTap, VlanModeTrunk, nil, []string{"10-12"})
+	if err != nil {
+		t.Fatalf("NewPortSpec: %v", err)
+	}
+	if spec.Name != "vt1a2b3c4d-0" || spec.Tag != 0 || !reflect.DeepEqual(spec.Trunks, []int{10, 11, 12}) {
+		t.Fatalf("unexpected spec: %+v", spec)
+	}
+	if got := BridgeName("Production Switch"); got != "br-production-s" {
+		t.Fatalf("unexpected bridge name %q", got)
+	}
+}
@@ -0,0 +1,164 @@
+package ovs
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/VerteraIO/vertera/internal/agent/runtime"
+	"github.com/VerteraIO/vertera/internal/network"
+)
+
+// Runner executes a command and returns its combined output.
+type Runner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+func execRunner(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+// Client drives Open vSwitch through ovs-vsctl and iproute2.
+type Client struct {
+	run Runner
+}
+
+var _ runtime.OpenvSwitch = (*Client)(nil)
+
+// NewClient returns a Client that shells out to the host's ovs-vsctl and ip binaries.
+func NewClient() *Client {
+	return &Client{run: execRunner}
+}
+
+// NewClientWithRunner returns a Client that uses run instead of exec (useful in tests).
+func NewClientWithRunner(run Runner) *Client {
+	return &Client{run: run}
+}
+
+func (c *Client) vsctl(ctx context.Context, args ...string) error {
+	out, err := c.run(ctx, "ovs-vsctl", args...)
+	if err != nil {
+		return fmt.Errorf("ovs-vsctl %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// EnsureBridge creates the bridge if it does not exist yet.
+func (c *Client) EnsureBridge(ctx context.Context, bridge string) error {
+	return c.vsctl(ctx, "--may-exist", "add-br", bridge)
+}
+
+// AddPort creates (or updates) the port described by spec on its bridge.
+// Tap devices are created up front so OVS can attach them before the VMM opens them.
+func (c *Client) AddPort(ctx context.Context, spec network.PortSpec) error {
+	if spec.Type == network.PortTypeTap {
+		if err := c.ensureTap(ctx, spec.Name, spec.MTU); err != nil {
+			return err
+		}
+	}
+	args, err := AddPortArgs(spec)
+	if err != nil {
+		return err
+	}
+	return c.vsctl(ctx, args...)
+}
+
+// DeletePort removes the port from the bridge and deletes any tap device behind it.
+func (c *Client) DeletePort(ctx context.Context, bridge, name string) error {
+	if err := c.vsctl(ctx, "--if-exists", "del-port", bridge, name); err != nil {
+		return err
+	}
+	// The tap may never have existed (vhost-user) or was already removed.
+	_, _ = c.run(ctx, "ip", "tuntap", "del", "dev", name, "mode", "tap")
+	return nil
+}
+
+func (c *Client) ensureTap(ctx context.Context, name string, mtu int) error {
+	if _, err := c.run(ctx, "ip", "link", "show", "dev", name); err != nil {
+		if out, err := c.run(ctx, "ip", "tuntap", "add", "dev", name, "mode", "tap", "vnet_hdr"); err != nil {
+			return fmt.Errorf("create tap %s: %w: %s", name, err, strings.TrimSpace(string(out)))
+		}
+	}
+	args := []string{"link", "set", "dev", name, "up"}
+	if mtu > 0 {
+		args = append(args, "mtu", strconv.Itoa(mtu))
+	}
+	if out, err := c.run(ctx, "ip", args...); err != nil {
+		return fmt.Errorf("set tap %s up: %w: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// AddPortArgs builds a single ovs-vsctl transaction that adds the port and
+// (re)applies its VLAN and interface settings, so repeated calls converge.
+func AddPortArgs(spec network.PortSpec) ([]string, error) {
+	if spec.Bridge == "" || spec.Name == "" {
+		return nil, fmt.Errorf("bridge and port name are required")
+	}
+	args := []string{"--may-exist", "add-port", spec.Bridge, spec.Name}
+
+	// VLAN settings live on the Port row.
+	port := []string{"--", "set", "Port", spec.Name}
+	switch spec.VlanMode {
+	case network.VlanModeAccess:
+		if spec.Tag == 0 {
+			return nil, fmt.Errorf("access port %s requires a tag", spec.Name)
+		}
+		port = append(port, "vlan_mode=access", "tag="+strconv.Itoa(spec.Tag))
+		args = append(args, port...)
+		args = append(args, "--", "clear", "Port", spec.Name, "trunks")
+	case network.VlanModeTrunk:
+		if spec.Tag != 0 {
+			port = append(port, "vlan_mode=native-untagged", "tag="+strconv.Itoa(spec.Tag))
+		} else {
+			port = append(port, "vlan_mode=trunk")
+		}
+		if len(spec.Trunks) > 0 {
+			port = append(port, "trunks="+joinInts(spec.Trunks))
+		}
+		args = append(args, port...)
+		if spec.Tag == 0 {
+			args = append(args, "--", "clear", "Port", spec.Name, "tag")
+		}
+		if len(spec.Trunks) == 0 {
+			args = append(args, "--", "clear", "Port", spec.Name, "trunks")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported vlan mode %q", spec.VlanMode)
+	}
+
+	// Interface type, vhost-user socket and ownership metadata live on the Interface row.
+	iface := []string{"--", "set", "Interface", spec.Name}
+	switch spec.Type {
+	case network.PortTypeTap, "":
+		iface = append(iface, "type=system")
+	case network.PortTypeVhostUser:
+		if spec.SocketPath == "" {
+			return nil, fmt.Errorf("vhost-user port %s requires a socket path", spec.Name)
+		}
+		iface = append(iface, "type=dpdkvhostuserclient", "options:vhost-server-path="+spec.SocketPath)
+	default:
+		return nil, fmt.Errorf("unsupported port type %q", spec.Type)
+	}
+	if spec.MTU > 0 {
+		iface = append(iface, "mtu_request="+strconv.Itoa(spec.MTU))
+	}
+	keys := make([]string, 0, len(spec.ExternalIDs))
+	for k := range spec.ExternalIDs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		iface = append(iface, fmt.Sprintf("external_ids:%s=%q", k, spec.ExternalIDs[k]))
+	}
+	return append(args, iface...), nil
+}
+
+func joinInts(vs []int) string {
+	s := make([]string, len(vs))
+	for i, v := range vs {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ",")
+}
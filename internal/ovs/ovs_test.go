@@ -0,0 +1,61 @@
+package ovs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/VerteraIO/vertera/internal/network"
+)
+
+func TestAddPortArgs(t *testing.T) {
+	access := network.PortSpec{Bridge: "br0", Name: "vt1", Type: network.PortTypeTap, VlanMode: network.VlanModeAccess, Tag: 100}
+	args, err := AddPortArgs(access)
+	if err != nil {
+		t.Fatalf("AddPortArgs: %v", err)
+	}
+	got := strings.Join(args, " ")
+	want := "--may-exist add-port br0 vt1 -- set Port vt1 vlan_mode=access tag=100 -- clear Port vt1 trunks -- set Interface vt1 type=system"
+	if got != want {
+		t.Fatalf("access args:\n got %s\nwant %s", got, want)
+	}
+
+	trunk := network.PortSpec{
+		Bridge: "br0", Name: "vu1", Type: network.PortTypeVhostUser, SocketPath: "/run/vu1.sock",
+		VlanMode: network.VlanModeTrunk, Trunks: []int{10, 11},
+	}
+	args, err = AddPortArgs(trunk)
+	if err != nil {
+		t.Fatalf("AddPortArgs: %v", err)
+	}
+	got = strings.Join(args, " ")
+	for _, frag := range []string{"vlan_mode=trunk", "trunks=10,11", "clear Port vu1 tag", "type=dpdkvhostuserclient", "options:vhost-server-path=/run/vu1.sock"} {
+		if !strings.Contains(got, frag) {
+			t.Fatalf("trunk args missing %q: %s", frag, got)
+		}
+	}
+
+	if _, err := AddPortArgs(network.PortSpec{Bridge: "br0", Name: "vu2", Type: network.PortTypeVhostUser, VlanMode: network.VlanModeTrunk}); err == nil {
+		t.Fatal("expected vhost-user without socket to fail")
+	}
+}
+
+func TestAddPortCreatesTap(t *testing.T) {
+	var calls []string
+	c := NewClientWithRunner(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		line := name + " " + strings.Join(args, " ")
+		calls = append(calls, line)
+		if strings.HasPrefix(line, "ip link show") {
+			return nil, errors.New("does not exist")
+		}
+		return nil, nil
+	})
+	spec := network.PortSpec{Bridge: "br0", Name: "vt1", Type: network.PortTypeTap, VlanMode: network.VlanModeAccess, Tag: 5}
+	if err := c.AddPort(context.Background(), spec); err != nil {
+		t.Fatalf("AddPort: %v", err)
+	}
+	if len(calls) != 4 || !strings.HasPrefix(calls[1], "ip tuntap add dev vt1") || !strings.HasPrefix(calls[3], "ovs-vsctl") {
+		t.Fatalf("unexpected calls: %q", calls)
+	}
+}
@@ -0,0 +1,254 @@
+package packages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// downloads serialises downloads of the same cache file across services, so
+// concurrent tasks needing one artifact share a single download.
+var downloads = struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}{locks: make(map[string]*sync.Mutex)}
+
+func downloadLock(path string) *sync.Mutex {
+	downloads.mu.Lock()
+	defer downloads.mu.Unlock()
+	l, ok := downloads.locks[path]
+	if !ok {
+		l = &sync.Mutex{}
+		downloads.locks[path] = l
+	}
+	return l
+}
+
+// DownloadPackage downloads a package to the cache directory and verifies
+// its SHA-256 digest. A cached file is reused only if its digest matches.
+// Files come from the controller mirror when one is set, and from their
+// upstream URL otherwise or as a fallback.
+//
+// A download is written to a partial file that only takes the final name
+// once its digest matched. Interrupted downloads resume where they stopped,
+// and transient failures are retried with backoff.
+func (s *Service) DownloadPackage(info PackageInfo) (string, error) {
+	if info.SHA256 == "" {
+		return "", fmt.Errorf("%w: %s has no sha256 digest", ErrIntegrity, info.Name)
+	}
+	if info.Name == "" || strings.ContainsAny(info.Name, `/\`) || info.Name == "." || info.Name == ".." {
+		return "", fmt.Errorf("invalid package file name %q", info.Name)
+	}
+	// Create cache directory if it doesn't exist
+	if err := os.MkdirAll(s.cacheDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	filePath := filepath.Join(s.cacheDir, info.Name)
+	lock := downloadLock(filePath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Reuse a cached file only if its content matches
+	if sum, err := fileSHA256(filePath); err == nil {
+		if checkDigest(info, sum) == nil {
+			return filePath, nil // Already cached
+		}
+		log.Printf("packages: cached %s does not match its digest, downloading again", info.Name)
+	}
+
+	type source struct{ url, token string }
+	var sources []source
+	if s.Mirror != "" {
+		sources = append(sources, source{strings.TrimRight(s.Mirror, "/") + "/api/v1/mirror/files/sha256:" + info.SHA256, s.MirrorToken})
+	}
+	if info.URL != "" && (s.Mirror == "" || s.UpstreamFallback) {
+		sources = append(sources, source{info.URL, ""})
+	}
+	if len(sources) == 0 {
+		return "", fmt.Errorf("%s is only available upstream and upstream fallback is disabled", info.Name)
+	}
+	var errs []error
+	for _, src := range sources {
+		err := s.fetch(src.url, src.token, filePath, info)
+		if err == nil {
+			return filePath, nil
+		}
+		errs = append(errs, fmt.Errorf("fetch %s from %s: %w", info.Name, src.url, err))
+	}
+	return "", errors.Join(errs...)
+}
+
+// retryable marks a download failure that is worth another attempt.
+type retryable struct{ err error }
+
+func (e retryable) Error() string { return e.err.Error() }
+func (e retryable) Unwrap() error { return e.err }
+
+// fetch downloads url to filePath, retrying transient failures, and checks
+// the digest of the file before giving it its final name. The partial file
+// is named after the digest, so a resumed download never mixes contents.
+func (s *Service) fetch(url, token, filePath string, info PackageInfo) error {
+	part := filePath + "." + info.SHA256 + ".part"
+	delay := s.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := s.fetchOnce(url, token, part, info)
+		if err == nil {
+			break
+		}
+		var r retryable
+		if !errors.As(err, &r) || attempt >= s.attempts {
+			return err
+		}
+		log.Printf("packages: download %s (attempt %d/%d): %v; retrying in %s", info.Name, attempt, s.attempts, err, delay)
+		time.Sleep(delay)
+		delay = min(delay*2, 30*time.Second)
+	}
+	sum, err := fileSHA256(part)
+	if err != nil {
+		return err
+	}
+	if err := checkDigest(info, sum); err != nil {
+		_ = os.Remove(part)
+		return err
+	}
+	return os.Rename(part, filePath)
+}
+
+// fetchOnce appends the rest of url to the partial file, asking the server
+// to resume after the bytes already there.
+func (s *Service) fetchOnce(url, token, part string, info PackageInfo) error {
+	out, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer func() {
+		if err := out.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+			fmt.Fprintf(os.Stderr, "Error closing file: %v\n", err)
+		}
+	}()
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "VerteraIO/1.0")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return retryable{fmt.Errorf("failed to download: %w", err)}
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing response body: %v\n", err)
+		}
+	}()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 && rangeStart(resp) == offset:
+		log.Printf("packages: resuming %s at byte %d", info.Name, offset)
+	case resp.StatusCode == http.StatusOK:
+		// The server sent the whole file.
+		if err := restart(out); err != nil {
+			return err
+		}
+		offset = 0
+	case resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial file does not fit what the server has; start over.
+		if err := restart(out); err != nil {
+			return err
+		}
+		return retryable{fmt.Errorf("cannot resume at byte %d: %s", offset, resp.Status)}
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return retryable{fmt.Errorf("download failed with status: %s", resp.Status)}
+	default:
+		return fmt.Errorf("download failed with status: %s", resp.Status)
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	} else if info.Size > 0 {
+		total = info.Size
+	}
+	stall := time.AfterFunc(s.stallTimeout, cancel)
+	defer stall.Stop()
+	pw := &progressWriter{name: info.Name, done: offset, total: total, report: s.Progress, stall: stall, stallTimeout: s.stallTimeout}
+	if _, err := io.Copy(io.MultiWriter(out, pw), resp.Body); err != nil {
+		return retryable{fmt.Errorf("failed to write file: %w", err)}
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if s.Progress != nil {
+		s.Progress(info.Name, pw.done, total)
+	}
+	return nil
+}
+
+// restart empties the partial file.
+func restart(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+// rangeStart returns the first byte of a 206 response's Content-Range, or -1.
+func rangeStart(resp *http.Response) int64 {
+	v, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return -1
+	}
+	first, _, ok := strings.Cut(v, "-")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// progressWriter counts downloaded bytes, reports them about once a second
+// and keeps the stall timer from firing while data arrives.
+type progressWriter struct {
+	name         string
+	done, total  int64
+	report       func(name string, done, total int64)
+	last         time.Time
+	stall        *time.Timer
+	stallTimeout time.Duration
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.done += int64(len(p))
+	w.stall.Reset(w.stallTimeout)
+	if w.report != nil && time.Since(w.last) >= time.Second {
+		w.last = time.Now()
+		w.report(w.name, w.done, w.total)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,146 @@
+package packages
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testService(t *testing.T) *Service {
+	t.Helper()
+	svc := NewService(t.TempDir(), nil)
+	svc.retryDelay = time.Millisecond
+	return svc
+}
+
+func testPackage(url string, body []byte) PackageInfo {
+	sum := sha256.Sum256(body)
+	return PackageInfo{Name: "pkg.rpm", URL: url, SHA256: hex.EncodeToString(sum[:]), Required: true}
+}
+
+func TestDownloadPackageResumes(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 10000)
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 1 {
+			// Drop the connection halfway through the first response.
+			w.Header().Set("Content-Length", "100000")
+			_, _ = w.Write(body[:40000])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "pkg.rpm", time.Time{}, bytes.NewReader(body))
+	}))
+	defer ts.Close()
+
+	svc := testService(t)
+	var done, total int64
+	svc.Progress = func(_ string, d, tot int64) { done, total = d, tot }
+	path, err := svc.DownloadPackage(testPackage(ts.URL, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != "bytes=40000-" {
+		t.Fatalf("expected the second request to resume at byte 40000, got %q", ranges)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, body) {
+		t.Fatal("resumed file differs")
+	}
+	if done != int64(len(body)) || total != int64(len(body)) {
+		t.Fatalf("expected final progress %d/%d, got %d/%d", len(body), len(body), done, total)
+	}
+}
+
+func TestDownloadPackageDiscardsBadPartial(t *testing.T) {
+	body := []byte("the real package")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "pkg.rpm", time.Time{}, bytes.NewReader(body))
+	}))
+	defer ts.Close()
+
+	svc := testService(t)
+	info := testPackage(ts.URL, body)
+	part := svc.cacheDir + "/pkg.rpm." + info.SHA256 + ".part"
+	if err := os.WriteFile(part, []byte("garbage!"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.DownloadPackage(info); !errors.Is(err, ErrIntegrity) {
+		t.Fatalf("expected an integrity error, got %v", err)
+	}
+	if _, err := os.Stat(part); !os.IsNotExist(err) {
+		t.Fatalf("expected the partial file to be removed, got %v", err)
+	}
+	if _, err := svc.DownloadPackage(info); err != nil {
+		t.Fatalf("expected a fresh download to succeed, got %v", err)
+	}
+}
+
+func TestDownloadPackageRetries(t *testing.T) {
+	body := []byte("flaky package")
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/missing.rpm":
+			hits.Add(1)
+			http.NotFound(w, r)
+		case hits.Add(1) <= 2:
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+		default:
+			_, _ = w.Write(body)
+		}
+	}))
+	defer ts.Close()
+
+	svc := testService(t)
+	if _, err := svc.DownloadPackage(testPackage(ts.URL+"/pkg.rpm", body)); err != nil || hits.Load() != 3 {
+		t.Fatalf("expected success on the third attempt, got %v after %d", err, hits.Load())
+	}
+	hits.Store(0)
+	missing := testPackage(ts.URL+"/missing.rpm", []byte("missing"))
+	missing.Name = "missing.rpm"
+	if _, err := svc.DownloadPackage(missing); err == nil || hits.Load() != 1 {
+		t.Fatalf("expected a 404 to fail without retries, got %v after %d", err, hits.Load())
+	}
+}
+
+func TestDownloadPackageShared(t *testing.T) {
+	body := []byte("shared package")
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write(body)
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	info := testPackage(ts.URL, body)
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each task builds its own service, like the agent does.
+			_, errs[i] = NewService(dir, nil).DownloadPackage(info)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("expected one download, got %d", hits.Load())
+	}
+}
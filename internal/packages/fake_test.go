@@ -0,0 +1,114 @@
+package packages
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FakeManager is an in-memory package manager for tests. It reads package
+// names and versions from file names of the form <name>-<version>.<ext>.
+type FakeManager struct {
+	// Format is the family the fake poses as; empty means rpm.
+	Format Family
+	// Err, when set, fails Apply and Remove.
+	Err error
+
+	mu        sync.Mutex
+	installed map[string]string
+	calls     []string
+}
+
+var _ Manager = (*FakeManager)(nil)
+
+func (m *FakeManager) Family() Family {
+	if m.Format == "" {
+		return FamilyRPM
+	}
+	return m.Format
+}
+
+func (m *FakeManager) Installed(name string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.installed[name]
+}
+
+// Packages returns the installed packages and their versions.
+func (m *FakeManager) Packages() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]string, len(m.installed))
+	for n, v := range m.installed {
+		out[n] = v
+	}
+	return out
+}
+
+// Calls returns the changes made so far, e.g. "upgrade a-1.rpm b-1.rpm".
+func (m *FakeManager) Calls() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.calls...)
+}
+
+func (m *FakeManager) PackageName(file string) (string, error) {
+	name, _, err := parseFakeFile(file)
+	return name, err
+}
+
+func (m *FakeManager) Apply(action string, files []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record(action, files)
+	if m.Err != nil {
+		return m.Err
+	}
+	if m.installed == nil {
+		m.installed = make(map[string]string)
+	}
+	for _, f := range files {
+		name, version, err := parseFakeFile(f)
+		if err != nil {
+			return err
+		}
+		m.installed[name] = version
+	}
+	return nil
+}
+
+func (m *FakeManager) Remove(names []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.record("remove", names)
+	if m.Err != nil {
+		return m.Err
+	}
+	for _, n := range names {
+		delete(m.installed, n)
+	}
+	return nil
+}
+
+// VerifySignatures accepts every file.
+func (m *FakeManager) VerifySignatures(keys, files []string) error {
+	return nil
+}
+
+func (m *FakeManager) record(action string, args []string) {
+	names := make([]string, len(args))
+	for i, a := range args {
+		names[i] = filepath.Base(a)
+	}
+	m.calls = append(m.calls, strings.TrimSpace(action+" "+strings.Join(names, " ")))
+}
+
+func parseFakeFile(file string) (name, version string, err error) {
+	base := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	i := strings.LastIndex(base, "-")
+	if i <= 0 {
+		return "", "", fmt.Errorf("fake package file %s is not named <name>-<version>.<ext>", filepath.Base(file))
+	}
+	return base[:i], base[i+1:], nil
+}
@@ -0,0 +1,312 @@
+package packages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// packageSpec tells how a package type shows up on a host of each family.
+type packageSpec struct {
+	// pkg is the package whose version is the installed version.
+	pkg map[Family]string
+	// service is the systemd unit restarted after a change; none if missing.
+	service map[Family]string
+	// binary must be on the PATH once the package is installed.
+	binary string
+}
+
+var specs = map[PackageType]packageSpec{
+	PackageTypeOVS: {
+		pkg:     map[Family]string{FamilyRPM: "openvswitch", FamilyDeb: "openvswitch-switch"},
+		service: map[Family]string{FamilyRPM: "openvswitch", FamilyDeb: "openvswitch-switch"},
+		binary:  "ovs-vsctl",
+	},
+	PackageTypeCloudHypervisor: {
+		pkg:    map[Family]string{FamilyRPM: "cloud-hypervisor", FamilyDeb: "cloud-hypervisor"},
+		binary: "cloud-hypervisor",
+	},
+}
+
+// unit is a package type as it appears on this host.
+type unit struct {
+	m       Manager
+	pkg     string
+	service string
+	binary  string
+}
+
+// release is a version of a package type and the cached files it was
+// installed from.
+type release struct {
+	Version  string   `json:"version"`
+	Packages []string `json:"packages,omitempty"`
+}
+
+// installState is what the service last installed for a package type and
+// what was installed before, kept in the cache directory so that the files
+// of the previous release stay available for a rollback.
+type installState struct {
+	Current  *release `json:"current,omitempty"`
+	Previous *release `json:"previous,omitempty"`
+}
+
+// manager returns the host's package manager, detecting it on first use.
+func (s *Service) manager() (Manager, error) {
+	s.detect.Do(func() {
+		if s.Manager == nil {
+			s.Manager, s.detectErr = DetectManager(s.osRelease)
+		}
+	})
+	return s.Manager, s.detectErr
+}
+
+// Validate reports whether hosts know how to install the package type.
+func (t PackageType) Validate() error {
+	if _, ok := specs[t]; !ok {
+		return fmt.Errorf("unsupported package type: %s", t)
+	}
+	return nil
+}
+
+// unit resolves how a package type is installed with the host's package
+// manager.
+func (s *Service) unit(pkgType PackageType) (*unit, error) {
+	if err := pkgType.Validate(); err != nil {
+		return nil, err
+	}
+	m, err := s.manager()
+	if err != nil {
+		return nil, err
+	}
+	spec, f := specs[pkgType], m.Family()
+	return &unit{m: m, pkg: spec.pkg[f], service: spec.service[f], binary: spec.binary}, nil
+}
+
+// Install brings the package type to the requested version using the
+// provided local package files: it installs, upgrades or downgrades them
+// with the host's package manager and then restarts and verifies the
+// package. If that fails the previous release is restored, or the new
+// packages removed when there was none. A request without a version leaves
+// an installed package alone.
+func (s *Service) Install(req InstallRequest) error {
+	u, err := s.unit(req.PackageType)
+	if err != nil {
+		return err
+	}
+	if req.OSVersion != "" && OSFamily(req.OSVersion) != u.m.Family() {
+		return fmt.Errorf("%s packages cannot be installed on a host using %s packages", req.OSVersion, u.m.Family())
+	}
+	for _, p := range req.Packages {
+		if filepath.Ext(p) != u.m.Family().Ext() {
+			return fmt.Errorf("%s is not a %s package", filepath.Base(p), u.m.Family())
+		}
+	}
+	if err := s.VerifySignatures(req.Packages); err != nil {
+		return err
+	}
+	installed := u.m.Installed(u.pkg)
+	if installed != "" && (req.Version == "" || CompareVersions(installed, req.Version) == 0) {
+		return s.activate(u, installed, false)
+	}
+	if len(req.Packages) == 0 {
+		return fmt.Errorf("no packages to install for %s %s", req.PackageType, req.Version)
+	}
+	st := s.loadState(req.PackageType)
+	prev := &release{Version: installed}
+	if st.Current != nil && st.Current.Version == installed {
+		prev.Packages = st.Current.Packages
+	}
+
+	if err := u.m.Apply(transition(installed, req.Version), req.Packages); err != nil {
+		return err
+	}
+	if err := s.activate(u, req.Version, true); err != nil {
+		if rerr := s.restore(req.PackageType, u, prev, req.Packages); rerr != nil {
+			return fmt.Errorf("%s %s failed: %w; rollback failed: %v", req.PackageType, req.Version, err, rerr)
+		}
+		if prev.Version == "" {
+			return fmt.Errorf("%s %s failed: %w; removed it again", req.PackageType, req.Version, err)
+		}
+		return fmt.Errorf("%s %s failed: %w; rolled back to %s", req.PackageType, req.Version, err, prev.Version)
+	}
+	next := installState{Current: &release{Version: req.Version, Packages: req.Packages}}
+	if prev.Version != "" {
+		next.Previous = prev
+	}
+	return s.saveState(req.PackageType, next)
+}
+
+// Uninstall stops and removes the package type, keeping its cached files so
+// that Rollback can install it again.
+func (s *Service) Uninstall(pkgType PackageType) error {
+	u, err := s.unit(pkgType)
+	if err != nil {
+		return err
+	}
+	installed := u.m.Installed(u.pkg)
+	if installed == "" {
+		return nil
+	}
+	st := s.loadState(pkgType)
+	var files []string
+	if st.Current != nil && st.Current.Version == installed {
+		files = st.Current.Packages
+	}
+	if u.service != "" {
+		if err := s.run.command("systemctl", "disable", "--now", u.service); err != nil {
+			return err
+		}
+	}
+	if err := s.remove(u, files); err != nil {
+		return err
+	}
+	return s.saveState(pkgType, installState{Previous: &release{Version: installed, Packages: files}})
+}
+
+// Rollback reinstalls the release that was in place before the last change
+// made through Install or Uninstall. Rolling back twice returns to where the
+// host started.
+func (s *Service) Rollback(pkgType PackageType) error {
+	u, err := s.unit(pkgType)
+	if err != nil {
+		return err
+	}
+	st := s.loadState(pkgType)
+	if st.Previous == nil {
+		return fmt.Errorf("%s has no previous release to roll back to", pkgType)
+	}
+	var current []string
+	if st.Current != nil {
+		current = st.Current.Packages
+	}
+	if err := s.VerifySignatures(st.Previous.Packages); err != nil {
+		return err
+	}
+	if err := s.restore(pkgType, u, st.Previous, current); err != nil {
+		return err
+	}
+	return s.saveState(pkgType, installState{Current: st.Previous, Previous: st.Current})
+}
+
+// restore puts back prev after a change to the given files: it removes the
+// files' packages when nothing was installed before, and otherwise reinstalls
+// prev from its cached files.
+func (s *Service) restore(pkgType PackageType, u *unit, prev *release, files []string) error {
+	if prev.Version == "" {
+		if u.service != "" {
+			_ = s.run.command("systemctl", "disable", "--now", u.service)
+		}
+		return s.remove(u, files)
+	}
+	if len(prev.Packages) == 0 {
+		return fmt.Errorf("the packages of %s %s are not cached", pkgType, prev.Version)
+	}
+	for _, p := range prev.Packages {
+		if _, err := os.Stat(p); err != nil {
+			return fmt.Errorf("the packages of %s %s are not cached: %w", pkgType, prev.Version, err)
+		}
+	}
+	if err := u.m.Apply(transition(u.m.Installed(u.pkg), prev.Version), prev.Packages); err != nil {
+		return err
+	}
+	return s.activate(u, prev.Version, true)
+}
+
+// remove uninstalls the packages in files, or the main package when the
+// files are unknown.
+func (s *Service) remove(u *unit, files []string) error {
+	names := []string{u.pkg}
+	if len(files) > 0 {
+		names = names[:0]
+		for _, f := range files {
+			name, err := u.m.PackageName(f)
+			if err != nil {
+				return err
+			}
+			names = append(names, name)
+		}
+	}
+	return u.m.Remove(names)
+}
+
+// activate enables the package's service, restarting it after a change and
+// otherwise only starting it if needed, and checks that the expected version
+// is installed and usable.
+func (s *Service) activate(u *unit, version string, restart bool) error {
+	if u.service != "" {
+		if err := s.run.command("systemctl", "enable", "--now", u.service); err != nil {
+			return err
+		}
+		if restart {
+			if err := s.run.command("systemctl", "restart", u.service); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := s.run("sh", "-c", "command -v "+u.binary); err != nil {
+		return fmt.Errorf("%s not found after installation", u.binary)
+	}
+	if got := u.m.Installed(u.pkg); version != "" && CompareVersions(got, version) != 0 {
+		return fmt.Errorf("%s %s is installed, expected %s", u.pkg, got, version)
+	}
+	return nil
+}
+
+// InstalledVersion returns the installed version of a package type, or ""
+// when it is not installed.
+func (s *Service) InstalledVersion(pkgType PackageType) string {
+	u, err := s.unit(pkgType)
+	if err != nil {
+		return ""
+	}
+	return u.m.Installed(u.pkg)
+}
+
+// transition is the dnf command that moves from one version to another.
+func transition(from, to string) string {
+	switch {
+	case from == "":
+		return "install"
+	case CompareVersions(to, from) > 0:
+		return "upgrade"
+	case CompareVersions(to, from) < 0:
+		return "downgrade"
+	}
+	return "reinstall"
+}
+
+func (s *Service) statePath(pkgType PackageType) string {
+	return filepath.Join(s.cacheDir, "state", string(pkgType)+".json")
+}
+
+func (s *Service) loadState(pkgType PackageType) installState {
+	var st installState
+	data, err := os.ReadFile(s.statePath(pkgType))
+	if err == nil {
+		err = json.Unmarshal(data, &st)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("packages: ignoring install state of %s: %v", pkgType, err)
+	}
+	return st
+}
+
+func (s *Service) saveState(pkgType PackageType, st installState) error {
+	path := s.statePath(pkgType)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
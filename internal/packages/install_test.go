@@ -0,0 +1,194 @@
+package packages
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeHost runs the service's systemctl and shell commands against a fake
+// package manager. Package files are named <name>-<version>.<ext>.
+type fakeHost struct {
+	*FakeManager
+	// broken versions of openvswitch fail to start.
+	broken map[string]bool
+	calls  []string
+	seen   int
+}
+
+func (h *fakeHost) run(name string, args ...string) ([]byte, error) {
+	h.calls = append(h.calls, name+" "+strings.Join(args, " "))
+	if name == "systemctl" && args[0] == "restart" && h.broken[h.Installed("openvswitch")] {
+		return []byte("Job for openvswitch.service failed"), errors.New("exit status 1")
+	}
+	return nil, nil
+}
+
+// ran reports whether a command or package change starting with prefix ran
+// since the last call.
+func (h *fakeHost) ran(prefix string) bool {
+	calls := append(h.calls, h.Calls()[h.seen:]...)
+	h.calls, h.seen = nil, len(h.Calls())
+	for _, c := range calls {
+		if strings.HasPrefix(c, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func setupHost(t *testing.T, family Family) (*Service, *fakeHost, func(version string) InstallRequest) {
+	t.Helper()
+	h := &fakeHost{FakeManager: &FakeManager{Format: family}, broken: map[string]bool{}}
+	svc := NewService(t.TempDir(), nil)
+	svc.Manager = h.FakeManager
+	svc.run = h.run
+	svc.Keyring = filepath.Join(t.TempDir(), "RPM-GPG-KEY-vertera")
+	if err := os.WriteFile(svc.Keyring, []byte("key"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"openvswitch", "python3-openvswitch"}
+	if family == FamilyDeb {
+		names = []string{"openvswitch-switch", "openvswitch-common"}
+	}
+	req := func(version string) InstallRequest {
+		var paths []string
+		for _, name := range names {
+			p := filepath.Join(svc.cacheDir, name+"-"+version+family.Ext())
+			if err := os.WriteFile(p, []byte(version), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			paths = append(paths, p)
+		}
+		return InstallRequest{PackageType: PackageTypeOVS, Packages: paths, Version: version}
+	}
+	return svc, h, req
+}
+
+func TestInstallVersions(t *testing.T) {
+	svc, h, req := setupHost(t, FamilyRPM)
+
+	steps := []struct {
+		version, action string
+	}{
+		{"3.5.0", "install"},
+		{"3.6.0", "upgrade"},
+		{"3.5.1", "downgrade"},
+	}
+	for _, step := range steps {
+		if err := svc.Install(req(step.version)); err != nil {
+			t.Fatal(err)
+		}
+		if !h.ran(step.action) || svc.InstalledVersion(PackageTypeOVS) != step.version {
+			t.Fatalf("expected %s to reach %s, got %s", step.action, step.version, svc.InstalledVersion(PackageTypeOVS))
+		}
+	}
+
+	// The installed version is kept without touching the running service.
+	if err := svc.Install(req("3.5.1")); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Calls()) != h.seen {
+		t.Fatal("reinstalled the installed version")
+	}
+	if err := svc.Install(InstallRequest{PackageType: PackageTypeOVS}); err != nil || h.ran("systemctl restart") {
+		t.Fatalf("expected a request without version to keep the package, got %v", err)
+	}
+
+	if err := svc.Rollback(PackageTypeOVS); err != nil {
+		t.Fatal(err)
+	}
+	if !h.ran("upgrade") || svc.InstalledVersion(PackageTypeOVS) != "3.6.0" {
+		t.Fatalf("expected rollback to 3.6.0, got %s", svc.InstalledVersion(PackageTypeOVS))
+	}
+	if err := svc.Rollback(PackageTypeOVS); err != nil || svc.InstalledVersion(PackageTypeOVS) != "3.5.1" {
+		t.Fatalf("expected a second rollback to undo the first, got %s, %v", svc.InstalledVersion(PackageTypeOVS), err)
+	}
+}
+
+func TestInstallVersionsWithTrailingZeros(t *testing.T) {
+	if CompareVersions("47.0", "47.0.0") != 0 || CompareVersions("47.0", "47.0.1") >= 0 || CompareVersions("47.1", "47.0.9") <= 0 {
+		t.Fatal("expected missing trailing parts to count as 0")
+	}
+	svc, h, req := setupHost(t, FamilyRPM)
+
+	// The registry's 47.0 is the package manager's 47.0.0.
+	r := req("47.0.0")
+	r.Version = "47.0"
+	if err := svc.Install(r); err != nil {
+		t.Fatalf("expected the install to match its version, got %v", err)
+	}
+	if !h.ran("install") || svc.InstalledVersion(PackageTypeOVS) != "47.0.0" {
+		t.Fatalf("expected 47.0.0 to be installed, got %s", svc.InstalledVersion(PackageTypeOVS))
+	}
+	if err := svc.Install(r); err != nil || h.ran("downgrade") || h.ran("upgrade") || h.ran("reinstall") {
+		t.Fatalf("expected the installed version to be kept, got %v", err)
+	}
+}
+
+func TestInstallRollsBackFailedStart(t *testing.T) {
+	svc, h, req := setupHost(t, FamilyRPM)
+	h.broken["3.6.0"] = true
+
+	err := svc.Install(req("3.6.0"))
+	if err == nil || !strings.Contains(err.Error(), "removed it again") {
+		t.Fatalf("expected a failed first install to be removed, got %v", err)
+	}
+	if len(h.Packages()) != 0 {
+		t.Fatalf("expected no packages left, got %v", h.Packages())
+	}
+
+	if err := svc.Install(req("3.5.0")); err != nil {
+		t.Fatal(err)
+	}
+	err = svc.Install(req("3.6.0"))
+	if err == nil || !strings.Contains(err.Error(), "rolled back to 3.5.0") {
+		t.Fatalf("expected a rollback to 3.5.0, got %v", err)
+	}
+	if h.Installed("openvswitch") != "3.5.0" || h.Installed("python3-openvswitch") != "3.5.0" {
+		t.Fatalf("expected 3.5.0 to be restored, got %v", h.Packages())
+	}
+}
+
+func TestUninstall(t *testing.T) {
+	svc, h, req := setupHost(t, FamilyRPM)
+	if err := svc.Rollback(PackageTypeOVS); err == nil {
+		t.Fatal("expected rollback without history to fail")
+	}
+	if err := svc.Install(req("3.6.0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Uninstall(PackageTypeOVS); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Packages()) != 0 || !h.ran("systemctl disable --now openvswitch") {
+		t.Fatalf("expected the service stopped and packages removed, got %v", h.Packages())
+	}
+	// The cached files of the removed release can be installed again.
+	if err := svc.Rollback(PackageTypeOVS); err != nil || h.Installed("python3-openvswitch") != "3.6.0" {
+		t.Fatalf("expected rollback to reinstall 3.6.0, got %v, %v", h.Packages(), err)
+	}
+}
+
+func TestInstallOnDebian(t *testing.T) {
+	svc, h, req := setupHost(t, FamilyDeb)
+	if err := svc.Install(req("3.3.0")); err != nil {
+		t.Fatal(err)
+	}
+	if svc.InstalledVersion(PackageTypeOVS) != "3.3.0" || !h.ran("systemctl enable --now openvswitch-switch") {
+		t.Fatalf("expected openvswitch-switch 3.3.0 to run, got %v", h.Packages())
+	}
+
+	rpms := req("3.4.0")
+	rpms.Packages = []string{filepath.Join(svc.cacheDir, "openvswitch-3.4.0.rpm")}
+	if err := svc.Install(rpms); err == nil || !strings.Contains(err.Error(), "not a deb package") {
+		t.Fatalf("expected RPMs to be refused, got %v", err)
+	}
+	el := req("3.4.0")
+	el.OSVersion = "el9"
+	if err := svc.Install(el); err == nil {
+		t.Fatal("expected el9 packages to be refused on a Debian host")
+	}
+}
@@ -0,0 +1,173 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Manager installs package files with a host's package manager.
+type Manager interface {
+	// Family is the package format the manager installs.
+	Family() Family
+	// Installed returns the upstream version of an installed package, or ""
+	// when it is not installed.
+	Installed(name string) string
+	// PackageName reads the name of the package in a file.
+	PackageName(file string) (string, error)
+	// Apply installs local package files. action is install, upgrade,
+	// downgrade or reinstall, depending on the versions already installed.
+	Apply(action string, files []string) error
+	// Remove uninstalls packages by name.
+	Remove(names []string) error
+	// VerifySignatures checks the signatures of package files against the
+	// given public key files. It is only used for families whose files are
+	// signed, see Family.SignedFiles.
+	VerifySignatures(keys, files []string) error
+}
+
+// runner runs a command and returns its combined output.
+type runner func(name string, args ...string) ([]byte, error)
+
+// runCommand runs a command and returns its combined output.
+func runCommand(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// command runs a command, folding its output into the error.
+func (run runner) command(name string, args ...string) error {
+	out, err := run(name, args...)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// DetectManager picks the package manager of the distribution described by
+// an os-release file such as /etc/os-release.
+func DetectManager(osReleasePath string) (Manager, error) {
+	r, err := ReadOSRelease(osReleasePath)
+	if err != nil {
+		return nil, fmt.Errorf("detect package manager: %w", err)
+	}
+	switch {
+	case r.is("rhel") || r.is("fedora") || r.is("centos"):
+		return NewDNF(), nil
+	case r.is("debian") || r.is("ubuntu"):
+		return NewApt(), nil
+	}
+	return nil, fmt.Errorf("no supported package manager for distribution %q", r.ID)
+}
+
+// dnf installs RPMs with dnf and queries them with rpm.
+type dnf struct{ run runner }
+
+// NewDNF returns the dnf/rpm backend used on Enterprise Linux and Fedora.
+func NewDNF() Manager { return dnf{run: runCommand} }
+
+func (dnf) Family() Family { return FamilyRPM }
+
+func (m dnf) Installed(name string) string {
+	out, err := m.run("rpm", "-q", "--qf", "%{VERSION}", name)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func (m dnf) PackageName(file string) (string, error) {
+	out, err := m.run("rpm", "-qp", "--qf", "%{NAME}", file)
+	if err != nil {
+		return "", fmt.Errorf("read package name of %s: %w", filepath.Base(file), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (m dnf) Apply(action string, files []string) error {
+	return m.run.command("dnf", append([]string{action, "-y"}, files...)...)
+}
+
+func (m dnf) Remove(names []string) error {
+	return m.run.command("dnf", append([]string{"remove", "-y"}, names...)...)
+}
+
+// VerifySignatures imports the keys into a scratch RPM database, so the
+// host's trusted keys play no part.
+func (m dnf) VerifySignatures(keys, files []string) error {
+	dbPath, err := os.MkdirTemp("", "vertera-rpmdb-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dbPath)
+	if err := m.run.command("rpmkeys", append([]string{"--dbpath", dbPath, "--import"}, keys...)...); err != nil {
+		return fmt.Errorf("import keyring: %w", err)
+	}
+	for _, f := range files {
+		out, err := m.run("rpmkeys", "--dbpath", dbPath, "--checksig", f)
+		// Unsigned packages pass with only "digests OK", so require the
+		// signature to be reported as checked.
+		if err != nil || !strings.Contains(string(out), "signatures OK") {
+			return fmt.Errorf("%w: %s: signature not verified: %s", ErrIntegrity, filepath.Base(f), strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// apt installs .deb files with apt-get and queries them with dpkg.
+type apt struct{ run runner }
+
+// NewApt returns the apt/dpkg backend used on Debian and Ubuntu.
+func NewApt() Manager { return apt{run: runCommand} }
+
+func (apt) Family() Family { return FamilyDeb }
+
+// Installed strips the epoch and Debian revision, leaving the upstream
+// version the registry knows.
+func (m apt) Installed(name string) string {
+	out, err := m.run("dpkg-query", "-W", "-f", "${db:Status-Status} ${Version}", name)
+	if err != nil {
+		return ""
+	}
+	status, version, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+	if status != "installed" {
+		return ""
+	}
+	if _, v, ok := strings.Cut(version, ":"); ok {
+		version = v
+	}
+	if i := strings.LastIndex(version, "-"); i > 0 {
+		version = version[:i]
+	}
+	return version
+}
+
+func (m apt) PackageName(file string) (string, error) {
+	out, err := m.run("dpkg-deb", "--field", file, "Package")
+	if err != nil {
+		return "", fmt.Errorf("read package name of %s: %w", filepath.Base(file), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Apply hands apt-get the files as paths, which it installs together with
+// their dependencies; downgrades must be allowed explicitly.
+func (m apt) Apply(action string, files []string) error {
+	args := []string{"install", "-y", "--allow-downgrades"}
+	if action == "reinstall" {
+		args = append(args, "--reinstall")
+	}
+	return m.run.command("apt-get", append(args, files...)...)
+}
+
+func (m apt) Remove(names []string) error {
+	return m.run.command("apt-get", append([]string{"remove", "-y"}, names...)...)
+}
+
+// VerifySignatures fails: .deb files carry no signatures of their own, only
+// the repositories they are published in are signed, so the service checks
+// them against their registry digests instead.
+func (apt) VerifySignatures(keys, files []string) error {
+	return fmt.Errorf("%w: .deb files carry no signatures of their own", ErrIntegrity)
+}
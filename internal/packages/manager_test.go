@@ -0,0 +1,78 @@
+package packages
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOSRelease(t *testing.T) {
+	cases := []struct {
+		name, data, osVersion string
+		family                Family
+	}{
+		{"rocky", "ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\nVERSION_ID=\"9.4\"\n", "el9", FamilyRPM},
+		{"fedora", "ID=fedora\nVERSION_ID=40\n", "fc40", FamilyRPM},
+		{"debian", "ID=debian\nVERSION_ID=\"12\"\n", "debian12", FamilyDeb},
+		{"ubuntu", "ID=ubuntu\nID_LIKE=debian\nVERSION_ID=\"24.04\"\n", "ubuntu24.04", FamilyDeb},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := ParseOSRelease(c.data).OSVersion()
+			if got != c.osVersion {
+				t.Fatalf("expected %s, got %s", c.osVersion, got)
+			}
+			if err := ValidateOSVersion(got); err != nil {
+				t.Fatal(err)
+			}
+			if OSFamily(got) != c.family {
+				t.Fatalf("expected %s to be %s, got %s", got, c.family, OSFamily(got))
+			}
+
+			path := filepath.Join(t.TempDir(), "os-release")
+			if err := os.WriteFile(path, []byte(c.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			m, err := DetectManager(path)
+			if err != nil || m.Family() != c.family {
+				t.Fatalf("expected a %s manager, got %v, %v", c.family, m, err)
+			}
+		})
+	}
+
+	for _, v := range []string{"el", "rhel9", "ubuntu24", "debian12.1"} {
+		if ValidateOSVersion(v) == nil {
+			t.Errorf("expected %q to be refused", v)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "os-release")
+	if err := os.WriteFile(path, []byte("ID=alpine\nVERSION_ID=3.20\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DetectManager(path); err == nil {
+		t.Fatal("expected no manager for alpine")
+	}
+}
+
+func TestAptInstalled(t *testing.T) {
+	out := map[string]string{
+		"openvswitch-switch": "installed 1:3.3.0-1ubuntu2",
+		"cloud-hypervisor":   "config-files 39.0-1",
+	}
+	m := apt{run: func(name string, args ...string) ([]byte, error) {
+		if v, ok := out[args[len(args)-1]]; ok {
+			return []byte(v), nil
+		}
+		return []byte("no packages found"), errors.New("exit status 1")
+	}}
+	if v := m.Installed("openvswitch-switch"); v != "3.3.0" {
+		t.Fatalf("expected the upstream version 3.3.0, got %q", v)
+	}
+	if v := m.Installed("cloud-hypervisor"); v != "" {
+		t.Fatalf("expected a removed package not to count, got %q", v)
+	}
+	if v := m.Installed("missing"); v != "" {
+		t.Fatalf("expected a missing package to be empty, got %q", v)
+	}
+}
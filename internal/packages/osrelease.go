@@ -0,0 +1,111 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Family is a group of distributions sharing a package format and manager.
+type Family string
+
+const (
+	FamilyRPM Family = "rpm"
+	FamilyDeb Family = "deb"
+)
+
+// OSRelease holds the fields of /etc/os-release that identify a distribution.
+type OSRelease struct {
+	ID        string
+	IDLike    []string
+	VersionID string
+}
+
+// ReadOSRelease parses an os-release file such as /etc/os-release.
+func ReadOSRelease(path string) (OSRelease, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return OSRelease{}, err
+	}
+	return ParseOSRelease(string(data)), nil
+}
+
+// ParseOSRelease parses the content of an os-release file.
+func ParseOSRelease(data string) OSRelease {
+	var r OSRelease
+	for _, line := range strings.Split(data, "\n") {
+		key, val, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		val = strings.Trim(val, `"'`)
+		switch key {
+		case "ID":
+			r.ID = val
+		case "ID_LIKE":
+			r.IDLike = strings.Fields(val)
+		case "VERSION_ID":
+			r.VersionID = val
+		}
+	}
+	return r
+}
+
+func (r OSRelease) is(id string) bool {
+	return r.ID == id || slices.Contains(r.IDLike, id)
+}
+
+// OSVersion returns the OS version artifacts are built for on this
+// distribution: el9 for RHEL and its rebuilds, fc40 for Fedora, debian12 and
+// ubuntu24.04. It is empty for distributions without builds.
+func (r OSRelease) OSVersion() string {
+	if r.VersionID == "" {
+		return ""
+	}
+	major, _, _ := strings.Cut(r.VersionID, ".")
+	switch {
+	case r.ID == "fedora":
+		return "fc" + major
+	case r.is("rhel") || r.is("centos"):
+		return "el" + major
+	case r.ID == "ubuntu":
+		return "ubuntu" + r.VersionID
+	case r.ID == "debian":
+		return "debian" + major
+	}
+	return ""
+}
+
+var osVersionPattern = regexp.MustCompile(`^(el[0-9]+|fc[0-9]+|debian[0-9]+|ubuntu[0-9]+\.[0-9]+)$`)
+
+// ValidateOSVersion checks an OS version such as el9, fc40, debian12 or
+// ubuntu24.04; empty means any.
+func ValidateOSVersion(v string) error {
+	if v != "" && !osVersionPattern.MatchString(v) {
+		return fmt.Errorf("unsupported os_version %q: expected e.g. el9, fc40, debian12 or ubuntu24.04", v)
+	}
+	return nil
+}
+
+// OSFamily returns the family of a valid OS version.
+func OSFamily(v string) Family {
+	if strings.HasPrefix(v, "debian") || strings.HasPrefix(v, "ubuntu") {
+		return FamilyDeb
+	}
+	return FamilyRPM
+}
+
+// Ext is the file extension of the family's packages.
+func (f Family) Ext() string {
+	return "." + string(f)
+}
+
+// SignedFiles reports whether the family's package files carry signatures of
+// their own. RPMs do. A .deb file does not: only the metadata of the
+// repository publishing it is signed, so its registry digest is all there is
+// to check it against.
+func (f Family) SignedFiles() bool {
+	return f == FamilyRPM
+}
@@ -0,0 +1,24 @@
+package packages
+
+import "fmt"
+
+// Registry resolves the files of a package release. The controller's
+// artifact registry implements it; agents resolve from what the controller
+// sent with the task.
+type Registry interface {
+	ResolvePackage(pkgType PackageType, version, osVersion string) ([]PackageInfo, error)
+}
+
+// Resolved is a Registry over packages the controller already resolved, keyed
+// by package type.
+type Resolved map[PackageType][]PackageInfo
+
+// ResolvePackage returns the files resolved for pkgType; version and
+// osVersion were applied when resolving.
+func (r Resolved) ResolvePackage(pkgType PackageType, version, osVersion string) ([]PackageInfo, error) {
+	infos, ok := r[pkgType]
+	if !ok {
+		return nil, fmt.Errorf("package %s was not resolved by the controller", pkgType)
+	}
+	return infos, nil
+}
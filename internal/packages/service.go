@@ -2,13 +2,10 @@ package packages
 
 import (
 	"fmt"
-	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
+	"sync"
 	"time"
-	"os/exec"
 )
 
 // PackageType represents the type of package to install
@@ -23,224 +20,117 @@ const (
 type PackageRequest struct {
 	Packages []PackageType `json:"packages"`
 	Version  string        `json:"version,omitempty"`
-	OSVersion string       `json:"os_version,omitempty"` // el9, el10
-}
-
-// Install executes the installation for the given package type using the provided local RPM file paths.
-// It runs system package managers directly and verifies installation.
-func (s *Service) Install(req InstallRequest) error {
-    switch req.PackageType {
-    case PackageTypeOVS:
-        return s.installOVS(req.Packages)
-    case PackageTypeCloudHypervisor:
-        return s.installCH(req.Packages)
-    default:
-        return fmt.Errorf("unsupported package type: %s", req.PackageType)
-    }
-}
-
-func (s *Service) installOVS(pkgs []string) error {
-    // If already installed, return success
-    if err := exec.Command("rpm", "-q", "openvswitch").Run(); err == nil {
-        return nil
-    }
-    if len(pkgs) > 0 {
-        // dnf install -y <rpms>
-        args := append([]string{"install", "-y"}, pkgs...)
-        cmd := exec.Command("dnf", args...)
-        cmd.Stdout = os.Stdout
-        cmd.Stderr = os.Stderr
-        if err := cmd.Run(); err != nil {
-            return fmt.Errorf("dnf install failed: %w", err)
-        }
-    }
-    // Enable and start service
-    if err := exec.Command("systemctl", "enable", "openvswitch").Run(); err != nil {
-        return fmt.Errorf("enable openvswitch failed: %w", err)
-    }
-    if err := exec.Command("systemctl", "start", "openvswitch").Run(); err != nil {
-        return fmt.Errorf("start openvswitch failed: %w", err)
-    }
-    // Verify
-    if err := exec.Command("bash", "-c", "command -v ovs-vsctl").Run(); err != nil {
-        return fmt.Errorf("ovs-vsctl not found after installation")
-    }
-    return nil
-}
-
-func (s *Service) installCH(pkgs []string) error {
-    // If already installed, return success
-    if err := exec.Command("rpm", "-q", "cloud-hypervisor").Run(); err == nil {
-        return nil
-    }
-    if len(pkgs) > 0 {
-        args := append([]string{"-ivh"}, pkgs...)
-        cmd := exec.Command("rpm", args...)
-        cmd.Stdout = os.Stdout
-        cmd.Stderr = os.Stderr
-        if err := cmd.Run(); err != nil {
-            return fmt.Errorf("rpm install failed: %w", err)
-        }
-    }
-    // Verify
-    if err := exec.Command("bash", "-c", "command -v cloud-hypervisor").Run(); err != nil {
-        return fmt.Errorf("cloud-hypervisor not found after installation")
-    }
-    return nil
+	OSVersion string       `json:"os_version,omitempty"` // e.g. el9, fc40, debian12, ubuntu24.04
 }
 
 // PackageInfo represents information about a package
 type PackageInfo struct {
 	Name     string `json:"name"`
+	Version  string `json:"version"`
 	URL      string `json:"url"`
 	Size     int64  `json:"size"`
+	// SHA256 is the hex digest the downloaded file must match.
+	SHA256   string `json:"sha256"`
 	Required bool   `json:"required"`
 }
 
 // Service handles package management operations
 type Service struct {
+	// Keyring is a GPG key file or directory of key files that RPM
+	// signatures are verified against before installing. Without one
+	// installs fail unless SkipSignatures is set.
+	Keyring string
+	// SkipSignatures lets packages be installed without a Keyring, checked
+	// against their registry digests only.
+	SkipSignatures bool
+	// Log, when set, receives notes for the task log, such as a skipped
+	// signature check.
+	Log func(msg string)
+	// Mirror is the controller's HTTP API (e.g. http://controller:8080)
+	// whose package mirror files are fetched from, authenticated with
+	// MirrorToken. Without a mirror files come from their upstream URL.
+	Mirror      string
+	MirrorToken string
+	// UpstreamFallback lets files the mirror cannot serve be fetched from
+	// their upstream URL.
+	UpstreamFallback bool
+	// Progress, when set, is told how many bytes of a download have been
+	// written, about once a second and when the download completes; total
+	// is -1 when the size is unknown.
+	Progress func(name string, done, total int64)
+
 	cacheDir   string
+	registry   Registry
 	httpClient *http.Client
+	// attempts is how often a source is tried, retryDelay the first pause
+	// between attempts and stallTimeout how long a download may go without
+	// receiving data.
+	attempts     int
+	retryDelay   time.Duration
+	stallTimeout time.Duration
+	// Manager installs package files; nil detects the host's package
+	// manager from /etc/os-release on first use.
+	Manager Manager
+	// run runs system commands such as systemctl.
+	run       runner
+	osRelease string
+	detect    sync.Once
+	detectErr error
 }
 
-// NewService creates a new package service
-func NewService(cacheDir string) *Service {
+// NewService creates a new package service resolving packages from reg
+func NewService(cacheDir string, reg Registry) *Service {
+	// No overall timeout: large downloads take long, stalls are caught by
+	// stallTimeout instead.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 60 * time.Second
 	return &Service{
-		cacheDir:   cacheDir,
-		httpClient: &http.Client{Timeout: 60 * time.Second},
+		cacheDir:     cacheDir,
+		registry:     reg,
+		httpClient:   &http.Client{Transport: transport},
+		attempts:     5,
+		retryDelay:   time.Second,
+		stallTimeout: time.Minute,
+		run:          runCommand,
+		osRelease:    "/etc/os-release",
 	}
 }
 
-// GetPackageInfo returns information about available packages
+// GetPackageInfo returns the files of a package release from the registry.
+// An empty version picks the newest stable release.
 func (s *Service) GetPackageInfo(pkgType PackageType, version, osVersion string) ([]PackageInfo, error) {
-	switch pkgType {
-	case PackageTypeOVS:
-		return s.getOVSPackageInfo(version, osVersion)
-	case PackageTypeCloudHypervisor:
-		return s.getCHPackageInfo(version)
-	default:
-		return nil, fmt.Errorf("unsupported package type: %s", pkgType)
-	}
-}
-
-// DownloadPackage downloads a package to the cache directory
-func (s *Service) DownloadPackage(info PackageInfo) (string, error) {
-	// Create cache directory if it doesn't exist
-	if err := os.MkdirAll(s.cacheDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create cache directory: %w", err)
-	}
-
-	filePath := filepath.Join(s.cacheDir, info.Name)
-
-	// Check if file already exists and has correct size
-	if fi, err := os.Stat(filePath); err == nil {
-		if fi.Size() == info.Size && info.Size > 0 {
-			return filePath, nil // Already cached
-		}
-	}
-
-	// Download the file
-	req, err := http.NewRequest("GET", info.URL, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("User-Agent", "VerteraIO/1.0")
-
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to download: %w", err)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error closing response body: %v\n", err)
-		}
-	}()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("download failed with status: %s", resp.Status)
-	}
-
-	// Create the file
-	out, err := os.Create(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to create file: %w", err)
-	}
-	defer func() {
-		if err := out.Close(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error closing file: %v\n", err)
-		}
-	}()
-
-	// Copy the response body to file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to write file: %w", err)
-	}
-
-	return filePath, nil
-}
-
-// getOVSPackageInfo returns OVS package information
-func (s *Service) getOVSPackageInfo(version, osVersion string) ([]PackageInfo, error) {
-	if version == "" {
-		version = "3.6.0"
-	}
-	if osVersion == "" {
-		osVersion = "el9" // default
-	}
-
-	baseURL := fmt.Sprintf("https://github.com/VerteraIO/openvswitch-rpm-build/releases/download/v%s/", version)
-
-	packages := []PackageInfo{
-		{
-			Name:     fmt.Sprintf("openvswitch-selinux-policy-%s-1.%s.noarch.rpm", version, osVersion),
-			URL:      baseURL + fmt.Sprintf("openvswitch-selinux-policy-%s-1.%s.noarch.rpm", version, osVersion),
-			Required: false,
-		},
-		{
-			Name:     fmt.Sprintf("openvswitch-%s-1.%s.x86_64.rpm", version, osVersion),
-			URL:      baseURL + fmt.Sprintf("openvswitch-%s-1.%s.x86_64.rpm", version, osVersion),
-			Required: true,
-		},
-		{
-			Name:     fmt.Sprintf("python3-openvswitch-%s-1.%s.noarch.rpm", version, osVersion),
-			URL:      baseURL + fmt.Sprintf("python3-openvswitch-%s-1.%s.noarch.rpm", version, osVersion),
-			Required: false,
-		},
-	}
-
-	return packages, nil
-}
-
-// getCHPackageInfo returns Cloud Hypervisor package information
-func (s *Service) getCHPackageInfo(version string) ([]PackageInfo, error) {
-	if version == "" {
-		version = "47.0"
+	if err := pkgType.Validate(); err != nil {
+		return nil, err
 	}
-
-	baseURL := fmt.Sprintf("https://github.com/VerteraIO/cloud-hypervisor-build/releases/download/v%s/", version)
-	rpmName := fmt.Sprintf("cloud-hypervisor-%s.0-1.g88ffa129.el10.x86_64.rpm", version)
-
-	packages := []PackageInfo{
-		{
-			Name:     rpmName,
-			URL:      baseURL + rpmName,
-			Required: true,
-		},
+	if s.registry == nil {
+		return nil, fmt.Errorf("no artifact registry to resolve %s from", pkgType)
 	}
-
-	return packages, nil
+	return s.registry.ResolvePackage(pkgType, version, osVersion)
 }
 
 // InstallRequest represents the full installation request with downloaded packages
 type InstallRequest struct {
 	PackageType PackageType `json:"package_type"`
 	Packages    []string    `json:"packages"` // File paths to downloaded packages
+	// Version is the release the packages hold; empty keeps an installed
+	// version.
+	Version     string      `json:"version"`
 	OSVersion   string      `json:"os_version"`
 }
 
 // GenerateInstallScript generates a shell script for package installation
+// with the package manager of the requested OS version's family.
 func (s *Service) GenerateInstallScript(req InstallRequest) (string, error) {
+	if err := req.PackageType.Validate(); err != nil {
+		return "", err
+	}
+	if err := ValidateOSVersion(req.OSVersion); err != nil {
+		return "", err
+	}
+	family := OSFamily(req.OSVersion)
+	spec := specs[req.PackageType]
+	title := scriptTitles[req.PackageType]
+
 	var script strings.Builder
 
 	script.WriteString("#!/bin/bash\n")
@@ -249,81 +139,44 @@ func (s *Service) GenerateInstallScript(req InstallRequest) (string, error) {
 	script.WriteString(fmt.Sprintf("# Package type: %s\n", req.PackageType))
 	script.WriteString(fmt.Sprintf("# OS version: %s\n\n", req.OSVersion))
 
-	switch req.PackageType {
-	case PackageTypeOVS:
-		script.WriteString(s.generateOVSInstallScript(req.Packages))
-	case PackageTypeCloudHypervisor:
-		script.WriteString(s.generateCHInstallScript(req.Packages))
-	default:
-		return "", fmt.Errorf("unsupported package type: %s", req.PackageType)
-	}
-
-	return script.String(), nil
-}
-
-func (s *Service) generateOVSInstallScript(packages []string) string {
-	var script strings.Builder
-
-	script.WriteString("# Install Open vSwitch packages\n")
-	script.WriteString("echo \"Installing Open vSwitch...\"\n\n")
+	script.WriteString(fmt.Sprintf("# Install %s\n", title))
+	script.WriteString(fmt.Sprintf("echo \"Installing %s...\"\n\n", title))
 
 	// Check if already installed
-	script.WriteString("if rpm -q openvswitch >/dev/null 2>&1; then\n")
-	script.WriteString("  echo \"Open vSwitch is already installed\"\n")
+	query := map[Family]string{FamilyRPM: "rpm -q", FamilyDeb: "dpkg -s"}[family]
+	script.WriteString(fmt.Sprintf("if %s %s >/dev/null 2>&1; then\n", query, spec.pkg[family]))
+	script.WriteString(fmt.Sprintf("  echo \"%s is already installed\"\n", title))
 	script.WriteString("  exit 0\n")
 	script.WriteString("fi\n\n")
 
 	// Install packages
-	if len(packages) > 0 {
-		script.WriteString("# Install RPM packages\n")
-		script.WriteString(fmt.Sprintf("dnf install -y %s\n\n", strings.Join(packages, " ")))
+	if len(req.Packages) > 0 {
+		install := map[Family]string{FamilyRPM: "dnf install -y", FamilyDeb: "apt-get install -y"}[family]
+		script.WriteString(fmt.Sprintf("# Install %s packages\n", family))
+		script.WriteString(fmt.Sprintf("%s %s\n\n", install, strings.Join(req.Packages, " ")))
 	}
 
 	// Start and enable service
-	script.WriteString("# Start and enable Open vSwitch service\n")
-	script.WriteString("systemctl start openvswitch\n")
-	script.WriteString("systemctl enable openvswitch\n\n")
+	if unit := spec.service[family]; unit != "" {
+		script.WriteString(fmt.Sprintf("# Start and enable %s service\n", title))
+		script.WriteString(fmt.Sprintf("systemctl start %s\n", unit))
+		script.WriteString(fmt.Sprintf("systemctl enable %s\n\n", unit))
+	}
 
 	// Verify installation
 	script.WriteString("# Verify installation\n")
-	script.WriteString("if ! command -v ovs-vsctl >/dev/null 2>&1; then\n")
-	script.WriteString("  echo \"ERROR: ovs-vsctl not found after installation\"\n")
+	script.WriteString(fmt.Sprintf("if ! command -v %s >/dev/null 2>&1; then\n", spec.binary))
+	script.WriteString(fmt.Sprintf("  echo \"ERROR: %s not found after installation\"\n", spec.binary))
 	script.WriteString("  exit 1\n")
 	script.WriteString("fi\n\n")
 
-	script.WriteString("echo \"Open vSwitch installation completed successfully\"\n")
-	script.WriteString("ovs-vsctl --version\n")
+	script.WriteString(fmt.Sprintf("echo \"%s installation completed successfully\"\n", title))
+	script.WriteString(fmt.Sprintf("%s --version\n", spec.binary))
 
-	return script.String()
+	return script.String(), nil
 }
 
-func (s *Service) generateCHInstallScript(packages []string) string {
-	var script strings.Builder
-
-	script.WriteString("# Install Cloud Hypervisor\n")
-	script.WriteString("echo \"Installing Cloud Hypervisor...\"\n\n")
-
-	// Check if already installed
-	script.WriteString("if rpm -q cloud-hypervisor >/dev/null 2>&1; then\n")
-	script.WriteString("  echo \"Cloud Hypervisor is already installed\"\n")
-	script.WriteString("  exit 0\n")
-	script.WriteString("fi\n\n")
-
-	// Install packages
-	if len(packages) > 0 {
-		script.WriteString("# Install RPM package\n")
-		script.WriteString(fmt.Sprintf("rpm -ivh %s\n\n", strings.Join(packages, " ")))
-	}
-
-	// Verify installation
-	script.WriteString("# Verify installation\n")
-	script.WriteString("if ! command -v cloud-hypervisor >/dev/null 2>&1; then\n")
-	script.WriteString("  echo \"ERROR: cloud-hypervisor not found after installation\"\n")
-	script.WriteString("  exit 1\n")
-	script.WriteString("fi\n\n")
-
-	script.WriteString("echo \"Cloud Hypervisor installation completed successfully\"\n")
-	script.WriteString("cloud-hypervisor --version\n")
-
-	return script.String()
+var scriptTitles = map[PackageType]string{
+	PackageTypeOVS:             "Open vSwitch",
+	PackageTypeCloudHypervisor: "Cloud Hypervisor",
 }
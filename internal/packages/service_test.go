@@ -0,0 +1,141 @@
+package packages
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadPackageVerifiesDigest(t *testing.T) {
+	body := []byte("openvswitch rpm")
+	sum := sha256.Sum256(body)
+	digest := hex.EncodeToString(sum[:])
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		_, _ = w.Write(body)
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	svc := NewService(dir, nil)
+	info := PackageInfo{Name: "openvswitch.rpm", URL: ts.URL + "/openvswitch.rpm", SHA256: digest, Required: true}
+
+	if _, err := svc.DownloadPackage(PackageInfo{Name: info.Name, URL: info.URL}); !errors.Is(err, ErrIntegrity) {
+		t.Fatalf("expected a package without digest to be refused, got %v", err)
+	}
+	path, err := svc.DownloadPackage(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.DownloadPackage(info); err != nil || hits != 1 {
+		t.Fatalf("expected the verified file to be reused, got %v after %d downloads", err, hits)
+	}
+
+	// A corrupted cache entry is downloaded again.
+	if err := os.WriteFile(path, []byte("tampered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.DownloadPackage(info); err != nil || hits != 2 {
+		t.Fatalf("expected the corrupted file to be downloaded again, got %v after %d downloads", err, hits)
+	}
+
+	wrong := info
+	wrong.Name = "other.rpm"
+	wrong.SHA256 = hex.EncodeToString(make([]byte, sha256.Size))
+	if _, err := svc.DownloadPackage(wrong); !errors.Is(err, ErrIntegrity) {
+		t.Fatalf("expected a digest mismatch, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, wrong.Name)); !os.IsNotExist(err) {
+		t.Fatalf("expected the mismatching file to be removed, got %v", err)
+	}
+}
+
+func TestVerifySignaturesKeyring(t *testing.T) {
+	svc := NewService(t.TempDir(), nil)
+	svc.Manager = &FakeManager{Format: FamilyRPM}
+	if err := svc.VerifySignatures([]string{"pkg.rpm"}); !errors.Is(err, ErrIntegrity) {
+		t.Fatalf("expected installs without a keyring to be refused, got %v", err)
+	}
+	var notes []string
+	svc.SkipSignatures, svc.Log = true, func(msg string) { notes = append(notes, msg) }
+	if err := svc.VerifySignatures([]string{"pkg.rpm"}); err != nil || len(notes) != 1 || !strings.Contains(notes[0], "signature check skipped") {
+		t.Fatalf("expected the opted-out check to be skipped and noted, got %v %q", err, notes)
+	}
+	svc.Keyring = t.TempDir()
+	if err := svc.VerifySignatures([]string{"pkg.rpm"}); err == nil {
+		t.Fatal("expected an empty keyring to be refused")
+	}
+
+	// .deb files have no signatures to check; the policy is digests only
+	deb := NewService(t.TempDir(), nil)
+	deb.Manager = &FakeManager{Format: FamilyDeb}
+	notes = nil
+	deb.Log = func(msg string) { notes = append(notes, msg) }
+	if err := deb.VerifySignatures([]string{"pkg.deb"}); err != nil || len(notes) != 1 || !strings.Contains(notes[0], "registry digests only") {
+		t.Fatalf("expected deb packages to pass on their digests with a note, got %v %q", err, notes)
+	}
+}
+
+func TestDownloadPackageFromMirror(t *testing.T) {
+	mirrored := []byte("mirrored rpm")
+	upstreamOnly := []byte("upstream rpm")
+	digest := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "invalid mirror token", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/v1/mirror/files/sha256:"+digest(mirrored) {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(mirrored)
+	}))
+	defer mirror.Close()
+	upstreamHits := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits++
+		_, _ = w.Write(upstreamOnly)
+	}))
+	defer upstream.Close()
+
+	svc := NewService(t.TempDir(), nil)
+	svc.Mirror, svc.MirrorToken = mirror.URL+"/", "secret"
+	if _, err := svc.DownloadPackage(PackageInfo{Name: "a.rpm", URL: upstream.URL + "/a.rpm", SHA256: digest(mirrored)}); err != nil || upstreamHits != 0 {
+		t.Fatalf("expected the file from the mirror, got %v after %d upstream downloads", err, upstreamHits)
+	}
+	b := PackageInfo{Name: "b.rpm", URL: upstream.URL + "/b.rpm", SHA256: digest(upstreamOnly)}
+	if _, err := svc.DownloadPackage(b); err == nil || upstreamHits != 0 {
+		t.Fatalf("expected no upstream download without fallback, got %v after %d upstream downloads", err, upstreamHits)
+	}
+	svc.UpstreamFallback = true
+	if _, err := svc.DownloadPackage(b); err != nil || upstreamHits != 1 {
+		t.Fatalf("expected the upstream fallback, got %v after %d upstream downloads", err, upstreamHits)
+	}
+}
+
+func TestGenerateInstallScriptPerFamily(t *testing.T) {
+	svc := NewService(t.TempDir(), nil)
+	req := InstallRequest{PackageType: PackageTypeOVS, Packages: []string{"openvswitch-switch_3.3.1-1_amd64.deb"}, OSVersion: "ubuntu24.04"}
+	script, err := svc.GenerateInstallScript(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(script, "apt-get install -y openvswitch-switch_3.3.1-1_amd64.deb") || !strings.Contains(script, "systemctl enable openvswitch-switch") {
+		t.Fatalf("expected an apt script, got:\n%s", script)
+	}
+	req.OSVersion = "el9"
+	if script, err = svc.GenerateInstallScript(req); err != nil || !strings.Contains(script, "dnf install -y") {
+		t.Fatalf("expected a dnf script, got %v:\n%s", err, script)
+	}
+}
@@ -0,0 +1,110 @@
+package packages
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// ErrIntegrity is returned when a package file does not match its registered
+// digest or its signature cannot be verified.
+var ErrIntegrity = errors.New("package integrity check failed")
+
+// fileSHA256 returns the hex SHA-256 digest of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkDigest compares the digest of a downloaded file with the registered
+// one.
+func checkDigest(info PackageInfo, got string) error {
+	if got != info.SHA256 {
+		return fmt.Errorf("%w: %s: sha256 is %s, expected %s", ErrIntegrity, info.Name, got, info.SHA256)
+	}
+	return nil
+}
+
+// VerifySignatures checks the signatures of package files against the keys in
+// s.Keyring, a key file or a directory of key files, using the host's package
+// manager. Which files are checked is a per-family policy: families whose
+// files carry no signatures (see Family.SignedFiles) are checked against
+// their registry digests only, whatever the keyring, and the task log says
+// so. For the others a missing keyring fails the check unless
+// SkipSignatures allows installing on the registry digests alone; the skip
+// is noted in the task log as well.
+func (s *Service) VerifySignatures(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	m, err := s.manager()
+	if err != nil {
+		return err
+	}
+	if f := m.Family(); !f.SignedFiles() {
+		s.note("%s packages carry no signatures of their own, %d package(s) checked against their registry digests only", f, len(paths))
+		return nil
+	}
+	if s.Keyring == "" {
+		if !s.SkipSignatures {
+			return fmt.Errorf("%w: no keyring configured to verify the signatures of %d package(s); set VERTERA_PACKAGE_KEYRING, or VERTERA_PACKAGE_SKIP_SIGNATURES=true to rely on registry digests alone", ErrIntegrity, len(paths))
+		}
+		s.note("signature check skipped for %d package(s): no keyring configured and VERTERA_PACKAGE_SKIP_SIGNATURES is set", len(paths))
+		return nil
+	}
+	keys, err := keyFiles(s.Keyring)
+	if err != nil {
+		return fmt.Errorf("read keyring: %w", err)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("keyring %s holds no keys", s.Keyring)
+	}
+	if err := m.VerifySignatures(keys, paths); err != nil {
+		return fmt.Errorf("keyring %s: %w", s.Keyring, err)
+	}
+	return nil
+}
+
+// note logs msg and passes it to the task log.
+func (s *Service) note(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("packages: %s", msg)
+	if s.Log != nil {
+		s.Log(msg)
+	}
+}
+
+// keyFiles lists the key files of a keyring path.
+func keyFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			out = append(out, filepath.Join(path, e.Name()))
+		}
+	}
+	return out, nil
+}
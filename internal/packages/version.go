@@ -0,0 +1,42 @@
+package packages
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CompareVersions orders dotted versions such as 3.6.0 and 47.0, comparing
+// numeric parts as numbers. Missing trailing parts count as 0, so 47.0 and
+// 47.0.0 are the same version. It returns -1, 0 or 1.
+func CompareVersions(a, b string) int {
+	split := func(v string) []string {
+		return strings.FieldsFunc(v, func(r rune) bool { return r == '.' || r == '-' || r == '_' || r == '+' || r == '~' })
+	}
+	pa, pb := split(a), split(b)
+	part := func(parts []string, i int) string {
+		if i < len(parts) {
+			return parts[i]
+		}
+		return "0"
+	}
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		xa, xb := part(pa, i), part(pb, i)
+		na, errA := strconv.Atoi(xa)
+		nb, errB := strconv.Atoi(xb)
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		case xa != xb:
+			if xa < xb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,61 @@
+// Package qemuimg creates, converts and resizes VM disk images with the
+// qemu-img tool.
+package qemuimg
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/VerteraIO/vertera/internal/agent/runtime"
+)
+
+// Runner executes a command and returns its combined output.
+type Runner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+func execRunner(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+// Client drives qemu-img.
+type Client struct {
+	run Runner
+}
+
+var _ runtime.DiskImages = (*Client)(nil)
+
+// NewClient returns a Client that shells out to the host's qemu-img binary.
+func NewClient() *Client {
+	return &Client{run: execRunner}
+}
+
+// NewClientWithRunner returns a Client that uses run instead of exec (useful in tests).
+func NewClientWithRunner(run Runner) *Client {
+	return &Client{run: run}
+}
+
+func (c *Client) qemuImg(ctx context.Context, args ...string) error {
+	out, err := c.run(ctx, "qemu-img", args...)
+	if err != nil {
+		return fmt.Errorf("qemu-img %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Convert writes a sparse raw copy of the image at src to dst.
+func (c *Client) Convert(ctx context.Context, src, srcFormat, dst string) error {
+	return c.qemuImg(ctx, "convert", "-f", srcFormat, "-O", "raw", "-S", "4k", src, dst)
+}
+
+// CreateOverlay creates a qcow2 overlay at dst whose reads fall through to
+// backing until the guest writes the blocks.
+func (c *Client) CreateOverlay(ctx context.Context, backing, backingFormat, dst string) error {
+	return c.qemuImg(ctx, "create", "-f", "qcow2", "-F", backingFormat, "-b", backing, dst)
+}
+
+// Resize grows the image at path to sizeBytes; qemu-img refuses to shrink.
+func (c *Client) Resize(ctx context.Context, path, format string, sizeBytes int64) error {
+	return c.qemuImg(ctx, "resize", "-f", format, path, strconv.FormatInt(sizeBytes, 10))
+}
@@ -0,0 +1,44 @@
+package qemuimg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	var calls []string
+	c := NewClientWithRunner(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		calls = append(calls, name+" "+strings.Join(args, " "))
+		return nil, nil
+	})
+	ctx := context.Background()
+	if err := c.CreateOverlay(ctx, "/cache/img.qcow2", "qcow2", "/vm/disk0.qcow2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Convert(ctx, "/cache/img.qcow2", "qcow2", "/vm/disk1.raw"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Resize(ctx, "/vm/disk0.qcow2", "qcow2", 10<<30); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"qemu-img create -f qcow2 -F qcow2 -b /cache/img.qcow2 /vm/disk0.qcow2",
+		"qemu-img convert -f qcow2 -O raw -S 4k /cache/img.qcow2 /vm/disk1.raw",
+		"qemu-img resize -f qcow2 /vm/disk0.qcow2 10737418240",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected commands:\n%s", strings.Join(calls, "\n"))
+	}
+}
+
+func TestErrorIncludesOutput(t *testing.T) {
+	c := NewClientWithRunner(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		return []byte("qemu-img: Use the --shrink option to perform a shrink operation.\n"), errors.New("exit status 1")
+	})
+	err := c.Resize(context.Background(), "/vm/disk0.raw", "raw", 1<<30)
+	if err == nil || !strings.Contains(err.Error(), "--shrink") {
+		t.Fatalf("expected qemu-img output in error, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package vmspec
+
+import (
+	"fmt"
+	"time"
+)
+
+// Restart policies of a VM.
+const (
+	// RestartNever leaves a VM whose VMM exited alone.
+	RestartNever = "never"
+	// RestartOnFailure restarts a VM whose VMM crashed, but not one whose
+	// guest powered itself off.
+	RestartOnFailure = "on-failure"
+	// RestartAlways restarts a VM whenever its VMM exited on its own.
+	RestartAlways = "always"
+)
+
+// maxRestartBackoff caps the growing delay between restarts.
+const maxRestartBackoff = 5 * time.Minute
+
+// RestartPolicy says whether the agent restarts a VM whose VMM exited
+// without being asked to.
+type RestartPolicy struct {
+	Policy string `json:"policy"`
+	// MaxRestarts bounds the restarts in a row; a VMM that stayed up for a
+	// while counts as healthy again.
+	MaxRestarts int `json:"maxRestarts"`
+	// BackoffSeconds is the delay before the first restart, doubled for each
+	// further one up to 5 minutes.
+	BackoffSeconds int `json:"backoffSeconds"`
+}
+
+func (p *RestartPolicy) validate() error {
+	switch p.Policy {
+	case RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return fmt.Errorf("unknown restart policy %q", p.Policy)
+	}
+	if p.MaxRestarts < 0 || p.BackoffSeconds < 0 {
+		return fmt.Errorf("restart policy: maxRestarts and backoffSeconds must not be negative")
+	}
+	return nil
+}
+
+// Applies reports whether the policy restarts a VM whose VMM exited with
+// exitCode (non-zero for a crash). A nil policy never restarts.
+func (p *RestartPolicy) Applies(exitCode int) bool {
+	if p == nil {
+		return false
+	}
+	switch p.Policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return exitCode != 0
+	}
+	return false
+}
+
+// Delay reports whether a VM whose VMM exited with exitCode after restarts
+// restarts in a row is restarted, and how long to wait before doing so.
+func (p *RestartPolicy) Delay(exitCode, restarts int) (time.Duration, bool) {
+	if !p.Applies(exitCode) || restarts >= p.MaxRestarts {
+		return 0, false
+	}
+	d := time.Duration(p.BackoffSeconds) * time.Second
+	for i := 0; i < restarts && d < maxRestartBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxRestartBackoff), true
+}
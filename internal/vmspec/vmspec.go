@@ -0,0 +1,302 @@
+// Package vmspec describes a VM's desired configuration as exchanged between
+// the controller and the agent, and translates it into a Cloud Hypervisor
+// VmConfig once host-local paths are known.
+package vmspec
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
+
+	"github.com/VerteraIO/vertera/internal/network"
+)
+
+// Spec is the desired configuration of a VM. The controller fills it from the
+// API resource; the agent persists it next to the VM so it can recreate the VM
+// after a power-off or VMM restart.
+type Spec struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Vcpus     int    `json:"vcpus"`
+	MemoryMiB int    `json:"memoryMiB"`
+	// MaxVcpus and MaxMemoryMiB bound live resizes: vCPUs up to MaxVcpus can
+	// be hot-plugged and memory up to MaxMemoryMiB is hot-pluggable through
+	// virtio-mem. Zero means no headroom.
+	MaxVcpus     int `json:"maxVcpus,omitempty"`
+	MaxMemoryMiB int `json:"maxMemoryMiB,omitempty"`
+	// Hugepages backs guest memory with the host's hugepage pool.
+	Hugepages bool   `json:"hugepages,omitempty"`
+	Nics      []Nic  `json:"nics,omitempty"`
+	Disks     []Disk `json:"disks,omitempty"`
+	// CloudInit, when set, is served to the guest on a NoCloud seed disk.
+	CloudInit *CloudInit `json:"cloudInit,omitempty"`
+	// Restart is how the agent handles the VM's VMM exiting on its own;
+	// without one the VM is left alone.
+	Restart *RestartPolicy `json:"restart,omitempty"`
+}
+
+// CloudInit is the cloud-init data of a VM. An empty MetaData is replaced by
+// one naming the VM's ID as instance-id and its name as hostname.
+type CloudInit struct {
+	UserData      string `json:"userData,omitempty"`
+	MetaData      string `json:"metaData,omitempty"`
+	NetworkConfig string `json:"networkConfig,omitempty"`
+}
+
+// MemoryHotplugMethod is how memory beyond the boot size is hot-plugged;
+// virtio-mem, unlike ACPI hotplug, can also give memory back.
+const MemoryHotplugMethod = "VirtioMem"
+
+// SeedDiskID is the CH device id of the cloud-init seed disk.
+const SeedDiskID = "cidata"
+
+// Nic is a VM network interface plugged into a DVS port.
+type Nic struct {
+	// ID is the CH device id, e.g. "net0".
+	ID   string           `json:"id"`
+	MAC  string           `json:"mac"`
+	Port network.PortSpec `json:"port"`
+}
+
+// Disk is a VM block device backed by a file in the VM's runtime directory.
+type Disk struct {
+	// ID is the CH device id, e.g. "disk0".
+	ID      string `json:"id"`
+	SizeGiB int    `json:"sizeGiB"`
+	// Image is the image the disk is created from; without one the disk
+	// starts out empty.
+	Image *Image `json:"image,omitempty"`
+	// Clone is how the disk is created from Image.
+	Clone Clone `json:"clone,omitempty"`
+}
+
+// Image identifies a catalog image a disk is created from. The agent fetches
+// it from the controller by ID and checks it against SHA256.
+type Image struct {
+	ID     string `json:"id"`
+	Format string `json:"format"`
+	SHA256 string `json:"sha256"`
+}
+
+// Clone is how a disk is created from its image.
+type Clone string
+
+const (
+	// CloneCOW disks are qcow2 overlays backed by the host's cached image.
+	CloneCOW Clone = "cow"
+	// CloneFull disks are raw copies of the image.
+	CloneFull Clone = "full"
+)
+
+// Layout holds the host-local paths needed to build a VmConfig.
+type Layout struct {
+	// Dir is the VM's runtime directory (sockets and the spec live here).
+	Dir string
+	// VolumeDir is the VM's directory on persistent, possibly shared,
+	// storage holding its disks.
+	VolumeDir string
+	// Firmware is the guest firmware (e.g. CLOUDHV.fd or hypervisor-fw).
+	Firmware string
+}
+
+// specFile is the name of the persisted spec inside a VM's runtime directory.
+const specFile = "spec.json"
+
+// Save writes the spec into dir atomically.
+func (s *Spec) Save(dir string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, specFile+".tmp")
+	if err := os.WriteFile(tmp, b, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, specFile))
+}
+
+// Load reads the spec persisted in dir.
+func Load(dir string) (*Spec, error) {
+	b, err := os.ReadFile(filepath.Join(dir, specFile))
+	if err != nil {
+		return nil, err
+	}
+	var s Spec
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", specFile, err)
+	}
+	return &s, nil
+}
+
+// Validate checks the spec for values Cloud Hypervisor would reject.
+func (s *Spec) Validate() error {
+	if s.ID == "" {
+		return fmt.Errorf("vm id is required")
+	}
+	if s.Vcpus < 1 || s.Vcpus > 254 {
+		return fmt.Errorf("vcpus %d out of range 1-254", s.Vcpus)
+	}
+	if s.MemoryMiB < 128 {
+		return fmt.Errorf("memoryMiB %d below minimum 128", s.MemoryMiB)
+	}
+	if s.MaxVcpus > 254 {
+		return fmt.Errorf("maxVcpus %d above maximum 254", s.MaxVcpus)
+	}
+	if s.Restart != nil {
+		if err := s.Restart.validate(); err != nil {
+			return err
+		}
+	}
+	seen := map[string]bool{SeedDiskID: s.CloudInit != nil}
+	for _, n := range s.Nics {
+		if n.ID == "" || seen[n.ID] {
+			return fmt.Errorf("nic id %q missing or duplicated", n.ID)
+		}
+		seen[n.ID] = true
+	}
+	for _, d := range s.Disks {
+		if d.ID == "" || seen[d.ID] {
+			return fmt.Errorf("disk id %q missing or duplicated", d.ID)
+		}
+		seen[d.ID] = true
+		if d.SizeGiB < 1 {
+			return fmt.Errorf("disk %s: sizeGiB must be at least 1", d.ID)
+		}
+		if d.Image == nil {
+			if d.Clone != "" {
+				return fmt.Errorf("disk %s: clone needs an image", d.ID)
+			}
+			continue
+		}
+		if d.Image.ID == "" || d.Image.SHA256 == "" {
+			return fmt.Errorf("disk %s: image id and sha256 are required", d.ID)
+		}
+		if d.Image.Format != "raw" && d.Image.Format != "qcow2" {
+			return fmt.Errorf("disk %s: unsupported image format %q", d.ID, d.Image.Format)
+		}
+		if d.Clone != CloneCOW && d.Clone != CloneFull {
+			return fmt.Errorf("disk %s: unknown clone mode %q", d.ID, d.Clone)
+		}
+	}
+	return nil
+}
+
+// DiskPath is where the agent keeps the backing file of a disk. Copy-on-write
+// clones are qcow2 overlays; every other disk is a raw file.
+func (l Layout) DiskPath(d Disk) string {
+	if d.Image != nil && d.Clone == CloneCOW {
+		return filepath.Join(l.VolumeDir, d.ID+".qcow2")
+	}
+	return filepath.Join(l.VolumeDir, d.ID+".raw")
+}
+
+// SeedPath is where the agent keeps the cloud-init seed image.
+func (l Layout) SeedPath() string {
+	return filepath.Join(l.Dir, "cidata.iso")
+}
+
+// SerialSocketPath is the unix socket Cloud Hypervisor serves the VM's
+// serial console on.
+func (l Layout) SerialSocketPath() string {
+	return filepath.Join(l.Dir, "serial.sock")
+}
+
+// VhostSocketPath is where the vhost-user socket of a NIC lives.
+func (l Layout) VhostSocketPath(n Nic) string {
+	return filepath.Join(l.Dir, n.ID+".vhost.sock")
+}
+
+// VmConfig translates the spec into a Cloud Hypervisor VM configuration.
+func (s *Spec) VmConfig(l Layout) ch.VmConfig {
+	memBytes := int64(s.MemoryMiB) << 20
+	cfg := ch.VmConfig{
+		Cpus:   &ch.CpusConfig{BootVcpus: s.Vcpus, MaxVcpus: max(s.Vcpus, s.MaxVcpus)},
+		Memory: &ch.MemoryConfig{Size: memBytes},
+	}
+	// virtio-mem plugs memory in 128 MiB blocks.
+	if size := int64(s.MaxMemoryMiB-s.MemoryMiB) << 20 &^ (128<<20 - 1); size > 0 {
+		method := MemoryHotplugMethod
+		cfg.Memory.HotplugMethod, cfg.Memory.HotplugSize = &method, &size
+	}
+	if s.Hugepages {
+		hp := true
+		cfg.Memory.Hugepages = &hp
+	}
+	if l.Firmware != "" {
+		fw := l.Firmware
+		cfg.Payload.Firmware = &fw
+	}
+	uuid := s.ID
+	cfg.Platform = &ch.PlatformConfig{Uuid: &uuid}
+	// The guest's console is its serial port, served on a socket the agent
+	// attaches to; the virtio console is left off.
+	serial := l.SerialSocketPath()
+	cfg.Serial = &ch.ConsoleConfig{Mode: ch.ConsoleConfigModeSocket, Socket: &serial}
+	cfg.Console = &ch.ConsoleConfig{Mode: ch.ConsoleConfigModeOff}
+
+	if len(s.Disks) > 0 || s.CloudInit != nil {
+		disks := make([]ch.DiskConfig, 0, len(s.Disks)+1)
+		for _, d := range s.Disks {
+			disks = append(disks, DiskConfig(d, l))
+		}
+		if s.CloudInit != nil {
+			disks = append(disks, SeedDiskConfig(l))
+		}
+		cfg.Disks = &disks
+	}
+
+	if len(s.Nics) > 0 {
+		nets := make([]ch.NetConfig, 0, len(s.Nics))
+		for _, n := range s.Nics {
+			nets = append(nets, NetConfig(n, l))
+		}
+		cfg.Net = &nets
+		for _, n := range s.Nics {
+			if n.Port.Type == network.PortTypeVhostUser {
+				// vhost-user backends map guest memory, so it must be shared.
+				shared := true
+				cfg.Memory.Shared = &shared
+				break
+			}
+		}
+	}
+	return cfg
+}
+
+// DiskConfig translates a single disk (also used for hot-plug).
+func DiskConfig(d Disk, l Layout) ch.DiskConfig {
+	id, path := d.ID, l.DiskPath(d)
+	return ch.DiskConfig{Id: &id, Path: &path}
+}
+
+// SeedDiskConfig is the read-only disk serving the cloud-init seed image.
+func SeedDiskConfig(l Layout) ch.DiskConfig {
+	id, path, ro := SeedDiskID, l.SeedPath(), true
+	return ch.DiskConfig{Id: &id, Path: &path, Readonly: &ro}
+}
+
+// NetConfig translates a single NIC (also used for hot-plug).
+func NetConfig(n Nic, l Layout) ch.NetConfig {
+	id, mac := n.ID, n.MAC
+	nc := ch.NetConfig{Id: &id, Mac: &mac}
+	if n.Port.MTU > 0 {
+		mtu := n.Port.MTU
+		nc.Mtu = &mtu
+	}
+	switch n.Port.Type {
+	case network.PortTypeVhostUser:
+		vu, mode := true, "server"
+		sock := n.Port.SocketPath
+		if sock == "" {
+			sock = l.VhostSocketPath(n)
+		}
+		nc.VhostUser, nc.VhostMode, nc.VhostSocket = &vu, &mode, &sock
+	default:
+		tap := n.Port.Name
+		nc.Tap = &tap
+	}
+	return nc
+}
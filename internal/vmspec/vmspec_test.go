@@ -0,0 +1,135 @@
+package vmspec
+
+import (
+	"testing"
+	"time"
+
+	ch "github.com/VerteraIO/cloud-hypervisor-go/chclient"
+
+	"github.com/VerteraIO/vertera/internal/network"
+)
+
+func TestVmConfig(t *testing.T) {
+	s := Spec{
+		ID:        "vm1",
+		Vcpus:     2,
+		MemoryMiB: 1024,
+		Nics: []Nic{
+			{ID: "net0", MAC: "02:00:00:00:00:01", Port: network.PortSpec{Name: "vtvm1-0", Type: network.PortTypeTap, MTU: 9000}},
+			{ID: "net1", MAC: "02:00:00:00:00:02", Port: network.PortSpec{Name: "vtvm1-1", Type: network.PortTypeVhostUser}},
+		},
+		Disks: []Disk{{ID: "disk0", SizeGiB: 10}},
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	cfg := s.VmConfig(Layout{Dir: "/run/vms/vm1", VolumeDir: "/var/lib/volumes/vm1", Firmware: "/fw"})
+
+	if cfg.Cpus.BootVcpus != 2 || cfg.Memory.Size != 1024<<20 || *cfg.Payload.Firmware != "/fw" {
+		t.Fatalf("unexpected cpus/memory/payload: %+v %+v %+v", cfg.Cpus, cfg.Memory, cfg.Payload)
+	}
+	if cfg.Cpus.MaxVcpus != 2 || cfg.Memory.HotplugSize != nil {
+		t.Fatalf("expected no resize headroom: %+v %+v", cfg.Cpus, cfg.Memory)
+	}
+	s.MaxVcpus, s.MaxMemoryMiB = 4, 4096
+	if hc := s.VmConfig(Layout{}); hc.Cpus.MaxVcpus != 4 || *hc.Memory.HotplugSize != 3072<<20 || *hc.Memory.HotplugMethod != MemoryHotplugMethod {
+		t.Fatalf("unexpected resize headroom: %+v %+v", hc.Cpus, hc.Memory)
+	}
+	if cfg.Serial.Mode != ch.ConsoleConfigModeSocket || *cfg.Serial.Socket != "/run/vms/vm1/serial.sock" || cfg.Console.Mode != ch.ConsoleConfigModeOff {
+		t.Fatalf("unexpected console config: %+v %+v", cfg.Serial, cfg.Console)
+	}
+	if d := (*cfg.Disks)[0]; *d.Path != "/var/lib/volumes/vm1/disk0.raw" || *d.Id != "disk0" {
+		t.Fatalf("unexpected disk: %+v", d)
+	}
+	nets := *cfg.Net
+	if *nets[0].Tap != "vtvm1-0" || *nets[0].Mtu != 9000 || *nets[0].Mac != "02:00:00:00:00:01" {
+		t.Fatalf("unexpected tap nic: %+v", nets[0])
+	}
+	if nets[1].VhostUser == nil || !*nets[1].VhostUser || *nets[1].VhostSocket != "/run/vms/vm1/net1.vhost.sock" {
+		t.Fatalf("unexpected vhost-user nic: %+v", nets[1])
+	}
+	if cfg.Memory.Shared == nil || !*cfg.Memory.Shared {
+		t.Fatalf("vhost-user requires shared memory")
+	}
+
+	s.Disks = append(s.Disks, Disk{ID: "net0", SizeGiB: 1})
+	if err := s.Validate(); err == nil {
+		t.Fatalf("expected duplicate device id to be rejected")
+	}
+}
+
+func TestImageDisks(t *testing.T) {
+	img := &Image{ID: "img1", Format: "qcow2", SHA256: "abc"}
+	s := Spec{
+		ID:        "vm1",
+		Vcpus:     1,
+		MemoryMiB: 512,
+		Disks:     []Disk{{ID: "disk0", SizeGiB: 10, Image: img, Clone: CloneCOW}, {ID: "disk1", SizeGiB: 10, Image: img, Clone: CloneFull}},
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	l := Layout{Dir: "/run/vms/vm1", VolumeDir: "/var/lib/volumes/vm1"}
+	if p := l.DiskPath(s.Disks[0]); p != "/var/lib/volumes/vm1/disk0.qcow2" {
+		t.Fatalf("cow disk path %s", p)
+	}
+	if p := l.DiskPath(s.Disks[1]); p != "/var/lib/volumes/vm1/disk1.raw" {
+		t.Fatalf("full clone disk path %s", p)
+	}
+
+	s.Disks[1].Clone = ""
+	if err := s.Validate(); err == nil {
+		t.Fatal("expected image disk without clone mode to be rejected")
+	}
+	s.Disks[1] = Disk{ID: "disk1", SizeGiB: 10, Clone: CloneFull}
+	if err := s.Validate(); err == nil {
+		t.Fatal("expected clone without image to be rejected")
+	}
+}
+
+func TestCloudInitSeedDisk(t *testing.T) {
+	s := Spec{ID: "vm1", Vcpus: 1, MemoryMiB: 512, CloudInit: &CloudInit{UserData: "#cloud-config\n"}}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	cfg := s.VmConfig(Layout{Dir: "/run/vms/vm1"})
+	if cfg.Disks == nil || len(*cfg.Disks) != 1 {
+		t.Fatalf("expected the seed disk, got %+v", cfg.Disks)
+	}
+	if d := (*cfg.Disks)[0]; *d.Id != SeedDiskID || *d.Path != "/run/vms/vm1/cidata.iso" || !*d.Readonly {
+		t.Fatalf("unexpected seed disk: %+v", d)
+	}
+
+	s.Disks = []Disk{{ID: SeedDiskID, SizeGiB: 1}}
+	if err := s.Validate(); err == nil {
+		t.Fatal("expected a disk clashing with the seed disk to be rejected")
+	}
+}
+
+func TestRestartPolicy(t *testing.T) {
+	var none *RestartPolicy
+	if _, ok := none.Delay(1, 0); ok {
+		t.Fatal("a VM without a policy must not restart")
+	}
+	p := &RestartPolicy{Policy: RestartOnFailure, MaxRestarts: 3, BackoffSeconds: 2}
+	if _, ok := p.Delay(0, 0); ok {
+		t.Fatal("on-failure must not restart after a guest power-off")
+	}
+	for restarts, want := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		if d, ok := p.Delay(1, restarts); !ok || d != want {
+			t.Fatalf("restart %d: got %v %v, want %v", restarts, d, ok, want)
+		}
+	}
+	if _, ok := p.Delay(1, 3); ok {
+		t.Fatal("expected restarts to be exhausted")
+	}
+	p = &RestartPolicy{Policy: RestartAlways, MaxRestarts: 20, BackoffSeconds: 60}
+	if d, ok := p.Delay(0, 10); !ok || d != 5*time.Minute {
+		t.Fatalf("expected the capped backoff, got %v %v", d, ok)
+	}
+
+	s := Spec{ID: "vm1", Vcpus: 1, MemoryMiB: 512, Restart: &RestartPolicy{Policy: "sometimes"}}
+	if err := s.Validate(); err == nil {
+		t.Fatal("expected an unknown restart policy to be rejected")
+	}
+}